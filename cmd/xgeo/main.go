@@ -1,63 +1,634 @@
 package main
 
 import (
-	"compress/bzip2"
-	"compress/gzip"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/paulmach/orb"
 	gio "github.com/stationa/xgeo/io"
+	"github.com/stationa/xgeo/pluscode"
+	"github.com/stationa/xgeo/script"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
 	"strings"
 )
 
 var (
-	src = kingpin.Arg("source", "Source file").File()
+	src         = kingpin.Arg("source", "Source file, or - for standard input").Default("-").String()
+	inputFormat = kingpin.Flag("input-format", "Source format, detected from the content by default").Enum(inputFormats...)
+	scriptFile  = kingpin.Flag("script", "Lua script defining a process(feature) function").ExistingFile()
+	mProperty   = kingpin.Flag("m-property", "Store shapefile M values in this property instead of as a fourth ordinate").String()
+	encoding    = kingpin.Flag("encoding", "Character encoding of shapefile attributes, overriding the .cpg file").String()
+	reproject   = kingpin.Flag("reproject", "Reproject shapefiles to WGS84 using their .prj file (--no-reproject to keep their coordinates)").Default("true").Bool()
+	layers      = kingpin.Flag("layer", "Shapefile of a zip archive, TopoJSON object or GeoPackage table to read, all by default (repeatable)").Strings()
+	layerProp   = kingpin.Flag("layer-property", "Store the name of the layer or the path of the KML Folders each feature is read from in this property, or write TopoJSON objects, GeoPackage tables or KML Folders named by it").String()
+	gpxLayers   = kingpin.Flag("gpx-layers", "Comma-separated GPX layers to read, among waypoints, routes and tracks, all by default").String()
+	bbox        = kingpin.Flag("bbox", "Only read features intersecting minx,miny,maxx,maxy, using the index of FlatGeobuf and GeoPackage sources").String()
+	listLayers  = kingpin.Flag("list-layers", "List the layers of the source and exit").Bool()
+	delimiter   = kingpin.Flag("delimiter", "CSV field delimiter, e.g. ';' or tab, detected from the header row by default").String()
+	lazyQuotes  = kingpin.Flag("lazy-quotes", "Allow stray quotes in CSV fields").Bool()
+	geomColumn  = kingpin.Flag("geometry-column", "CSV column of WKT, WKB or GeoJSON geometries").String()
+	lonColumn   = kingpin.Flag("lon-column", "CSV column of point longitudes, used with --lat-column").String()
+	latColumn   = kingpin.Flag("lat-column", "CSV column of point latitudes, used with --lon-column").String()
+	csvColumns  = kingpin.Flag("columns", "Comma-separated properties written to CSV output, those of all features by default").String()
+	quoteAll    = kingpin.Flag("quote-all", "Quote every field of CSV output, not only those that need it").Bool()
+	quantize    = kingpin.Flag("quantization", "Round TopoJSON output coordinates to this many values per axis, e.g. 100000").Int()
+	kmlStyle    = kingpin.Flag("kml-style", "Style KML placemarks with the stroke, fill and marker-color properties of features").Bool()
+	gpkgIndex   = kingpin.Flag("spatial-index", "Write an R-tree index of each GeoPackage table (--no-spatial-index to skip it)").Default("true").Bool()
+	fromPlus    = kingpin.Flag("from-plus-code", "Replace geometries with the location of the Plus Code in this property").String()
+	plusCell    = kingpin.Flag("plus-code-cell", "Decode Plus Codes to the polygon of their cell rather than its center").Bool()
+	plusCode    = kingpin.Flag("plus-code", "Store the Plus Code of each point or centroid in this property").String()
+	plusLength  = kingpin.Flag("plus-code-length", "Number of digits of the Plus Codes stored with --plus-code").Default("10").Int()
+	output      = kingpin.Flag("output", "Output file, or - for standard output, compressed if it ends with .gz, .xz or .zst (bzip2 is read-only)").Short('o').Default("-").String()
+	format      = kingpin.Flag("format", "Output format, inferred from the output file extension by default").Enum(outputFormats...)
+	limit       = kingpin.Flag("limit", "Stop after writing this many features").Int()
 )
 
-func main() {
-	kingpin.Parse()
+var (
+	inputFormats  = []string{"geojson", "geojsonseq", "ndjson", "shapefile", "csv", "tsv", "kml", "kmz", "gpx", "topojson", "flatgeobuf", "geopackage"}
+	outputFormats = []string{"geojson", "geojsonseq", "ndjson", "shapefile", "csv", "tsv", "kml", "kmz", "topojson", "flatgeobuf", "geopackage"}
+)
 
-	var reader gio.FeatureReader
-	var err error
+// openReader opens a source file, or standard input for "-". Compressed
+// sources are decompressed, and the format is detected from the content
+// unless it is set with --input-format. Features outside of bound, if it is
+// not nil, may be left out.
+func openReader(filename string, bound *orb.Bound) (gio.FeatureReader, error) {
+	if filename == "-" {
+		return newReader(filename, os.Stdin, bound)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := newReader(filename, file, bound)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileReader{reader, file}, nil
+}
 
-	filename := (*src).Name()
-	if strings.HasSuffix(filename, ".zip") || strings.HasSuffix(filename, ".shp") {
-		reader, err = gio.NewShapefileReader(filename)
-	} else {
-		var input io.Reader = *src
-		if strings.HasSuffix(filename, ".gz") {
-			input, _ = gzip.NewReader(input)
-			filename = filename[:len(filename)-len(".gz")]
+// fileReader closes its source file once it is read
+type fileReader struct {
+	gio.FeatureReader
+	file *os.File
+}
+
+func (f *fileReader) Read(ctx context.Context, out chan *gio.Feature) error {
+	defer f.file.Close()
+	return f.FeatureReader.Read(ctx, out)
+}
+
+// newReader returns the reader of a source file that is open
+func newReader(filename string, file *os.File, bound *orb.Bound) (gio.FeatureReader, error) {
+	input, compressions, err := gio.Decompress(file)
+	if err != nil {
+		return nil, err
+	}
+	detected := gio.DetectReader(input)
+	format := detected
+	if *inputFormat != "" {
+		format = *inputFormat
+	}
+	switch format {
+	case "geojson":
+		return gio.NewGeoJSONReader(input)
+	case "geojsonseq", "ndjson":
+		return gio.NewGeoJSONSeqReader(input)
+	case "shapefile", "zip", "kmz":
+		if file != os.Stdin && len(compressions) == 0 {
+			return openArchive(filename)
+		}
+		if detected != "zip" {
+			return nil, errors.New("a shapefile read from standard input or a compressed file must be zipped")
+		}
+		return spoolArchive(input)
+	case "geopackage":
+		if file != os.Stdin && len(compressions) == 0 {
+			reader, err := newGeoPackageReader(filename)
+			if err != nil {
+				return nil, err
+			}
+			reader.BBox = bound
+			return reader, nil
 		}
-		if strings.HasSuffix(filename, ".bz2") {
-			input = bzip2.NewReader(input)
-			filename = filename[:len(filename)-len(".bz2")]
+		return spoolArchive(input)
+	case "kml":
+		reader, err := gio.NewKMLReader(input)
+		if err != nil {
+			return nil, err
+		}
+		reader.FolderProperty = *layerProp
+		return reader, nil
+	case "topojson":
+		reader, err := gio.NewTopoJSONReader(input, *layers...)
+		if err != nil {
+			return nil, err
+		}
+		reader.LayerProperty = *layerProp
+		return reader, nil
+	case "flatgeobuf":
+		var source io.Reader = input
+		if file != os.Stdin && len(compressions) == 0 {
+			// The file itself lets the reader seek to the features in bound
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			source = file
+		}
+		reader, err := gio.NewFlatGeobufReader(source)
+		if err != nil {
+			return nil, err
+		}
+		reader.BBox = bound
+		reader.LayerProperty = *layerProp
+		return reader, nil
+	case "gpx":
+		var names []string
+		if *gpxLayers != "" {
+			names = strings.Split(*gpxLayers, ",")
+		}
+		reader, err := gio.NewGPXReader(input, names...)
+		if err != nil {
+			return nil, err
+		}
+		reader.LayerProperty = *layerProp
+		return reader, nil
+	case "csv", "tsv":
+		reader, err := gio.NewCSVReader(input)
+		if err != nil {
+			return nil, err
+		}
+		if format == "tsv" {
+			reader.Comma = '\t'
 		}
-		if strings.HasSuffix(filename, ".geojson") {
-			reader, err = gio.NewGeoJSONReader(input)
+		if *delimiter != "" {
+			if reader.Comma, err = delimiterRune(*delimiter); err != nil {
+				return nil, err
+			}
 		}
+		reader.LazyQuotes = *lazyQuotes
+		reader.GeometryColumn = *geomColumn
+		reader.LonColumn = *lonColumn
+		reader.LatColumn = *latColumn
+		return reader, nil
+	}
+	return nil, errors.New("unsupported source format")
+}
+
+// delimiterRune parses the --delimiter flag, which is a single character or
+// "tab"
+func delimiterRune(s string) (rune, error) {
+	if s == "tab" || s == `\t` {
+		return '\t', nil
 	}
+	runes := []rune(s)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("invalid delimiter %q", s)
+	}
+	return runes[0], nil
+}
+
+func newShapefileReader(filename string) (*gio.ShapefileReader, error) {
+	reader, err := gio.NewShapefileReader(filename, *layers...)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	features := make(chan map[string]interface{})
-	go func() {
-		defer close(features)
-		err := reader.Read(features)
+	reader.MeasureProperty = *mProperty
+	reader.LayerProperty = *layerProp
+	reader.Reproject = *reproject
+	if *reproject {
+		for _, warning := range reader.Warnings() {
+			fmt.Fprintf(os.Stderr, "xgeo: warning: %s\n", warning)
+		}
+	}
+	if *encoding != "" {
+		if reader.Encoding, err = gio.LookupEncoding(*encoding); err != nil {
+			return nil, err
+		}
+	}
+	return reader, nil
+}
+
+func newGeoPackageReader(filename string) (*gio.GeoPackageReader, error) {
+	reader, err := gio.NewGeoPackageReader(filename, *layers...)
+	if err != nil {
+		return nil, err
+	}
+	reader.LayerProperty = *layerProp
+	return reader, nil
+}
+
+// openArchive opens a GeoPackage, a KMZ archive, or a shapefile that may be
+// zipped
+func openArchive(filename string) (gio.FeatureReader, error) {
+	if gio.IsGeoPackage(filename) {
+		return newGeoPackageReader(filename)
+	}
+	if gio.IsKMZ(filename) {
+		reader, err := gio.NewKMZReader(filename)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-	}()
+		reader.FolderProperty = *layerProp
+		return reader, nil
+	}
+	return newShapefileReader(filename)
+}
 
-	for feature := range features {
-		if feature == nil {
-			continue
+// spoolArchive copies a GeoPackage, a KMZ archive or a zipped shapefile to a
+// temporary file, since none of them can be read in a single pass
+func spoolArchive(input io.Reader) (gio.FeatureReader, error) {
+	tmp, err := ioutil.TempFile("", "xgeo")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(tmp, input)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	var reader gio.FeatureReader
+	if err == nil {
+		reader, err = openArchive(tmp.Name())
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &spooledReader{reader, tmp.Name()}, nil
+}
+
+// spooledReader removes the temporary copy of its source once it is read
+type spooledReader struct {
+	gio.FeatureReader
+	filename string
+}
+
+func (s *spooledReader) Read(ctx context.Context, out chan *gio.Feature) error {
+	defer os.Remove(s.filename)
+	return s.FeatureReader.Read(ctx, out)
+}
+
+// outputFormat infers the output format from a file extension, defaulting to
+// a GeoJSON FeatureCollection
+func outputFormat(filename string) string {
+	switch path.Ext(filename) {
+	case ".geojsons", ".geojsonseq":
+		return "geojsonseq"
+	case ".geojsonl", ".ndjson", ".jsonl":
+		return "ndjson"
+	case ".shp", ".zip":
+		return "shapefile"
+	case ".csv":
+		return "csv"
+	case ".tsv", ".tab":
+		return "tsv"
+	case ".kml":
+		return "kml"
+	case ".kmz":
+		return "kmz"
+	case ".topojson":
+		return "topojson"
+	case ".fgb":
+		return "flatgeobuf"
+	case ".gpkg":
+		return "geopackage"
+	}
+	return "geojson"
+}
+
+func newWriter(format string, w io.Writer) (gio.FeatureWriter, error) {
+	switch format {
+	case "geojsonseq":
+		return gio.NewGeoJSONSeqWriter(w)
+	case "ndjson":
+		return gio.NewNDJSONWriter(w)
+	case "csv", "tsv":
+		newCSVWriter := gio.NewCSVWriter
+		if format == "tsv" {
+			newCSVWriter = gio.NewTSVWriter
+		}
+		writer, err := newCSVWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		if *delimiter != "" {
+			if writer.Comma, err = delimiterRune(*delimiter); err != nil {
+				return nil, err
+			}
+		}
+		if *geomColumn != "" {
+			writer.GeometryColumn = *geomColumn
+		}
+		writer.LonColumn = *lonColumn
+		writer.LatColumn = *latColumn
+		writer.QuoteAll = *quoteAll
+		if *csvColumns != "" {
+			writer.Columns = strings.Split(*csvColumns, ",")
+		}
+		return writer, nil
+	case "kml", "kmz":
+		newKMLWriter := gio.NewKMLWriter
+		if format == "kmz" {
+			newKMLWriter = gio.NewKMZWriter
+		}
+		writer, err := newKMLWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		writer.FolderProperty = *layerProp
+		writer.Style = *kmlStyle
+		return writer, nil
+	case "topojson":
+		writer, err := gio.NewTopoJSONWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		// Name the object after the output file, as topojson's geo2topo does
+		if name := outputLayerName(); name != "" {
+			writer.ObjectName = name
 		}
-		json, err := json.Marshal(feature)
+		writer.ObjectProperty = *layerProp
+		writer.Quantization = *quantize
+		return writer, nil
+	case "flatgeobuf":
+		writer, err := gio.NewFlatGeobufWriter(w)
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+		writer.Name = outputLayerName()
+		return writer, nil
+	}
+	return gio.NewGeoJSONWriter(w)
+}
+
+func newGeoPackageWriter(filename string) (*gio.GeoPackageWriter, error) {
+	writer, err := gio.NewGeoPackageWriter(filename)
+	if err != nil {
+		return nil, err
+	}
+	writer.TableProperty = *layerProp
+	writer.SpatialIndex = *gpkgIndex
+	return writer, nil
+}
+
+// outputLayerName returns the base name of the output file without its
+// extensions, or an empty string for standard output
+func outputLayerName() string {
+	if *output == "-" {
+		return ""
+	}
+	_, base := gio.CompressionFromExtension(path.Base(*output))
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// parseBound parses the --bbox flag
+func parseBound(s string) (*orb.Bound, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid bounding box %q, expected minx,miny,maxx,maxy", s)
+	}
+	var v [4]float64
+	for i, part := range parts {
+		var err error
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+			return nil, fmt.Errorf("invalid bounding box %q, expected minx,miny,maxx,maxy", s)
+		}
+	}
+	if v[0] > v[2] || v[1] > v[3] {
+		return nil, fmt.Errorf("invalid bounding box %q, its minimum exceeds its maximum", s)
+	}
+	return &orb.Bound{Min: orb.Point{v[0], v[1]}, Max: orb.Point{v[2], v[3]}}, nil
+}
+
+// intersecting returns a stage that keeps the features whose geometry
+// intersects a bound
+func intersecting(bound orb.Bound) func(context.Context, chan *gio.Feature, chan *gio.Feature) error {
+	return func(ctx context.Context, in chan *gio.Feature, out chan *gio.Feature) error {
+		for feature := range in {
+			if feature == nil || feature.Geometry == nil || !feature.Geometry.Bound().Intersects(bound) {
+				continue
+			}
+			if err := gio.Emit(ctx, out, feature); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// stdioArgs rewrites the "-" arguments, such as those that stand for standard
+// input and output, which kingpin would otherwise take for a flag
+func stdioArgs(args []string) []string {
+	var result []string
+	for i, arg := range args {
+		if arg != "-" {
+			result = append(result, arg)
+		} else if flag := valueFlag(args[:i]); flag != nil {
+			result[len(result)-1] = "--" + flag.Name + "=-"
+		}
+		// Otherwise it is the source, which defaults to standard input
+	}
+	return result
+}
+
+// valueFlag returns the flag that the last of args names if it takes a value,
+// or nil
+func valueFlag(args []string) *kingpin.FlagModel {
+	if len(args) == 0 {
+		return nil
+	}
+	arg := args[len(args)-1]
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		if flag.IsBoolFlag() {
+			continue
+		}
+		if arg == "--"+flag.Name || (flag.Short != 0 && arg == "-"+string(flag.Short)) {
+			return flag
 		}
-		fmt.Println(string(json))
+	}
+	return nil
+}
+
+// runStage runs a processing stage in its own goroutine, and returns the
+// channel of the features that it sends. Its error is sent to errs once it
+// returns.
+func runStage(ctx context.Context, in chan *gio.Feature, run func(context.Context, chan *gio.Feature, chan *gio.Feature) error, errs chan error) chan *gio.Feature {
+	out := make(chan *gio.Feature)
+	go func() {
+		defer close(out)
+		errs <- run(ctx, in, out)
+	}()
+	return out
+}
+
+func main() {
+	kingpin.MustParse(kingpin.CommandLine.Parse(stdioArgs(os.Args[1:])))
+
+	if compression, _ := gio.CompressionFromExtension(*output); compression == "bzip2" {
+		kingpin.Fatalf("%s: bzip2 is only supported for input, use .gz, .xz or .zst instead", *output)
+	}
+
+	filename := *src
+	if filename == "-" {
+		filename = "standard input"
+	}
+	var bound *orb.Bound
+	if *bbox != "" {
+		var err error
+		bound, err = parseBound(*bbox)
+		kingpin.FatalIfError(err, "--bbox")
+	}
+	reader, err := openReader(*src, bound)
+	kingpin.FatalIfError(err, "%s", filename)
+	if *listLayers {
+		source := reader
+		if opened, ok := source.(*fileReader); ok {
+			source = opened.FeatureReader
+			opened.file.Close()
+		}
+		if spooled, ok := source.(*spooledReader); ok {
+			source = spooled.FeatureReader
+			os.Remove(spooled.filename)
+		}
+		layered, ok := source.(interface{ Layers() []string })
+		if !ok {
+			kingpin.Fatalf("%s: source has no layers", filename)
+		}
+		for _, layer := range layered.Layers() {
+			fmt.Println(layer)
+		}
+		return
+	}
+
+	// Cancelling ctx stops the reader and the script, e.g. once the limit is
+	// reached or if the writer fails
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	features := make(chan *gio.Feature)
+	readErr := make(chan error, 1)
+	go func(out chan *gio.Feature) {
+		defer close(out)
+		readErr <- reader.Read(ctx, out)
+	}(features)
+
+	// Features are filtered by --bbox as they are read, then Plus Codes are
+	// decoded and encoded before the script runs. FlatGeobuf readers and
+	// GeoPackage readers of files filter features themselves.
+	stageErrs := make(chan error, 3)
+	filtered := false
+	switch reader.(type) {
+	case *gio.FlatGeobufReader, *gio.GeoPackageReader:
+		filtered = true
+	}
+	if bound != nil && !filtered {
+		features = runStage(ctx, features, intersecting(*bound), stageErrs)
+	} else {
+		stageErrs <- nil
+	}
+	if *fromPlus != "" {
+		features = runStage(ctx, features, pluscode.NewDecoder(*fromPlus, *plusCell).Run, stageErrs)
+	} else {
+		stageErrs <- nil
+	}
+	if *plusCode != "" {
+		encoder, err := pluscode.NewEncoder(*plusCode, *plusLength)
+		kingpin.FatalIfError(err, "--plus-code-length")
+		features = runStage(ctx, features, encoder.Run, stageErrs)
+	} else {
+		stageErrs <- nil
+	}
+
+	scriptErr := make(chan error, 1)
+	if *scriptFile != "" {
+		s, err := script.NewScript(*scriptFile)
+		kingpin.FatalIfError(err, "%s", *scriptFile)
+		features = runStage(ctx, features, s.Run, scriptErr)
+	} else {
+		scriptErr <- nil
+	}
+
+	if *limit > 0 {
+		in := features
+		features = make(chan *gio.Feature)
+		go func(out chan *gio.Feature) {
+			defer close(out)
+			defer cancel()
+			for n := 0; n < *limit; n++ {
+				feature, ok := <-in
+				if !ok || gio.Emit(ctx, out, feature) != nil {
+					return
+				}
+			}
+		}(features)
+	}
+
+	outputName := *output
+	if outputName == "-" {
+		outputName = "standard output"
+	}
+	compression, base := gio.CompressionFromExtension(*output)
+	if *format == "" {
+		*format = outputFormat(base)
+	}
+	var writer gio.FeatureWriter
+	var out *os.File
+	var compressed io.WriteCloser
+	if *format == "shapefile" {
+		if *output == "-" {
+			kingpin.Fatalf("shapefile output requires an --output file")
+		}
+		if compression != "" {
+			kingpin.Fatalf("%s: shapefile output cannot be compressed, use a .zip file instead", *output)
+		}
+		writer, err = gio.NewShapefileWriter(*output)
+	} else if *format == "geopackage" {
+		if *output == "-" {
+			kingpin.Fatalf("GeoPackage output requires an --output file")
+		}
+		if compression != "" {
+			kingpin.Fatalf("%s: GeoPackage output cannot be compressed", *output)
+		}
+		writer, err = newGeoPackageWriter(*output)
+	} else {
+		out = os.Stdout
+		if *output != "-" {
+			out, err = os.Create(*output)
+			kingpin.FatalIfError(err, "%s", outputName)
+		}
+		var w io.Writer = out
+		if compression != "" {
+			compressed, err = gio.Compress(out, compression)
+			kingpin.FatalIfError(err, "%s", outputName)
+			w = compressed
+		}
+		writer, err = newWriter(*format, w)
+	}
+	kingpin.FatalIfError(err, "%s", outputName)
+	err = writer.Write(features)
+	cancel()
+	kingpin.FatalIfError(err, "%s", outputName)
+	if compressed != nil {
+		kingpin.FatalIfError(compressed.Close(), "%s", outputName)
+	}
+	if err := <-readErr; err != context.Canceled {
+		kingpin.FatalIfError(err, "%s", filename)
+	}
+	for i := 0; i < cap(stageErrs); i++ {
+		if err := <-stageErrs; err != context.Canceled {
+			kingpin.FatalIfError(err, "%s", filename)
+		}
+	}
+	if err := <-scriptErr; err != context.Canceled {
+		kingpin.FatalIfError(err, "%s", *scriptFile)
+	}
+	if out != nil {
+		kingpin.FatalIfError(out.Close(), "%s", outputName)
 	}
 }
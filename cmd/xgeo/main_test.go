@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStdioArgs(t *testing.T) {
+	tests := []struct {
+		args, want []string
+	}{
+		{[]string{"-"}, nil},
+		{[]string{"-", "-o", "-"}, []string{"--output=-"}},
+		{[]string{"--output", "-", "in.csv"}, []string{"--output=-", "in.csv"}},
+		{[]string{"--delimiter", "-", "-"}, []string{"--delimiter=-"}},
+		{[]string{"-", "--columns", "-", "--format", "csv"}, []string{"--columns=-", "--format", "csv"}},
+		// Boolean flags take no value
+		{[]string{"--quote-all", "-", "--output=out.csv"}, []string{"--quote-all", "--output=out.csv"}},
+		{[]string{"--delimiter=-", "-"}, []string{"--delimiter=-"}},
+	}
+	for _, test := range tests {
+		if got := stdioArgs(test.args); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.args, got, test.want)
+		}
+	}
+}
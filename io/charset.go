@@ -0,0 +1,187 @@
+package io
+
+import (
+	"fmt"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// UTF8 is returned by LookupEncoding for UTF-8, which needs no conversion
+var UTF8 encoding.Encoding = encoding.Nop
+
+var encodings = map[string]encoding.Encoding{
+	"utf8":        UTF8,
+	"437":         charmap.CodePage437,
+	"850":         charmap.CodePage850,
+	"852":         charmap.CodePage852,
+	"855":         charmap.CodePage855,
+	"858":         charmap.CodePage858,
+	"860":         charmap.CodePage860,
+	"862":         charmap.CodePage862,
+	"863":         charmap.CodePage863,
+	"865":         charmap.CodePage865,
+	"866":         charmap.CodePage866,
+	"874":         charmap.Windows874,
+	"1250":        charmap.Windows1250,
+	"1251":        charmap.Windows1251,
+	"1252":        charmap.Windows1252,
+	"1253":        charmap.Windows1253,
+	"1254":        charmap.Windows1254,
+	"1255":        charmap.Windows1255,
+	"1256":        charmap.Windows1256,
+	"1257":        charmap.Windows1257,
+	"1258":        charmap.Windows1258,
+	"88591":       charmap.ISO8859_1,
+	"88592":       charmap.ISO8859_2,
+	"88593":       charmap.ISO8859_3,
+	"88594":       charmap.ISO8859_4,
+	"88595":       charmap.ISO8859_5,
+	"88596":       charmap.ISO8859_6,
+	"88597":       charmap.ISO8859_7,
+	"88598":       charmap.ISO8859_8,
+	"88599":       charmap.ISO8859_9,
+	"885910":      charmap.ISO8859_10,
+	"885913":      charmap.ISO8859_13,
+	"885914":      charmap.ISO8859_14,
+	"885915":      charmap.ISO8859_15,
+	"885916":      charmap.ISO8859_16,
+	"latin1":      charmap.ISO8859_1,
+	"koi8r":       charmap.KOI8R,
+	"koi8u":       charmap.KOI8U,
+	"macintosh":   charmap.Macintosh,
+	"932":         japanese.ShiftJIS,
+	"shiftjis":    japanese.ShiftJIS,
+	"sjis":        japanese.ShiftJIS,
+	"windows31j":  japanese.ShiftJIS,
+	"eucjp":       japanese.EUCJP,
+	"936":         simplifiedchinese.GBK,
+	"gbk":         simplifiedchinese.GBK,
+	"gb2312":      simplifiedchinese.GBK,
+	"gb18030":     simplifiedchinese.GB18030,
+	"949":         korean.EUCKR,
+	"euckr":       korean.EUCKR,
+	"950":         traditionalchinese.Big5,
+	"big5":        traditionalchinese.Big5,
+	"65001":       UTF8,
+	"unicode":     UTF8,
+	"unicodeutf8": UTF8,
+}
+
+// Language driver IDs found at offset 29 of a DBF header
+var languageDrivers = map[byte]encoding.Encoding{
+	0x01: charmap.CodePage437,
+	0x02: charmap.CodePage850,
+	0x03: charmap.Windows1252,
+	0x08: charmap.CodePage865,
+	0x09: charmap.CodePage437,
+	0x0a: charmap.CodePage850,
+	0x0b: charmap.CodePage437,
+	0x0d: charmap.CodePage437,
+	0x0e: charmap.CodePage850,
+	0x0f: charmap.CodePage437,
+	0x10: charmap.CodePage850,
+	0x11: charmap.CodePage437,
+	0x12: charmap.CodePage850,
+	0x13: japanese.ShiftJIS,
+	0x14: charmap.CodePage850,
+	0x15: charmap.CodePage437,
+	0x16: charmap.CodePage850,
+	0x17: charmap.CodePage865,
+	0x18: charmap.CodePage437,
+	0x19: charmap.CodePage437,
+	0x1a: charmap.CodePage850,
+	0x1b: charmap.CodePage437,
+	0x1c: charmap.CodePage863,
+	0x1d: charmap.CodePage850,
+	0x1f: charmap.CodePage852,
+	0x22: charmap.CodePage852,
+	0x23: charmap.CodePage852,
+	0x24: charmap.CodePage860,
+	0x25: charmap.CodePage850,
+	0x26: charmap.CodePage866,
+	0x37: charmap.CodePage850,
+	0x40: charmap.CodePage852,
+	0x4d: simplifiedchinese.GBK,
+	0x4e: korean.EUCKR,
+	0x4f: traditionalchinese.Big5,
+	0x50: charmap.Windows874,
+	0x57: charmap.Windows1252,
+	0x58: charmap.Windows1252,
+	0x59: charmap.Windows1252,
+	0x64: charmap.CodePage852,
+	0x65: charmap.CodePage866,
+	0x66: charmap.CodePage865,
+	0x78: traditionalchinese.Big5,
+	0x79: korean.EUCKR,
+	0x7a: simplifiedchinese.GBK,
+	0x7b: japanese.ShiftJIS,
+	0x7c: charmap.Windows874,
+	0x7d: charmap.Windows1255,
+	0x7e: charmap.Windows1256,
+	0x96: charmap.MacintoshCyrillic,
+	0xc8: charmap.Windows1250,
+	0xc9: charmap.Windows1251,
+	0xca: charmap.Windows1254,
+	0xcb: charmap.Windows1253,
+	0xcc: charmap.Windows1257,
+}
+
+// LookupEncoding returns the character encoding for a code page name as found
+// in .cpg files, e.g. "UTF-8", "1252", "ANSI 1251", "ISO-8859-2", "CP932" or
+// "Shift_JIS"
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	key := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+	if enc, ok := encodings[key]; ok {
+		return enc, nil
+	}
+	for _, prefix := range []string{"iso", "windows", "cp", "ansi", "oem", "ibm"} {
+		if enc, ok := encodings[strings.TrimPrefix(key, prefix)]; ok && strings.HasPrefix(key, prefix) {
+			return enc, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown character encoding %q", name)
+}
+
+// decodeString converts a string in the given encoding to valid UTF-8. Without
+// a declared encoding, strings that are not valid UTF-8 are assumed to be
+// Windows-1252, the most common encoding of shapefiles without a code page.
+func decodeString(enc encoding.Encoding, s string) string {
+	if enc == nil {
+		if utf8.ValidString(s) {
+			return s
+		}
+		enc = charmap.Windows1252
+	}
+	if enc != UTF8 {
+		decoded, err := enc.NewDecoder().String(s)
+		if err == nil {
+			return decoded
+		}
+	}
+	return toValidUTF8(s)
+}
+
+func toValidUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteRune(r)
+		i += size
+	}
+	return b.String()
+}
@@ -0,0 +1,153 @@
+package io
+
+import (
+	"github.com/jonas-p/go-shp"
+	"github.com/paulmach/orb"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookupEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		want encoding.Encoding
+	}{
+		{"UTF-8", UTF8},
+		{"utf8", UTF8},
+		{"65001", UTF8},
+		{"1252", charmap.Windows1252},
+		{"ANSI 1251", charmap.Windows1251},
+		{"Windows-1250", charmap.Windows1250},
+		{"CP1257", charmap.Windows1257},
+		{"ISO-8859-2", charmap.ISO8859_2},
+		{"iso 8859 15", charmap.ISO8859_15},
+		{"88591", charmap.ISO8859_1},
+		{"OEM 866", charmap.CodePage866},
+		{"IBM437", charmap.CodePage437},
+		{"KOI8-R", charmap.KOI8R},
+		{"CP932", japanese.ShiftJIS},
+		{"Shift_JIS", japanese.ShiftJIS},
+		{"GB2312", simplifiedchinese.GBK},
+		{"big5", traditionalchinese.Big5},
+	}
+	for _, test := range tests {
+		got, err := LookupEncoding(test.name)
+		if err != nil || got != test.want {
+			t.Errorf("%q: got %v, %v, want %v", test.name, got, err, test.want)
+		}
+	}
+	for _, name := range []string{"", "EBCDIC", "cp", "iso", "1252x", "windows-utf8x"} {
+		if enc, err := LookupEncoding(name); err == nil {
+			t.Errorf("%q: got %v", name, enc)
+		}
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xgeo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name string
+		// ldid is the language driver ID of the DBF header
+		ldid byte
+		// cpg is the content of the .cpg file, if any
+		cpg  string
+		want encoding.Encoding
+	}{
+		{"no code page", 0, "", nil},
+		{"ldid", 0x57, "", charmap.Windows1252},
+		{"ldid 866", 0x26, "", charmap.CodePage866},
+		{"unknown ldid", 0xff, "", nil},
+		{"cpg", 0, "UTF-8\r\n", UTF8},
+		{"cpg over ldid", 0xc9, "1250", charmap.Windows1250},
+		{"unknown cpg", 0xc9, "EBCDIC", charmap.Windows1251},
+	}
+	for _, test := range tests {
+		base := filepath.Join(dir, strings.Replace(test.name, " ", "_", -1))
+		header := make([]byte, 32)
+		header[29] = test.ldid
+		if err := ioutil.WriteFile(base+".dbf", header, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if test.cpg != "" {
+			if err := ioutil.WriteFile(base+".cpg", []byte(test.cpg), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		source := &shapefileSource{base: base}
+		if got := source.detectEncoding(); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDecodeString(t *testing.T) {
+	tests := []struct {
+		enc  encoding.Encoding
+		s    string
+		want string
+	}{
+		{nil, "plain", "plain"},
+		{nil, "caf\xc3\xa9", "café"},
+		// Without an encoding, invalid UTF-8 is read as Windows-1252
+		{nil, "caf\xe9 \x80", "café €"},
+		{UTF8, "caf\xc3\xa9", "café"},
+		{UTF8, "caf\xe9", "caf�"},
+		{charmap.Windows1252, "caf\xc3\xa9", "cafÃ©"},
+		{charmap.Windows1251, "\xcc\xee\xf1\xea\xe2\xe0", "Москва"},
+		{charmap.CodePage437, "\x82t\x82", "été"},
+		{japanese.ShiftJIS, "\x93\x8c\x8b\x9e", "東京"},
+	}
+	for _, test := range tests {
+		if got := decodeString(test.enc, test.s); got != test.want {
+			t.Errorf("%v %q: got %q, want %q", test.enc, test.s, got, test.want)
+		}
+	}
+}
+
+func TestShapefileReaderEncoding(t *testing.T) {
+	fields := []shp.Field{{Fieldtype: 'C', Size: 6}}
+	copy(fields[0].Name[:], "na\xefve")
+	records := [][]string{{"\xcc\xee\xf1\xea\xe2\xe0"}}
+	filename, _ := writeShapefile(t, "encoded.shp", []*Feature{NewFeature(orb.Point{1, 2})})
+	defer os.RemoveAll(filepath.Dir(filename))
+	base := strings.TrimSuffix(filename, ".shp")
+	os.Remove(base + ".cpg")
+	dbf := dbfFile(fields, records)
+	for _, test := range []struct {
+		ldid      byte
+		key, want string
+	}{
+		// Field names are in the encoding of the layer as well
+		{0xc9, "naпve", "Москва"},
+		// Windows-1252 by default
+		{0, "naïve", "Ìîñêâà"},
+	} {
+		dbf[29] = test.ldid
+		if err := ioutil.WriteFile(base+".dbf", dbf, 0644); err != nil {
+			t.Fatal(err)
+		}
+		reader, err := NewShapefileReader(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := readAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(read) != 1 || len(read[0].Properties) != 1 || read[0].Properties[0] != (Property{test.key, test.want}) {
+			t.Errorf("LDID %#x: got %v", test.ldid, read[0].Properties)
+		}
+	}
+}
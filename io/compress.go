@@ -0,0 +1,87 @@
+package io
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+var compressionExtensions = map[string]string{
+	".gz":  "gzip",
+	".bz2": "bzip2",
+	".xz":  "xz",
+	".zst": "zstd",
+}
+
+// Decompress detects the compression of an input from its first bytes and
+// returns a reader of its decompressed content, along with the compression
+// formats found, outermost first. Input that is compressed more than once is
+// decompressed completely. The returned reader is buffered so that the format
+// of the content can be detected with DetectReader.
+func Decompress(r io.Reader) (*bufio.Reader, []string, error) {
+	input := bufio.NewReaderSize(r, DetectLength)
+	var compressions []string
+	for {
+		// Compressions are told apart by magic numbers of at most 6 bytes,
+		// and waiting for more would hold up a slow source
+		header, err := input.Peek(6)
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		var decompressed io.Reader
+		compression := DetectFormat(header)
+		switch compression {
+		case "gzip":
+			decompressed, err = gzip.NewReader(input)
+		case "bzip2":
+			decompressed = bzip2.NewReader(input)
+		case "xz":
+			decompressed, err = xz.NewReader(input)
+		case "zstd":
+			var dec *zstd.Decoder
+			dec, err = zstd.NewReader(input, zstd.WithDecoderConcurrency(1))
+			if err == nil {
+				decompressed = dec.IOReadCloser()
+			}
+		default:
+			return input, compressions, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", compression, err)
+		}
+		compressions = append(compressions, compression)
+		input = bufio.NewReaderSize(decompressed, DetectLength)
+	}
+}
+
+// CompressionFromExtension returns the compression format implied by the
+// extension of a filename, such as gzip for .gz, and the filename without
+// that extension. The compression is empty for other extensions.
+func CompressionFromExtension(filename string) (string, string) {
+	ext := filepath.Ext(filename)
+	if compression, ok := compressionExtensions[strings.ToLower(ext)]; ok {
+		return compression, strings.TrimSuffix(filename, ext)
+	}
+	return "", filename
+}
+
+// Compress returns a writer that compresses its input to w. It must be closed
+// to flush the compressed stream, which does not close w. There is no bzip2
+// encoder, so bzip2 is only supported by Decompress.
+func Compress(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "xz":
+		return xz.NewWriter(w)
+	case "zstd":
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("%s compression is not supported for output", compression)
+}
@@ -0,0 +1,128 @@
+package io
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// compress compresses data with each compression in turn
+func compress(t *testing.T, data []byte, compressions ...string) []byte {
+	for _, compression := range compressions {
+		var buf bytes.Buffer
+		w, err := Compress(&buf, compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data = buf.Bytes()
+	}
+	return data
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat(`{"type":"Point","coordinates":[1,2]}`+"\n", 1000))
+	tests := [][]string{
+		nil,
+		{"gzip"},
+		{"xz"},
+		{"zstd"},
+		// Compressed twice, the outermost last
+		{"zstd", "gzip"},
+		{"gzip", "xz"},
+	}
+	for _, compressions := range tests {
+		compressed := compress(t, data, compressions...)
+		if len(compressions) > 0 && bytes.Equal(compressed, data) {
+			t.Errorf("%v: data is unchanged", compressions)
+		}
+		r, found, err := Decompress(bytes.NewReader(compressed))
+		if err != nil {
+			t.Errorf("%v: %v", compressions, err)
+			continue
+		}
+		// Outermost first
+		var want []string
+		for i := len(compressions) - 1; i >= 0; i-- {
+			want = append(want, compressions[i])
+		}
+		if !reflect.DeepEqual(found, want) {
+			t.Errorf("%v: found %v, want %v", compressions, found, want)
+		}
+		if got, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%v: read %d bytes, %v, want %d", compressions, len(got), err, len(data))
+		}
+	}
+}
+
+func TestDecompressBzip2(t *testing.T) {
+	// Written by Python's bz2 module, as Go has no bzip2 encoder
+	compressed, _ := hex.DecodeString("425a68393141592653596eefe542000011db80001010043010400a2e21dc2a2000231a7a2369910f47942800c469a68d0eb1a5f0d55e69cd1a1007c81c40432862f60762ee48a70a120dddfca840")
+	r, found, err := Decompress(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(found, []string{"bzip2"}) {
+		t.Errorf("found %v", found)
+	}
+	if got, err := ioutil.ReadAll(r); err != nil || string(got) != `{"type":"Point","coordinates":[1,2]}`+"\n" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestDecompressShort(t *testing.T) {
+	for _, input := range []string{"", "{", "\x1f\x8b"} {
+		r, found, err := Decompress(strings.NewReader(input))
+		if input == "\x1f\x8b" {
+			// A gzip header cut short
+			if err == nil {
+				t.Errorf("%q: no error", input)
+			}
+			continue
+		}
+		if err != nil || len(found) != 0 {
+			t.Errorf("%q: found %v, %v", input, found, err)
+			continue
+		}
+		if got, _ := ioutil.ReadAll(r); string(got) != input {
+			t.Errorf("%q: got %q", input, got)
+		}
+	}
+}
+
+func TestCompressBzip2(t *testing.T) {
+	if w, err := Compress(&bytes.Buffer{}, "bzip2"); err == nil || w != nil {
+		t.Errorf("got %v, %v, want an error", w, err)
+	}
+	if _, err := Compress(&bytes.Buffer{}, "lz4"); err == nil {
+		t.Error("compressed with lz4")
+	}
+}
+
+func TestCompressionFromExtension(t *testing.T) {
+	tests := []struct {
+		filename, compression, base string
+	}{
+		{"out.geojson.gz", "gzip", "out.geojson"},
+		{"dir/out.csv.XZ", "xz", "dir/out.csv"},
+		{"out.fgb.zst", "zstd", "out.fgb"},
+		{"out.json.bz2", "bzip2", "out.json"},
+		{"out.geojson", "", "out.geojson"},
+		{"out.gz.json", "", "out.gz.json"},
+		{"-", "", "-"},
+	}
+	for _, test := range tests {
+		compression, base := CompressionFromExtension(test.filename)
+		if compression != test.compression || base != test.base {
+			t.Errorf("%s: got %q and %q", test.filename, compression, base)
+		}
+	}
+}
@@ -0,0 +1,589 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/stationa/xgeo/io/wkb"
+	"github.com/stationa/xgeo/io/wkt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Column names that are taken for geometries when reading CSV, in order of
+// preference
+var (
+	csvGeometryColumns = []string{"geometry", "geom", "the_geom", "wkb_geometry", "wkt", "wkb", "geojson", "shape"}
+	csvLonColumns      = []string{"lon", "lng", "long", "longitude", "x"}
+	csvLatColumns      = []string{"lat", "latitude", "y"}
+)
+
+// csvDelimiters are the delimiters that CSVReader detects
+const csvDelimiters = ",\t;|"
+
+// csvInferRows is the number of rows from which the type of each column is
+// inferred
+const csvInferRows = 1000
+
+// CSVReader reads features from delimited text whose first row names the
+// columns. The geometry of each feature is read from a column of WKT,
+// hex-encoded WKB or GeoJSON geometries, or from a pair of longitude and
+// latitude columns. The other columns become properties. Columns whose values
+// in the first 1000 rows are all numbers or all booleans are converted from
+// text, and empty values are read as null.
+type CSVReader struct {
+	input io.Reader
+
+	// Comma is the field delimiter. If zero, it is detected from the header
+	// row among commas, tabs, semicolons and pipes.
+	Comma rune
+
+	// LazyQuotes allows quotes in unquoted fields and unescaped quotes in
+	// quoted fields, as produced by some spreadsheet exports. It is implied
+	// for tab separated values, which are rarely quoted.
+	LazyQuotes bool
+
+	// GeometryColumn is the column of WKT, WKB or GeoJSON geometries.
+	// LonColumn and LatColumn are the columns of point coordinates, used if
+	// GeometryColumn is empty. If none is set, they are detected from the
+	// column names, such as geometry, wkt, lon and lat.
+	GeometryColumn string
+	LonColumn      string
+	LatColumn      string
+}
+
+func NewCSVReader(input io.Reader) (*CSVReader, error) {
+	return &CSVReader{
+		input: input,
+	}, nil
+}
+
+func (c *CSVReader) Read(ctx context.Context, out chan *Feature) error {
+	input := bufio.NewReaderSize(c.input, ParseBufferSize)
+	r := csv.NewReader(input)
+	r.Comma = c.Comma
+	if r.Comma == 0 {
+		header, _ := input.Peek(ParseBufferSize)
+		r.Comma = detectDelimiter(header)
+	}
+	r.LazyQuotes = c.LazyQuotes || r.Comma == '\t'
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	columns, err := c.geometryColumns(header)
+	if err != nil {
+		return err
+	}
+	// The first rows are kept to infer the column types, along with the line
+	// on which each starts for error messages
+	var records [][]string
+	var lines []int
+	var readErr error
+	for len(records) < csvInferRows {
+		record, err := r.Read()
+		if err != nil {
+			readErr = err
+			break
+		}
+		line, _ := r.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+	columns.inferTypes(header, records)
+
+	index := 0
+	emit := func(record []string, line int) error {
+		feature, err := columns.feature(header, record)
+		if err != nil {
+			return &ErrMalformedFeature{Index: index, Line: line, Err: err}
+		}
+		index++
+		return Emit(ctx, out, feature)
+	}
+	for i, record := range records {
+		if err := emit(record, lines[i]); err != nil {
+			return err
+		}
+	}
+	for readErr == nil {
+		var record []string
+		if record, readErr = r.Read(); readErr == nil {
+			line, _ := r.FieldPos(0)
+			if err := emit(record, line); err != nil {
+				return err
+			}
+		}
+	}
+	if parseErr, ok := readErr.(*csv.ParseError); ok {
+		return &ErrMalformedFeature{Index: index, Line: parseErr.Line, Err: parseErr.Err}
+	}
+	if readErr != io.EOF {
+		return readErr
+	}
+	return nil
+}
+
+// csvColumns are the indexes of the geometry columns of a CSV header, or -1,
+// and the types of all columns
+type csvColumns struct {
+	geometry, lon, lat int
+	types              []csvColumnType
+}
+
+type csvColumnType int
+
+const (
+	csvNull csvColumnType = iota
+	csvBool
+	csvInteger
+	csvReal
+	csvText
+)
+
+func (c *csvColumns) inferTypes(header []string, records [][]string) {
+	c.types = make([]csvColumnType, len(header))
+	for _, record := range records {
+		for i, text := range record {
+			if i < len(c.types) {
+				c.types[i] = mergeTypes(c.types[i], valueType(inferValue(text)))
+			}
+		}
+	}
+}
+
+func (c *CSVReader) geometryColumns(header []string) (*csvColumns, error) {
+	columns := &csvColumns{geometry: -1, lon: -1, lat: -1}
+	find := func(name string) (int, error) {
+		for i, column := range header {
+			if column == name {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("no column %q", name)
+	}
+	var err error
+	switch {
+	case c.GeometryColumn != "":
+		columns.geometry, err = find(c.GeometryColumn)
+		return columns, err
+	case c.LonColumn != "" || c.LatColumn != "":
+		if c.LonColumn == "" || c.LatColumn == "" {
+			return nil, fmt.Errorf("both a longitude and a latitude column are needed")
+		}
+		if columns.lon, err = find(c.LonColumn); err != nil {
+			return nil, err
+		}
+		columns.lat, err = find(c.LatColumn)
+		return columns, err
+	}
+	columns.geometry = findColumn(header, csvGeometryColumns)
+	if columns.geometry < 0 {
+		columns.lon = findColumn(header, csvLonColumns)
+		columns.lat = findColumn(header, csvLatColumns)
+		if columns.lon < 0 || columns.lat < 0 {
+			columns.lon, columns.lat = -1, -1
+		}
+	}
+	return columns, nil
+}
+
+// findColumn returns the index of the first of the names that is a column of
+// the header, ignoring case, or -1
+func findColumn(header []string, names []string) int {
+	for _, name := range names {
+		for i, column := range header {
+			if strings.EqualFold(strings.TrimSpace(column), name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (c *csvColumns) feature(header []string, record []string) (*Feature, error) {
+	feature := NewFeature(nil)
+	feature.Properties = make(Properties, 0, len(header))
+	for i, name := range header {
+		var value string
+		if i < len(record) {
+			value = record[i]
+		}
+		if i == c.geometry || i == c.lon || i == c.lat {
+			continue
+		}
+		feature.Properties = append(feature.Properties, Property{name, columnValue(value, c.types[i])})
+	}
+	if c.geometry >= 0 && c.geometry < len(record) {
+		g, z, m, err := decodeGeometryText(record[c.geometry])
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", header[c.geometry], err)
+		}
+		feature.Geometry, feature.Z, feature.M = g, z, m
+	}
+	if c.lon >= 0 && c.lon < len(record) && c.lat < len(record) {
+		lon, lat := strings.TrimSpace(record[c.lon]), strings.TrimSpace(record[c.lat])
+		if lon == "" && lat == "" {
+			return feature, nil
+		}
+		x, err := strconv.ParseFloat(lon, 64)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: invalid longitude %q", header[c.lon], lon)
+		}
+		y, err := strconv.ParseFloat(lat, 64)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: invalid latitude %q", header[c.lat], lat)
+		}
+		feature.Geometry = orb.Point{x, y}
+	}
+	return feature, nil
+}
+
+// decodeGeometryText decodes a geometry written as WKT, hex-encoded WKB or a
+// GeoJSON object. An empty string is a null geometry.
+func decodeGeometryText(text string) (orb.Geometry, []float64, []float64, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		return nil, nil, nil, nil
+	case text[0] == '{':
+		var geometry map[string]interface{}
+		if err := jsonConfig.UnmarshalFromString(text, &geometry); err != nil {
+			return nil, nil, nil, err
+		}
+		return DecodeGeometryZM(geometry)
+	case isHex(strings.TrimPrefix(text, `\x`)):
+		// PostgreSQL writes bytea columns in hex with a \x prefix
+		data, err := hex.DecodeString(strings.TrimPrefix(text, `\x`))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return wkb.Unmarshal(data)
+	}
+	return wkt.Unmarshal(text)
+}
+
+func isHex(s string) bool {
+	if len(s) < 10 || len(s)%2 != 0 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// inferValue converts CSV text to a number or a boolean when it is one.
+// Numbers with leading zeros, such as postal codes, are kept as text.
+func inferValue(text string) interface{} {
+	if text == "" {
+		return nil
+	}
+	switch text {
+	case "true", "TRUE", "True":
+		return true
+	case "false", "FALSE", "False":
+		return false
+	}
+	digits := strings.TrimLeft(text, "+-")
+	if digits == "" || strings.IndexFunc(digits, isNotNumeric) >= 0 {
+		return text
+	}
+	if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+		return text
+	}
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f
+	}
+	return text
+}
+
+func valueType(value interface{}) csvColumnType {
+	switch value.(type) {
+	case nil:
+		return csvNull
+	case bool:
+		return csvBool
+	case int64:
+		return csvInteger
+	case float64:
+		return csvReal
+	}
+	return csvText
+}
+
+func mergeTypes(a, b csvColumnType) csvColumnType {
+	switch {
+	case a == b || b == csvNull:
+		return a
+	case a == csvNull:
+		return b
+	case a == csvInteger && b == csvReal, a == csvReal && b == csvInteger:
+		return csvReal
+	}
+	return csvText
+}
+
+// columnValue converts CSV text to the type inferred for its column, keeping
+// the text if it is of another type
+func columnValue(text string, t csvColumnType) interface{} {
+	value := inferValue(text)
+	switch valueType(value) {
+	case csvNull, t:
+		return value
+	case csvInteger:
+		if t == csvReal {
+			return float64(value.(int64))
+		}
+	}
+	return text
+}
+
+func isNotNumeric(r rune) bool {
+	return !(r >= '0' && r <= '9' || r == '.' || r == 'e' || r == 'E' || r == '+' || r == '-')
+}
+
+// detectDelimiter returns the most frequent of the CSV delimiters outside of
+// quotes in the first line of text, or a comma if there are none
+func detectDelimiter(text []byte) rune {
+	if end := bytes.IndexByte(text, '\n'); end >= 0 {
+		text = text[:end]
+	}
+	counts := map[rune]int{}
+	quoted := false
+	for _, c := range string(text) {
+		if c == '"' {
+			quoted = !quoted
+		} else if !quoted && strings.ContainsRune(csvDelimiters, c) {
+			counts[c]++
+		}
+	}
+	best := ','
+	for _, c := range csvDelimiters {
+		if counts[c] > counts[best] {
+			best = c
+		}
+	}
+	return best
+}
+
+// CSVWriter writes features as delimited text with a header row. Geometries
+// are written as WKT, or as longitude and latitude columns for points.
+type CSVWriter struct {
+	output io.Writer
+
+	// Comma is the field delimiter
+	Comma rune
+
+	// QuoteAll quotes every field, instead of only those that contain the
+	// delimiter, quotes or line breaks
+	QuoteAll bool
+
+	// Columns are the properties written, in order. If nil, they are the
+	// properties of all features, in the order in which they first appear,
+	// so features are kept in memory until the input ends.
+	Columns []string
+
+	// GeometryColumn is the name of the WKT column. If LonColumn and
+	// LatColumn are set instead, point coordinates are written to those
+	// columns, and other geometries are an error. A property with the same
+	// name as a geometry column is written to a column with a numeric suffix,
+	// e.g. geometry_1.
+	GeometryColumn string
+	LonColumn      string
+	LatColumn      string
+}
+
+// NewCSVWriter returns a writer of comma separated values with a WKT column
+// named geometry
+func NewCSVWriter(output io.Writer) (*CSVWriter, error) {
+	return &CSVWriter{
+		output:         output,
+		Comma:          ',',
+		GeometryColumn: "geometry",
+	}, nil
+}
+
+// NewTSVWriter returns a writer of tab separated values with a WKT column
+// named geometry
+func NewTSVWriter(output io.Writer) (*CSVWriter, error) {
+	return &CSVWriter{
+		output:         output,
+		Comma:          '\t',
+		GeometryColumn: "geometry",
+	}, nil
+}
+
+func (c *CSVWriter) Write(in chan *Feature) error {
+	var w csvRecordWriter
+	if c.QuoteAll {
+		w = &quotedCSVWriter{w: bufio.NewWriter(c.output), comma: c.Comma}
+	} else {
+		csvWriter := csv.NewWriter(c.output)
+		csvWriter.Comma = c.Comma
+		w = csvWriter
+	}
+	lonLat := c.LonColumn != "" && c.LatColumn != ""
+	var header []string
+	if lonLat {
+		header = []string{c.LonColumn, c.LatColumn}
+	} else {
+		header = []string{c.GeometryColumn}
+	}
+
+	columns := c.Columns
+	var features []*Feature
+	if columns == nil {
+		columns = []string{}
+		known := map[string]bool{}
+		for feature := range in {
+			if feature == nil {
+				continue
+			}
+			for _, prop := range feature.Properties {
+				if !known[prop.Key] {
+					known[prop.Key] = true
+					columns = append(columns, prop.Key)
+				}
+			}
+			features = append(features, feature)
+		}
+	}
+	if err := w.Write(csvHeader(header, columns)); err != nil {
+		return err
+	}
+
+	index := 0
+	write := func(feature *Feature) error {
+		record := make([]string, 0, len(columns)+2)
+		if lonLat {
+			switch g := feature.Geometry.(type) {
+			case nil:
+				record = append(record, "", "")
+			case orb.Point:
+				record = append(record, formatFloat(g[0]), formatFloat(g[1]))
+			default:
+				return fmt.Errorf("feature %d: a %s cannot be written to longitude and latitude columns", index, g.GeoJSONType())
+			}
+		} else if feature.Geometry == nil {
+			record = append(record, "")
+		} else {
+			var z, m []float64
+			if feature.HasZ() {
+				z = feature.Z
+			}
+			if feature.HasM() {
+				m = feature.M
+			}
+			record = append(record, wkt.Marshal(feature.Geometry, z, m))
+		}
+		for _, column := range columns {
+			value, _ := feature.Properties.Get(column)
+			if value == nil {
+				record = append(record, "")
+			} else {
+				record = append(record, stringValue(value))
+			}
+		}
+		index++
+		return w.Write(record)
+	}
+	for _, feature := range features {
+		if err := write(feature); err != nil {
+			return err
+		}
+	}
+	for feature := range in {
+		if feature == nil {
+			continue
+		}
+		if err := write(feature); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvHeader returns the names of the geometry columns followed by the
+// property columns, which get a numeric suffix if they would take the name of
+// a geometry column
+func csvHeader(geometryColumns []string, columns []string) []string {
+	header := append([]string{}, geometryColumns...)
+	reserved := map[string]bool{}
+	taken := map[string]bool{}
+	for _, name := range geometryColumns {
+		reserved[name] = true
+		taken[name] = true
+	}
+	for _, name := range columns {
+		taken[name] = true
+	}
+	for _, column := range columns {
+		name := column
+		if reserved[column] {
+			for i := 1; taken[name]; i++ {
+				name = fmt.Sprintf("%s_%d", column, i)
+			}
+			taken[name] = true
+		}
+		header = append(header, name)
+	}
+	return header
+}
+
+// csvRecordWriter writes CSV records, as csv.Writer does
+type csvRecordWriter interface {
+	Write(record []string) error
+	Flush()
+	Error() error
+}
+
+// quotedCSVWriter writes CSV records with every field quoted
+type quotedCSVWriter struct {
+	w     *bufio.Writer
+	comma rune
+	err   error
+}
+
+func (q *quotedCSVWriter) Write(record []string) error {
+	for i, field := range record {
+		if i > 0 {
+			q.w.WriteRune(q.comma)
+		}
+		q.w.WriteByte('"')
+		q.w.WriteString(strings.Replace(field, `"`, `""`, -1))
+		q.w.WriteByte('"')
+	}
+	_, err := q.w.WriteString("\n")
+	return err
+}
+
+func (q *quotedCSVWriter) Flush() {
+	q.err = q.w.Flush()
+}
+
+func (q *quotedCSVWriter) Error() error {
+	return q.err
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,74 @@
+package io
+
+import (
+	"bytes"
+	"github.com/paulmach/orb"
+	"strings"
+	"testing"
+)
+
+func TestCSVWriter(t *testing.T) {
+	first := &Feature{
+		Geometry:   orb.Point{1, 2},
+		Properties: Properties{{Key: "name", Value: `a "b", c`}, {Key: "n", Value: 3}},
+	}
+	second := &Feature{
+		Properties: Properties{{Key: "n", Value: 4}, {Key: "name", Value: "d"}},
+	}
+	other := &Feature{
+		Geometry:   orb.Point{3, 4},
+		Properties: Properties{{Key: "geometry", Value: "point"}, {Key: "geometry_1", Value: 1}},
+	}
+	tests := []struct {
+		name     string
+		setup    func(*CSVWriter)
+		features []*Feature
+		want     string
+		err      string
+	}{
+		{
+			"first feature columns", nil, []*Feature{first, second},
+			"geometry,name,n\nPOINT (1 2),\"a \"\"b\"\", c\",3\n,d,4\n", "",
+		},
+		{
+			"other properties", nil, []*Feature{second, other, first},
+			"geometry,n,name,geometry_2,geometry_1\n,4,d,,\nPOINT (3 4),,,point,1\nPOINT (1 2),3,\"a \"\"b\"\", c\",,\n", "",
+		},
+		{
+			"columns", func(c *CSVWriter) { c.Columns = []string{"n", "missing"} }, []*Feature{first, other},
+			"geometry,n,missing\nPOINT (1 2),3,\nPOINT (3 4),,\n", "",
+		},
+		{
+			"geometry property", nil, []*Feature{other},
+			"geometry,geometry_2,geometry_1\nPOINT (3 4),point,1\n", "",
+		},
+		{
+			"quote all", func(c *CSVWriter) { c.QuoteAll = true; c.Comma = ';' }, []*Feature{first},
+			"\"geometry\";\"name\";\"n\"\n\"POINT (1 2)\";\"a \"\"b\"\", c\";\"3\"\n", "",
+		},
+		{
+			"lon lat", func(c *CSVWriter) { c.LonColumn, c.LatColumn = "lon", "lat" }, []*Feature{other},
+			"lon,lat,geometry,geometry_1\n3,4,point,1\n", "",
+		},
+		{"empty", nil, nil, "geometry\n", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			writer, _ := NewCSVWriter(&out)
+			if test.setup != nil {
+				test.setup(writer)
+			}
+			err := writeAll(writer, test.features)
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatalf("unexpected error %v", err)
+			case test.err != "" && (err == nil || !strings.HasPrefix(err.Error(), test.err)):
+				t.Fatalf("got error %v, want %q", err, test.err)
+			}
+			if test.err == "" && out.String() != test.want {
+				t.Errorf("got %q, want %q", out.String(), test.want)
+			}
+		})
+	}
+}
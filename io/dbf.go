@@ -0,0 +1,339 @@
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	shp "github.com/jonas-p/go-shp"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DateLayout is the layout of DBF date fields
+const DateLayout = "20060102"
+
+// Limits of the DBF format: field names have at most 10 characters, text
+// fields at most 254 bytes, and numbers are written in at most 20 characters
+const (
+	dbfNameLength   = 10
+	dbfStringLength = 254
+	dbfNumberLength = 20
+	dbfMaxPrecision = 15
+)
+
+// decodeAttribute converts the raw value of a DBF field, padding included, to
+// a typed value according to the field's type. Blank values, and unparseable
+// numeric, logical and date values, are returned as nil.
+func decodeAttribute(field shp.Field, raw string) interface{} {
+	switch field.Fieldtype {
+	case 'I':
+		if len(raw) != 4 {
+			return nil
+		}
+		return int64(int32(binary.LittleEndian.Uint32([]byte(raw))))
+	case 'O':
+		if len(raw) != 8 {
+			return nil
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64([]byte(raw)))
+	}
+	value := strings.Trim(raw, " \x00")
+	switch field.Fieldtype {
+	case 'N':
+		if value == "" {
+			return nil
+		}
+		if field.Precision == 0 {
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				return n
+			}
+		}
+		return parseFloat(value)
+	case 'F':
+		if value == "" {
+			return nil
+		}
+		return parseFloat(value)
+	case 'L':
+		switch value {
+		case "T", "t", "Y", "y":
+			return true
+		case "F", "f", "N", "n":
+			return false
+		}
+		return nil
+	case 'D':
+		date, err := time.Parse(DateLayout, value)
+		if err != nil {
+			return nil
+		}
+		return date
+	}
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func parseFloat(value string) interface{} {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		// Numbers that overflow the field width are stored as asterisks
+		return nil
+	}
+	return n
+}
+
+// FormatTime formats dates without a time of day as YYYY-MM-DD, and any other
+// time as RFC 3339
+func FormatTime(t time.Time) string {
+	if t.Equal(t.Truncate(24*time.Hour)) && t.Location() == time.UTC {
+		return t.Format("2006-01-02")
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// encodeAttribute converts a property value to one of the types accepted by
+// shp.Writer.WriteAttribute for the given field. It returns nil for values
+// that leave the field blank.
+func encodeAttribute(field shp.Field, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch field.Fieldtype {
+	case 'N', 'F':
+		var f float64
+		switch v := value.(type) {
+		case int:
+			f = float64(v)
+		case int64:
+			if field.Precision == 0 {
+				return int(v)
+			}
+			f = float64(v)
+		case float64:
+			f = v
+		case string:
+			var err error
+			if f, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+				return nil
+			}
+		default:
+			return nil
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil
+		}
+		if field.Precision == 0 && math.Abs(f) < 1e18 {
+			return int(math.Round(f))
+		}
+		return f
+	case 'L':
+		if b, ok := value.(bool); ok {
+			if b {
+				return "T"
+			}
+			return "F"
+		}
+		return nil
+	case 'D':
+		switch v := value.(type) {
+		case time.Time:
+			return v.Format(DateLayout)
+		case string:
+			for _, layout := range []string{"2006-01-02", time.RFC3339Nano, DateLayout} {
+				if t, err := time.Parse(layout, v); err == nil {
+					return t.Format(DateLayout)
+				}
+			}
+		}
+		return nil
+	}
+	return truncateString(stringValue(value), int(field.Size))
+}
+
+// stringValue formats a property value for a text field
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return FormatTime(v)
+	}
+	stream := jsonConfig.BorrowStream(nil)
+	defer jsonConfig.ReturnStream(stream)
+	writeValue(stream, value)
+	return string(stream.Buffer())
+}
+
+// truncateString shortens s to at most n bytes without splitting a character
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// fieldStats collects what inferFields needs to know about the values of a
+// property
+type fieldStats struct {
+	logical, integer, real, date, text bool
+	intDigits, decimals, length        int
+}
+
+func (s *fieldStats) add(value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case bool:
+		s.logical = true
+	case int:
+		s.addInteger(int64(v))
+	case int64:
+		s.addInteger(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			s.addInteger(int64(v))
+			break
+		}
+		formatted := strconv.FormatFloat(v, 'f', -1, 64)
+		if math.IsNaN(v) || math.IsInf(v, 0) || len(formatted) > dbfNumberLength {
+			s.text = true
+			break
+		}
+		s.real = true
+		parts := strings.SplitN(formatted, ".", 2)
+		if len(parts[0]) > s.intDigits {
+			s.intDigits = len(parts[0])
+		}
+		if len(parts) > 1 && len(parts[1]) > s.decimals {
+			s.decimals = len(parts[1])
+		}
+	case time.Time:
+		if v.Equal(v.Truncate(24*time.Hour)) && v.Location() == time.UTC {
+			s.date = true
+		} else {
+			s.text = true
+		}
+	default:
+		s.text = true
+	}
+	if n := len(stringValue(value)); n > s.length {
+		s.length = n
+	}
+}
+
+func (s *fieldStats) addInteger(v int64) {
+	s.integer = true
+	if n := len(strconv.FormatInt(v, 10)); n > s.intDigits {
+		s.intDigits = n
+	}
+}
+
+// field chooses the narrowest DBF field type that holds all values, falling
+// back to text when the values have different types
+func (s *fieldStats) field(name string) shp.Field {
+	numeric := s.integer || s.real
+	kinds := 0
+	for _, kind := range []bool{s.logical, numeric, s.date, s.text} {
+		if kind {
+			kinds++
+		}
+	}
+	if kinds == 1 {
+		switch {
+		case s.logical:
+			return shp.Field{Name: fieldName(name), Fieldtype: 'L', Size: 1}
+		case s.date:
+			return shp.DateField(name)
+		case numeric && !s.real:
+			return shp.NumberField(name, uint8(s.intDigits))
+		case numeric:
+			precision := s.decimals
+			if precision > dbfMaxPrecision {
+				precision = dbfMaxPrecision
+			}
+			if s.intDigits+1+precision > dbfNumberLength {
+				precision = dbfNumberLength - 1 - s.intDigits
+			}
+			if precision > 0 {
+				field := shp.FloatField(name, uint8(s.intDigits+1+precision), uint8(precision))
+				field.Fieldtype = 'N'
+				return field
+			}
+		}
+	}
+	length := s.length
+	if length < 1 {
+		length = 1
+	}
+	if length > dbfStringLength {
+		length = dbfStringLength
+	}
+	return shp.StringField(name, uint8(length))
+}
+
+// inferFields chooses a DBF field for every property key of the records, in
+// order of first appearance, and returns the keys along with the fields.
+// Names are truncated to the 10 characters allowed by DBF, and those that
+// collide get a numeric suffix.
+func inferFields(records []Properties) ([]string, []shp.Field) {
+	var keys []string
+	stats := map[string]*fieldStats{}
+	for _, properties := range records {
+		for _, prop := range properties {
+			s, ok := stats[prop.Key]
+			if !ok {
+				s = &fieldStats{}
+				stats[prop.Key] = s
+				keys = append(keys, prop.Key)
+			}
+			s.add(prop.Value)
+		}
+	}
+	names := uniqueFieldNames(keys)
+	fields := make([]shp.Field, len(keys))
+	for i, key := range keys {
+		fields[i] = stats[key].field(names[i])
+	}
+	return keys, fields
+}
+
+func uniqueFieldNames(keys []string) []string {
+	used := map[string]bool{}
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		base := key
+		if base == "" {
+			base = "field"
+		}
+		name := truncateString(base, dbfNameLength)
+		for n := 1; used[strings.ToUpper(name)]; n++ {
+			suffix := fmt.Sprintf("_%d", n)
+			name = truncateString(base, dbfNameLength-len(suffix)) + suffix
+		}
+		used[strings.ToUpper(name)] = true
+		names[i] = name
+	}
+	return names
+}
+
+// fieldName pads a name to the fixed size of a DBF field name
+func fieldName(name string) [11]byte {
+	var b [11]byte
+	copy(b[:], name)
+	return b
+}
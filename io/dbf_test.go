@@ -0,0 +1,146 @@
+package io
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"github.com/jonas-p/go-shp"
+	"github.com/paulmach/orb"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeAttribute(t *testing.T) {
+	tests := []struct {
+		fieldType byte
+		precision uint8
+		raw       string
+		want      interface{}
+	}{
+		{'C', 0, "  text  ", "text"},
+		{'C', 0, "          ", nil},
+		{'C', 0, "\x00\x00\x00", nil},
+		{'N', 0, "   42", int64(42)},
+		{'N', 2, " 4.25", 4.25},
+		{'N', 0, "     ", nil},
+		{'N', 0, "*****", nil},
+		{'F', 3, "-1.5", -1.5},
+		{'F', 3, "    ", nil},
+		{'L', 0, "T", true},
+		{'L', 0, "n", false},
+		{'L', 0, "?", nil},
+		{'D', 0, "20180102", time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{'D', 0, "        ", nil},
+		{'I', 0, "\xfe\xff\xff\xff", int64(-2)},
+		{'I', 0, " \x00\x00\x00", int64(32)},
+		{'I', 0, "    ", int64(0x20202020)},
+		{'O', 0, "\x00\x00\x00\x00\x00\x00\xf8\x3f", 1.5},
+	}
+	for _, test := range tests {
+		field := shp.Field{Fieldtype: test.fieldType, Precision: test.precision}
+		if got := decodeAttribute(field, test.raw); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%c field %q: got %#v, want %#v", test.fieldType, test.raw, got, test.want)
+		}
+	}
+}
+
+// dbfFile builds a DBF file from fields and the raw values of records
+func dbfFile(fields []shp.Field, records [][]string) []byte {
+	var buf bytes.Buffer
+	recordLength := 1
+	for _, field := range fields {
+		recordLength += int(field.Size)
+	}
+	buf.Write([]byte{3, 120, 1, 1})
+	binary.Write(&buf, binary.LittleEndian, int32(len(records)))
+	binary.Write(&buf, binary.LittleEndian, int16(32*len(fields)+33))
+	binary.Write(&buf, binary.LittleEndian, int16(recordLength))
+	buf.Write(make([]byte, 20))
+	binary.Write(&buf, binary.LittleEndian, fields)
+	buf.WriteByte(0x0d)
+	for _, record := range records {
+		buf.WriteByte(' ')
+		for _, value := range record {
+			buf.WriteString(value)
+		}
+	}
+	buf.WriteByte(0x1a)
+	return buf.Bytes()
+}
+
+func TestShapefileReaderBinaryAttributes(t *testing.T) {
+	fields := []shp.Field{{Fieldtype: 'I', Size: 4}, {Fieldtype: 'O', Size: 8}, {Fieldtype: 'C', Size: 4}}
+	copy(fields[0].Name[:], "count")
+	copy(fields[1].Name[:], "value")
+	copy(fields[2].Name[:], "name")
+	binaryInt := func(n int32) string {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(n))
+		return string(b)
+	}
+	binaryFloat := func(f float64) string {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+		return string(b)
+	}
+	// Values whose first or last bytes are spaces
+	floats := []float64{math.Float64frombits(0x4020000000000020), math.Float64frombits(0x2000000000000001), 2.5}
+	records := [][]string{
+		{binaryInt(32), binaryFloat(floats[0]), " a  "},
+		{binaryInt(0x20202020), binaryFloat(floats[1]), "    "},
+		{binaryInt(-1), binaryFloat(floats[2]), "bcde"},
+	}
+	want := []Properties{
+		{{"count", int64(32)}, {"value", floats[0]}, {"name", "a"}},
+		{{"count", int64(0x20202020)}, {"value", floats[1]}, {"name", nil}},
+		{{"count", int64(-1)}, {"value", floats[2]}, {"name", "bcde"}},
+	}
+
+	var features []*Feature
+	for i := range records {
+		features = append(features, NewFeature(orb.Point{float64(i), 0}))
+	}
+	filename, _ := writeShapefile(t, "binary.shp", features)
+	defer os.RemoveAll(filepath.Dir(filename))
+	base := strings.TrimSuffix(filename, ".shp")
+	if err := ioutil.WriteFile(base+".dbf", dbfFile(fields, records), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := os.Create(base + ".zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(archive)
+	for _, ext := range []string{".shp", ".shx", ".dbf"} {
+		data, _ := ioutil.ReadFile(base + ext)
+		f, _ := w.Create("binary" + ext)
+		f.Write(data)
+	}
+	w.Close()
+	archive.Close()
+
+	for _, filename := range []string{base + ".shp", base + ".zip"} {
+		reader, err := NewShapefileReader(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := readAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(read) != len(want) {
+			t.Fatalf("%s: read %d features, want %d", filepath.Base(filename), len(read), len(want))
+		}
+		for i, f := range read {
+			if !reflect.DeepEqual(f.Properties, want[i]) {
+				t.Errorf("%s: feature %d: got %v, want %v", filepath.Base(filename), i, f.Properties, want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stationa/xgeo/io/sqlite"
+	"os"
+	"regexp"
+	"unicode/utf8"
+)
+
+// DetectLength is the number of leading bytes that DetectFormat needs to
+// tell formats apart reliably
+const DetectLength = 64 * 1024
+
+// topologyType matches the type member of a TopoJSON Topology
+var topologyType = regexp.MustCompile(`"type"\s*:\s*"Topology"`)
+
+// featuresMember matches the features member of a GeoJSON FeatureCollection
+var featuresMember = regexp.MustCompile(`"features"\s*:`)
+
+// collectionStart matches the start of a GeoJSON FeatureCollection or a
+// TopoJSON Topology whose type comes first, as xgeo writes them
+var collectionStart = regexp.MustCompile(`^\{\s*"type"\s*:\s*"(FeatureCollection|Topology)"`)
+
+// magicNumbers are the leading bytes of binary formats
+var magicNumbers = []struct {
+	format string
+	magic  []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"bzip2", []byte("BZh")},
+	{"xz", []byte("\xfd7zXZ\x00")},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"zip", []byte("PK\x03\x04")},
+	{"zip", []byte("PK\x05\x06")},
+	// The big-endian file code 9994 of the main file header
+	{"shapefile", []byte{0x00, 0x00, 0x27, 0x0a}},
+	{"flatgeobuf", fgbMagic},
+	{"geopackage", []byte(sqlite.Magic)},
+}
+
+// DetectFormat guesses the format of an input from its first bytes. It
+// returns "gzip", "bzip2", "xz", "zstd", "zip", "shapefile", "flatgeobuf",
+// "geopackage", "geojson", "geojsonseq", "topojson", "kml", "gpx" or "csv", or an empty
+// string if the format is not recognized.
+// Input that starts with a complete JSON object on its own line followed by
+// more content is taken to be newline-delimited GeoJSON, which the geojsonseq
+// reader also handles. Text whose first line contains a comma, tab, semicolon
+// or pipe is taken to be CSV.
+func DetectFormat(header []byte) string {
+	for _, m := range magicNumbers {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.format
+		}
+	}
+	text := bytes.TrimLeft(bytes.TrimPrefix(header, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(text) == 0 {
+		return ""
+	}
+	switch text[0] {
+	case RecordSeparator:
+		return "geojsonseq"
+	case '{':
+		end := bytes.IndexByte(text, '\n')
+		if end >= 0 {
+			rest := bytes.TrimSpace(text[end:])
+			if len(rest) > 0 && rest[0] == '{' && jsonConfig.Valid(text[:end]) {
+				return "geojsonseq"
+			}
+		}
+		if topologyType.Match(text) {
+			return "topojson"
+		}
+		return "geojson"
+	case '<':
+		kml, gpx := bytes.Index(text, []byte("<kml")), bytes.Index(text, []byte("<gpx"))
+		switch {
+		case kml >= 0 && (gpx < 0 || kml < gpx):
+			return "kml"
+		case gpx >= 0:
+			return "gpx"
+		}
+		return ""
+	}
+	line := text
+	if end := bytes.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+	if utf8.Valid(line) && bytes.IndexFunc(line, isBinary) < 0 && bytes.ContainsAny(line, csvDelimiters) {
+		return "csv"
+	}
+	return ""
+}
+
+// DetectReader detects the format of a buffered input like DetectFormat, but
+// only waits for as many bytes as it takes to tell the formats apart, so that
+// a slow source such as a pipe is not held up until DetectLength bytes have
+// arrived. A JSON object whose first line is incomplete is taken to be GeoJSON
+// or TopoJSON as soon as it starts with a FeatureCollection or Topology type.
+func DetectReader(r *bufio.Reader) string {
+	for n := 1; ; n++ {
+		header, err := r.Peek(n)
+		if buffered := r.Buffered(); buffered > len(header) {
+			header, _ = r.Peek(buffered)
+		}
+		if err != nil || len(header) >= DetectLength || detected(header) {
+			return DetectFormat(header)
+		}
+		n = len(header)
+	}
+}
+
+// detected tells whether the leading bytes of an input are enough for
+// DetectFormat, which more bytes would not change
+func detected(header []byte) bool {
+	for _, m := range magicNumbers {
+		if bytes.HasPrefix(header, m.magic) {
+			return true
+		}
+	}
+	for _, m := range magicNumbers {
+		// The input may still turn out to start with the magic number
+		if bytes.HasPrefix(m.magic, header) {
+			return false
+		}
+	}
+	text := bytes.TrimLeft(bytes.TrimPrefix(header, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(text) == 0 {
+		return false
+	}
+	end := bytes.IndexByte(text, '\n')
+	switch text[0] {
+	case RecordSeparator:
+		return true
+	case '{':
+		if end < 0 {
+			return collectionStart.Match(text)
+		}
+		rest := bytes.TrimSpace(text[end:])
+		if len(rest) > 0 && rest[0] == '{' {
+			return true
+		}
+		return topologyType.Match(text) || featuresMember.Match(text)
+	case '<':
+		return bytes.Contains(text, []byte("<kml")) || bytes.Contains(text, []byte("<gpx"))
+	}
+	return end >= 0
+}
+
+func isBinary(r rune) bool {
+	return r < ' ' && r != '\t' && r != '\r'
+}
+
+// isZipFile tells whether a file is a zip archive, whatever its name
+func isZipFile(filename string) bool {
+	return detectFile(filename, 4) == "zip"
+}
+
+// IsGeoPackage tells whether a file is a SQLite database, as GeoPackages are,
+// whatever its name
+func IsGeoPackage(filename string) bool {
+	return detectFile(filename, len(sqlite.Magic)) == "geopackage"
+}
+
+// detectFile detects the format of a file from its first length bytes
+func detectFile(filename string, length int) string {
+	f, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	header := make([]byte, length)
+	n, _ := f.Read(header)
+	return DetectFormat(header[:n])
+}
@@ -0,0 +1,53 @@
+package io
+
+import (
+	"bufio"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDetectReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		format string
+	}{
+		{"collection", []string{`{"type":"FeatureCollection","features":[{"type":"Feature",`}, "geojson"},
+		{"topology", []string{`{"type":"Topology","arcs":[`}, "topojson"},
+		{"newline-delimited", []string{`{"type":"Feature","geometry":null}`, "\n{\"type\":"}, "geojsonseq"},
+		{"pretty-printed", []string{"{\n  \"features\": [\n"}, "geojson"},
+		{"text sequence", []string{"\x1e{"}, "geojsonseq"},
+		{"gzip", []string{"\x1f", "\x8b"}, "gzip"},
+		{"geopackage", []string{"SQLite ", "format 3\x00"}, "geopackage"},
+		{"csv", []string{"name,lat", ",lon\n1"}, "csv"},
+		{"kml", []string{`<?xml version="1.0"?>`, "\n<kml "}, "kml"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The pipe is never closed, like a source that is slow to write
+			// more, so detection must not wait for more bytes
+			r, w := io.Pipe()
+			defer r.Close()
+			go func() {
+				for _, chunk := range test.chunks {
+					if _, err := w.Write([]byte(chunk)); err != nil {
+						return
+					}
+				}
+			}()
+			formats := make(chan string, 1)
+			go func() {
+				formats <- DetectReader(bufio.NewReaderSize(r, DetectLength))
+			}()
+			select {
+			case format := <-formats:
+				if format != test.format {
+					t.Errorf("got %q, want %q", format, test.format)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("detection waited for more input")
+			}
+		})
+	}
+}
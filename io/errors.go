@@ -0,0 +1,46 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFeatureCollection is returned when a GeoJSON input is neither a
+// FeatureCollection nor a single Feature or geometry
+var ErrNotFeatureCollection = errors.New("not a GeoJSON FeatureCollection, Feature or geometry")
+
+// ErrMalformedFeature is returned when a feature cannot be parsed
+type ErrMalformedFeature struct {
+	// Index is the position of the feature in the input, starting at 0
+	Index int
+	// Offset is the byte offset at which the feature starts
+	Offset int64
+	// Line is the line on which the feature starts, for line-oriented
+	// formats, or 0
+	Line int
+	Err  error
+}
+
+func (e *ErrMalformedFeature) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("malformed feature %d on line %d: %v", e.Index, e.Line, e.Err)
+	}
+	return fmt.Sprintf("malformed feature %d at byte %d: %v", e.Index, e.Offset, e.Err)
+}
+
+// ErrUnsupportedShape is returned for shapefile records with a shape type
+// that cannot be read
+type ErrUnsupportedShape struct {
+	// Record is the shapefile record number, starting at 1
+	Record int
+	// Layer is the name of the shapefile in an archive of several, or empty
+	Layer string
+	Err   error
+}
+
+func (e *ErrUnsupportedShape) Error() string {
+	if e.Layer != "" {
+		return fmt.Sprintf("unsupported shape in record %d of layer %s: %v", e.Record, e.Layer, e.Err)
+	}
+	return fmt.Sprintf("unsupported shape in record %d: %v", e.Record, e.Err)
+}
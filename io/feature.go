@@ -0,0 +1,102 @@
+package io
+
+import (
+	"github.com/paulmach/orb"
+)
+
+// Feature is a single geographic feature with an optional geometry, an
+// ordered list of properties and the optional GeoJSON "id" and "bbox" members.
+// Since orb geometries are two dimensional, the optional Z and M ordinates of
+// each position are kept alongside, in the order the positions are visited.
+type Feature struct {
+	ID         interface{}
+	Geometry   orb.Geometry
+	Z          []float64
+	M          []float64
+	Properties Properties
+	BBox       []float64
+}
+
+func NewFeature(geometry orb.Geometry) *Feature {
+	return &Feature{
+		Geometry: geometry,
+	}
+}
+
+// HasZ reports whether the feature has a Z ordinate for every position
+func (f *Feature) HasZ() bool {
+	return len(f.Z) > 0 && len(f.Z) == PositionCount(f.Geometry)
+}
+
+// HasM reports whether the feature has an M ordinate for every position
+func (f *Feature) HasM() bool {
+	return len(f.M) > 0 && len(f.M) == PositionCount(f.Geometry)
+}
+
+type Property struct {
+	Key   string
+	Value interface{}
+}
+
+// Properties is an ordered list of key/value pairs, so that features keep
+// the attribute order of their source
+type Properties []Property
+
+func (p Properties) Index(key string) int {
+	for i, prop := range p {
+		if prop.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p Properties) Get(key string) (interface{}, bool) {
+	if i := p.Index(key); i >= 0 {
+		return p[i].Value, true
+	}
+	return nil, false
+}
+
+func (p *Properties) Set(key string, value interface{}) {
+	if i := p.Index(key); i >= 0 {
+		(*p)[i].Value = value
+		return
+	}
+	*p = append(*p, Property{key, value})
+}
+
+func (p *Properties) Delete(key string) {
+	if i := p.Index(key); i >= 0 {
+		*p = append((*p)[:i], (*p)[i+1:]...)
+	}
+}
+
+func (p Properties) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(p))
+	for _, prop := range p {
+		m[prop.Key] = prop.Value
+	}
+	return m
+}
+
+func (f *Feature) MarshalJSON() ([]byte, error) {
+	stream := jsonConfig.BorrowStream(nil)
+	defer jsonConfig.ReturnStream(stream)
+	writeFeature(stream, f)
+	if stream.Error != nil {
+		return nil, stream.Error
+	}
+	return append([]byte(nil), stream.Buffer()...), nil
+}
+
+func (f *Feature) UnmarshalJSON(data []byte) error {
+	dec := jsonConfig.BorrowIterator(data)
+	defer jsonConfig.ReturnIterator(dec)
+	feature, err := readFeature(dec)
+	if err != nil {
+		return err
+	}
+	*f = *feature
+	return nil
+}
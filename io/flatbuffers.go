@@ -0,0 +1,321 @@
+package io
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"runtime"
+	"sort"
+)
+
+// errMalformedFlatBuffer is returned for a FlatBuffers table whose offsets
+// point outside of its buffer
+var errMalformedFlatBuffer = errors.New("malformed FlatBuffers table")
+
+// fbTable is a table of a FlatBuffers buffer. Its accessors panic if the
+// buffer is malformed, which recoverFlatBuffer turns into an error.
+type fbTable struct {
+	buf []byte
+	pos int
+}
+
+// fbRoot returns the root table of a buffer
+func fbRoot(buf []byte) fbTable {
+	return fbTable{buf, int(binary.LittleEndian.Uint32(buf))}
+}
+
+// recoverFlatBuffer sets *err to errMalformedFlatBuffer if the function that
+// defers it panics on an index out of range
+func recoverFlatBuffer(err *error) {
+	if r := recover(); r != nil {
+		if _, ok := r.(runtime.Error); !ok {
+			panic(r)
+		}
+		*err = errMalformedFlatBuffer
+	}
+}
+
+// field returns the position of a field from its index in the schema, or 0
+// if the table does not have it
+func (t fbTable) field(slot int) int {
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	entry := 4 + 2*slot
+	if entry >= int(binary.LittleEndian.Uint16(t.buf[vtable:])) {
+		return 0
+	}
+	if offset := int(binary.LittleEndian.Uint16(t.buf[vtable+entry:])); offset != 0 {
+		return t.pos + offset
+	}
+	return 0
+}
+
+func (t fbTable) byteField(slot int, def uint8) uint8 {
+	if pos := t.field(slot); pos != 0 {
+		return t.buf[pos]
+	}
+	return def
+}
+
+func (t fbTable) boolField(slot int, def bool) bool {
+	if pos := t.field(slot); pos != 0 {
+		return t.buf[pos] != 0
+	}
+	return def
+}
+
+func (t fbTable) uint16Field(slot int, def uint16) uint16 {
+	if pos := t.field(slot); pos != 0 {
+		return binary.LittleEndian.Uint16(t.buf[pos:])
+	}
+	return def
+}
+
+func (t fbTable) int32Field(slot int, def int32) int32 {
+	if pos := t.field(slot); pos != 0 {
+		return int32(binary.LittleEndian.Uint32(t.buf[pos:]))
+	}
+	return def
+}
+
+func (t fbTable) uint64Field(slot int, def uint64) uint64 {
+	if pos := t.field(slot); pos != 0 {
+		return binary.LittleEndian.Uint64(t.buf[pos:])
+	}
+	return def
+}
+
+// indirect follows the offset stored at a position
+func (t fbTable) indirect(pos int) int {
+	return pos + int(binary.LittleEndian.Uint32(t.buf[pos:]))
+}
+
+// vectorField returns the position of the first element of a vector or
+// string, and its length
+func (t fbTable) vectorField(slot int) (int, int) {
+	pos := t.field(slot)
+	if pos == 0 {
+		return 0, 0
+	}
+	vector := t.indirect(pos)
+	return vector + 4, int(binary.LittleEndian.Uint32(t.buf[vector:]))
+}
+
+func (t fbTable) bytesField(slot int) []byte {
+	start, n := t.vectorField(slot)
+	return t.buf[start : start+n]
+}
+
+func (t fbTable) stringField(slot int) string {
+	return string(t.bytesField(slot))
+}
+
+func (t fbTable) float64sField(slot int) []float64 {
+	start, n := t.vectorField(slot)
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.LittleEndian.Uint64(t.buf[start+8*i:]))
+	}
+	return values
+}
+
+func (t fbTable) uint32sField(slot int) []uint32 {
+	start, n := t.vectorField(slot)
+	values := make([]uint32, n)
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint32(t.buf[start+4*i:])
+	}
+	return values
+}
+
+func (t fbTable) tableField(slot int) (fbTable, bool) {
+	pos := t.field(slot)
+	if pos == 0 {
+		return fbTable{}, false
+	}
+	return fbTable{t.buf, t.indirect(pos)}, true
+}
+
+func (t fbTable) tablesField(slot int) []fbTable {
+	start, n := t.vectorField(slot)
+	tables := make([]fbTable, n)
+	for i := range tables {
+		tables[i] = fbTable{t.buf, t.indirect(start + 4*i)}
+	}
+	return tables
+}
+
+// fbBuilder writes a size-prefixed FlatBuffers buffer front to back: each
+// table is preceded by its vtable and followed by the strings, vectors and
+// tables it refers to, so that all offsets point forward as the format
+// requires. Values are aligned relative to the start of the size prefix, as
+// the FlatBuffers libraries do.
+type fbBuilder struct {
+	buf []byte
+}
+
+// fbField is a field of a table being built: either a scalar of 1, 2, 4 or 8
+// bytes, or an offset to an object written after the table
+type fbField struct {
+	slot  int
+	size  int
+	bits  uint64
+	write func(b *fbBuilder) int
+}
+
+func fbScalar(slot int, size int, bits uint64) fbField {
+	return fbField{slot: slot, size: size, bits: bits}
+}
+
+func fbBool(slot int, value bool) fbField {
+	if value {
+		return fbScalar(slot, 1, 1)
+	}
+	return fbScalar(slot, 1, 0)
+}
+
+func fbString(slot int, s string) fbField {
+	return fbField{slot: slot, size: 4, write: func(b *fbBuilder) int {
+		return b.vector(1, len(s), func() { b.buf = append(append(b.buf, s...), 0) })
+	}}
+}
+
+func fbBytes(slot int, data []byte) fbField {
+	return fbField{slot: slot, size: 4, write: func(b *fbBuilder) int {
+		return b.vector(1, len(data), func() { b.buf = append(b.buf, data...) })
+	}}
+}
+
+func fbFloat64s(slot int, values []float64) fbField {
+	return fbField{slot: slot, size: 4, write: func(b *fbBuilder) int {
+		return b.vector(8, len(values), func() {
+			for _, v := range values {
+				b.buf = appendUint64(b.buf, math.Float64bits(v))
+			}
+		})
+	}}
+}
+
+func fbUint32s(slot int, values []uint32) fbField {
+	return fbField{slot: slot, size: 4, write: func(b *fbBuilder) int {
+		return b.vector(4, len(values), func() {
+			for _, v := range values {
+				b.buf = appendUint32(b.buf, v)
+			}
+		})
+	}}
+}
+
+func fbTableRef(slot int, fields []fbField) fbField {
+	return fbField{slot: slot, size: 4, write: func(b *fbBuilder) int {
+		return b.table(fields)
+	}}
+}
+
+func fbTables(slot int, tables [][]fbField) fbField {
+	return fbField{slot: slot, size: 4, write: func(b *fbBuilder) int {
+		var start int
+		pos := b.vector(4, len(tables), func() {
+			start = len(b.buf)
+			b.buf = append(b.buf, make([]byte, 4*len(tables))...)
+		})
+		for i, fields := range tables {
+			offset := start + 4*i
+			b.putOffset(offset, b.table(fields))
+		}
+		return pos
+	}}
+}
+
+// newFBBuilder starts a buffer with room for its size prefix and the offset
+// of its root table
+func newFBBuilder() *fbBuilder {
+	return &fbBuilder{buf: make([]byte, 8)}
+}
+
+// finish writes the root table and returns the size-prefixed buffer
+func (b *fbBuilder) finish(root []fbField) []byte {
+	b.putOffset(4, b.table(root))
+	binary.LittleEndian.PutUint32(b.buf, uint32(len(b.buf)-4))
+	return b.buf
+}
+
+func (b *fbBuilder) pad(align int, extra int) {
+	for (len(b.buf)+extra)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v), byte(v>>8))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v)), uint32(v>>32))
+}
+
+func (b *fbBuilder) putOffset(pos int, target int) {
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(target-pos))
+}
+
+// vector writes the length of a vector, aligned so that its elements are,
+// then the elements themselves, and returns its position
+func (b *fbBuilder) vector(elementSize int, n int, elements func()) int {
+	b.pad(4, 0)
+	b.pad(elementSize, 4)
+	pos := len(b.buf)
+	b.buf = appendUint32(b.buf, uint32(n))
+	elements()
+	return pos
+}
+
+// table writes a vtable, the table, then the objects that the table refers
+// to, and returns the position of the table
+func (b *fbBuilder) table(fields []fbField) int {
+	// Larger fields come first so that the table needs little padding
+	fields = append([]fbField(nil), fields...)
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].size > fields[j].size })
+	slots, largest := 0, 4
+	for _, f := range fields {
+		if f.slot >= slots {
+			slots = f.slot + 1
+		}
+		if f.size > largest {
+			largest = f.size
+		}
+	}
+	b.pad(2, 0)
+	vtable := len(b.buf)
+	b.buf = append(b.buf, make([]byte, 4+2*slots)...)
+	b.pad(4, 0)
+	b.pad(largest, 4)
+	pos := len(b.buf)
+	b.buf = appendUint32(b.buf, uint32(pos-vtable))
+	positions := make([]int, len(fields))
+	for i, f := range fields {
+		b.pad(f.size, 0)
+		positions[i] = len(b.buf)
+		binary.LittleEndian.PutUint16(b.buf[vtable+4+2*f.slot:], uint16(positions[i]-pos))
+		switch f.size {
+		case 1:
+			b.buf = append(b.buf, byte(f.bits))
+		case 2:
+			b.buf = appendUint16(b.buf, uint16(f.bits))
+		case 4:
+			b.buf = appendUint32(b.buf, uint32(f.bits))
+		case 8:
+			b.buf = appendUint64(b.buf, f.bits)
+		}
+	}
+	binary.LittleEndian.PutUint16(b.buf[vtable:], uint16(4+2*slots))
+	binary.LittleEndian.PutUint16(b.buf[vtable+2:], uint16(len(b.buf)-pos))
+	for i, f := range fields {
+		if f.write != nil {
+			b.putOffset(positions[i], f.write(b))
+		}
+	}
+	return pos
+}
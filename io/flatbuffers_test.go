@@ -0,0 +1,117 @@
+package io
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlatBuffers(t *testing.T) {
+	buf := newFBBuilder().finish([]fbField{
+		fbScalar(0, 1, 7),
+		fbBool(1, true),
+		fbScalar(2, 2, 65535),
+		fbScalar(3, 4, uint64(0xFFFFFFFE)),
+		fbScalar(4, 8, 1<<40),
+		fbString(5, "name"),
+		fbBytes(6, []byte{1, 2, 3}),
+		fbFloat64s(7, []float64{1.5, -2}),
+		fbUint32s(8, []uint32{3, 4, 5}),
+		fbTableRef(9, []fbField{fbString(0, "nested"), fbScalar(1, 1, 2)}),
+		fbTables(10, [][]fbField{{fbScalar(0, 1, 1)}, {}, {fbString(1, "last")}}),
+		// Slot 11 is missing, and 12 is beyond the vtable of the nested tables
+	})
+	if int(buf[0])|int(buf[1])<<8|int(buf[2])<<16|int(buf[3])<<24 != len(buf)-4 {
+		t.Fatalf("size prefix does not match the length %d", len(buf)-4)
+	}
+
+	var err error
+	func() {
+		defer recoverFlatBuffer(&err)
+		root := fbRoot(buf[4:])
+		if got := root.byteField(0, 0); got != 7 {
+			t.Errorf("byte: got %d", got)
+		}
+		if !root.boolField(1, false) {
+			t.Error("bool: got false")
+		}
+		if got := root.uint16Field(2, 0); got != 65535 {
+			t.Errorf("uint16: got %d", got)
+		}
+		if got := root.int32Field(3, 0); got != -2 {
+			t.Errorf("int32: got %d", got)
+		}
+		if got := root.uint64Field(4, 0); got != 1<<40 {
+			t.Errorf("uint64: got %d", got)
+		}
+		if got := root.stringField(5); got != "name" {
+			t.Errorf("string: got %q", got)
+		}
+		if got := root.bytesField(6); !reflect.DeepEqual(got, []byte{1, 2, 3}) {
+			t.Errorf("bytes: got %v", got)
+		}
+		if got := root.float64sField(7); !reflect.DeepEqual(got, []float64{1.5, -2}) {
+			t.Errorf("float64s: got %v", got)
+		}
+		if got := root.uint32sField(8); !reflect.DeepEqual(got, []uint32{3, 4, 5}) {
+			t.Errorf("uint32s: got %v", got)
+		}
+		nested, ok := root.tableField(9)
+		if !ok || nested.stringField(0) != "nested" || nested.byteField(1, 0) != 2 {
+			t.Errorf("table: got %v, %q", ok, nested.stringField(0))
+		}
+		tables := root.tablesField(10)
+		if len(tables) != 3 || tables[0].byteField(0, 0) != 1 || tables[1].byteField(0, 9) != 9 || tables[2].stringField(1) != "last" {
+			t.Errorf("tables: got %d tables", len(tables))
+		}
+		if _, ok := root.tableField(11); ok {
+			t.Error("missing table: found")
+		}
+		if got := tables[0].uint64Field(12, 42); got != 42 {
+			t.Errorf("missing scalar: got %d, want the default", got)
+		}
+		if got := root.float64sField(11); len(got) != 0 {
+			t.Errorf("missing vector: got %v", got)
+		}
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlatBuffersAlignment(t *testing.T) {
+	// Vectors of 8-byte values must be aligned from the start of the size
+	// prefix whatever comes before them
+	for n := 0; n < 8; n++ {
+		buf := newFBBuilder().finish([]fbField{
+			fbBytes(0, make([]byte, n)),
+			fbTableRef(1, []fbField{fbScalar(0, 1, 1), fbFloat64s(1, []float64{1, 2})}),
+		})
+		var pos int
+		func() {
+			defer recoverFlatBuffer(new(error))
+			root := fbRoot(buf[4:])
+			nested, _ := root.tableField(1)
+			start, _ := nested.vectorField(1)
+			pos = start + 4
+		}()
+		if pos%8 != 0 {
+			t.Errorf("%d leading bytes: float64s at %d", n, pos)
+		}
+	}
+}
+
+func TestFlatBuffersMalformed(t *testing.T) {
+	buf := newFBBuilder().finish([]fbField{fbString(0, "name"), fbFloat64s(1, []float64{1, 2, 3})})[4:]
+	for n := 4; n < len(buf); n++ {
+		var err error
+		func() {
+			defer recoverFlatBuffer(&err)
+			root := fbRoot(buf[:n])
+			root.stringField(0)
+			root.float64sField(1)
+		}()
+		if err != nil && err != errMalformedFlatBuffer {
+			t.Errorf("%d bytes: got %v", n, err)
+		}
+	}
+}
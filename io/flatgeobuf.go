@@ -0,0 +1,576 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/project"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+)
+
+// fgbMagic starts FlatGeobuf files, followed by the patch version of the
+// format
+var fgbMagic = []byte("fgb\x03fgb")
+
+// fgbMaxBufferLength bounds the length of the header and of each feature, so
+// that a corrupt length does not exhaust memory
+const fgbMaxBufferLength = 1 << 30
+
+var (
+	errNotFlatGeobuf  = errors.New("not a FlatGeobuf file")
+	errMalformedIndex = errors.New("malformed FlatGeobuf index")
+)
+
+// Fields of the tables of the FlatGeobuf schema, numbered in the order they
+// are declared in header.fbs and feature.fbs
+const (
+	fgbHeaderName          = 0
+	fgbHeaderEnvelope      = 1
+	fgbHeaderGeometryType  = 2
+	fgbHeaderHasZ          = 3
+	fgbHeaderHasM          = 4
+	fgbHeaderColumns       = 7
+	fgbHeaderFeaturesCount = 8
+	fgbHeaderIndexNodeSize = 9
+	fgbHeaderCRS           = 10
+
+	fgbColumnName       = 0
+	fgbColumnType       = 1
+	fgbColumnPrimaryKey = 9
+
+	fgbCRSOrg  = 0
+	fgbCRSCode = 1
+	fgbCRSWKT  = 4
+
+	fgbGeometryEnds  = 0
+	fgbGeometryXY    = 1
+	fgbGeometryZ     = 2
+	fgbGeometryM     = 3
+	fgbGeometryType  = 6
+	fgbGeometryParts = 7
+
+	fgbFeatureGeometry   = 0
+	fgbFeatureProperties = 1
+	fgbFeatureColumns    = 2
+)
+
+// FlatGeobuf geometry types. Curves, surfaces and triangles are not
+// supported.
+const (
+	fgbUnknown = iota
+	fgbPoint
+	fgbLineString
+	fgbPolygon
+	fgbMultiPoint
+	fgbMultiLineString
+	fgbMultiPolygon
+	fgbGeometryCollection
+)
+
+var fgbGeometryTypes = []string{
+	"Unknown", "Point", "LineString", "Polygon", "MultiPoint", "MultiLineString", "MultiPolygon",
+	"GeometryCollection", "CircularString", "CompoundCurve", "CurvePolygon", "MultiCurve",
+	"MultiSurface", "Curve", "Surface", "PolyhedralSurface", "TIN", "Triangle",
+}
+
+// FlatGeobuf column types
+const (
+	fgbByte = iota
+	fgbUByte
+	fgbBool
+	fgbShort
+	fgbUShort
+	fgbInt
+	fgbUInt
+	fgbLong
+	fgbULong
+	fgbFloat
+	fgbDouble
+	fgbString
+	fgbJSON
+	fgbDateTime
+	fgbBinary
+)
+
+type fgbColumn struct {
+	name string
+	kind uint8
+	// id marks the primary key column, which holds feature IDs
+	id bool
+}
+
+// FlatGeobufReader reads a FlatGeobuf file. Properties are read with the type
+// of their column: integers as int64, floating point numbers as float64,
+// dates as time.Time and JSON as the values it holds. The value of a primary
+// key column is the feature's ID rather than a property.
+//
+// If BBox is set and the file has a packed Hilbert R-tree index, only the
+// features that the index finds are read, unless the file is in another
+// coordinate system than WGS84. When the input is a file rather
+// than a stream, the reader reads only the nodes of the index that it visits
+// and seeks to each feature found, instead of reading the whole file.
+type FlatGeobufReader struct {
+	input        *bufio.Reader
+	file         io.ReaderAt
+	name         string
+	geometryType uint8
+	columns      []fgbColumn
+	count        uint64
+	nodeSize     uint16
+	projection   orb.Projection
+
+	// indexOffset is the byte offset of the index, just after the header
+	indexOffset int64
+
+	// BBox, when set, restricts reading to the features whose bounding box
+	// intersects it
+	BBox *orb.Bound
+
+	// LayerProperty, when set, is the name of a property that receives the
+	// name of the layer, as written in the header
+	LayerProperty string
+}
+
+// NewFlatGeobufReader reads the header of a FlatGeobuf file. If input is an
+// io.ReaderAt, such as an *os.File, reads restricted to BBox seek to the
+// features they need.
+func NewFlatGeobufReader(input io.Reader) (*FlatGeobufReader, error) {
+	f := &FlatGeobufReader{input: bufio.NewReader(input)}
+	f.file, _ = input.(io.ReaderAt)
+	magic := make([]byte, len(fgbMagic)+1)
+	if _, err := io.ReadFull(f.input, magic); err != nil || !bytes.HasPrefix(magic, fgbMagic) {
+		return nil, errNotFlatGeobuf
+	}
+	header, err := readFGBBuffer(f.input)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := f.readHeader(header); err != nil {
+		return nil, fmt.Errorf("header: %v", err)
+	}
+	f.indexOffset = int64(len(magic) + 4 + len(header))
+	return f, nil
+}
+
+func (f *FlatGeobufReader) readHeader(data []byte) (err error) {
+	defer recoverFlatBuffer(&err)
+	header := fbRoot(data)
+	f.name = header.stringField(fgbHeaderName)
+	f.geometryType = header.byteField(fgbHeaderGeometryType, fgbUnknown)
+	f.columns = fgbColumns(header.tablesField(fgbHeaderColumns))
+	f.count = header.uint64Field(fgbHeaderFeaturesCount, 0)
+	f.nodeSize = header.uint16Field(fgbHeaderIndexNodeSize, 16)
+	// The length of an index of more features would overflow
+	if f.nodeSize == 1 || f.count > 1<<56 {
+		return errMalformedIndex
+	}
+	if crs, ok := header.tableField(fgbHeaderCRS); ok {
+		f.projection, err = fgbProjection(crs)
+	}
+	return err
+}
+
+func fgbColumns(tables []fbTable) []fgbColumn {
+	columns := make([]fgbColumn, len(tables))
+	for i, t := range tables {
+		columns[i] = fgbColumn{
+			name: t.stringField(fgbColumnName),
+			kind: t.byteField(fgbColumnType, fgbByte),
+			id:   t.boolField(fgbColumnPrimaryKey, false),
+		}
+	}
+	return columns
+}
+
+// fgbProjection returns the projection of the coordinate system of a file to
+// WGS84 longitude and latitude, or nil if the coordinates are already in
+// degrees
+func fgbProjection(crs fbTable) (orb.Projection, error) {
+	org := strings.ToUpper(crs.stringField(fgbCRSOrg))
+	code := crs.int32Field(fgbCRSCode, 0)
+	if (org == "" || org == "EPSG") && code == 4326 {
+		return nil, nil
+	}
+	if wkt := crs.stringField(fgbCRSWKT); wkt != "" {
+		return ParseProjection(wkt)
+	}
+	if (org == "" || org == "EPSG") && (code == 3857 || code == 900913) {
+		return project.Mercator.ToWGS84, nil
+	}
+	if code == 0 {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported coordinate system %s:%d", org, code)
+}
+
+// Layers returns the name of the layer, if the header has one
+func (f *FlatGeobufReader) Layers() []string {
+	if f.name == "" {
+		return nil
+	}
+	return []string{f.name}
+}
+
+func (f *FlatGeobufReader) Read(ctx context.Context, out chan *Feature) error {
+	var indexLength int64
+	if f.nodeSize > 0 && f.count > 0 {
+		indexLength = fgbIndexLength(f.count, f.nodeSize)
+	}
+	start := f.indexOffset + indexLength
+	// The index is in the coordinates of the file, which are only those of
+	// BBox for WGS84 files
+	if f.BBox == nil || indexLength == 0 || f.projection != nil {
+		if _, err := io.CopyN(ioutil.Discard, f.input, indexLength); err != nil {
+			return fgbIndexError(err)
+		}
+		return f.readSequentially(ctx, out, start, nil)
+	}
+	if f.file != nil {
+		results, err := fgbSearch(io.NewSectionReader(f.file, f.indexOffset, indexLength), f.count, f.nodeSize, *f.BBox)
+		if err != nil {
+			return fgbIndexError(err)
+		}
+		return f.readAt(ctx, out, start, results)
+	}
+	// A stream is read through, but only the features found are decoded
+	index, err := ioutil.ReadAll(io.LimitReader(f.input, indexLength))
+	if err == nil && int64(len(index)) < indexLength {
+		err = io.EOF
+	}
+	if err != nil {
+		return fgbIndexError(err)
+	}
+	results, err := fgbSearch(bytes.NewReader(index), f.count, f.nodeSize, *f.BBox)
+	if err != nil {
+		return fgbIndexError(err)
+	}
+	if results == nil {
+		return nil
+	}
+	return f.readSequentially(ctx, out, start, results)
+}
+
+// fgbIndexError reports an error reading the index, which is truncated if
+// the input ends
+func fgbIndexError(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("index: %v", err)
+}
+
+// readSequentially reads the features that follow the index, which start at
+// a given byte offset. If results is not nil, only the features it lists are
+// decoded; otherwise features are filtered with BBox, if it is set.
+func (f *FlatGeobufReader) readSequentially(ctx context.Context, out chan *Feature, start int64, results []fgbSearchResult) error {
+	offset := start
+	for index := 0; results == nil || len(results) > 0; index++ {
+		if results != nil && uint64(offset-start) < results[0].offset {
+			var prefix [4]byte
+			if _, err := io.ReadFull(f.input, prefix[:]); err != nil {
+				return &ErrMalformedFeature{Index: index, Offset: offset, Err: err}
+			}
+			length := int64(binary.LittleEndian.Uint32(prefix[:]))
+			if _, err := io.CopyN(ioutil.Discard, f.input, length); err != nil {
+				return &ErrMalformedFeature{Index: index, Offset: offset, Err: io.ErrUnexpectedEOF}
+			}
+			offset += 4 + length
+			continue
+		}
+		data, err := readFGBBuffer(f.input)
+		if err == io.EOF && results == nil {
+			return nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return &ErrMalformedFeature{Index: index, Offset: offset, Err: err}
+		}
+		feature, err := f.decodeFeature(data)
+		if err != nil {
+			return &ErrMalformedFeature{Index: index, Offset: offset, Err: err}
+		}
+		offset += 4 + int64(len(data))
+		if results != nil {
+			results = results[1:]
+		} else if f.BBox != nil && (feature.Geometry == nil || !feature.Geometry.Bound().Intersects(*f.BBox)) {
+			continue
+		}
+		if err := Emit(ctx, out, feature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readAt reads the features found in the index from the file, in the order
+// they are stored
+func (f *FlatGeobufReader) readAt(ctx context.Context, out chan *Feature, start int64, results []fgbSearchResult) error {
+	for _, result := range results {
+		offset := start + int64(result.offset)
+		data, err := readFGBBuffer(io.NewSectionReader(f.file, offset, math.MaxInt64-offset))
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return &ErrMalformedFeature{Index: result.index, Offset: offset, Err: err}
+		}
+		feature, err := f.decodeFeature(data)
+		if err != nil {
+			return &ErrMalformedFeature{Index: result.index, Offset: offset, Err: err}
+		}
+		if err := Emit(ctx, out, feature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFGBBuffer reads a length-prefixed buffer, returning io.EOF if the input
+// ends before it
+func readFGBBuffer(r io.Reader) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(prefix[:])
+	if length > fgbMaxBufferLength {
+		return nil, fmt.Errorf("buffer of %d bytes is too large", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+func (f *FlatGeobufReader) decodeFeature(data []byte) (feature *Feature, err error) {
+	defer recoverFlatBuffer(&err)
+	t := fbRoot(data)
+	feature = NewFeature(nil)
+	if geometry, ok := t.tableField(fgbFeatureGeometry); ok {
+		d := &fgbGeometryDecoder{}
+		if feature.Geometry, err = d.decode(geometry, f.geometryType); err != nil {
+			return nil, err
+		}
+		if f.projection != nil && feature.Geometry != nil {
+			feature.Geometry = project.Geometry(feature.Geometry, f.projection)
+		}
+		feature.Z, feature.M = d.z, d.m
+		if !feature.HasZ() {
+			feature.Z = nil
+		}
+		if !feature.HasM() || allNaN(feature.M) {
+			feature.M = nil
+		}
+	}
+	columns := f.columns
+	if tables := t.tablesField(fgbFeatureColumns); len(tables) > 0 {
+		columns = fgbColumns(tables)
+	}
+	if feature.ID, feature.Properties, err = fgbProperties(t.bytesField(fgbFeatureProperties), columns); err != nil {
+		return nil, err
+	}
+	if f.LayerProperty != "" {
+		feature.Properties.Set(f.LayerProperty, f.name)
+	}
+	return feature, nil
+}
+
+// fgbProperties decodes the ID and the properties of a feature, each of
+// which is the index of its column followed by its value
+func fgbProperties(data []byte, columns []fgbColumn) (interface{}, Properties, error) {
+	var id interface{}
+	var properties Properties
+	for pos := 0; pos < len(data); {
+		i := int(binary.LittleEndian.Uint16(data[pos:]))
+		if i >= len(columns) {
+			return nil, nil, fmt.Errorf("no column %d", i)
+		}
+		value, n := fgbValue(data[pos+2:], columns[i].kind)
+		if n < 0 {
+			return nil, nil, fmt.Errorf("column %s has unsupported type %d", columns[i].name, columns[i].kind)
+		}
+		if columns[i].id {
+			id = value
+		} else {
+			properties = append(properties, Property{columns[i].name, value})
+		}
+		pos += 2 + n
+	}
+	return id, properties, nil
+}
+
+// allNaN tells whether all values are NaN, which stands for missing M values
+func allNaN(values []float64) bool {
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// fgbValue decodes a value of a column type, and returns it along with the
+// number of bytes it takes, or -1 for an unknown type
+func fgbValue(data []byte, kind uint8) (interface{}, int) {
+	switch kind {
+	case fgbByte:
+		return int64(int8(data[0])), 1
+	case fgbUByte:
+		return int64(data[0]), 1
+	case fgbBool:
+		return data[0] != 0, 1
+	case fgbShort:
+		return int64(int16(binary.LittleEndian.Uint16(data))), 2
+	case fgbUShort:
+		return int64(binary.LittleEndian.Uint16(data)), 2
+	case fgbInt:
+		return int64(int32(binary.LittleEndian.Uint32(data))), 4
+	case fgbUInt:
+		return int64(binary.LittleEndian.Uint32(data)), 4
+	case fgbLong:
+		return int64(binary.LittleEndian.Uint64(data)), 8
+	case fgbULong:
+		v := binary.LittleEndian.Uint64(data)
+		if v > math.MaxInt64 {
+			return float64(v), 8
+		}
+		return int64(v), 8
+	case fgbFloat:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data))), 4
+	case fgbDouble:
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), 8
+	case fgbString, fgbJSON, fgbDateTime, fgbBinary:
+		length := int(binary.LittleEndian.Uint32(data))
+		raw := data[4 : 4+length]
+		switch kind {
+		case fgbJSON:
+			var value interface{}
+			if err := jsonConfig.Unmarshal(raw, &value); err == nil {
+				return value, 4 + length
+			}
+		case fgbDateTime:
+			return dateTimeValue(string(raw)), 4 + length
+		case fgbBinary:
+			return append([]byte(nil), raw...), 4 + length
+		}
+		return string(raw), 4 + length
+	}
+	return nil, -1
+}
+
+// dateTimeValue parses an ISO 8601 date and time, keeping it as text if it
+// is not valid
+func dateTimeValue(s string) interface{} {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return s
+}
+
+// fgbGeometryDecoder collects the Z and M ordinates of the parts of a
+// geometry in traversal order
+type fgbGeometryDecoder struct {
+	z, m []float64
+}
+
+// decode converts a geometry, whose type is given by the header unless it is
+// unknown there
+func (d *fgbGeometryDecoder) decode(t fbTable, geometryType uint8) (orb.Geometry, error) {
+	if geometryType == fgbUnknown {
+		geometryType = t.byteField(fgbGeometryType, fgbUnknown)
+	}
+	switch geometryType {
+	case fgbMultiPolygon:
+		var multi orb.MultiPolygon
+		for _, part := range t.tablesField(fgbGeometryParts) {
+			g, err := d.decode(part, fgbPolygon)
+			if err != nil {
+				return nil, err
+			}
+			multi = append(multi, g.(orb.Polygon))
+		}
+		return multi, nil
+	case fgbGeometryCollection:
+		var collection orb.Collection
+		for _, part := range t.tablesField(fgbGeometryParts) {
+			g, err := d.decode(part, fgbUnknown)
+			if err != nil {
+				return nil, err
+			}
+			if g != nil {
+				collection = append(collection, g)
+			}
+		}
+		return collection, nil
+	}
+	xy := t.float64sField(fgbGeometryXY)
+	points := make([]orb.Point, len(xy)/2)
+	for i := range points {
+		points[i] = orb.Point{xy[2*i], xy[2*i+1]}
+	}
+	d.z = append(d.z, t.float64sField(fgbGeometryZ)...)
+	d.m = append(d.m, t.float64sField(fgbGeometryM)...)
+	switch geometryType {
+	case fgbPoint:
+		if len(points) == 0 {
+			return nil, nil
+		}
+		return points[0], nil
+	case fgbMultiPoint:
+		return orb.MultiPoint(points), nil
+	case fgbLineString:
+		return orb.LineString(points), nil
+	case fgbMultiLineString:
+		parts, err := fgbParts(points, t.uint32sField(fgbGeometryEnds))
+		multi := make(orb.MultiLineString, len(parts))
+		for i, part := range parts {
+			multi[i] = orb.LineString(part)
+		}
+		return multi, err
+	case fgbPolygon:
+		parts, err := fgbParts(points, t.uint32sField(fgbGeometryEnds))
+		polygon := make(orb.Polygon, len(parts))
+		for i, part := range parts {
+			polygon[i] = orb.Ring(part)
+		}
+		return polygon, err
+	}
+	if int(geometryType) < len(fgbGeometryTypes) {
+		return nil, fmt.Errorf("unsupported geometry type %s", fgbGeometryTypes[geometryType])
+	}
+	return nil, fmt.Errorf("unknown geometry type %d", geometryType)
+}
+
+// fgbParts splits the points of a geometry at the end index of each part. A
+// geometry of a single part has no ends.
+func fgbParts(points []orb.Point, ends []uint32) ([][]orb.Point, error) {
+	if len(ends) == 0 {
+		return [][]orb.Point{points}, nil
+	}
+	parts := make([][]orb.Point, len(ends))
+	start := 0
+	for i, end := range ends {
+		if int(end) < start || int(end) > len(points) {
+			return nil, fmt.Errorf("invalid end %d of part %d", end, i)
+		}
+		parts[i] = points[start:end]
+		start = int(end)
+	}
+	return parts, nil
+}
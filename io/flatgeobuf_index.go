@@ -0,0 +1,217 @@
+package io
+
+import (
+	"encoding/binary"
+	"github.com/paulmach/orb"
+	"io"
+	"math"
+	"sort"
+)
+
+// fgbNodeLength is the length of a node of a packed Hilbert R-tree: the
+// minimum and maximum X and Y of its bounding box, then an offset
+const fgbNodeLength = 40
+
+// fgbEmptyBound is the bounding box of a feature without geometry, which
+// intersects no other and leaves the bounding box of its parent unchanged
+var fgbEmptyBound = orb.Bound{Min: orb.Point{math.Inf(1), math.Inf(1)}, Max: orb.Point{math.Inf(-1), math.Inf(-1)}}
+
+// fgbNode is a node of a packed Hilbert R-tree. The offset of a leaf is the
+// byte offset of its feature from the first feature, and the offset of any
+// other node is the index of its first child.
+type fgbNode struct {
+	bound  orb.Bound
+	offset uint64
+}
+
+// fgbLevelBounds returns the range of node indexes of each level of a packed
+// R-tree of n items, from the leaves to the root. The root is stored first
+// and the leaves last.
+func fgbLevelBounds(n uint64, nodeSize uint16) [][2]uint64 {
+	counts := []uint64{n}
+	total := n
+	for count := n; ; {
+		count = (count + uint64(nodeSize) - 1) / uint64(nodeSize)
+		counts = append(counts, count)
+		total += count
+		if count == 1 {
+			break
+		}
+	}
+	bounds := make([][2]uint64, len(counts))
+	for i, count := range counts {
+		total -= count
+		bounds[i] = [2]uint64{total, total + count}
+	}
+	return bounds
+}
+
+// fgbIndexLength returns the length in bytes of the packed R-tree of n items
+func fgbIndexLength(n uint64, nodeSize uint16) int64 {
+	bounds := fgbLevelBounds(n, nodeSize)
+	return int64(bounds[0][1]) * fgbNodeLength
+}
+
+// fgbHilbert returns the position of a point of a 65536 by 65536 grid along a
+// Hilbert curve, with the branch-free algorithm of the reference
+// implementation
+func fgbHilbert(x, y uint32) uint32 {
+	a := x ^ y
+	b := 0xFFFF ^ a
+	c := 0xFFFF ^ (x | y)
+	d := x & (y ^ 0xFFFF)
+	A := a | (b >> 1)
+	B := (a >> 1) ^ a
+	C := ((c >> 1) ^ (b & (d >> 1))) ^ c
+	D := ((a & (c >> 1)) ^ (d >> 1)) ^ d
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 2)) ^ (b & (b >> 2))
+	B = (a & (b >> 2)) ^ (b & ((a ^ b) >> 2))
+	C ^= (a & (c >> 2)) ^ (b & (d >> 2))
+	D ^= (b & (c >> 2)) ^ ((a ^ b) & (d >> 2))
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 4)) ^ (b & (b >> 4))
+	B = (a & (b >> 4)) ^ (b & ((a ^ b) >> 4))
+	C ^= (a & (c >> 4)) ^ (b & (d >> 4))
+	D ^= (b & (c >> 4)) ^ ((a ^ b) & (d >> 4))
+
+	a, b, c, d = A, B, C, D
+	C ^= (a & (c >> 8)) ^ (b & (d >> 8))
+	D ^= (b & (c >> 8)) ^ ((a ^ b) & (d >> 8))
+
+	a = C ^ (C >> 1)
+	b = D ^ (D >> 1)
+
+	i0 := x ^ y
+	i1 := b | (0xFFFF ^ (i0 | a))
+	for _, shift := range []struct {
+		n    uint
+		mask uint32
+	}{{8, 0x00FF00FF}, {4, 0x0F0F0F0F}, {2, 0x33333333}, {1, 0x55555555}} {
+		i0 = (i0 | (i0 << shift.n)) & shift.mask
+		i1 = (i1 | (i1 << shift.n)) & shift.mask
+	}
+	return (i1 << 1) | i0
+}
+
+// fgbHilbertOrder returns the indexes of bounds sorted by the position of
+// their center along a Hilbert curve covering their extent, so that nearby
+// items are stored together. Like the reference implementation, it sorts them
+// in decreasing order. Empty bounds come last.
+func fgbHilbertOrder(bounds []orb.Bound) []int {
+	extent := fgbEmptyBound
+	for _, b := range bounds {
+		if extent.IsEmpty() {
+			extent = b
+		} else {
+			extent = extent.Union(b)
+		}
+	}
+	width, height := extent.Max[0]-extent.Min[0], extent.Max[1]-extent.Min[1]
+	values := make([]uint64, len(bounds))
+	order := make([]int, len(bounds))
+	for i, b := range bounds {
+		order[i] = i
+		if b.IsEmpty() {
+			continue
+		}
+		var x, y uint32
+		center := b.Center()
+		if width != 0 {
+			x = uint32(math.Floor(0xFFFF * (center[0] - extent.Min[0]) / width))
+		}
+		if height != 0 {
+			y = uint32(math.Floor(0xFFFF * (center[1] - extent.Min[1]) / height))
+		}
+		// Shifted so that empty bounds, left at 0, sort last
+		values[i] = uint64(fgbHilbert(x, y)) + 1
+	}
+	sort.SliceStable(order, func(i, j int) bool { return values[order[i]] > values[order[j]] })
+	return order
+}
+
+// fgbBuildIndex returns a packed R-tree over leaves, which are given in the
+// order of their features
+func fgbBuildIndex(leaves []fgbNode, nodeSize uint16) []byte {
+	levels := fgbLevelBounds(uint64(len(leaves)), nodeSize)
+	nodes := make([]fgbNode, levels[0][1])
+	copy(nodes[levels[0][0]:], leaves)
+	for i, level := range levels[:len(levels)-1] {
+		parent := levels[i+1][0]
+		for pos := level[0]; pos < level[1]; parent++ {
+			node := fgbNode{bound: nodes[pos].bound, offset: pos}
+			for end := pos + uint64(nodeSize); pos < end && pos < level[1]; pos++ {
+				node.bound = node.bound.Union(nodes[pos].bound)
+			}
+			nodes[parent] = node
+		}
+	}
+	index := make([]byte, len(nodes)*fgbNodeLength)
+	for i, node := range nodes {
+		item := index[i*fgbNodeLength:]
+		for j, v := range []float64{node.bound.Min[0], node.bound.Min[1], node.bound.Max[0], node.bound.Max[1]} {
+			binary.LittleEndian.PutUint64(item[8*j:], math.Float64bits(v))
+		}
+		binary.LittleEndian.PutUint64(item[32:], node.offset)
+	}
+	return index
+}
+
+// fgbSearchResult is a feature found in a packed R-tree, with its index in
+// the file and its byte offset from the first feature
+type fgbSearchResult struct {
+	index  int
+	offset uint64
+}
+
+// fgbSearch returns the features of a packed R-tree of n items whose bounding
+// box intersects a bound, in the order they are stored. It reads the nodes it
+// visits from index, one level at a time.
+func fgbSearch(index io.ReaderAt, n uint64, nodeSize uint16, bound orb.Bound) ([]fgbSearchResult, error) {
+	levels := fgbLevelBounds(n, nodeSize)
+	buf := make([]byte, int(nodeSize)*fgbNodeLength)
+	var results []fgbSearchResult
+	queue := []uint64{0}
+	for level := len(levels) - 1; level >= 0 && len(queue) > 0; level-- {
+		var next []uint64
+		for _, first := range queue {
+			end := first + uint64(nodeSize)
+			if end > levels[level][1] {
+				end = levels[level][1]
+			}
+			if first >= end {
+				return nil, errMalformedIndex
+			}
+			nodes := buf[:(end-first)*fgbNodeLength]
+			if _, err := index.ReadAt(nodes, int64(first)*fgbNodeLength); err != nil {
+				return nil, err
+			}
+			for pos := first; pos < end; pos++ {
+				node := fgbReadNode(nodes[(pos-first)*fgbNodeLength:])
+				if !node.bound.Intersects(bound) {
+					continue
+				}
+				if level == 0 {
+					results = append(results, fgbSearchResult{int(pos - levels[0][0]), node.offset})
+				} else {
+					next = append(next, node.offset)
+				}
+			}
+		}
+		queue = next
+	}
+	return results, nil
+}
+
+func fgbReadNode(item []byte) fgbNode {
+	var v [4]float64
+	for i := range v {
+		v[i] = math.Float64frombits(binary.LittleEndian.Uint64(item[8*i:]))
+	}
+	return fgbNode{
+		bound:  orb.Bound{Min: orb.Point{v[0], v[1]}, Max: orb.Point{v[2], v[3]}},
+		offset: binary.LittleEndian.Uint64(item[32:]),
+	}
+}
@@ -0,0 +1,336 @@
+package io
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/project"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// writeFlatGeobuf writes features to a FlatGeobuf buffer
+func writeFlatGeobuf(t *testing.T, features []*Feature, nodeSize uint16) []byte {
+	var buf bytes.Buffer
+	writer, _ := NewFlatGeobufWriter(&buf)
+	writer.IndexNodeSize = nodeSize
+	if err := writeAll(writer, features); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFlatGeobufRoundTrip(t *testing.T) {
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	features := []*Feature{
+		{
+			ID:         1.0,
+			Geometry:   orb.Point{1, 2},
+			Z:          []float64{3},
+			Properties: Properties{{Key: "name", Value: "a"}, {Key: "n", Value: 1.0}, {Key: "id", Value: "x"}},
+		},
+		{
+			ID:         2.0,
+			Geometry:   orb.LineString{{0, 0}, {1, 1}},
+			M:          []float64{5, 6},
+			Properties: Properties{{Key: "name", Value: nil}, {Key: "n", Value: 2.5}, {Key: "ok", Value: true}},
+		},
+		{
+			ID:         3.0,
+			Properties: Properties{{Key: "date", Value: date}, {Key: "list", Value: []interface{}{1.0, "b"}}},
+		},
+		{
+			ID:       4.0,
+			Geometry: orb.Polygon{{{0, 0}, {4, 0}, {4, 4}, {0, 0}}, {{1, 1}, {2, 1}, {2, 2}, {1, 1}}},
+		},
+		{
+			ID:       5.0,
+			Geometry: orb.MultiPolygon{{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}, {{{5, 5}, {6, 5}, {6, 6}, {5, 5}}}},
+			Z:        []float64{1, 2, 3, 4, 5, 6, 7, 8},
+			M:        []float64{1, 2, 3, 4, 5, 6, 7, 8},
+		},
+	}
+	want := []*Feature{
+		{
+			ID:         int64(1),
+			Geometry:   orb.Point{1, 2},
+			Z:          []float64{3},
+			Properties: Properties{{"name", "a"}, {"n", 1.0}, {"id", "x"}},
+		},
+		{
+			ID:         int64(2),
+			Geometry:   orb.LineString{{0, 0}, {1, 1}},
+			Z:          []float64{0, 0},
+			M:          []float64{5, 6},
+			Properties: Properties{{"n", 2.5}, {"ok", true}},
+		},
+		{
+			ID:         int64(3),
+			Properties: Properties{{"date", date}, {"list", []interface{}{1.0, "b"}}},
+		},
+		{
+			ID:       int64(4),
+			Geometry: orb.Polygon{{{0, 0}, {4, 0}, {4, 4}, {0, 0}}, {{1, 1}, {2, 1}, {2, 2}, {1, 1}}},
+			Z:        []float64{0, 0, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			ID:       int64(5),
+			Geometry: features[4].Geometry,
+			Z:        features[4].Z,
+			M:        features[4].M,
+		},
+	}
+
+	for _, nodeSize := range []uint16{0, 2, 16} {
+		t.Run(fmt.Sprintf("node size %d", nodeSize), func(t *testing.T) {
+			reader, err := NewFlatGeobufReader(bytes.NewReader(writeFlatGeobuf(t, features, nodeSize)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			read, err := readAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The index sorts features along a Hilbert curve
+			sort.Slice(read, func(i, j int) bool { return read[i].ID.(int64) < read[j].ID.(int64) })
+			if len(read) != len(want) {
+				t.Fatalf("read %d features, want %d", len(read), len(want))
+			}
+			for i, f := range read {
+				if !reflect.DeepEqual(f, want[i]) {
+					t.Errorf("feature %d: got %+v, want %+v", i, f, want[i])
+				}
+			}
+		})
+	}
+}
+
+// randomFeatures returns features with random points and boxes, and some
+// without geometry
+func randomFeatures(n int) []*Feature {
+	r := rand.New(rand.NewSource(1))
+	features := make([]*Feature, n)
+	for i := range features {
+		p := orb.Point{r.Float64()*360 - 180, r.Float64()*180 - 90}
+		var g orb.Geometry = p
+		switch i % 5 {
+		case 1:
+			g = orb.Bound{Min: p, Max: orb.Point{p[0] + r.Float64()*10, p[1] + r.Float64()*10}}.ToPolygon()
+		case 2:
+			g = orb.LineString{p, {p[0] + r.Float64(), p[1] - r.Float64()}}
+		case 3:
+			if i%3 == 0 {
+				g = nil
+			}
+		}
+		features[i] = &Feature{ID: float64(i), Geometry: g}
+	}
+	return features
+}
+
+// readerOnly hides the io.ReaderAt of a reader, so that it is read as a
+// stream
+type readerOnly struct {
+	io.Reader
+}
+
+func TestFlatGeobufBBox(t *testing.T) {
+	features := randomFeatures(3000)
+	bounds := make([]orb.Bound, len(features))
+	for i, f := range features {
+		bounds[i] = fgbEmptyBound
+		if f.Geometry != nil {
+			bounds[i] = f.Geometry.Bound()
+		}
+	}
+	data := writeFlatGeobuf(t, features, 16)
+	file, err := ioutil.TempFile("", "xgeo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []orb.Bound{
+		{Min: orb.Point{-10, -10}, Max: orb.Point{10, 10}},
+		{Min: orb.Point{100, 40}, Max: orb.Point{101, 41}},
+		{Min: orb.Point{-180, -90}, Max: orb.Point{180, 90}},
+		{Min: orb.Point{0, 89}, Max: orb.Point{0, 89}},
+		{Min: orb.Point{200, 0}, Max: orb.Point{210, 10}},
+	}
+	for _, query := range queries {
+		var want []int
+		for i, b := range bounds {
+			if b.Intersects(query) {
+				want = append(want, i)
+			}
+		}
+		for _, input := range []struct {
+			name   string
+			reader io.Reader
+		}{
+			{"file", io.NewSectionReader(file, 0, int64(len(data)))},
+			{"stream", readerOnly{bytes.NewReader(data)}},
+		} {
+			reader, err := NewFlatGeobufReader(input.reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reader.nodeSize == 0 {
+				t.Fatal("no index was written")
+			}
+			reader.BBox = &query
+			read, err := readAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []int{}
+			for _, f := range read {
+				got = append(got, int(f.ID.(int64)))
+			}
+			sort.Ints(got)
+			if len(want) == 0 {
+				want = []int{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %v: found %d features, want %d", input.name, query, len(got), len(want))
+			}
+		}
+	}
+}
+
+func TestFlatGeobufBBoxProjected(t *testing.T) {
+	var features []*Feature
+	for _, p := range []orb.Point{{0, 0}, {0.5, 0.5}, {2, 2}} {
+		feature := NewFeature(project.Point(p, project.WGS84.ToMercator))
+		feature.Properties.Set("lon", p[0])
+		features = append(features, feature)
+	}
+	data := writeFlatGeobuf(t, features, 16)
+	// Mark the coordinates, written in meters, as EPSG:3857
+	header := data[:12+binary.LittleEndian.Uint32(data[8:12])]
+	code := []byte{0xe6, 0x10, 0, 0}
+	if bytes.Count(header, code) != 1 {
+		t.Fatal("cannot find the EPSG code of the header")
+	}
+	binary.LittleEndian.PutUint32(header[bytes.Index(header, code):], 3857)
+
+	for _, input := range []struct {
+		name   string
+		reader io.Reader
+	}{
+		{"file", bytes.NewReader(data)},
+		{"stream", readerOnly{bytes.NewReader(data)}},
+	} {
+		reader, err := NewFlatGeobufReader(input.reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reader.projection == nil {
+			t.Fatal("the file is not projected")
+		}
+		// The index would only find the first point, within a meter of 0, 0
+		reader.BBox = &orb.Bound{Min: orb.Point{-1, -1}, Max: orb.Point{1, 1}}
+		read, err := readAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []float64
+		for _, f := range read {
+			lon, _ := f.Properties.Get("lon")
+			got = append(got, lon.(float64))
+		}
+		// Features are stored in the order of the index
+		sort.Float64s(got)
+		if !reflect.DeepEqual(got, []float64{0, 0.5}) {
+			t.Errorf("%s: got the features at longitudes %v", input.name, got)
+		}
+	}
+}
+
+func TestFlatGeobufSearch(t *testing.T) {
+	features := randomFeatures(500)
+	bounds := make([]orb.Bound, len(features))
+	for i, f := range features {
+		bounds[i] = fgbEmptyBound
+		if f.Geometry != nil {
+			bounds[i] = f.Geometry.Bound()
+		}
+	}
+	query := orb.Bound{Min: orb.Point{-50, -20}, Max: orb.Point{20, 30}}
+	for _, nodeSize := range []uint16{2, 3, 16, 1000} {
+		order := fgbHilbertOrder(bounds)
+		leaves := make([]fgbNode, len(order))
+		for i, j := range order {
+			leaves[i] = fgbNode{bounds[j], uint64(j)}
+		}
+		index := fgbBuildIndex(leaves, nodeSize)
+		if int64(len(index)) != fgbIndexLength(uint64(len(leaves)), nodeSize) {
+			t.Errorf("node size %d: index of %d bytes, want %d", nodeSize, len(index), fgbIndexLength(uint64(len(leaves)), nodeSize))
+		}
+		results, err := fgbSearch(bytes.NewReader(index), uint64(len(leaves)), nodeSize, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := map[int]bool{}
+		for k, result := range results {
+			if k > 0 && result.index <= results[k-1].index {
+				t.Errorf("node size %d: results out of order", nodeSize)
+			}
+			found[int(result.offset)] = true
+		}
+		for i, b := range bounds {
+			if b.Intersects(query) != found[i] {
+				t.Errorf("node size %d: feature %d found %v", nodeSize, i, found[i])
+			}
+		}
+	}
+}
+
+func TestFlatGeobufHilbert(t *testing.T) {
+	// The first 4^8 positions of the curve fill the 256 by 256 corner of the
+	// grid, one cell after another
+	const size = 256
+	cells := make([][2]int, size*size)
+	seen := make([]bool, size*size)
+	for x := 0; x < size; x++ {
+		for y := 0; y < size; y++ {
+			d := fgbHilbert(uint32(x), uint32(y))
+			if d >= size*size || seen[d] {
+				t.Fatalf("cell %d, %d at position %d", x, y, d)
+			}
+			seen[d] = true
+			cells[d] = [2]int{x, y}
+		}
+	}
+	for d := 1; d < len(cells); d++ {
+		dx, dy := cells[d][0]-cells[d-1][0], cells[d][1]-cells[d-1][1]
+		if math.Abs(float64(dx))+math.Abs(float64(dy)) != 1 {
+			t.Fatalf("positions %d and %d are not adjacent", d-1, d)
+		}
+	}
+}
+
+func TestFlatGeobufHilbertOrder(t *testing.T) {
+	bounds := []orb.Bound{
+		fgbEmptyBound,
+		{Min: orb.Point{0, 0}, Max: orb.Point{0, 0}},
+		{Min: orb.Point{10, 10}, Max: orb.Point{10, 10}},
+		{Min: orb.Point{0, 10}, Max: orb.Point{0, 10}},
+	}
+	order := fgbHilbertOrder(bounds)
+	if order[len(order)-1] != 0 {
+		t.Errorf("got order %v, want the empty bound last", order)
+	}
+}
@@ -0,0 +1,400 @@
+package io
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/paulmach/orb"
+	"io"
+	"math"
+	"time"
+)
+
+// FlatGeobufWriter writes features to a FlatGeobuf file, with a column for
+// each property key, typed after the values of the property. Values that do
+// not fit the type of their column are written as strings, and null values
+// are left out. Feature IDs are written to a primary key column named id, or
+// id_1 and so on if a property has that name. The layer has Z or M ordinates
+// if any feature has them, and features without them are written with a Z of
+// 0 and M values of NaN.
+//
+// Since the header holds the schema and the number of features, and the index
+// comes before the features, all features are kept in memory until the input
+// ends.
+type FlatGeobufWriter struct {
+	output io.Writer
+
+	// Name is the name of the layer written in the header
+	Name string
+
+	// IndexNodeSize is the number of children of each node of the packed
+	// Hilbert R-tree index, or 0 to write no index. Features are stored in
+	// the order of the index, rather than in input order. Features without
+	// geometry are indexed with an empty bounding box, which no search finds.
+	IndexNodeSize uint16
+}
+
+func NewFlatGeobufWriter(output io.Writer) (*FlatGeobufWriter, error) {
+	return &FlatGeobufWriter{
+		output:        output,
+		IndexNodeSize: 16,
+	}, nil
+}
+
+func (w *FlatGeobufWriter) Write(in chan *Feature) error {
+	var features []*Feature
+	for feature := range in {
+		if feature != nil {
+			features = append(features, feature)
+		}
+	}
+	if w.IndexNodeSize == 1 {
+		return errors.New("the nodes of an index must have at least 2 children")
+	}
+	columns := fgbInferColumns(features)
+	if len(columns) > math.MaxUint16+1 {
+		return errors.New("FlatGeobuf files have at most 65536 columns")
+	}
+	geometryType, hasZ, hasM := fgbLayerType(features)
+
+	nodeSize := w.IndexNodeSize
+	bounds := make([]orb.Bound, len(features))
+	var extent orb.Bound
+	empty := true
+	for i, feature := range features {
+		if PositionCount(feature.Geometry) == 0 {
+			bounds[i] = fgbEmptyBound
+			continue
+		}
+		bounds[i] = feature.Geometry.Bound()
+		if empty {
+			extent, empty = bounds[i], false
+		}
+		extent = extent.Union(bounds[i])
+	}
+	if empty {
+		nodeSize = 0
+	}
+	order := make([]int, len(features))
+	for i := range order {
+		order[i] = i
+	}
+	if nodeSize > 0 {
+		order = fgbHilbertOrder(bounds)
+	}
+
+	encoded := make([][]byte, len(features))
+	leaves := make([]fgbNode, len(features))
+	var offset uint64
+	for i, j := range order {
+		encoded[i] = fgbEncodeFeature(features[j], columns, geometryType, hasZ, hasM)
+		leaves[i] = fgbNode{bounds[j], offset}
+		offset += uint64(len(encoded[i]))
+		// Only the encoded feature is kept
+		features[j] = nil
+	}
+
+	header := []fbField{
+		fbScalar(fgbHeaderGeometryType, 1, geometryType),
+		fbBool(fgbHeaderHasZ, hasZ),
+		fbBool(fgbHeaderHasM, hasM),
+		fbScalar(fgbHeaderFeaturesCount, 8, uint64(len(features))),
+		fbScalar(fgbHeaderIndexNodeSize, 2, uint64(nodeSize)),
+		fbTableRef(fgbHeaderCRS, []fbField{fbString(fgbCRSOrg, "EPSG"), fbScalar(fgbCRSCode, 4, 4326)}),
+	}
+	if w.Name != "" {
+		header = append(header, fbString(fgbHeaderName, w.Name))
+	}
+	if !empty {
+		header = append(header, fbFloat64s(fgbHeaderEnvelope, []float64{extent.Min[0], extent.Min[1], extent.Max[0], extent.Max[1]}))
+	}
+	if len(columns) > 0 {
+		tables := make([][]fbField, len(columns))
+		for i, column := range columns {
+			tables[i] = []fbField{fbString(fgbColumnName, column.name), fbScalar(fgbColumnType, 1, uint64(column.kind))}
+			if column.id {
+				tables[i] = append(tables[i], fbBool(fgbColumnPrimaryKey, true))
+			}
+		}
+		header = append(header, fbTables(fgbHeaderColumns, tables))
+	}
+
+	out := bufio.NewWriter(w.output)
+	out.Write(fgbMagic)
+	out.WriteByte(0)
+	out.Write(newFBBuilder().finish(header))
+	if nodeSize > 0 {
+		out.Write(fgbBuildIndex(leaves, nodeSize))
+	}
+	for _, data := range encoded {
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// fgbLayerType returns the geometry type shared by all features, or unknown
+// if they have different ones, and whether any of them has Z and M ordinates
+func fgbLayerType(features []*Feature) (uint64, bool, bool) {
+	geometryType := -1
+	hasZ, hasM := false, false
+	for _, feature := range features {
+		if feature.Geometry == nil {
+			continue
+		}
+		t := fgbTypeOf(feature.Geometry)
+		if geometryType == -1 {
+			geometryType = t
+		} else if geometryType != t {
+			geometryType = fgbUnknown
+		}
+		hasZ = hasZ || feature.HasZ()
+		hasM = hasM || feature.HasM()
+	}
+	if geometryType == -1 {
+		geometryType = fgbUnknown
+	}
+	return uint64(geometryType), hasZ, hasM
+}
+
+func fgbTypeOf(g orb.Geometry) int {
+	switch g.(type) {
+	case orb.Point:
+		return fgbPoint
+	case orb.MultiPoint:
+		return fgbMultiPoint
+	case orb.LineString:
+		return fgbLineString
+	case orb.MultiLineString:
+		return fgbMultiLineString
+	case orb.Polygon, orb.Ring, orb.Bound:
+		return fgbPolygon
+	case orb.MultiPolygon:
+		return fgbMultiPolygon
+	}
+	return fgbGeometryCollection
+}
+
+// fgbInferColumns chooses a column for every property key of the features,
+// in order of first appearance: the type of all its values if they have the
+// same, a double for a mix of integers and floating point numbers, or else a
+// string. If any feature has an ID, an ID column comes first.
+func fgbInferColumns(features []*Feature) []fgbColumn {
+	var columns []fgbColumn
+	kinds := map[string]map[uint8]bool{}
+	ids := map[uint8]bool{}
+	for _, feature := range features {
+		if feature.ID != nil {
+			ids[fgbKindOf(feature.ID)] = true
+		}
+		for _, prop := range feature.Properties {
+			seen, ok := kinds[prop.Key]
+			if !ok {
+				seen = map[uint8]bool{}
+				kinds[prop.Key] = seen
+				columns = append(columns, fgbColumn{name: prop.Key})
+			}
+			if prop.Value != nil {
+				seen[fgbKindOf(prop.Value)] = true
+			}
+		}
+	}
+	for i, column := range columns {
+		columns[i].kind = fgbColumnKind(kinds[column.name])
+	}
+	if len(ids) > 0 {
+		name := "id"
+		for i := 1; kinds[name] != nil; i++ {
+			name = fmt.Sprintf("id_%d", i)
+		}
+		columns = append([]fgbColumn{{name: name, kind: fgbColumnKind(ids), id: true}}, columns...)
+	}
+	return columns
+}
+
+// fgbColumnKind returns the column type that holds values of the given types
+func fgbColumnKind(seen map[uint8]bool) uint8 {
+	switch {
+	case len(seen) == 1:
+		for kind := range seen {
+			return kind
+		}
+	case len(seen) == 2 && (seen[fgbInt] || seen[fgbLong]) && seen[fgbDouble]:
+		return fgbDouble
+	case len(seen) == 2 && seen[fgbInt] && seen[fgbLong]:
+		return fgbLong
+	}
+	return fgbString
+}
+
+// fgbKindOf returns the narrowest column type that holds a value
+func fgbKindOf(value interface{}) uint8 {
+	var n int64
+	switch v := value.(type) {
+	case bool:
+		return fgbBool
+	case int:
+		n = int64(v)
+	case int64:
+		n = v
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) >= 1<<53 {
+			return fgbDouble
+		}
+		n = int64(v)
+	case time.Time:
+		return fgbDateTime
+	case []interface{}, map[string]interface{}:
+		return fgbJSON
+	default:
+		return fgbString
+	}
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		return fgbLong
+	}
+	return fgbInt
+}
+
+func fgbEncodeFeature(feature *Feature, columns []fgbColumn, layerType uint64, hasZ, hasM bool) []byte {
+	var fields []fbField
+	if feature.Geometry != nil {
+		e := &fgbGeometryEncoder{}
+		n := PositionCount(feature.Geometry)
+		if hasZ {
+			e.z = feature.Z
+			if !feature.HasZ() {
+				e.z = make([]float64, n)
+			}
+		}
+		if hasM {
+			e.m = feature.M
+			if !feature.HasM() {
+				e.m = make([]float64, n)
+				for i := range e.m {
+					e.m[i] = math.NaN()
+				}
+			}
+		}
+		fields = append(fields, fbTableRef(fgbFeatureGeometry, e.encode(feature.Geometry, layerType == fgbUnknown)))
+	}
+	var properties []byte
+	for i, column := range columns {
+		value := feature.ID
+		if !column.id {
+			value, _ = feature.Properties.Get(column.name)
+		}
+		if value == nil {
+			continue
+		}
+		properties = append(properties, byte(i), byte(i>>8))
+		properties = fgbAppendValue(properties, column.kind, value)
+	}
+	if len(properties) > 0 {
+		fields = append(fields, fbBytes(fgbFeatureProperties, properties))
+	}
+	return newFBBuilder().finish(fields)
+}
+
+// fgbAppendValue encodes a property value for a column of a given type
+func fgbAppendValue(data []byte, kind uint8, value interface{}) []byte {
+	switch kind {
+	case fgbBool:
+		if value.(bool) {
+			return append(data, 1)
+		}
+		return append(data, 0)
+	case fgbInt, fgbLong, fgbDouble:
+		var f float64
+		switch v := value.(type) {
+		case int:
+			f = float64(v)
+		case int64:
+			if kind == fgbLong {
+				return appendUint64(data, uint64(v))
+			}
+			f = float64(v)
+		case float64:
+			f = v
+		}
+		switch kind {
+		case fgbInt:
+			return appendUint32(data, uint32(int32(f)))
+		case fgbLong:
+			return appendUint64(data, uint64(int64(f)))
+		}
+		return appendUint64(data, math.Float64bits(f))
+	}
+	s := stringValue(value)
+	return append(appendUint32(data, uint32(len(s))), s...)
+}
+
+// fgbGeometryEncoder splits the Z and M ordinates of a geometry between the
+// parts it is written as
+type fgbGeometryEncoder struct {
+	z, m  []float64
+	index int
+}
+
+// encode returns the fields of a geometry, whose type is written if the
+// layer has none
+func (e *fgbGeometryEncoder) encode(g orb.Geometry, typed bool) []fbField {
+	var fields []fbField
+	if typed {
+		fields = append(fields, fbScalar(fgbGeometryType, 1, uint64(fgbTypeOf(g))))
+	}
+	var parts [][]orb.Point
+	switch g := g.(type) {
+	case orb.Point:
+		parts = [][]orb.Point{{g}}
+	case orb.MultiPoint:
+		parts = [][]orb.Point{g}
+	case orb.LineString:
+		parts = [][]orb.Point{g}
+	case orb.MultiLineString:
+		for _, line := range g {
+			parts = append(parts, line)
+		}
+	case orb.Ring:
+		parts = [][]orb.Point{g}
+	case orb.Bound:
+		parts = [][]orb.Point{g.ToRing()}
+	case orb.Polygon:
+		for _, ring := range g {
+			parts = append(parts, ring)
+		}
+	case orb.MultiPolygon:
+		tables := make([][]fbField, len(g))
+		for i, polygon := range g {
+			tables[i] = e.encode(polygon, true)
+		}
+		return append(fields, fbTables(fgbGeometryParts, tables))
+	case orb.Collection:
+		tables := make([][]fbField, len(g))
+		for i, item := range g {
+			tables[i] = e.encode(item, true)
+		}
+		return append(fields, fbTables(fgbGeometryParts, tables))
+	}
+	var xy []float64
+	var ends []uint32
+	for _, part := range parts {
+		for _, p := range part {
+			xy = append(xy, p[0], p[1])
+		}
+		ends = append(ends, uint32(len(xy)/2))
+	}
+	fields = append(fields, fbFloat64s(fgbGeometryXY, xy))
+	if len(parts) > 1 {
+		fields = append(fields, fbUint32s(fgbGeometryEnds, ends))
+	}
+	n := len(xy) / 2
+	if e.z != nil {
+		fields = append(fields, fbFloat64s(fgbGeometryZ, e.z[e.index:e.index+n]))
+	}
+	if e.m != nil {
+		fields = append(fields, fbFloat64s(fgbGeometryM, e.m[e.index:e.index+n]))
+	}
+	e.index += n
+	return fields
+}
@@ -1,12 +1,22 @@
 package io
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/json-iterator/go"
+	"github.com/paulmach/orb"
 	"io"
+	"sort"
+	"time"
 )
 
 const ParseBufferSize = 16 * 1024
 
+var jsonConfig = jsoniter.ConfigCompatibleWithStandardLibrary
+
 type GeoJSONReader struct {
 	input io.Reader
 }
@@ -17,27 +27,439 @@ func NewGeoJSONReader(input io.Reader) (*GeoJSONReader, error) {
 	}, nil
 }
 
-func (g *GeoJSONReader) Read(out chan map[string]interface{}) error {
-	cfg := jsoniter.Config{}
-	dec := jsoniter.Parse(cfg.Froze(), g.input, ParseBufferSize)
-	skipToFeatures(dec)
-	for dec.ReadArray() {
-		feature := dec.Read().(map[string]interface{})
-		out <- feature
+// Read emits the features of a FeatureCollection as they are parsed, or a
+// single feature if the input is a Feature or a bare geometry
+func (g *GeoJSONReader) Read(ctx context.Context, out chan *Feature) error {
+	input := &lineReader{r: bufio.NewReaderSize(g.input, ParseBufferSize), line: 1}
+	dec := jsoniter.Parse(jsonConfig, input, ParseBufferSize)
+	return readGeoJSON(ctx, dec, out, func() int {
+		return input.line
+	})
+}
+
+// readGeoJSON reads a GeoJSON object, calling line to find the line of each
+// feature for error reporting. An object with a "features" member is read as
+// a FeatureCollection even if it has no "type".
+func readGeoJSON(ctx context.Context, dec *jsoniter.Iterator, out chan *Feature, line func() int) error {
+	if dec.WhatIsNext() != jsoniter.ObjectValue {
+		if dec.Error != nil && dec.Error != io.EOF {
+			return dec.Error
+		}
+		return ErrNotFeatureCollection
 	}
-	return nil
+	start := line()
+	var geoJSONType string
+	collection := false
+	// The members of a Feature or a geometry, in case that is what this is
+	feature := &Feature{}
+	members := map[string]interface{}{}
+	for field := dec.ReadObject(); field != ""; field = dec.ReadObject() {
+		switch field {
+		case "type":
+			geoJSONType = dec.ReadString()
+		case "features":
+			collection = true
+			for i := 0; dec.ReadArray(); i++ {
+				// Skip whitespace so that the line is that of the feature
+				dec.WhatIsNext()
+				featureLine := line()
+				f, err := readFeature(dec)
+				if err != nil {
+					return &ErrMalformedFeature{Index: i, Line: featureLine, Err: err}
+				}
+				if err := Emit(ctx, out, f); err != nil {
+					return err
+				}
+			}
+		case "coordinates", "geometries":
+			members[field] = dec.Read()
+		default:
+			if err := readFeatureMember(dec, feature, field); err != nil {
+				return &ErrMalformedFeature{Line: start, Err: err}
+			}
+		}
+		if dec.Error != nil {
+			break
+		}
+	}
+	if dec.Error != nil && dec.Error != io.EOF {
+		return dec.Error
+	}
+	if geoJSONType == "" && collection {
+		geoJSONType = "FeatureCollection"
+	}
+	switch geoJSONType {
+	case "FeatureCollection":
+		return nil
+	case "Feature":
+		return Emit(ctx, out, feature)
+	case "Point", "MultiPoint", "LineString", "MultiLineString", "Polygon", "MultiPolygon", "GeometryCollection":
+	default:
+		return ErrNotFeatureCollection
+	}
+	members["type"] = geoJSONType
+	geometry, z, m, err := DecodeGeometryZM(members)
+	if err != nil {
+		return &ErrMalformedFeature{Line: start, Err: err}
+	}
+	bare := NewFeature(geometry)
+	bare.Z, bare.M, bare.BBox = z, m, feature.BBox
+	return Emit(ctx, out, bare)
+}
+
+// lineReader passes its input to the parser a line at a time, and counts the
+// lines, so that line is that of the byte the parser is at
+type lineReader struct {
+	r     *bufio.Reader
+	line  int
+	ended bool
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	if l.ended {
+		l.line++
+		l.ended = false
+	}
+	if l.r.Buffered() == 0 {
+		if _, err := l.r.Peek(1); err != nil {
+			return 0, err
+		}
+	}
+	n := l.r.Buffered()
+	if n > len(p) {
+		n = len(p)
+	}
+	data, _ := l.r.Peek(n)
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i+1]
+		l.ended = true
+	}
+	n = copy(p, data)
+	l.r.Discard(n)
+	return n, nil
 }
 
-func skipToFeatures(dec *jsoniter.Iterator) {
-	for {
-		field := dec.ReadObject()
-		if field == "" {
-			panic("GeoJSON object must have a \"features\" array")
+func readFeature(dec *jsoniter.Iterator) (*Feature, error) {
+	if dec.WhatIsNext() != jsoniter.ObjectValue {
+		dec.Skip()
+		return nil, fmt.Errorf("GeoJSON feature must be an object")
+	}
+	feature := &Feature{}
+	for field := dec.ReadObject(); field != ""; field = dec.ReadObject() {
+		if field == "type" {
+			if t := dec.ReadString(); t != "Feature" {
+				return nil, fmt.Errorf("GeoJSON feature has type %q", t)
+			}
+		} else if err := readFeatureMember(dec, feature, field); err != nil {
+			return nil, err
 		}
-		if field == "features" {
+		if dec.Error != nil {
 			break
 		}
-		// Skip whatever the value is
+	}
+	if dec.Error != nil && dec.Error != io.EOF {
+		return nil, dec.Error
+	}
+	return feature, nil
+}
+
+// readFeatureMember reads the value of any Feature member other than "type"
+func readFeatureMember(dec *jsoniter.Iterator, feature *Feature, field string) error {
+	switch field {
+	case "id":
+		feature.ID = dec.Read()
+	case "bbox":
+		for dec.ReadArray() {
+			feature.BBox = append(feature.BBox, dec.ReadFloat64())
+		}
+	case "geometry":
+		geometry, z, m, err := readGeometry(dec)
+		if err != nil {
+			return err
+		}
+		feature.Geometry, feature.Z, feature.M = geometry, z, m
+	case "properties":
+		if dec.ReadNil() {
+			return nil
+		}
+		for key := dec.ReadObject(); key != ""; key = dec.ReadObject() {
+			feature.Properties = append(feature.Properties, Property{key, dec.Read()})
+		}
+	default:
+		// Foreign members are not retained
 		dec.Skip()
 	}
+	return nil
+}
+
+func readGeometry(dec *jsoniter.Iterator) (orb.Geometry, []float64, []float64, error) {
+	if dec.ReadNil() {
+		return nil, nil, nil, nil
+	}
+	geometry, ok := dec.Read().(map[string]interface{})
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("GeoJSON geometry must be an object or null")
+	}
+	return DecodeGeometryZM(geometry)
+}
+
+func writeFeature(stream *jsoniter.Stream, f *Feature) {
+	stream.WriteObjectStart()
+	stream.WriteObjectField("type")
+	stream.WriteString("Feature")
+	if f.ID != nil {
+		stream.WriteMore()
+		stream.WriteObjectField("id")
+		writeValue(stream, f.ID)
+	}
+	if len(f.BBox) > 0 {
+		stream.WriteMore()
+		stream.WriteObjectField("bbox")
+		writeFloats(stream, f.BBox)
+	}
+	stream.WriteMore()
+	stream.WriteObjectField("geometry")
+	writeGeometry(stream, f)
+	stream.WriteMore()
+	stream.WriteObjectField("properties")
+	writeProperties(stream, f.Properties)
+	stream.WriteObjectEnd()
+}
+
+func writeProperties(stream *jsoniter.Stream, properties Properties) {
+	stream.WriteObjectStart()
+	for i, prop := range properties {
+		if i > 0 {
+			stream.WriteMore()
+		}
+		stream.WriteObjectField(prop.Key)
+		writeValue(stream, prop.Value)
+	}
+	stream.WriteObjectEnd()
+}
+
+// writeValue writes the JSON types that readers produce without going
+// through reflection, and falls back to encoding/json for anything else
+func writeValue(stream *jsoniter.Stream, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		stream.WriteNil()
+	case bool:
+		stream.WriteBool(v)
+	case string:
+		stream.WriteString(v)
+	case float64:
+		stream.WriteFloat64(v)
+	case int:
+		stream.WriteInt(v)
+	case int64:
+		stream.WriteInt64(v)
+	case time.Time:
+		stream.WriteString(FormatTime(v))
+	case []interface{}:
+		stream.WriteArrayStart()
+		for i, item := range v {
+			if i > 0 {
+				stream.WriteMore()
+			}
+			writeValue(stream, item)
+		}
+		stream.WriteArrayEnd()
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		stream.WriteObjectStart()
+		for i, key := range keys {
+			if i > 0 {
+				stream.WriteMore()
+			}
+			stream.WriteObjectField(key)
+			writeValue(stream, v[key])
+		}
+		stream.WriteObjectEnd()
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			stream.Error = err
+			return
+		}
+		stream.WriteRaw(string(raw))
+	}
+}
+
+func writeGeometry(stream *jsoniter.Stream, f *Feature) {
+	w := &coordinateWriter{stream: stream}
+	// GeoJSON positions can only carry M as a fourth ordinate after Z
+	if f.HasZ() {
+		w.z = f.Z
+		if f.HasM() {
+			w.m = f.M
+		}
+	}
+	w.writeGeometry(f.Geometry)
+}
+
+type coordinateWriter struct {
+	stream *jsoniter.Stream
+	z, m   []float64
+	i      int
+}
+
+func (w *coordinateWriter) writeGeometry(g orb.Geometry) {
+	stream := w.stream
+	if g == nil {
+		stream.WriteNil()
+		return
+	}
+	if b, ok := g.(orb.Bound); ok {
+		g = b.ToPolygon()
+	}
+	if r, ok := g.(orb.Ring); ok {
+		g = orb.Polygon{r}
+	}
+	stream.WriteObjectStart()
+	stream.WriteObjectField("type")
+	stream.WriteString(g.GeoJSONType())
+	stream.WriteMore()
+	if c, ok := g.(orb.Collection); ok {
+		stream.WriteObjectField("geometries")
+		stream.WriteArrayStart()
+		for i, item := range c {
+			if i > 0 {
+				stream.WriteMore()
+			}
+			w.writeGeometry(item)
+		}
+		stream.WriteArrayEnd()
+	} else {
+		stream.WriteObjectField("coordinates")
+		w.writeCoordinates(g)
+	}
+	stream.WriteObjectEnd()
+}
+
+func (w *coordinateWriter) writeCoordinates(g orb.Geometry) {
+	stream := w.stream
+	switch g := g.(type) {
+	case orb.Point:
+		w.writePosition(g)
+	case orb.MultiPoint:
+		w.writePoints(g)
+	case orb.LineString:
+		w.writePoints(g)
+	case orb.Ring:
+		w.writePoints(g)
+	case orb.MultiLineString:
+		stream.WriteArrayStart()
+		for i, line := range g {
+			if i > 0 {
+				stream.WriteMore()
+			}
+			w.writePoints(line)
+		}
+		stream.WriteArrayEnd()
+	case orb.Polygon:
+		stream.WriteArrayStart()
+		for i, ring := range g {
+			if i > 0 {
+				stream.WriteMore()
+			}
+			w.writePoints(ring)
+		}
+		stream.WriteArrayEnd()
+	case orb.MultiPolygon:
+		stream.WriteArrayStart()
+		for i, polygon := range g {
+			if i > 0 {
+				stream.WriteMore()
+			}
+			w.writeCoordinates(polygon)
+		}
+		stream.WriteArrayEnd()
+	}
+}
+
+func (w *coordinateWriter) writePoints(points []orb.Point) {
+	w.stream.WriteArrayStart()
+	for i, p := range points {
+		if i > 0 {
+			w.stream.WriteMore()
+		}
+		w.writePosition(p)
+	}
+	w.stream.WriteArrayEnd()
+}
+
+func (w *coordinateWriter) writePosition(p orb.Point) {
+	stream := w.stream
+	stream.WriteArrayStart()
+	stream.WriteFloat64(p[0])
+	stream.WriteMore()
+	stream.WriteFloat64(p[1])
+	if w.z != nil {
+		stream.WriteMore()
+		stream.WriteFloat64(w.z[w.i])
+		if w.m != nil {
+			stream.WriteMore()
+			stream.WriteFloat64(w.m[w.i])
+		}
+	}
+	w.i++
+	stream.WriteArrayEnd()
+}
+
+func writeFloats(stream *jsoniter.Stream, values []float64) {
+	stream.WriteArrayStart()
+	for i, v := range values {
+		if i > 0 {
+			stream.WriteMore()
+		}
+		stream.WriteFloat64(v)
+	}
+	stream.WriteArrayEnd()
+}
+
+// GeoJSONWriter writes features as a single GeoJSON FeatureCollection, without
+// holding more than a buffer's worth of output in memory
+type GeoJSONWriter struct {
+	output io.Writer
+}
+
+func NewGeoJSONWriter(output io.Writer) (*GeoJSONWriter, error) {
+	return &GeoJSONWriter{
+		output,
+	}, nil
+}
+
+func (g *GeoJSONWriter) Write(in chan *Feature) error {
+	stream := jsoniter.NewStream(jsonConfig, g.output, ParseBufferSize)
+	stream.WriteObjectStart()
+	stream.WriteObjectField("type")
+	stream.WriteString("FeatureCollection")
+	stream.WriteMore()
+	stream.WriteObjectField("features")
+	stream.WriteArrayStart()
+	first := true
+	for feature := range in {
+		if feature == nil {
+			continue
+		}
+		if !first {
+			stream.WriteMore()
+		}
+		first = false
+		writeFeature(stream, feature)
+		if stream.Buffered() > ParseBufferSize {
+			if err := stream.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	stream.WriteArrayEnd()
+	stream.WriteObjectEnd()
+	stream.WriteRaw("\n")
+	return stream.Flush()
 }
@@ -0,0 +1,54 @@
+package io
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// readAll reads every feature of a reader
+func readAll(reader FeatureReader) ([]*Feature, error) {
+	out := make(chan *Feature)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(out)
+		errs <- reader.Read(context.Background(), out)
+	}()
+	var features []*Feature
+	for feature := range out {
+		features = append(features, feature)
+	}
+	return features, <-errs
+}
+
+func TestGeoJSONReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		features int
+		err      string
+	}{
+		{"collection", `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":null,"properties":{}}]}`, 1, ""},
+		{"features without type", `{"features":[{"type":"Feature","geometry":null,"properties":{}},{"type":"Feature","geometry":null}]}`, 2, ""},
+		{"feature", `{"type":"Feature","geometry":{"type":"Point","coordinates":[1,2]}}`, 1, ""},
+		{"geometry", `{"type":"Point","coordinates":[1,2]}`, 1, ""},
+		{"other object", `{"name":"x"}`, 0, ErrNotFeatureCollection.Error()},
+		{"malformed feature line", "{\n\"type\": \"FeatureCollection\",\n\"features\": [\n{\"type\": \"Feature\", \"geometry\": null},\n\n  {\"type\": \"Feature\",\n\"geometry\": {\"type\": \"Point\"}}\n]}", 1, "malformed feature 1 on line 6"},
+		{"malformed feature long line", `{"features":[{"type":"Feature","geometry":null,"properties":{"s":"` + strings.Repeat("x", 3*ParseBufferSize) + `"}},` + "\n" + `{"type":"Topology"}]}`, 1, "malformed feature 1 on line 2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, _ := NewGeoJSONReader(strings.NewReader(test.input))
+			features, err := readAll(reader)
+			if len(features) != test.features {
+				t.Errorf("read %d features, want %d", len(features), test.features)
+			}
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("unexpected error %v", err)
+			case test.err != "" && (err == nil || !strings.HasPrefix(err.Error(), test.err)):
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/json-iterator/go"
+	"io"
+)
+
+// RecordSeparator starts every record of a GeoJSON text sequence (RFC 8142)
+const RecordSeparator = '\x1e'
+
+// GeoJSONSeqReader reads newline-delimited GeoJSON, as well as GeoJSON text
+// sequences (RFC 8142) in which every record starts with an RS character and
+// may span several lines. Each record is a Feature, a bare geometry or a
+// FeatureCollection. Errors report the line on which the record starts.
+type GeoJSONSeqReader struct {
+	input io.Reader
+}
+
+func NewGeoJSONSeqReader(input io.Reader) (*GeoJSONSeqReader, error) {
+	return &GeoJSONSeqReader{
+		input,
+	}, nil
+}
+
+func (g *GeoJSONSeqReader) Read(ctx context.Context, out chan *Feature) error {
+	r := bufio.NewReaderSize(g.input, ParseBufferSize)
+	s := &seqParser{ctx: ctx, out: out}
+	var record seqRecord
+	var sequence bool
+	var offset int64
+	lineNumber := 0
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(line) > 0 {
+			lineNumber++
+			switch {
+			case line[0] == RecordSeparator:
+				sequence = true
+				if err := s.parse(record); err != nil {
+					return err
+				}
+				record = seqRecord{line, lineNumber, offset}
+			case sequence:
+				record.data = append(record.data, line...)
+			default:
+				if err := s.parse(seqRecord{line, lineNumber, offset}); err != nil {
+					return err
+				}
+			}
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			return s.parse(record)
+		}
+	}
+}
+
+// seqRecord is a single record of a sequence, with the line and byte offset
+// at which it starts
+type seqRecord struct {
+	data   []byte
+	line   int
+	offset int64
+}
+
+type seqParser struct {
+	ctx   context.Context
+	out   chan *Feature
+	index int
+}
+
+func (s *seqParser) parse(record seqRecord) error {
+	data := bytes.TrimSpace(bytes.TrimLeft(record.data, "\x1e"))
+	if len(data) == 0 {
+		return nil
+	}
+	dec := jsonConfig.BorrowIterator(data)
+	defer jsonConfig.ReturnIterator(dec)
+	err := readGeoJSON(s.ctx, dec, s.out, func() int {
+		return record.line
+	})
+	if err != nil && err == s.ctx.Err() {
+		return err
+	}
+	if err != nil {
+		if malformed, ok := err.(*ErrMalformedFeature); ok {
+			err = malformed.Err
+		}
+		return &ErrMalformedFeature{Index: s.index, Offset: record.offset, Line: record.line, Err: err}
+	}
+	s.index++
+	return nil
+}
+
+// GeoJSONSeqWriter writes one GeoJSON feature per line, either as a GeoJSON
+// text sequence or as newline-delimited GeoJSON
+type GeoJSONSeqWriter struct {
+	output io.Writer
+
+	// RecordSeparator prefixes every feature with the RS character, as
+	// required by RFC 8142. Newline-delimited GeoJSON omits it.
+	RecordSeparator bool
+}
+
+// NewGeoJSONSeqWriter returns a writer for RFC 8142 GeoJSON text sequences
+func NewGeoJSONSeqWriter(output io.Writer) (*GeoJSONSeqWriter, error) {
+	return &GeoJSONSeqWriter{
+		output:          output,
+		RecordSeparator: true,
+	}, nil
+}
+
+// NewNDJSONWriter returns a writer for newline-delimited GeoJSON
+func NewNDJSONWriter(output io.Writer) (*GeoJSONSeqWriter, error) {
+	return &GeoJSONSeqWriter{
+		output: output,
+	}, nil
+}
+
+func (g *GeoJSONSeqWriter) Write(in chan *Feature) error {
+	stream := jsoniter.NewStream(jsonConfig, g.output, ParseBufferSize)
+	for feature := range in {
+		if feature == nil {
+			continue
+		}
+		if g.RecordSeparator {
+			stream.WriteRaw(string(RecordSeparator))
+		}
+		writeFeature(stream, feature)
+		stream.WriteRaw("\n")
+		// Records of a slow stream, such as a sequence that is followed, are
+		// written as they come
+		if stream.Buffered() > ParseBufferSize || len(in) == 0 {
+			if err := stream.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return stream.Flush()
+}
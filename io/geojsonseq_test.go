@@ -0,0 +1,47 @@
+package io
+
+import (
+	"bufio"
+	"github.com/paulmach/orb"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGeoJSONSeqWriterFlush(t *testing.T) {
+	r, w := io.Pipe()
+	writer, _ := NewGeoJSONSeqWriter(w)
+	in := make(chan *Feature, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- writer.Write(in)
+		w.Close()
+	}()
+	lines := bufio.NewReader(r)
+	// Every feature is written before the next one is sent
+	for i := 0; i < 3; i++ {
+		in <- NewFeature(orb.Point{float64(i), 0})
+		read := make(chan string, 1)
+		go func() {
+			line, _ := lines.ReadString('\n')
+			read <- line
+		}()
+		select {
+		case line := <-read:
+			if !strings.HasPrefix(line, string(RecordSeparator)+`{"type":"Feature"`) {
+				t.Errorf("feature %d: got %q", i, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("feature %d was not written", i)
+		}
+	}
+	close(in)
+	if rest, _ := ioutil.ReadAll(lines); len(rest) != 0 {
+		t.Errorf("got %q after the features", rest)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
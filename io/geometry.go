@@ -0,0 +1,266 @@
+package io
+
+import (
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/stationa/xgeo/io/internal/geom"
+	"reflect"
+)
+
+// DecodeGeometry converts a GeoJSON geometry object, as produced by the
+// feature readers, into an orb.Geometry
+func DecodeGeometry(geometry map[string]interface{}) (orb.Geometry, error) {
+	g, _, _, err := DecodeGeometryZM(geometry)
+	return g, err
+}
+
+// DecodeGeometryZM is like DecodeGeometry, but also returns the third (Z) and
+// fourth (M) ordinates of every position, in traversal order. Either slice is
+// nil unless all positions have that ordinate.
+func DecodeGeometryZM(geometry map[string]interface{}) (orb.Geometry, []float64, []float64, error) {
+	dec := &geometryDecoder{}
+	g, err := dec.decode(geometry)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	z, m := dec.z, dec.m
+	if len(z) != dec.count {
+		z = nil
+	}
+	if len(m) != dec.count {
+		m = nil
+	}
+	return g, z, m, nil
+}
+
+type geometryDecoder struct {
+	z, m  []float64
+	count int
+}
+
+func (d *geometryDecoder) decode(geometry map[string]interface{}) (orb.Geometry, error) {
+	if geometry == nil {
+		return nil, nil
+	}
+	geomType, _ := geometry["type"].(string)
+	if geomType == "GeometryCollection" {
+		geometries, ok := geometry["geometries"]
+		if !ok {
+			return nil, fmt.Errorf("GeometryCollection must have a \"geometries\" array")
+		}
+		items := reflect.ValueOf(geometries)
+		if items.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("GeometryCollection \"geometries\" must be an array")
+		}
+		collection := make(orb.Collection, 0, items.Len())
+		for i := 0; i < items.Len(); i++ {
+			item, ok := items.Index(i).Interface().(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("GeometryCollection member %d is not an object", i)
+			}
+			g, err := d.decode(item)
+			if err != nil {
+				return nil, err
+			}
+			collection = append(collection, g)
+		}
+		return collection, nil
+	}
+	coords, ok := geometry["coordinates"]
+	if !ok {
+		return nil, fmt.Errorf("%s geometry must have \"coordinates\"", geomType)
+	}
+	c := reflect.ValueOf(coords)
+	switch geomType {
+	case "Point":
+		return d.decodePoint(c)
+	case "MultiPoint":
+		return d.decodePoints(c)
+	case "LineString":
+		points, err := d.decodePoints(c)
+		return orb.LineString(points), err
+	case "MultiLineString":
+		lines, err := d.decodeLines(c)
+		return orb.MultiLineString(lines), err
+	case "Polygon":
+		return d.decodePolygon(c)
+	case "MultiPolygon":
+		if c.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("invalid MultiPolygon coordinates")
+		}
+		multiPolygon := make(orb.MultiPolygon, 0, c.Len())
+		for i := 0; i < c.Len(); i++ {
+			polygon, err := d.decodePolygon(elem(c, i))
+			if err != nil {
+				return nil, err
+			}
+			multiPolygon = append(multiPolygon, polygon)
+		}
+		return multiPolygon, nil
+	}
+	return nil, fmt.Errorf("unsupported geometry type %q", geomType)
+}
+
+// EncodeGeometry converts an orb.Geometry into a GeoJSON geometry object
+func EncodeGeometry(g orb.Geometry) map[string]interface{} {
+	switch g := g.(type) {
+	case orb.Point:
+		return map[string]interface{}{
+			"type":        "Point",
+			"coordinates": encodePoint(g),
+		}
+	case orb.MultiPoint:
+		return map[string]interface{}{
+			"type":        "MultiPoint",
+			"coordinates": encodePoints(g),
+		}
+	case orb.LineString:
+		return map[string]interface{}{
+			"type":        "LineString",
+			"coordinates": encodePoints(g),
+		}
+	case orb.MultiLineString:
+		coords := make([][][]float64, len(g))
+		for i, line := range g {
+			coords[i] = encodePoints(line)
+		}
+		return map[string]interface{}{
+			"type":        "MultiLineString",
+			"coordinates": coords,
+		}
+	case orb.Ring:
+		return EncodeGeometry(orb.Polygon{g})
+	case orb.Polygon:
+		return map[string]interface{}{
+			"type":        "Polygon",
+			"coordinates": encodePolygon(g),
+		}
+	case orb.MultiPolygon:
+		coords := make([][][][]float64, len(g))
+		for i, polygon := range g {
+			coords[i] = encodePolygon(polygon)
+		}
+		return map[string]interface{}{
+			"type":        "MultiPolygon",
+			"coordinates": coords,
+		}
+	case orb.Bound:
+		return EncodeGeometry(g.ToPolygon())
+	case orb.Collection:
+		geometries := make([]interface{}, len(g))
+		for i, item := range g {
+			geometries[i] = EncodeGeometry(item)
+		}
+		return map[string]interface{}{
+			"type":       "GeometryCollection",
+			"geometries": geometries,
+		}
+	}
+	return nil
+}
+
+// PositionCount returns the number of positions in a geometry, which is the
+// expected length of a feature's Z and M ordinates
+func PositionCount(g orb.Geometry) int {
+	return geom.PositionCount(g)
+}
+
+func elem(v reflect.Value, i int) reflect.Value {
+	e := v.Index(i)
+	for e.Kind() == reflect.Interface {
+		e = e.Elem()
+	}
+	return e
+}
+
+func (d *geometryDecoder) decodePoint(v reflect.Value) (orb.Point, error) {
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array || v.Len() < 2 {
+		return orb.Point{}, fmt.Errorf("a position must have at least two elements")
+	}
+	var ordinates [4]float64
+	n := v.Len()
+	if n > len(ordinates) {
+		n = len(ordinates)
+	}
+	for i := 0; i < n; i++ {
+		e := elem(v, i)
+		switch e.Kind() {
+		case reflect.Float32, reflect.Float64:
+			ordinates[i] = e.Float()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			ordinates[i] = float64(e.Int())
+		default:
+			return orb.Point{}, fmt.Errorf("a position must contain numbers")
+		}
+	}
+	d.count++
+	if n > 2 && len(d.z) == d.count-1 {
+		d.z = append(d.z, ordinates[2])
+	}
+	if n > 3 && len(d.m) == d.count-1 {
+		d.m = append(d.m, ordinates[3])
+	}
+	return orb.Point{ordinates[0], ordinates[1]}, nil
+}
+
+func (d *geometryDecoder) decodePoints(v reflect.Value) (orb.MultiPoint, error) {
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("expected an array of positions")
+	}
+	points := make(orb.MultiPoint, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		p, err := d.decodePoint(elem(v, i))
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+func (d *geometryDecoder) decodeLines(v reflect.Value) ([]orb.LineString, error) {
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("expected an array of lines")
+	}
+	lines := make([]orb.LineString, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		points, err := d.decodePoints(elem(v, i))
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, orb.LineString(points))
+	}
+	return lines, nil
+}
+
+func (d *geometryDecoder) decodePolygon(v reflect.Value) (orb.Polygon, error) {
+	lines, err := d.decodeLines(v)
+	if err != nil {
+		return nil, err
+	}
+	polygon := make(orb.Polygon, len(lines))
+	for i, line := range lines {
+		polygon[i] = orb.Ring(line)
+	}
+	return polygon, nil
+}
+
+func encodePoint(p orb.Point) []float64 {
+	return []float64{p[0], p[1]}
+}
+
+func encodePoints(points []orb.Point) [][]float64 {
+	coords := make([][]float64, len(points))
+	for i, p := range points {
+		coords[i] = encodePoint(p)
+	}
+	return coords
+}
+
+func encodePolygon(polygon orb.Polygon) [][][]float64 {
+	coords := make([][][]float64, len(polygon))
+	for i, ring := range polygon {
+		coords[i] = encodePoints(ring)
+	}
+	return coords
+}
@@ -0,0 +1,424 @@
+package io
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/project"
+	"github.com/stationa/xgeo/io/sqlite"
+	"github.com/stationa/xgeo/io/wkb"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// gpkgApplicationID is "GPKG", the application ID of GeoPackage databases
+const gpkgApplicationID = 0x47504B47
+
+// gpkgRTreeNodeLength is the length of the nodes of the R-tree indexes that
+// are written: SQLite's default of 51 cells of 24 bytes after a 4-byte header
+const (
+	gpkgRTreeCells      = 51
+	gpkgRTreeCellLength = 24
+	gpkgRTreeNodeLength = 4 + gpkgRTreeCells*gpkgRTreeCellLength
+)
+
+var errMalformedRTree = errors.New("malformed R-tree index")
+
+// GeoPackageReader reads the feature and attribute tables of a GeoPackage,
+// each of which is a layer. The fid of each feature is read as its ID, and
+// its other columns as properties: booleans as bool, dates as time.Time,
+// integers as int64 and floating point numbers as float64.
+//
+// If BBox is set, only the features whose geometry intersects it are read,
+// which are found with the R-tree index of their table if it has one.
+type GeoPackageReader struct {
+	file   *os.File
+	db     *sqlite.DB
+	layers []*gpkgLayer
+
+	// BBox, when set, restricts reading to the features whose geometry
+	// intersects it
+	BBox *orb.Bound
+
+	// LayerProperty, when set, is the name of a property that receives the
+	// name of the table each feature is read from
+	LayerProperty string
+}
+
+type gpkgLayer struct {
+	name    string
+	columns []sqlite.Column
+	// geometry is the index of the geometry column, or -1
+	geometry   int
+	projection orb.Projection
+	// rtree is the name of the R-tree index of the geometry column, or empty
+	rtree string
+}
+
+// gpkgSRS is a row of gpkg_spatial_ref_sys
+type gpkgSRS struct {
+	organization string
+	code         int64
+	definition   string
+}
+
+// NewGeoPackageReader opens a GeoPackage. If layers are given, only those
+// tables are read, in that order; otherwise all the feature and attribute
+// tables are, in the order of gpkg_contents.
+func NewGeoPackageReader(filename string, layers ...string) (*GeoPackageReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	g := &GeoPackageReader{file: file}
+	if err := g.open(layers); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *GeoPackageReader) open(names []string) error {
+	var err error
+	if g.db, err = sqlite.Open(g.file); err != nil {
+		return err
+	}
+	if _, ok := g.db.Object("gpkg_contents"); !ok {
+		return errors.New("not a GeoPackage, it has no gpkg_contents table")
+	}
+
+	systems := map[int64]gpkgSRS{}
+	err = g.scan("gpkg_spatial_ref_sys", []string{"srs_id", "organization", "organization_coordsys_id", "definition"}, func(values []interface{}) error {
+		id, _ := values[0].(int64)
+		srs := gpkgSRS{}
+		srs.organization, _ = values[1].(string)
+		srs.code, _ = values[2].(int64)
+		srs.definition, _ = values[3].(string)
+		systems[id] = srs
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	type geometryColumn struct {
+		name  string
+		srsID int64
+	}
+	geometryColumns := map[string]geometryColumn{}
+	if _, ok := g.db.Object("gpkg_geometry_columns"); ok {
+		err = g.scan("gpkg_geometry_columns", []string{"table_name", "column_name", "srs_id"}, func(values []interface{}) error {
+			table, _ := values[0].(string)
+			column := geometryColumn{}
+			column.name, _ = values[1].(string)
+			column.srsID, _ = values[2].(int64)
+			geometryColumns[strings.ToLower(table)] = column
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = g.scan("gpkg_contents", []string{"table_name", "data_type"}, func(values []interface{}) error {
+		name, _ := values[0].(string)
+		dataType, _ := values[1].(string)
+		if dataType != "features" && dataType != "attributes" {
+			return nil
+		}
+		layer := &gpkgLayer{name: name, geometry: -1}
+		var err error
+		if layer.columns, err = g.db.Columns(name); err != nil {
+			return err
+		}
+		if column, ok := geometryColumns[strings.ToLower(name)]; ok {
+			layer.geometry = columnIndex(layer.columns, column.name)
+			if layer.geometry < 0 {
+				return fmt.Errorf("table %s has no geometry column %s", name, column.name)
+			}
+			srs, ok := systems[column.srsID]
+			if !ok {
+				return fmt.Errorf("table %s: no spatial reference system %d", name, column.srsID)
+			}
+			if layer.projection, err = gpkgProjection(srs); err != nil {
+				return fmt.Errorf("table %s: %v", name, err)
+			}
+			rtree := "rtree_" + name + "_" + column.name
+			if _, ok := g.db.Object(rtree + "_node"); ok {
+				layer.rtree = rtree
+			}
+		}
+		g.layers = append(g.layers, layer)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(names) > 0 {
+		selected := make([]*gpkgLayer, 0, len(names))
+		for _, name := range names {
+			var found *gpkgLayer
+			for _, layer := range g.layers {
+				if strings.EqualFold(layer.name, name) {
+					found = layer
+					break
+				}
+			}
+			if found == nil {
+				return fmt.Errorf("no layer %q, available layers are %s", name, strings.Join(g.Layers(), ", "))
+			}
+			selected = append(selected, found)
+		}
+		g.layers = selected
+	}
+	return nil
+}
+
+// scan calls fn with the values of some columns of each row of a table
+func (g *GeoPackageReader) scan(table string, names []string, fn func(values []interface{}) error) error {
+	columns, err := g.db.Columns(table)
+	if err != nil {
+		return err
+	}
+	indexes := make([]int, len(names))
+	for i, name := range names {
+		if indexes[i] = columnIndex(columns, name); indexes[i] < 0 {
+			return fmt.Errorf("table %s has no column %s", table, name)
+		}
+	}
+	err = g.db.Scan(table, func(rowid int64, row []interface{}) error {
+		values := make([]interface{}, len(indexes))
+		for i, index := range indexes {
+			values[i] = row[index]
+		}
+		return fn(values)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %v", table, err)
+	}
+	return nil
+}
+
+func columnIndex(columns []sqlite.Column, name string) int {
+	for i, column := range columns {
+		if strings.EqualFold(column.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// gpkgProjection returns the projection of a spatial reference system to
+// WGS84 longitude and latitude, or nil if the coordinates are already in
+// degrees or the system is undefined
+func gpkgProjection(srs gpkgSRS) (orb.Projection, error) {
+	org := strings.ToUpper(srs.organization)
+	switch {
+	case org == "EPSG" && srs.code == 4326, org == "NONE", srs.definition == "undefined":
+		return nil, nil
+	case org == "EPSG" && (srs.code == 3857 || srs.code == 900913):
+		return project.Mercator.ToWGS84, nil
+	case srs.definition != "":
+		return ParseProjection(srs.definition)
+	}
+	return nil, fmt.Errorf("unsupported coordinate system %s:%d", srs.organization, srs.code)
+}
+
+// Layers returns the names of the tables that are read
+func (g *GeoPackageReader) Layers() []string {
+	names := make([]string, len(g.layers))
+	for i, layer := range g.layers {
+		names[i] = layer.name
+	}
+	return names
+}
+
+func (g *GeoPackageReader) Read(ctx context.Context, out chan *Feature) error {
+	defer g.file.Close()
+	for _, layer := range g.layers {
+		if err := g.readLayer(ctx, layer, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *GeoPackageReader) readLayer(ctx context.Context, layer *gpkgLayer, out chan *Feature) error {
+	emit := func(rowid int64, values []interface{}) error {
+		feature, err := g.feature(layer, rowid, values)
+		if err != nil {
+			return fmt.Errorf("malformed feature %d of table %s: %v", rowid, layer.name, err)
+		}
+		if g.BBox != nil && (feature.Geometry == nil || !feature.Geometry.Bound().Intersects(*g.BBox)) {
+			return nil
+		}
+		return Emit(ctx, out, feature)
+	}
+	// The index is in the coordinates of the table, which are only those of
+	// BBox for WGS84 tables
+	if g.BBox == nil || layer.rtree == "" || layer.projection != nil {
+		return g.db.Scan(layer.name, emit)
+	}
+	ids, err := g.search(layer.rtree, *g.BBox)
+	if err != nil {
+		return fmt.Errorf("%s: %v", layer.rtree, err)
+	}
+	for _, id := range ids {
+		values, err := g.db.Row(layer.name, id)
+		if err != nil {
+			return err
+		}
+		if values == nil {
+			continue
+		}
+		if err := emit(id, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *GeoPackageReader) feature(layer *gpkgLayer, rowid int64, values []interface{}) (*Feature, error) {
+	feature := NewFeature(nil)
+	feature.ID = rowid
+	for i, column := range layer.columns {
+		switch {
+		case column.RowID:
+		case i == layer.geometry:
+			if values[i] == nil {
+				continue
+			}
+			data, ok := values[i].([]byte)
+			if !ok {
+				return nil, fmt.Errorf("geometry is a %T rather than a blob", values[i])
+			}
+			var err error
+			if feature.Geometry, feature.Z, feature.M, err = gpkgDecodeGeometry(data); err != nil {
+				return nil, err
+			}
+			if layer.projection != nil && feature.Geometry != nil {
+				feature.Geometry = project.Geometry(feature.Geometry, layer.projection)
+			}
+		default:
+			feature.Properties = append(feature.Properties, Property{column.Name, gpkgValue(values[i], column.Type)})
+		}
+	}
+	if g.LayerProperty != "" {
+		feature.Properties.Set(g.LayerProperty, layer.name)
+	}
+	return feature, nil
+}
+
+// gpkgValue converts a value after the declared type of its column
+func gpkgValue(value interface{}, declared string) interface{} {
+	declared = strings.ToUpper(declared)
+	switch v := value.(type) {
+	case int64:
+		switch {
+		case declared == "BOOLEAN":
+			return v != 0
+		case strings.Contains(declared, "REAL"), strings.Contains(declared, "FLOA"), strings.Contains(declared, "DOUB"):
+			// SQLite stores whole floating point numbers as integers
+			return float64(v)
+		}
+	case string:
+		if declared == "DATE" || declared == "DATETIME" {
+			return dateTimeValue(v)
+		}
+	}
+	return value
+}
+
+// gpkgDecodeGeometry decodes a GeoPackage binary geometry: a header with the
+// SRS ID and an optional envelope, followed by WKB
+func gpkgDecodeGeometry(data []byte) (orb.Geometry, []float64, []float64, error) {
+	if len(data) < 8 || data[0] != 'G' || data[1] != 'P' {
+		return nil, nil, nil, errors.New("not a GeoPackage geometry")
+	}
+	flags := data[3]
+	if flags&0x20 != 0 {
+		return nil, nil, nil, errors.New("extended GeoPackage geometries are not supported")
+	}
+	envelopeLength := []int{0, 32, 48, 48, 64}
+	envelope := int(flags>>1) & 7
+	if envelope >= len(envelopeLength) || len(data) < 8+envelopeLength[envelope] {
+		return nil, nil, nil, errors.New("invalid GeoPackage geometry envelope")
+	}
+	return wkb.Unmarshal(data[8+envelopeLength[envelope]:])
+}
+
+// gpkgEncodeGeometry encodes a geometry as a little-endian GeoPackage binary
+// in WGS84. Like GDAL, it writes the envelope of any geometry but points.
+func gpkgEncodeGeometry(g orb.Geometry, z, m []float64) []byte {
+	flags := byte(1)
+	var envelope orb.Bound
+	empty := PositionCount(g) == 0
+	if empty {
+		flags |= 0x10
+	} else if _, point := g.(orb.Point); !point {
+		flags |= 1 << 1
+		envelope = g.Bound()
+	}
+	data := []byte{'G', 'P', 0, flags}
+	data = appendUint32(data, 4326)
+	if flags&(7<<1) != 0 {
+		for _, v := range []float64{envelope.Min[0], envelope.Max[0], envelope.Min[1], envelope.Max[1]} {
+			data = appendUint64(data, math.Float64bits(v))
+		}
+	}
+	return append(data, wkb.Marshal(g, z, m)...)
+}
+
+// search returns the ids of the entries of an R-tree index whose bounding box
+// intersects a bound, in increasing order
+func (g *GeoPackageReader) search(rtree string, bound orb.Bound) ([]int64, error) {
+	var ids []int64
+	nodes := []int64{1}
+	for depth := -1; len(nodes) > 0; depth-- {
+		var next []int64
+		for _, number := range nodes {
+			values, err := g.db.Row(rtree+"_node", number)
+			if err != nil {
+				return nil, err
+			}
+			if len(values) < 2 {
+				return nil, errMalformedRTree
+			}
+			data, _ := values[1].([]byte)
+			if len(data) < 4 {
+				return nil, errMalformedRTree
+			}
+			if number == 1 {
+				depth = int(binary.BigEndian.Uint16(data))
+			}
+			cells := int(binary.BigEndian.Uint16(data[2:]))
+			if 4+cells*gpkgRTreeCellLength > len(data) || depth < 0 {
+				return nil, errMalformedRTree
+			}
+			for i := 0; i < cells; i++ {
+				cell := data[4+i*gpkgRTreeCellLength:]
+				var v [4]float64
+				for j := range v {
+					v[j] = float64(math.Float32frombits(binary.BigEndian.Uint32(cell[8+4*j:])))
+				}
+				box := orb.Bound{Min: orb.Point{v[0], v[2]}, Max: orb.Point{v[1], v[3]}}
+				if !box.Intersects(bound) {
+					continue
+				}
+				id := int64(binary.BigEndian.Uint64(cell))
+				if depth == 0 {
+					ids = append(ids, id)
+				} else {
+					next = append(next, id)
+				}
+			}
+		}
+		nodes = next
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
@@ -0,0 +1,312 @@
+package io
+
+import (
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/stationa/xgeo/io/sqlite"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sqlite3Points returns the features of the points table of
+// testdata/sqlite3.gpkg, as testdata/sqlite3.sql inserts them
+func sqlite3Points() []*Feature {
+	var features []*Feature
+	for fid := 1; fid <= 600; fid++ {
+		if fid%7 == 0 {
+			continue
+		}
+		f := NewFeature(orb.Point{float64(fid*37%1440)/4 - 180, float64(fid*53%720)/4 - 90})
+		f.ID = int64(fid)
+		var code, value interface{}
+		if fid%3 != 0 {
+			code = fmt.Sprintf("P%d", fid)
+		}
+		if fid%5 != 0 {
+			value = float64(fid) / 2
+		}
+		note := fmt.Sprintf("short %d", fid)
+		if fid%100 == 1 {
+			note = fmt.Sprintf("%d:%s", fid, strings.Repeat("00", 300*(fid/100+1)))
+		}
+		f.Properties = Properties{{"name", fmt.Sprintf("point %d", fid%37)}, {"code", code}, {"value", value}, {"note", note}}
+		features = append(features, f)
+	}
+	return features
+}
+
+func TestGeoPackageReaderSQLite(t *testing.T) {
+	reader, err := NewGeoPackageReader("testdata/sqlite3.gpkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reader.Layers(); !reflect.DeepEqual(got, []string{"points", "notes"}) {
+		t.Errorf("got layers %v", got)
+	}
+	read, err := readAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sqlite3Points()
+	for _, note := range []struct {
+		id         int64
+		properties Properties
+	}{
+		{1, Properties{{"body", "first"}, {"day", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)}, {"flag", true}}},
+		{5, Properties{{"body", nil}, {"day", nil}, {"flag", false}}},
+		{9, Properties{{"body", strings.Repeat("00", 2000)}, {"day", time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC)}, {"flag", nil}}},
+	} {
+		f := NewFeature(nil)
+		f.ID = note.id
+		f.Properties = note.properties
+		want = append(want, f)
+	}
+	if len(read) != len(want) {
+		t.Fatalf("read %d features, want %d", len(read), len(want))
+	}
+	for i, f := range read {
+		if !reflect.DeepEqual(f, want[i]) {
+			t.Errorf("feature %d: got %.100v, want %.100v", i, *f, *want[i])
+		}
+	}
+}
+
+func TestGeoPackageReaderSQLiteBBox(t *testing.T) {
+	points := sqlite3Points()
+	for _, query := range []orb.Bound{
+		{Min: orb.Point{-10, -10}, Max: orb.Point{30, 20}},
+		{Min: orb.Point{-170.75, -76.75}, Max: orb.Point{-170.75, -76.75}},
+		{Min: orb.Point{-180, -90}, Max: orb.Point{180, 90}},
+		{Min: orb.Point{200, 0}, Max: orb.Point{210, 10}},
+	} {
+		reader, err := NewGeoPackageReader("testdata/sqlite3.gpkg", "points")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reader.layers[0].rtree == "" {
+			t.Fatal("the R-tree index is not found")
+		}
+		reader.BBox = &query
+		read, err := readAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []interface{}{}
+		for _, f := range read {
+			got = append(got, f.ID)
+		}
+		want := []interface{}{}
+		for _, f := range points {
+			if f.Geometry.Bound().Intersects(query) {
+				want = append(want, f.ID)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestGeoPackageSQLiteSchema(t *testing.T) {
+	file, err := os.Open("testdata/sqlite3.gpkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	db, err := sqlite.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name, objectType string
+		rootPage         bool
+	}{
+		{"points_name", "index", true},
+		{"sqlite_autoindex_points_1", "index", true},
+		{"rtree_points_geom", "table", false},
+		{"rtree_points_geom_node", "table", true},
+		{"tags", "table", true},
+		{"named", "view", false},
+		{"points_touch", "trigger", false},
+	} {
+		object, ok := db.Object(test.name)
+		if !ok || object.Type != test.objectType || (object.RootPage != 0) != test.rootPage {
+			t.Errorf("%s: got %+v, %v", test.name, object, ok)
+		}
+	}
+	for _, name := range []string{"scratch", "dropped", "doubles"} {
+		if _, ok := db.Object(name); ok {
+			t.Errorf("found table %s", name)
+		}
+	}
+
+	if _, err := db.Columns("tags"); err == nil || !strings.Contains(err.Error(), "WITHOUT ROWID") {
+		t.Errorf("WITHOUT ROWID table: got %v", err)
+	}
+	if err := db.Scan("named", func(int64, []interface{}) error { return nil }); err == nil {
+		t.Error("scanned a view")
+	}
+	if err := db.Scan("rtree_points_geom", func(int64, []interface{}) error { return nil }); err == nil || !strings.Contains(err.Error(), "virtual") {
+		t.Errorf("virtual table: got %v", err)
+	}
+	for _, test := range []struct {
+		rowid int64
+		found bool
+	}{{1, true}, {7, false}, {302, true}, {599, true}, {600, true}, {601, false}, {0, false}, {-1, false}} {
+		values, err := db.Row("points", test.rowid)
+		if err != nil || (values != nil) != test.found {
+			t.Errorf("rowid %d: got %.40v, %v", test.rowid, values, err)
+		}
+		if values != nil && values[0] != test.rowid {
+			t.Errorf("rowid %d: got fid %v", test.rowid, values[0])
+		}
+	}
+}
+
+func TestGeoPackageWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "out.gpkg")
+
+	var features, want []*Feature
+	for i := 1; i <= 3000; i++ {
+		f := NewFeature(orb.Point{float64(i*7919%3600)/10 - 180, float64(i*104729%1800)/10 - 90})
+		f.ID = float64(i)
+		f.Properties = Properties{{"layer", "points"}, {"name", fmt.Sprintf("point %d", i)}, {"n", float64(i) / 2}}
+		features = append(features, f)
+		want = append(want, &Feature{ID: int64(i), Geometry: f.Geometry, Properties: Properties{{"name", f.Properties[1].Value}, {"n", float64(i) / 2}, {"layer", "points"}}})
+	}
+	shapes := []*Feature{
+		{ID: "a", Geometry: orb.Polygon{{{0, 0}, {10, 0}, {10, 10}, {0, 0}}}, Properties: Properties{{"layer", "shapes"}, {"text", strings.Repeat("long ", 2000)}}},
+		{ID: 5, Properties: Properties{{"layer", "shapes"}, {"ok", true}}},
+		{ID: 2, Geometry: orb.LineString{{-5, -5}, {5, 5}}, Z: []float64{1, 2}, Properties: Properties{{"layer", "shapes"}}},
+	}
+	features = append(features, shapes...)
+	want = append(want,
+		&Feature{ID: int64(1), Geometry: shapes[0].Geometry, Properties: Properties{{"text", strings.Repeat("long ", 2000)}, {"ok", nil}, {"layer", "shapes"}}},
+		&Feature{ID: int64(5), Properties: Properties{{"text", nil}, {"ok", true}, {"layer", "shapes"}}},
+		&Feature{ID: int64(6), Geometry: shapes[2].Geometry, Z: []float64{1, 2}, Properties: Properties{{"text", nil}, {"ok", nil}, {"layer", "shapes"}}},
+	)
+
+	writer, _ := NewGeoPackageWriter(filename)
+	writer.TableProperty = "layer"
+	if err := writeAll(writer, features); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewGeoPackageReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.LayerProperty = "layer"
+	read, err := readAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(want) {
+		t.Fatalf("read %d features, want %d", len(read), len(want))
+	}
+	for i, f := range read {
+		if !reflect.DeepEqual(f, want[i]) {
+			t.Errorf("feature %d: got %.100v, want %.100v", i, *f, *want[i])
+		}
+	}
+
+	query := orb.Bound{Min: orb.Point{-20, -30}, Max: orb.Point{40, 10}}
+	reader, err = NewGeoPackageReader(filename, "points")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.BBox = &query
+	if read, err = readAll(reader); err != nil {
+		t.Fatal(err)
+	}
+	var got, found []interface{}
+	for _, f := range read {
+		got = append(got, f.ID)
+	}
+	for _, f := range want[:3000] {
+		if query.Contains(f.Geometry.(orb.Point)) {
+			found = append(found, f.ID)
+		}
+	}
+	if len(found) == 0 || !reflect.DeepEqual(got, found) {
+		t.Errorf("%v: got %d features, want %d", query, len(got), len(found))
+	}
+
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return
+	}
+	for _, query := range []struct {
+		sql, want string
+	}{
+		{"PRAGMA integrity_check", "ok"},
+		{"SELECT rtreecheck('rtree_points_geom'), rtreecheck('rtree_shapes_geom')", "ok|ok"},
+		{"SELECT count(*), sum(n) FROM points", "3000|2250750.0"},
+		{"SELECT count(*) FROM rtree_shapes_geom", "2"},
+		{"SELECT table_name, min_x, max_y FROM gpkg_contents", "points|-179.9|89.9\nshapes|-5.0|10.0"},
+	} {
+		out, err := exec.Command("sqlite3", filename, query.sql).CombinedOutput()
+		if got := strings.TrimSpace(string(out)); err != nil || got != query.want {
+			t.Errorf("%s: got %q, %v, want %q", query.sql, got, err, query.want)
+		}
+	}
+}
+
+func TestGeoPackageWriterIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "out.gpkg")
+
+	tests := []struct {
+		table string
+		ids   []interface{}
+		want  []int64
+	}{
+		{"zero", []interface{}{0, 1, 2.0}, []int64{0, 1, 2}},
+		{"negative", []interface{}{-5.0, nil, int64(3), 3, "x", 2.5}, []int64{-5, -4, 3, 4, 5, 6}},
+		{"none", []interface{}{nil, 0, 7}, []int64{1, 2, 7}},
+	}
+	var features []*Feature
+	for _, test := range tests {
+		for _, id := range test.ids {
+			f := NewFeature(orb.Point{1, 2})
+			f.ID = id
+			f.Properties = Properties{{"table", test.table}}
+			features = append(features, f)
+		}
+	}
+	writer, _ := NewGeoPackageWriter(filename)
+	writer.TableProperty = "table"
+	if err := writeAll(writer, features); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		reader, err := NewGeoPackageReader(filename, test.table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := readAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		for _, f := range read {
+			got = append(got, f.ID.(int64))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got fids %v, want %v", test.table, got, test.want)
+		}
+	}
+}
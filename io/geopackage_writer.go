@@ -0,0 +1,687 @@
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/stationa/xgeo/io/sqlite"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The statements creating the GeoPackage metadata tables, as given by the
+// specification
+const (
+	gpkgSpatialRefSysSQL = `CREATE TABLE gpkg_spatial_ref_sys (
+  srs_name TEXT NOT NULL,
+  srs_id INTEGER NOT NULL PRIMARY KEY,
+  organization TEXT NOT NULL,
+  organization_coordsys_id INTEGER NOT NULL,
+  definition  TEXT NOT NULL,
+  description TEXT
+)`
+	gpkgContentsSQL = `CREATE TABLE gpkg_contents (
+  table_name TEXT NOT NULL PRIMARY KEY,
+  data_type TEXT NOT NULL,
+  identifier TEXT UNIQUE,
+  description TEXT DEFAULT '',
+  last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+  min_x DOUBLE,
+  min_y DOUBLE,
+  max_x DOUBLE,
+  max_y DOUBLE,
+  srs_id INTEGER,
+  CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id)
+)`
+	gpkgGeometryColumnsSQL = `CREATE TABLE gpkg_geometry_columns (
+  table_name TEXT NOT NULL,
+  column_name TEXT NOT NULL,
+  geometry_type_name TEXT NOT NULL,
+  srs_id INTEGER NOT NULL,
+  z TINYINT NOT NULL,
+  m TINYINT NOT NULL,
+  CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name),
+  CONSTRAINT uk_gc_table_name UNIQUE (table_name),
+  CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+  CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id)
+)`
+	gpkgExtensionsSQL = `CREATE TABLE gpkg_extensions (
+  table_name TEXT,
+  column_name TEXT,
+  extension_name TEXT NOT NULL,
+  definition TEXT NOT NULL,
+  scope TEXT NOT NULL,
+  CONSTRAINT ge_tce UNIQUE (table_name, column_name, extension_name)
+)`
+)
+
+// gpkgRTreeTriggers keep an R-tree index up to date when its table is
+// edited, with the statements of the specification. %[1]s is the index, %[2]s
+// the table, %[3]s the geometry column, %[4]s the fid column and %[5]s the
+// trigger.
+var gpkgRTreeTriggers = []struct{ suffix, sql string }{
+	{"insert", `CREATE TRIGGER %[5]s AFTER INSERT ON %[2]s
+  WHEN (new.%[3]s NOT NULL AND NOT ST_IsEmpty(NEW.%[3]s))
+BEGIN
+  INSERT OR REPLACE INTO %[1]s VALUES (
+    NEW.%[4]s,
+    ST_MinX(NEW.%[3]s), ST_MaxX(NEW.%[3]s),
+    ST_MinY(NEW.%[3]s), ST_MaxY(NEW.%[3]s)
+  );
+END`},
+	{"update1", `CREATE TRIGGER %[5]s AFTER UPDATE OF %[3]s ON %[2]s
+  WHEN OLD.%[4]s = NEW.%[4]s AND
+       (NEW.%[3]s NOTNULL AND NOT ST_IsEmpty(NEW.%[3]s))
+BEGIN
+  INSERT OR REPLACE INTO %[1]s VALUES (
+    NEW.%[4]s,
+    ST_MinX(NEW.%[3]s), ST_MaxX(NEW.%[3]s),
+    ST_MinY(NEW.%[3]s), ST_MaxY(NEW.%[3]s)
+  );
+END`},
+	{"update2", `CREATE TRIGGER %[5]s AFTER UPDATE OF %[3]s ON %[2]s
+  WHEN OLD.%[4]s = NEW.%[4]s AND
+       (NEW.%[3]s ISNULL OR ST_IsEmpty(NEW.%[3]s))
+BEGIN
+  DELETE FROM %[1]s WHERE id = OLD.%[4]s;
+END`},
+	{"update3", `CREATE TRIGGER %[5]s AFTER UPDATE ON %[2]s
+  WHEN OLD.%[4]s != NEW.%[4]s AND
+       (NEW.%[3]s NOTNULL AND NOT ST_IsEmpty(NEW.%[3]s))
+BEGIN
+  DELETE FROM %[1]s WHERE id = OLD.%[4]s;
+  INSERT OR REPLACE INTO %[1]s VALUES (
+    NEW.%[4]s,
+    ST_MinX(NEW.%[3]s), ST_MaxX(NEW.%[3]s),
+    ST_MinY(NEW.%[3]s), ST_MaxY(NEW.%[3]s)
+  );
+END`},
+	{"update4", `CREATE TRIGGER %[5]s AFTER UPDATE ON %[2]s
+  WHEN OLD.%[4]s != NEW.%[4]s AND
+       (NEW.%[3]s ISNULL OR ST_IsEmpty(NEW.%[3]s))
+BEGIN
+  DELETE FROM %[1]s WHERE id IN (OLD.%[4]s, NEW.%[4]s);
+END`},
+	{"delete", `CREATE TRIGGER %[5]s AFTER DELETE ON %[2]s
+  WHEN old.%[3]s NOT NULL
+BEGIN
+  DELETE FROM %[1]s WHERE id = OLD.%[4]s;
+END`},
+}
+
+// gpkgWGS84WKT is the definition of EPSG:4326 in gpkg_spatial_ref_sys
+const gpkgWGS84WKT = `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AXIS["Latitude",NORTH],AXIS["Longitude",EAST],AUTHORITY["EPSG","4326"]]`
+
+// GeoPackageWriter writes features to a GeoPackage, in WGS84. Each table has
+// an INTEGER PRIMARY KEY fid column, a geometry column named geom and a column
+// for each property key, typed after the values of the property.
+//
+// Rows are written as features arrive, so only the bounding boxes of the
+// features are kept in memory, to build the R-tree indexes once the input
+// ends. The fid of a feature is its ID if that is an integer greater than the
+// fid of the previous feature of its table, and one more than that otherwise.
+// The first feature of a table keeps any integer ID, and gets fid 1 otherwise.
+type GeoPackageWriter struct {
+	filename string
+
+	// TableName is the name of the feature table that features are written
+	// to, which defaults to the base name of the file
+	TableName string
+
+	// TableProperty, when set, is a property naming the table that each
+	// feature is written to instead, as read by GeoPackageReader.LayerProperty.
+	// The property itself is not written.
+	TableProperty string
+
+	// SpatialIndex tells whether to write an R-tree index of the geometries of
+	// each table, registered as the gpkg_rtree_index extension
+	SpatialIndex bool
+}
+
+func NewGeoPackageWriter(filename string) (*GeoPackageWriter, error) {
+	base := filepath.Base(filename)
+	return &GeoPackageWriter{
+		filename:     filename,
+		TableName:    strings.TrimSuffix(base, filepath.Ext(base)),
+		SpatialIndex: true,
+	}, nil
+}
+
+// gpkgTable is a feature table being written
+type gpkgTable struct {
+	name    string
+	rows    *sqlite.TableWriter
+	columns []*gpkgColumn
+	// byKey maps property keys to columns, and names holds the lower case
+	// names of the columns, which SQL compares regardless of case
+	byKey map[string]int
+	names map[string]bool
+
+	// fid is the fid of the last row, if started is set
+	fid          int64
+	started      bool
+	geometryType string
+	z, m         gpkgOrdinate
+	extent       orb.Bound
+	empty        bool
+	entries      []gpkgEntry
+}
+
+type gpkgColumn struct {
+	name  string
+	kinds map[uint8]bool
+	// dates tells whether all the times of the column are dates
+	dates bool
+}
+
+// gpkgOrdinate counts the features that have a Z or M ordinate or lack it
+type gpkgOrdinate struct {
+	with, without int
+}
+
+// value is the z or m column of gpkg_geometry_columns: 0 if the ordinate is
+// prohibited, 1 if it is mandatory and 2 if it is optional
+func (o gpkgOrdinate) value() int64 {
+	switch {
+	case o.with == 0:
+		return 0
+	case o.without == 0:
+		return 1
+	}
+	return 2
+}
+
+// gpkgEntry is an entry of an R-tree index
+type gpkgEntry struct {
+	id  int64
+	box [4]float32
+}
+
+func (w *GeoPackageWriter) Write(in chan *Feature) error {
+	file, err := os.Create(w.filename)
+	if err != nil {
+		return err
+	}
+	err = w.write(file, in)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(w.filename)
+	}
+	return err
+}
+
+func (w *GeoPackageWriter) write(file *os.File, in chan *Feature) error {
+	db, err := sqlite.NewWriter(file)
+	if err != nil {
+		return err
+	}
+	db.ApplicationID = gpkgApplicationID
+	db.UserVersion = 10200
+
+	srs, err := db.CreateTable("gpkg_spatial_ref_sys", gpkgSpatialRefSysSQL)
+	if err != nil {
+		return err
+	}
+	systems := [][]interface{}{
+		{"Undefined cartesian SRS", -1, "NONE", -1, "undefined", "undefined cartesian coordinate reference system"},
+		{"Undefined geographic SRS", 0, "NONE", 0, "undefined", "undefined geographic coordinate reference system"},
+		{"WGS 84 geodetic", 4326, "EPSG", 4326, gpkgWGS84WKT, "longitude/latitude coordinates in decimal degrees on the WGS 84 spheroid"},
+	}
+	for _, values := range systems {
+		if err := srs.Insert(int64(values[1].(int)), values...); err != nil {
+			return err
+		}
+	}
+	contents, err := db.CreateTable("gpkg_contents", gpkgContentsSQL)
+	if err != nil {
+		return err
+	}
+	geometryColumns, err := db.CreateTable("gpkg_geometry_columns", gpkgGeometryColumnsSQL)
+	if err != nil {
+		return err
+	}
+	var extensions *sqlite.TableWriter
+	if w.SpatialIndex {
+		if extensions, err = db.CreateTable("gpkg_extensions", gpkgExtensionsSQL); err != nil {
+			return err
+		}
+	}
+
+	var tables []*gpkgTable
+	byName := map[string]*gpkgTable{}
+	table := func(name string) (*gpkgTable, error) {
+		if t, ok := byName[strings.ToLower(name)]; ok {
+			return t, nil
+		}
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "gpkg_") || strings.HasPrefix(lower, "sqlite_") || strings.HasPrefix(lower, "rtree_") {
+			return nil, fmt.Errorf("invalid table name %q", name)
+		}
+		t := &gpkgTable{name: name, byKey: map[string]int{}, names: map[string]bool{"fid": true, "geom": true}, empty: true}
+		var err error
+		if t.rows, err = db.CreateTable(name, t.sql()); err != nil {
+			return nil, err
+		}
+		byName[lower] = t
+		tables = append(tables, t)
+		return t, nil
+	}
+
+	for feature := range in {
+		if feature == nil {
+			continue
+		}
+		name := w.TableName
+		properties := feature.Properties
+		if w.TableProperty != "" {
+			if value, ok := feature.Properties.Get(w.TableProperty); ok && value != nil {
+				name = stringValue(value)
+			}
+			properties = make(Properties, 0, len(feature.Properties))
+			for _, prop := range feature.Properties {
+				if prop.Key != w.TableProperty {
+					properties = append(properties, prop)
+				}
+			}
+		}
+		t, err := table(name)
+		if err != nil {
+			return err
+		}
+		if err := t.write(feature, properties); err != nil {
+			return err
+		}
+	}
+	if len(tables) == 0 {
+		if _, err := table(w.TableName); err != nil {
+			return err
+		}
+	}
+
+	lastChange := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	for i, t := range tables {
+		if err := t.rows.Redefine(t.sql()); err != nil {
+			return err
+		}
+		var extent []interface{}
+		if !t.empty {
+			extent = []interface{}{t.extent.Min[0], t.extent.Min[1], t.extent.Max[0], t.extent.Max[1]}
+		} else {
+			extent = []interface{}{nil, nil, nil, nil}
+		}
+		row := append([]interface{}{t.name, "features", t.name, "", lastChange}, extent...)
+		if err := contents.Insert(int64(i+1), append(row, 4326)...); err != nil {
+			return err
+		}
+		geometryType := t.geometryType
+		if geometryType == "" {
+			geometryType = "GEOMETRY"
+		}
+		if err := geometryColumns.Insert(int64(i+1), t.name, "geom", geometryType, 4326, t.z.value(), t.m.value()); err != nil {
+			return err
+		}
+		if w.SpatialIndex {
+			if err := t.writeRTree(db); err != nil {
+				return err
+			}
+			err := extensions.Insert(int64(i+1), t.name, "geom", "gpkg_rtree_index", "http://www.geopackage.org/spec120/#extension_rtree", "write-only")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return db.Close()
+}
+
+// sql returns the statement creating the table with its current columns
+func (t *gpkgTable) sql() string {
+	geometryType := t.geometryType
+	if geometryType == "" {
+		geometryType = "GEOMETRY"
+	}
+	columns := []string{`"fid" INTEGER PRIMARY KEY NOT NULL`, `"geom" ` + geometryType}
+	for _, column := range t.columns {
+		columns = append(columns, sqlite.QuoteIdentifier(column.name)+" "+column.sqlType())
+	}
+	return fmt.Sprintf("CREATE TABLE %s (%s)", sqlite.QuoteIdentifier(t.name), strings.Join(columns, ", "))
+}
+
+// sqlType chooses the type of a column from the values it holds: their own
+// type if they have the same, REAL for a mix of integers and floating point
+// numbers, or else TEXT
+func (c *gpkgColumn) sqlType() string {
+	kind := uint8(fgbString)
+	switch {
+	case len(c.kinds) == 1:
+		for k := range c.kinds {
+			kind = k
+		}
+	case len(c.kinds) == 2 && (c.kinds[fgbInt] || c.kinds[fgbLong]) && c.kinds[fgbDouble]:
+		kind = fgbDouble
+	case len(c.kinds) == 2 && c.kinds[fgbInt] && c.kinds[fgbLong]:
+		kind = fgbLong
+	}
+	switch kind {
+	case fgbBool:
+		return "BOOLEAN"
+	case fgbInt, fgbLong:
+		return "INTEGER"
+	case fgbDouble:
+		return "REAL"
+	case fgbDateTime:
+		if c.dates {
+			return "DATE"
+		}
+		return "DATETIME"
+	case fgbBinary:
+		return "BLOB"
+	}
+	return "TEXT"
+}
+
+func (t *gpkgTable) write(feature *Feature, properties Properties) error {
+	fid := t.fid + 1
+	switch id := feature.ID.(type) {
+	case int:
+		if !t.started || int64(id) > t.fid {
+			fid = int64(id)
+		}
+	case int64:
+		if !t.started || id > t.fid {
+			fid = id
+		}
+	case float64:
+		if id == math.Trunc(id) && (!t.started || id > float64(t.fid)) && math.Abs(id) < 1<<53 {
+			fid = int64(id)
+		}
+	}
+	t.fid, t.started = fid, true
+
+	values := []interface{}{nil, nil}
+	if feature.Geometry != nil {
+		var z, m []float64
+		if feature.HasZ() {
+			z = feature.Z
+		}
+		if feature.HasM() {
+			m = feature.M
+		}
+		values[1] = gpkgEncodeGeometry(feature.Geometry, z, m)
+		t.addGeometry(fid, feature)
+	}
+	added := false
+	for _, prop := range properties {
+		i, ok := t.byKey[prop.Key]
+		if !ok {
+			i = len(t.columns)
+			t.byKey[prop.Key] = i
+			t.columns = append(t.columns, &gpkgColumn{name: t.columnName(prop.Key), kinds: map[uint8]bool{}, dates: true})
+			added = true
+		}
+		for len(values) <= 2+i {
+			values = append(values, nil)
+		}
+		values[2+i] = t.columns[i].value(prop.Value)
+	}
+	if added {
+		// Column types are settled once every feature is written
+		if err := t.rows.Redefine(t.sql()); err != nil {
+			return err
+		}
+	}
+	return t.rows.Insert(fid, values...)
+}
+
+// columnName returns a name for the column of a property key that no other
+// column of the table has
+func (t *gpkgTable) columnName(key string) string {
+	name := key
+	for n := 2; t.names[strings.ToLower(name)]; n++ {
+		name = key + "_" + strconv.Itoa(n)
+	}
+	t.names[strings.ToLower(name)] = true
+	return name
+}
+
+// value converts a property value to the value stored in the column
+func (c *gpkgColumn) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		c.kinds[fgbBinary] = true
+		return v
+	case bool, string:
+		c.kinds[fgbKindOf(v)] = true
+		return v
+	case time.Time:
+		c.kinds[fgbDateTime] = true
+		s := FormatTime(v)
+		if len(s) == len("2006-01-02") {
+			return s
+		}
+		c.dates = false
+		return v.UTC().Format("2006-01-02T15:04:05.000Z")
+	case int:
+		c.kinds[fgbKindOf(v)] = true
+		return int64(v)
+	case int64:
+		c.kinds[fgbKindOf(v)] = true
+		return v
+	case float64:
+		kind := fgbKindOf(v)
+		c.kinds[kind] = true
+		if kind != fgbDouble {
+			// Whole numbers are stored as integers, as SQLite does
+			return int64(v)
+		}
+		return v
+	}
+	c.kinds[fgbString] = true
+	return stringValue(value)
+}
+
+// addGeometry updates the geometry type, the extent and the index entries of
+// the table with the geometry of a feature
+func (t *gpkgTable) addGeometry(fid int64, feature *Feature) {
+	name := gpkgTypeName(feature.Geometry)
+	switch t.geometryType {
+	case "":
+		t.geometryType = name
+	case name:
+	default:
+		t.geometryType = "GEOMETRY"
+	}
+	if PositionCount(feature.Geometry) == 0 {
+		return
+	}
+	if feature.HasZ() {
+		t.z.with++
+	} else {
+		t.z.without++
+	}
+	if feature.HasM() {
+		t.m.with++
+	} else {
+		t.m.without++
+	}
+	bound := feature.Geometry.Bound()
+	if t.empty {
+		t.extent, t.empty = bound, false
+	}
+	t.extent = t.extent.Union(bound)
+	// Boxes are rounded outwards to single precision, as SQLite does
+	t.entries = append(t.entries, gpkgEntry{fid, [4]float32{
+		roundFloat32(bound.Min[0], -1), roundFloat32(bound.Max[0], 1),
+		roundFloat32(bound.Min[1], -1), roundFloat32(bound.Max[1], 1),
+	}})
+}
+
+// roundFloat32 rounds v to the nearest single precision number in a
+// direction, downwards if it is negative and upwards otherwise
+func roundFloat32(v float64, direction float32) float32 {
+	f := float32(v)
+	if direction < 0 && float64(f) > v || direction > 0 && float64(f) < v {
+		f = float32(math.Nextafter32(f, direction*float32(math.Inf(1))))
+	}
+	return f
+}
+
+func gpkgTypeName(g orb.Geometry) string {
+	switch g.(type) {
+	case orb.Point:
+		return "POINT"
+	case orb.MultiPoint:
+		return "MULTIPOINT"
+	case orb.LineString:
+		return "LINESTRING"
+	case orb.MultiLineString:
+		return "MULTILINESTRING"
+	case orb.Polygon, orb.Ring, orb.Bound:
+		return "POLYGON"
+	case orb.MultiPolygon:
+		return "MULTIPOLYGON"
+	}
+	return "GEOMETRYCOLLECTION"
+}
+
+// gpkgRTreeNode is a node of an R-tree index being built
+type gpkgRTreeNode struct {
+	number   int64
+	parent   int64
+	entries  []gpkgEntry
+	children []*gpkgRTreeNode
+	box      [4]float32
+}
+
+// writeRTree writes an R-tree index of the bounding boxes of the geometries,
+// as the rtree module of SQLite stores it: the nodes in one shadow table, the
+// leaf of each entry in another and the parent of each node in a third. It is
+// packed bottom up, with entries sorted along a Hilbert curve.
+func (t *gpkgTable) writeRTree(db *sqlite.Writer) error {
+	name := "rtree_" + t.name + "_geom"
+	quoted := sqlite.QuoteIdentifier(name)
+	db.AddObject("table", name, name, fmt.Sprintf("CREATE VIRTUAL TABLE %s USING rtree(id, minx, maxx, miny, maxy)", quoted))
+	rowids, err := db.CreateTable(name+"_rowid", fmt.Sprintf("CREATE TABLE %s(rowid INTEGER PRIMARY KEY,nodeno)", sqlite.QuoteIdentifier(name+"_rowid")))
+	if err != nil {
+		return err
+	}
+	nodes, err := db.CreateTable(name+"_node", fmt.Sprintf("CREATE TABLE %s(nodeno INTEGER PRIMARY KEY,data)", sqlite.QuoteIdentifier(name+"_node")))
+	if err != nil {
+		return err
+	}
+	parents, err := db.CreateTable(name+"_parent", fmt.Sprintf("CREATE TABLE %s(nodeno INTEGER PRIMARY KEY,parentnode)", sqlite.QuoteIdentifier(name+"_parent")))
+	if err != nil {
+		return err
+	}
+
+	entries := t.entries
+	if len(entries) > 0 {
+		bounds := make([]orb.Bound, len(entries))
+		for i, e := range entries {
+			bounds[i] = orb.Bound{Min: orb.Point{float64(e.box[0]), float64(e.box[2])}, Max: orb.Point{float64(e.box[1]), float64(e.box[3])}}
+		}
+		sorted := make([]gpkgEntry, len(entries))
+		for i, j := range fgbHilbertOrder(bounds) {
+			sorted[i] = entries[j]
+		}
+		entries = sorted
+	}
+	var level []*gpkgRTreeNode
+	groups := (len(entries) + gpkgRTreeCells - 1) / gpkgRTreeCells
+	for i := 0; i < groups; i++ {
+		node := &gpkgRTreeNode{entries: entries[i*len(entries)/groups : (i+1)*len(entries)/groups]}
+		node.box = node.entries[0].box
+		for _, e := range node.entries {
+			node.box = unionBox(node.box, e.box)
+		}
+		level = append(level, node)
+	}
+	depth := 0
+	for ; len(level) > 1; depth++ {
+		var up []*gpkgRTreeNode
+		groups := (len(level) + gpkgRTreeCells - 1) / gpkgRTreeCells
+		for i := 0; i < groups; i++ {
+			node := &gpkgRTreeNode{children: level[i*len(level)/groups : (i+1)*len(level)/groups]}
+			node.box = node.children[0].box
+			for _, child := range node.children {
+				node.box = unionBox(node.box, child.box)
+			}
+			up = append(up, node)
+		}
+		level = up
+	}
+	root := &gpkgRTreeNode{}
+	if len(level) == 1 {
+		root = level[0]
+	}
+
+	// The root is node 1, and the others are numbered level by level
+	all := []*gpkgRTreeNode{root}
+	root.number = 1
+	for i := 0; i < len(all); i++ {
+		for _, child := range all[i].children {
+			child.number, child.parent = int64(len(all)+1), all[i].number
+			all = append(all, child)
+		}
+	}
+	type leafEntry struct{ id, node int64 }
+	var leaves []leafEntry
+	for _, node := range all {
+		data := make([]byte, gpkgRTreeNodeLength)
+		if node == root {
+			binary.BigEndian.PutUint16(data, uint16(depth))
+		}
+		cells := node.entries
+		for _, child := range node.children {
+			cells = append(cells, gpkgEntry{child.number, child.box})
+		}
+		binary.BigEndian.PutUint16(data[2:], uint16(len(cells)))
+		for i, cell := range cells {
+			item := data[4+i*gpkgRTreeCellLength:]
+			binary.BigEndian.PutUint64(item, uint64(cell.id))
+			for j, v := range cell.box {
+				binary.BigEndian.PutUint32(item[8+4*j:], math.Float32bits(v))
+			}
+		}
+		if err := nodes.Insert(node.number, nil, data); err != nil {
+			return err
+		}
+		if node != root {
+			if err := parents.Insert(node.number, nil, node.parent); err != nil {
+				return err
+			}
+		}
+		for _, e := range node.entries {
+			leaves = append(leaves, leafEntry{e.id, node.number})
+		}
+	}
+	sort.Slice(leaves, func(i, j int) bool { return leaves[i].id < leaves[j].id })
+	for _, e := range leaves {
+		if err := rowids.Insert(e.id, nil, e.node); err != nil {
+			return err
+		}
+	}
+
+	for _, trigger := range gpkgRTreeTriggers {
+		triggerName := name + "_" + trigger.suffix
+		sql := fmt.Sprintf(trigger.sql, quoted, sqlite.QuoteIdentifier(t.name), `"geom"`, `"fid"`, sqlite.QuoteIdentifier(triggerName))
+		db.AddObject("trigger", triggerName, t.name, sql)
+	}
+	t.entries = nil
+	return nil
+}
+
+func unionBox(a, b [4]float32) [4]float32 {
+	return [4]float32{
+		float32(math.Min(float64(a[0]), float64(b[0]))), float32(math.Max(float64(a[1]), float64(b[1]))),
+		float32(math.Min(float64(a[2]), float64(b[2]))), float32(math.Max(float64(a[3]), float64(b[3]))),
+	}
+}
@@ -0,0 +1,237 @@
+package io
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/paulmach/orb"
+	"io"
+	"strings"
+)
+
+// GPXLayers are the layers of a GPX file, named after the layers GDAL reads
+var GPXLayers = []string{"waypoints", "routes", "tracks"}
+
+// GPXReader reads the waypoints of a GPX file as points, and its routes and
+// tracks as line strings, or multi-line strings for tracks of several
+// segments. Elevations are kept as Z ordinates, and the timestamps of route
+// and track points as a "times" property parallel to the positions.
+// Timestamps are kept as written, since a time.Time at midnight UTC would be
+// formatted as a date.
+type GPXReader struct {
+	input  io.Reader
+	layers map[string]bool
+
+	// LayerProperty, when set, is the name of a property that receives the
+	// layer of each feature: waypoints, routes or tracks
+	LayerProperty string
+}
+
+// NewGPXReader returns a reader of the given layers of a GPX file, or of all
+// of them if none are given
+func NewGPXReader(input io.Reader, layers ...string) (*GPXReader, error) {
+	if len(layers) == 0 {
+		layers = GPXLayers
+	}
+	selected := map[string]bool{}
+	for _, layer := range layers {
+		found := false
+		for _, name := range GPXLayers {
+			found = found || layer == name
+		}
+		if !found {
+			return nil, fmt.Errorf("no layer %q, available layers are %s", layer, strings.Join(GPXLayers, ", "))
+		}
+		selected[layer] = true
+	}
+	return &GPXReader{
+		input:  input,
+		layers: selected,
+	}, nil
+}
+
+// Layers returns the names of the layers that are read
+func (g *GPXReader) Layers() []string {
+	var layers []string
+	for _, layer := range GPXLayers {
+		if g.layers[layer] {
+			layers = append(layers, layer)
+		}
+	}
+	return layers
+}
+
+type gpxPoint struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Ele  *float64 `xml:"ele"`
+	Time string   `xml:"time"`
+	gpxDescription
+	Sym  string `xml:"sym"`
+	Type string `xml:"type"`
+}
+
+type gpxDescription struct {
+	Name string `xml:"name"`
+	Cmt  string `xml:"cmt"`
+	Desc string `xml:"desc"`
+	Src  string `xml:"src"`
+}
+
+type gpxRoute struct {
+	gpxDescription
+	Number *int       `xml:"number"`
+	Type   string     `xml:"type"`
+	Points []gpxPoint `xml:"rtept"`
+}
+
+type gpxTrack struct {
+	gpxDescription
+	Number   *int   `xml:"number"`
+	Type     string `xml:"type"`
+	Segments []struct {
+		Points []gpxPoint `xml:"trkpt"`
+	} `xml:"trkseg"`
+}
+
+func (g *GPXReader) Read(ctx context.Context, out chan *Feature) error {
+	dec := xml.NewDecoder(g.input)
+	dec.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		enc, err := LookupEncoding(label)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+	layers := map[string]string{"wpt": "waypoints", "rte": "routes", "trk": "tracks"}
+	index := 0
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		layer, ok := layers[start.Name.Local]
+		if !ok {
+			continue
+		}
+		if !g.layers[layer] {
+			if err := dec.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		line, _ := dec.InputPos()
+		var feature *Feature
+		switch layer {
+		case "waypoints":
+			var point gpxPoint
+			if err = dec.DecodeElement(&point, &start); err == nil {
+				feature = gpxWaypoint(point)
+			}
+		case "routes":
+			var route gpxRoute
+			if err = dec.DecodeElement(&route, &start); err == nil {
+				feature = gpxLine(route.gpxDescription, route.Number, route.Type, [][]gpxPoint{route.Points})
+			}
+		case "tracks":
+			var track gpxTrack
+			if err = dec.DecodeElement(&track, &start); err == nil {
+				segments := make([][]gpxPoint, len(track.Segments))
+				for i, segment := range track.Segments {
+					segments[i] = segment.Points
+				}
+				feature = gpxLine(track.gpxDescription, track.Number, track.Type, segments)
+			}
+		}
+		if err != nil {
+			return &ErrMalformedFeature{Index: index, Line: line, Err: err}
+		}
+		if g.LayerProperty != "" {
+			feature.Properties.Set(g.LayerProperty, layer)
+		}
+		if err := Emit(ctx, out, feature); err != nil {
+			return err
+		}
+		index++
+	}
+}
+
+func gpxWaypoint(point gpxPoint) *Feature {
+	feature := NewFeature(orb.Point{point.Lon, point.Lat})
+	if point.Ele != nil {
+		feature.Z = []float64{*point.Ele}
+	}
+	point.gpxDescription.setProperties(feature)
+	if point.Sym != "" {
+		feature.Properties.Set("sym", point.Sym)
+	}
+	if point.Type != "" {
+		feature.Properties.Set("type", point.Type)
+	}
+	if point.Time != "" {
+		feature.Properties.Set("time", strings.TrimSpace(point.Time))
+	}
+	return feature
+}
+
+// gpxLine builds the feature of a route, or of a track from its segments
+func gpxLine(description gpxDescription, number *int, lineType string, segments [][]gpxPoint) *Feature {
+	var lines orb.MultiLineString
+	var z []float64
+	var times []interface{}
+	hasTime := false
+	for _, points := range segments {
+		line := make(orb.LineString, len(points))
+		for i, point := range points {
+			line[i] = orb.Point{point.Lon, point.Lat}
+			if point.Ele != nil {
+				z = append(z, *point.Ele)
+			}
+			var t interface{}
+			if point.Time != "" {
+				t = strings.TrimSpace(point.Time)
+				hasTime = true
+			}
+			times = append(times, t)
+		}
+		lines = append(lines, line)
+	}
+	var feature *Feature
+	switch len(lines) {
+	case 0:
+		feature = NewFeature(nil)
+	case 1:
+		feature = NewFeature(lines[0])
+	default:
+		feature = NewFeature(lines)
+	}
+	if len(z) == len(times) && len(z) > 0 {
+		feature.Z = z
+	}
+	description.setProperties(feature)
+	if number != nil {
+		feature.Properties.Set("number", *number)
+	}
+	if lineType != "" {
+		feature.Properties.Set("type", lineType)
+	}
+	if hasTime {
+		feature.Properties.Set("times", times)
+	}
+	return feature
+}
+
+func (d gpxDescription) setProperties(feature *Feature) {
+	for _, prop := range []Property{{"name", d.Name}, {"cmt", d.Cmt}, {"desc", d.Desc}, {"src", d.Src}} {
+		if prop.Value != "" {
+			feature.Properties.Set(prop.Key, strings.TrimSpace(prop.Value.(string)))
+		}
+	}
+}
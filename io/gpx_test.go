@@ -0,0 +1,135 @@
+package io
+
+import (
+	"github.com/paulmach/orb"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const gpxDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <metadata><name>Survey</name><time>2021-05-01T00:00:00Z</time></metadata>
+  <wpt lat="48.5" lon="2.25">
+    <ele>35.5</ele>
+    <time>2021-05-01T08:30:00Z</time>
+    <name> Camp </name>
+    <desc>Base camp</desc>
+    <sym>Flag</sym>
+  </wpt>
+  <wpt lat="48.6" lon="2.3"/>
+  <rte>
+    <name>Route</name>
+    <number>2</number>
+    <rtept lat="1" lon="2"><ele>10</ele></rtept>
+    <rtept lat="3" lon="4"><ele>20</ele></rtept>
+  </rte>
+  <trk>
+    <name>Track</name>
+    <type>hiking</type>
+    <trkseg>
+      <trkpt lat="1" lon="2"><ele>10</ele><time>2021-05-01T00:00:00Z</time></trkpt>
+      <trkpt lat="3" lon="4"><ele>20</ele><time>2021-05-01T00:00:05.5Z</time></trkpt>
+    </trkseg>
+    <trkseg>
+      <trkpt lat="5" lon="6"><time>2021-05-01T02:00:00+02:00</time></trkpt>
+      <trkpt lat="7" lon="8"><ele>40</ele></trkpt>
+    </trkseg>
+  </trk>
+  <trk>
+    <trkseg>
+      <trkpt lat="1" lon="1"/>
+      <trkpt lat="2" lon="2"/>
+    </trkseg>
+  </trk>
+</gpx>
+`
+
+// gpxFeatures are the features of gpxDocument by layer
+var gpxFeatures = map[string][]*Feature{
+	"waypoints": {
+		{
+			Geometry: orb.Point{2.25, 48.5},
+			Z:        []float64{35.5},
+			Properties: Properties{
+				{"name", "Camp"},
+				{"desc", "Base camp"},
+				{"sym", "Flag"},
+				{"time", "2021-05-01T08:30:00Z"},
+			},
+		},
+		{Geometry: orb.Point{2.3, 48.6}},
+	},
+	"routes": {
+		{
+			Geometry:   orb.LineString{{2, 1}, {4, 3}},
+			Z:          []float64{10, 20},
+			Properties: Properties{{"name", "Route"}, {"number", 2}},
+		},
+	},
+	"tracks": {
+		{
+			Geometry: orb.MultiLineString{{{2, 1}, {4, 3}}, {{6, 5}, {8, 7}}},
+			// Z is dropped, since a point has no elevation
+			Properties: Properties{
+				{"name", "Track"},
+				{"type", "hiking"},
+				// Times are kept as written, midnight included
+				{"times", []interface{}{"2021-05-01T00:00:00Z", "2021-05-01T00:00:05.5Z", "2021-05-01T02:00:00+02:00", nil}},
+			},
+		},
+		{Geometry: orb.LineString{{1, 1}, {2, 2}}},
+	},
+}
+
+func TestGPXReader(t *testing.T) {
+	tests := []struct {
+		layers []string
+		want   []string
+	}{
+		{nil, []string{"waypoints", "routes", "tracks"}},
+		{[]string{"tracks"}, []string{"tracks"}},
+		{[]string{"tracks", "waypoints"}, []string{"waypoints", "tracks"}},
+	}
+	for _, test := range tests {
+		reader, err := NewGPXReader(strings.NewReader(gpxDocument), test.layers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := reader.Layers(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got layers %v, want %v", test.layers, got, test.want)
+		}
+		reader.LayerProperty = "layer"
+		read, err := readAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []*Feature
+		for _, layer := range test.want {
+			for _, feature := range gpxFeatures[layer] {
+				f := *feature
+				f.Properties = append(append(Properties(nil), feature.Properties...), Property{"layer", layer})
+				want = append(want, &f)
+			}
+		}
+		compareFeatures(t, strings.Join(test.want, ","), read, want)
+	}
+}
+
+func TestGPXReaderLayers(t *testing.T) {
+	_, err := NewGPXReader(strings.NewReader(gpxDocument), "tracks", "trackpoints")
+	if err == nil || err.Error() != `no layer "trackpoints", available layers are waypoints, routes, tracks` {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestGPXReaderErrors(t *testing.T) {
+	reader, _ := NewGPXReader(strings.NewReader(`<gpx><wpt lat="1" lon="2"/><wpt lat="north" lon="2"/></gpx>`))
+	read, err := readAll(reader)
+	if err, ok := err.(*ErrMalformedFeature); !ok || err.Index != 1 {
+		t.Errorf("got %v", err)
+	}
+	if len(read) != 1 {
+		t.Errorf("read %d features", len(read))
+	}
+}
@@ -1,5 +1,26 @@
 package io
 
+import (
+	"context"
+)
+
+// FeatureReader sends the features of its input to out until the input ends
+// or ctx is done. Readers do not close out, and release any files they hold
+// before Read returns.
 type FeatureReader interface {
-	Read(out chan map[string]interface{}) error
+	Read(ctx context.Context, out chan *Feature) error
+}
+
+type FeatureWriter interface {
+	Write(in chan *Feature) error
+}
+
+// Emit sends a feature to out, unless ctx is done first
+func Emit(ctx context.Context, out chan *Feature, feature *Feature) error {
+	select {
+	case out <- feature:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
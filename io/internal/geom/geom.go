@@ -0,0 +1,47 @@
+// Package geom provides the helpers for orb geometries that the io package
+// and its encodings share
+package geom
+
+import (
+	"github.com/paulmach/orb"
+)
+
+// PositionCount returns the number of positions in a geometry, which is the
+// expected length of a feature's Z and M ordinates
+func PositionCount(g orb.Geometry) int {
+	switch g := g.(type) {
+	case orb.Point:
+		return 1
+	case orb.MultiPoint:
+		return len(g)
+	case orb.LineString:
+		return len(g)
+	case orb.Ring:
+		return len(g)
+	case orb.MultiLineString:
+		n := 0
+		for _, line := range g {
+			n += len(line)
+		}
+		return n
+	case orb.Polygon:
+		n := 0
+		for _, ring := range g {
+			n += len(ring)
+		}
+		return n
+	case orb.MultiPolygon:
+		n := 0
+		for _, polygon := range g {
+			n += PositionCount(polygon)
+		}
+		return n
+	case orb.Collection:
+		n := 0
+		for _, item := range g {
+			n += PositionCount(item)
+		}
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,146 @@
+// Package geomtest provides geometries as the shapefile reader decodes them,
+// for the round-trip tests of geometry encodings
+package geomtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/paulmach/orb"
+	gio "github.com/stationa/xgeo/io"
+	"path/filepath"
+)
+
+// Case is a geometry with the Z and M ordinates of its positions
+type Case struct {
+	Name     string
+	Geometry orb.Geometry
+	Z, M     []float64
+}
+
+var shapes = []struct {
+	name     string
+	geometry orb.Geometry
+}{
+	{"point", orb.Point{1.5, -2}},
+	{"multipoint", orb.MultiPoint{{1, 2}, {3, 4}}},
+	{"linestring", orb.LineString{{0, 0}, {1, 1}, {2, 0}}},
+	{"multilinestring", orb.MultiLineString{{{0, 0}, {1, 1}}, {{2, 2}, {3, 3}, {4, 2}}}},
+	{"polygon", orb.Polygon{{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}}}},
+	{"polygon with hole", orb.Polygon{{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}}, {{1, 1}, {1, 2}, {2, 2}, {2, 1}, {1, 1}}}},
+	{"multipolygon", orb.MultiPolygon{{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}, {{{5, 5}, {6, 5}, {6, 6}, {5, 5}}}}},
+}
+
+// Shapefile writes every kind of geometry that shapefiles hold, in 2D and
+// with Z, M, and Z and M ordinates, to a shapefile of its own in dir, and
+// returns the cases read back from them. Since the reader decodes every line
+// and polygon shape to a MultiLineString or MultiPolygon, the single members
+// of these are cases as well, named with a "part" suffix.
+func Shapefile(dir string) ([]Case, error) {
+	var cases []Case
+	for i, shape := range shapes {
+		n := gio.PositionCount(shape.geometry)
+		z, m := make([]float64, n), make([]float64, n)
+		for j := range z {
+			z[j], m[j] = float64(10+j), float64(100+j)
+		}
+		for j, dim := range []struct {
+			name string
+			z, m []float64
+		}{{"", nil, nil}, {" Z", z, nil}, {" M", nil, m}, {" ZM", z, m}} {
+			filename := filepath.Join(dir, fmt.Sprintf("%d_%d.shp", i, j))
+			feature := &gio.Feature{Geometry: shape.geometry, Z: dim.z, M: dim.m}
+			read, err := roundTrip(filename, feature)
+			if err != nil {
+				return nil, fmt.Errorf("%s%s: %v", shape.name, dim.name, err)
+			}
+			cases = append(cases, Case{shape.name + dim.name, read.Geometry, read.Z, read.M})
+			var part orb.Geometry
+			switch g := read.Geometry.(type) {
+			case orb.MultiLineString:
+				if len(g) == 1 {
+					part = g[0]
+				}
+			case orb.MultiPolygon:
+				if len(g) == 1 {
+					part = g[0]
+				}
+			}
+			if part != nil {
+				cases = append(cases, Case{shape.name + " part" + dim.name, part, read.Z, read.M})
+			}
+		}
+	}
+	return cases, nil
+}
+
+// roundTrip writes a feature to a shapefile and reads it back
+func roundTrip(filename string, feature *gio.Feature) (*gio.Feature, error) {
+	writer, err := gio.NewShapefileWriter(filename)
+	if err != nil {
+		return nil, err
+	}
+	in := make(chan *gio.Feature, 1)
+	in <- feature
+	close(in)
+	if err := writer.Write(in); err != nil {
+		return nil, err
+	}
+	reader, err := gio.NewShapefileReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *gio.Feature, 2)
+	if err := reader.Read(context.Background(), out); err != nil {
+		return nil, err
+	}
+	close(out)
+	read := <-out
+	if read == nil || len(out) > 0 {
+		return nil, fmt.Errorf("read %d features, want 1", len(out)+1)
+	}
+	return read, nil
+}
+
+// Empty returns the empty geometry of every type. The nil geometry is the
+// empty point.
+func Empty() []Case {
+	return []Case{
+		{Name: "empty point"},
+		{Name: "empty multipoint", Geometry: orb.MultiPoint{}},
+		{Name: "empty linestring", Geometry: orb.LineString{}},
+		{Name: "empty multilinestring", Geometry: orb.MultiLineString{}},
+		{Name: "empty polygon", Geometry: orb.Polygon{}},
+		{Name: "empty multipolygon", Geometry: orb.MultiPolygon{}},
+		{Name: "empty geometrycollection", Geometry: orb.Collection{}},
+	}
+}
+
+// collection returns a geometry collection of the named cases, which must
+// all have Z and M ordinates or all lack them
+func collection(name string, cases []Case, names ...string) Case {
+	c := Case{Name: name, Geometry: orb.Collection{}}
+	for _, itemName := range names {
+		for _, item := range cases {
+			if item.Name == itemName {
+				c.Geometry = append(c.Geometry.(orb.Collection), item.Geometry)
+				c.Z = append(c.Z, item.Z...)
+				c.M = append(c.M, item.M...)
+			}
+		}
+	}
+	return c
+}
+
+// All returns the cases of Shapefile, geometry collections of them, and the
+// empty geometries
+func All(dir string) ([]Case, error) {
+	cases, err := Shapefile(dir)
+	if err != nil {
+		return nil, err
+	}
+	cases = append(cases,
+		collection("geometrycollection", cases, "point", "linestring part", "multipolygon"),
+		collection("geometrycollection ZM", cases, "point ZM", "linestring part ZM", "multipolygon ZM"),
+	)
+	return append(cases, Empty()...), nil
+}
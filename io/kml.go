@@ -0,0 +1,456 @@
+package io
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/paulmach/orb"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// KMLReader reads the Placemarks of a KML document, or of the main document
+// of a KMZ archive, however deeply they are nested in Folders
+type KMLReader struct {
+	input    io.Reader
+	archive  *zip.ReadCloser
+	document *zip.File
+
+	// FolderProperty, when set, is the name of a property that receives the
+	// path of the Folders each Placemark is in, e.g. "Sites/North"
+	FolderProperty string
+}
+
+func NewKMLReader(input io.Reader) (*KMLReader, error) {
+	return &KMLReader{input: input}, nil
+}
+
+// NewKMZReader opens a KMZ archive. Its main document is doc.kml, or else the
+// first KML file of the archive.
+func NewKMZReader(filename string) (*KMLReader, error) {
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	document := kmzDocument(&archive.Reader)
+	if document == nil {
+		archive.Close()
+		return nil, errors.New("archive does not contain a .kml file")
+	}
+	return &KMLReader{archive: archive, document: document}, nil
+}
+
+// IsKMZ tells whether a file is a zip archive containing a KML document
+func IsKMZ(filename string) bool {
+	if !isZipFile(filename) {
+		return false
+	}
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return false
+	}
+	defer archive.Close()
+	return kmzDocument(&archive.Reader) != nil
+}
+
+func kmzDocument(archive *zip.Reader) *zip.File {
+	var first *zip.File
+	for _, f := range archive.File {
+		if isHiddenZipEntry(f.Name) || !strings.EqualFold(path.Ext(f.Name), ".kml") {
+			continue
+		}
+		if strings.EqualFold(f.Name, "doc.kml") {
+			return f
+		}
+		if first == nil {
+			first = f
+		}
+	}
+	return first
+}
+
+func (k *KMLReader) Read(ctx context.Context, out chan *Feature) error {
+	input := k.input
+	if k.archive != nil {
+		defer k.archive.Close()
+		r, err := k.document.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		input = r
+	}
+	dec := xml.NewDecoder(input)
+	// KML found in the wild is not always well-formed
+	dec.Strict = false
+	dec.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		enc, err := LookupEncoding(label)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+
+	schemas := map[string]map[string]string{}
+	// The names of the open elements, and the names of the open Folders
+	var elements, folders []string
+	index := 0
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			parent := ""
+			if len(elements) > 0 {
+				parent = elements[len(elements)-1]
+			}
+			switch {
+			case t.Name.Local == "Placemark":
+				line, _ := dec.InputPos()
+				node := &kmlNode{}
+				if err := dec.DecodeElement(node, &t); err != nil {
+					return err
+				}
+				feature, err := k.placemark(node, schemas, folders)
+				if err != nil {
+					return &ErrMalformedFeature{Index: index, Line: line, Err: err}
+				}
+				if err := Emit(ctx, out, feature); err != nil {
+					return err
+				}
+				index++
+				continue
+			case t.Name.Local == "Schema":
+				node := &kmlNode{}
+				if err := dec.DecodeElement(node, &t); err != nil {
+					return err
+				}
+				fields := map[string]string{}
+				for _, field := range node.children("SimpleField") {
+					fields[field.attr("name")] = strings.ToLower(field.attr("type"))
+				}
+				schemas[node.attr("id")] = fields
+				continue
+			case t.Name.Local == "name" && parent == "Folder":
+				var name string
+				if err := dec.DecodeElement(&name, &t); err != nil {
+					return err
+				}
+				folders[len(folders)-1] = strings.TrimSpace(name)
+				continue
+			case t.Name.Local == "Folder":
+				folders = append(folders, "")
+			}
+			elements = append(elements, t.Name.Local)
+		case xml.EndElement:
+			if len(elements) > 0 {
+				if elements[len(elements)-1] == "Folder" {
+					folders = folders[:len(folders)-1]
+				}
+				elements = elements[:len(elements)-1]
+			}
+		}
+	}
+}
+
+// kmlNode is a generic XML element, which Placemarks are decoded into
+type kmlNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []*kmlNode `xml:",any"`
+}
+
+func (n *kmlNode) child(name string) *kmlNode {
+	for _, child := range n.Children {
+		if child.XMLName.Local == name {
+			return child
+		}
+	}
+	return nil
+}
+
+func (n *kmlNode) children(name string) []*kmlNode {
+	var children []*kmlNode
+	for _, child := range n.Children {
+		if child.XMLName.Local == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+func (n *kmlNode) attr(name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func (k *KMLReader) placemark(node *kmlNode, schemas map[string]map[string]string, folders []string) (*Feature, error) {
+	feature := &Feature{}
+	if id := node.attr("id"); id != "" {
+		feature.ID = id
+	}
+	for _, name := range []string{"name", "description"} {
+		if child := node.child(name); child != nil {
+			feature.Properties.Set(name, strings.TrimSpace(child.Text))
+		}
+	}
+	if data := node.child("ExtendedData"); data != nil {
+		for _, child := range data.Children {
+			switch child.XMLName.Local {
+			case "Data":
+				var value interface{}
+				if v := child.child("value"); v != nil {
+					value = v.Text
+				}
+				feature.Properties.Set(child.attr("name"), value)
+			case "SchemaData":
+				types := schemas[strings.TrimPrefix(child.attr("schemaUrl"), "#")]
+				for _, simple := range child.children("SimpleData") {
+					name := simple.attr("name")
+					feature.Properties.Set(name, kmlValue(simple.Text, types[name]))
+				}
+			}
+		}
+	}
+	if k.FolderProperty != "" && len(folders) > 0 {
+		var names []string
+		for _, name := range folders {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		feature.Properties.Set(k.FolderProperty, strings.Join(names, "/"))
+	}
+	for _, child := range node.Children {
+		if isKMLGeometry(child.XMLName.Local) {
+			dec := &kmlGeometryDecoder{}
+			g, err := dec.geometry(child)
+			if err != nil {
+				return nil, err
+			}
+			feature.Geometry = g
+			if len(dec.z) == dec.count && dec.count > 0 {
+				feature.Z = dec.z
+			}
+			break
+		}
+	}
+	return feature, nil
+}
+
+// kmlValue converts the text of a SimpleData element to the type of its
+// SimpleField, keeping it as a string if it does not parse
+func kmlValue(text, fieldType string) interface{} {
+	text = strings.TrimSpace(text)
+	switch fieldType {
+	case "int", "uint", "short", "ushort":
+		if i, err := strconv.Atoi(text); err == nil {
+			return i
+		}
+	case "float", "double":
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	case "bool":
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+	}
+	return text
+}
+
+func isKMLGeometry(name string) bool {
+	switch name {
+	case "Point", "LineString", "LinearRing", "Polygon", "MultiGeometry", "Track", "MultiTrack":
+		return true
+	}
+	return false
+}
+
+// kmlGeometryDecoder collects the altitude of every position, in traversal
+// order, as their Z ordinate
+type kmlGeometryDecoder struct {
+	z     []float64
+	count int
+}
+
+func (d *kmlGeometryDecoder) geometry(n *kmlNode) (orb.Geometry, error) {
+	switch n.XMLName.Local {
+	case "Point":
+		points, err := d.coordinates(n)
+		if err != nil || len(points) == 0 {
+			return nil, err
+		}
+		if len(points) > 1 {
+			return nil, errors.New("Point has more than one position")
+		}
+		return points[0], nil
+	case "LineString":
+		points, err := d.coordinates(n)
+		return orb.LineString(points), err
+	case "LinearRing":
+		points, err := d.coordinates(n)
+		return orb.Polygon{orb.Ring(points)}, err
+	case "Polygon":
+		return d.polygon(n)
+	case "Track":
+		return d.track(n)
+	case "MultiTrack":
+		var lines orb.MultiLineString
+		for _, child := range n.children("Track") {
+			line, err := d.track(child)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, line)
+		}
+		return lines, nil
+	}
+	var members []orb.Geometry
+	for _, child := range n.Children {
+		if !isKMLGeometry(child.XMLName.Local) {
+			continue
+		}
+		member, err := d.geometry(child)
+		if err != nil {
+			return nil, err
+		}
+		if member != nil {
+			members = append(members, member)
+		}
+	}
+	return collectKMLGeometries(members), nil
+}
+
+// collectKMLGeometries turns the members of a MultiGeometry into a
+// multi-geometry if they all have the same type, or a collection otherwise
+func collectKMLGeometries(members []orb.Geometry) orb.Geometry {
+	if len(members) == 0 {
+		return orb.Collection{}
+	}
+	switch members[0].(type) {
+	case orb.Point:
+		points := make(orb.MultiPoint, 0, len(members))
+		for _, member := range members {
+			point, ok := member.(orb.Point)
+			if !ok {
+				return orb.Collection(members)
+			}
+			points = append(points, point)
+		}
+		return points
+	case orb.LineString:
+		lines := make(orb.MultiLineString, 0, len(members))
+		for _, member := range members {
+			line, ok := member.(orb.LineString)
+			if !ok {
+				return orb.Collection(members)
+			}
+			lines = append(lines, line)
+		}
+		return lines
+	case orb.Polygon:
+		polygons := make(orb.MultiPolygon, 0, len(members))
+		for _, member := range members {
+			polygon, ok := member.(orb.Polygon)
+			if !ok {
+				return orb.Collection(members)
+			}
+			polygons = append(polygons, polygon)
+		}
+		return polygons
+	}
+	return orb.Collection(members)
+}
+
+func (d *kmlGeometryDecoder) polygon(n *kmlNode) (orb.Polygon, error) {
+	var rings []*kmlNode
+	if outer := n.child("outerBoundaryIs"); outer != nil {
+		rings = append(rings, outer.children("LinearRing")...)
+	}
+	if len(rings) == 0 {
+		return nil, errors.New("Polygon has no outer boundary")
+	}
+	for _, inner := range n.children("innerBoundaryIs") {
+		rings = append(rings, inner.children("LinearRing")...)
+	}
+	polygon := make(orb.Polygon, 0, len(rings))
+	for _, ring := range rings {
+		points, err := d.coordinates(ring)
+		if err != nil {
+			return nil, err
+		}
+		polygon = append(polygon, orb.Ring(points))
+	}
+	return polygon, nil
+}
+
+// kmlTupleSpace matches the spaces that some writers put around the commas of
+// coordinate tuples
+var kmlTupleSpace = regexp.MustCompile(`\s*,\s*`)
+
+// coordinates parses the coordinates element of a node, a list of
+// lon,lat[,alt] tuples separated by whitespace
+func (d *kmlGeometryDecoder) coordinates(n *kmlNode) ([]orb.Point, error) {
+	var text string
+	if c := n.child("coordinates"); c != nil {
+		text = kmlTupleSpace.ReplaceAllString(strings.TrimSpace(c.Text), ",")
+	}
+	var points []orb.Point
+	for _, tuple := range strings.Fields(text) {
+		point, err := d.position(strings.Split(tuple, ","))
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// track parses the gx:coord elements of a gx:Track, whose ordinates are
+// separated by spaces
+func (d *kmlGeometryDecoder) track(n *kmlNode) (orb.LineString, error) {
+	var line orb.LineString
+	for _, coord := range n.children("coord") {
+		point, err := d.position(strings.Fields(coord.Text))
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, point)
+	}
+	return line, nil
+}
+
+func (d *kmlGeometryDecoder) position(ordinates []string) (orb.Point, error) {
+	if len(ordinates) < 2 || len(ordinates) > 3 {
+		return orb.Point{}, fmt.Errorf("invalid coordinates %q", strings.Join(ordinates, ","))
+	}
+	var values [3]float64
+	for i, s := range ordinates {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return orb.Point{}, fmt.Errorf("invalid coordinates %q", strings.Join(ordinates, ","))
+		}
+		values[i] = v
+	}
+	d.count++
+	if len(ordinates) == 3 && len(d.z) == d.count-1 {
+		d.z = append(d.z, values[2])
+	}
+	return orb.Point{values[0], values[1]}, nil
+}
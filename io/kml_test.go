@@ -0,0 +1,355 @@
+package io
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/paulmach/orb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const kmlDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">
+<Document>
+  <name>Survey</name>
+  <Schema name="site" id="site">
+    <SimpleField name="visitors" type="int"/>
+    <SimpleField name="height" type="double"/>
+    <SimpleField name="open" type="bool"/>
+    <SimpleField name="code" type="int"/>
+  </Schema>
+  <Placemark id="top">
+    <name>Top</name>
+    <Point><coordinates>1,2</coordinates></Point>
+  </Placemark>
+  <Folder>
+    <name>Sites</name>
+    <Folder>
+      <name> North </name>
+      <Placemark id="hill">
+        <name>Hill</name>
+        <description>A <![CDATA[<b>hill</b>]]></description>
+        <ExtendedData>
+          <Data name="note"><value>windy</value></Data>
+          <SchemaData schemaUrl="#site">
+            <SimpleData name="visitors">12</SimpleData>
+            <SimpleData name="height">3.5</SimpleData>
+            <SimpleData name="open">true</SimpleData>
+            <SimpleData name="code">A1</SimpleData>
+          </SchemaData>
+        </ExtendedData>
+        <Point><coordinates>3, 4, 100</coordinates></Point>
+      </Placemark>
+    </Folder>
+    <Placemark>
+      <name>Roads</name>
+      <MultiGeometry>
+        <LineString><coordinates>0,0 1,1</coordinates></LineString>
+        <LineString><coordinates>2,2 3,3</coordinates></LineString>
+      </MultiGeometry>
+    </Placemark>
+  </Folder>
+  <Folder>
+    <Placemark>
+      <name>Park</name>
+      <MultiGeometry>
+        <Point><coordinates>1,1</coordinates></Point>
+        <Polygon>
+          <outerBoundaryIs><LinearRing><coordinates>0,0 4,0 4,4 0,4 0,0</coordinates></LinearRing></outerBoundaryIs>
+          <innerBoundaryIs><LinearRing><coordinates>1,1 2,1 2,2 1,1</coordinates></LinearRing></innerBoundaryIs>
+        </Polygon>
+      </MultiGeometry>
+    </Placemark>
+  </Folder>
+  <Placemark>
+    <gx:Track><gx:coord>1 2 3</gx:coord><gx:coord>4 5 6</gx:coord></gx:Track>
+  </Placemark>
+</Document>
+</kml>
+`
+
+// kmlFeatures are the features of kmlDocument, whose Folders are stored in a
+// "folder" property
+var kmlFeatures = []*Feature{
+	{
+		ID:         "top",
+		Geometry:   orb.Point{1, 2},
+		Properties: Properties{{"name", "Top"}},
+	},
+	{
+		ID:       "hill",
+		Geometry: orb.Point{3, 4},
+		Z:        []float64{100},
+		Properties: Properties{
+			{"name", "Hill"},
+			{"description", "A <b>hill</b>"},
+			{"note", "windy"},
+			{"visitors", 12},
+			{"height", 3.5},
+			{"open", true},
+			// Values that do not parse as their type are kept as strings
+			{"code", "A1"},
+			{"folder", "Sites/North"},
+		},
+	},
+	{
+		Geometry:   orb.MultiLineString{{{0, 0}, {1, 1}}, {{2, 2}, {3, 3}}},
+		Properties: Properties{{"name", "Roads"}, {"folder", "Sites"}},
+	},
+	{
+		Geometry: orb.Collection{
+			orb.Point{1, 1},
+			orb.Polygon{{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}}, {{1, 1}, {2, 1}, {2, 2}, {1, 1}}},
+		},
+		// The Folder has no name
+		Properties: Properties{{"name", "Park"}, {"folder", ""}},
+	},
+	{
+		Geometry: orb.LineString{{1, 2}, {4, 5}},
+		Z:        []float64{3, 6},
+	},
+}
+
+// withoutProperty returns copies of features without a property
+func withoutProperty(features []*Feature, key string) []*Feature {
+	var copies []*Feature
+	for _, feature := range features {
+		f := *feature
+		f.Properties = nil
+		for _, prop := range feature.Properties {
+			if prop.Key != key {
+				f.Properties = append(f.Properties, prop)
+			}
+		}
+		copies = append(copies, &f)
+	}
+	return copies
+}
+
+func compareFeatures(t *testing.T, name string, got, want []*Feature) {
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d features, want %d", name, len(got), len(want))
+		return
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("%s: feature %d: got %+v, want %+v", name, i, got[i], want[i])
+		}
+	}
+}
+
+func TestKMLReader(t *testing.T) {
+	reader, err := NewKMLReader(strings.NewReader(kmlDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.FolderProperty = "folder"
+	read, err := readAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFeatures(t, "folders", read, kmlFeatures)
+
+	// Folders are not stored by default
+	reader, _ = NewKMLReader(strings.NewReader(kmlDocument))
+	read, err = readAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFeatures(t, "no folders", read, withoutProperty(kmlFeatures, "folder"))
+}
+
+func TestKMLReaderErrors(t *testing.T) {
+	tests := []string{
+		`<kml><Placemark><Point><coordinates>1</coordinates></Point></Placemark></kml>`,
+		`<kml><Placemark><Point><coordinates>1,x</coordinates></Point></Placemark></kml>`,
+		`<kml><Placemark><Point><coordinates>1,2 3,4</coordinates></Point></Placemark></kml>`,
+		`<kml><Placemark><Polygon></Polygon></Placemark></kml>`,
+	}
+	for _, document := range tests {
+		reader, _ := NewKMLReader(strings.NewReader(document))
+		_, err := readAll(reader)
+		if _, ok := err.(*ErrMalformedFeature); !ok {
+			t.Errorf("%s: got %v", document, err)
+		}
+	}
+}
+
+// zipKML writes an archive of KML documents
+func zipKML(t *testing.T, filename string, names ...string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w := zip.NewWriter(file)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		document := kmlDocument
+		if name != "doc.kml" {
+			document = `<kml><Placemark><name>` + name + `</name></Placemark></kml>`
+		}
+		f.Write([]byte(document))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKMZReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xgeo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "doc.kmz")
+	zipKML(t, filename, "files/overlay.kml", "__MACOSX/._doc.kml", "doc.kml")
+	if !IsKMZ(filename) {
+		t.Fatal("not a KMZ archive")
+	}
+	reader, err := NewKMZReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.FolderProperty = "folder"
+	read, err := readAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFeatures(t, "doc.kml", read, kmlFeatures)
+
+	// Without a doc.kml, the first KML file is read
+	filename = filepath.Join(dir, "other.kmz")
+	zipKML(t, filename, "__MACOSX/._first.kml", "first.kml", "second.kml")
+	reader, err = NewKMZReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err = readAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFeatures(t, "first.kml", read, []*Feature{{Properties: Properties{{"name", "first.kml"}}}})
+
+	filename = filepath.Join(dir, "empty.zip")
+	zipKML(t, filename, "readme.txt")
+	if IsKMZ(filename) {
+		t.Error("an archive without KML is a KMZ archive")
+	}
+	if _, err := NewKMZReader(filename); err == nil {
+		t.Error("opened an archive without KML")
+	}
+}
+
+func TestKMLWriterFolders(t *testing.T) {
+	var features []*Feature
+	for _, folder := range []interface{}{"Sites/North", "Sites/North", "Sites/South", nil, "Other", "/Other/"} {
+		feature := NewFeature(orb.Point{1, 2})
+		feature.Properties.Set("folder", folder)
+		features = append(features, feature)
+	}
+	var buf bytes.Buffer
+	writer, _ := NewKMLWriter(&buf)
+	writer.FolderProperty = "folder"
+	if err := writeAll(writer, features); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, "Folder") || strings.HasPrefix(line, "<Placemark") {
+			got = append(got, line)
+		}
+	}
+	want := []string{
+		"<Folder><name>Sites</name>",
+		"<Folder><name>North</name>",
+		"<Placemark>",
+		"<Placemark>",
+		"</Folder>",
+		"<Folder><name>South</name>",
+		"<Placemark>",
+		"</Folder>",
+		"</Folder>",
+		"<Placemark>",
+		"<Folder><name>Other</name>",
+		"<Placemark>",
+		"<Placemark>",
+		"</Folder>",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if strings.Contains(buf.String(), "<ExtendedData>") {
+		t.Error("folders written as extended data")
+	}
+}
+
+func TestKMLWriterRoundTrip(t *testing.T) {
+	// KMLReader reads extended data as strings
+	features := withoutProperty(kmlFeatures, "")
+	hill := *features[1]
+	hill.Properties = Properties{
+		{"name", "Hill"},
+		{"description", "A <b>hill</b>"},
+		{"note", "windy"},
+		{"visitors", "12"},
+		{"folder", "Sites/North"},
+	}
+	features[1] = &hill
+	// Unnamed Folders are not written
+	park := *features[3]
+	park.Properties = Properties{{"name", "Park"}}
+	features[3] = &park
+
+	dir, err := ioutil.TempDir("", "xgeo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, kmz := range []bool{false, true} {
+		filename := filepath.Join(dir, "out.kml")
+		newWriter, name := NewKMLWriter, "KML"
+		if kmz {
+			filename = filepath.Join(dir, "out.kmz")
+			newWriter, name = NewKMZWriter, "KMZ"
+		}
+		file, err := os.Create(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer, _ := newWriter(file)
+		writer.FolderProperty = "folder"
+		if err := writeAll(writer, features); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		var reader *KMLReader
+		if kmz {
+			reader, err = NewKMZReader(filename)
+		} else {
+			file, err = os.Open(filename)
+			if err == nil {
+				defer file.Close()
+				reader, err = NewKMLReader(file)
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader.FolderProperty = "folder"
+		read, err := readAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareFeatures(t, name, read, features)
+	}
+}
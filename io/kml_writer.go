@@ -0,0 +1,338 @@
+package io
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"github.com/paulmach/orb"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const kmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+<Document>
+`
+
+// kmlStyleProperties are the simplestyle-spec properties that KMLWriter turns
+// into Styles
+var kmlStyleProperties = []string{
+	"marker-color", "marker-size", "stroke", "stroke-opacity", "stroke-width", "fill", "fill-opacity",
+}
+
+// KMLWriter writes features as the Placemarks of a KML document, or of the
+// doc.kml file of a KMZ archive
+type KMLWriter struct {
+	output io.Writer
+	kmz    bool
+
+	// NameProperty and DescriptionProperty are the properties written as the
+	// name and description of each Placemark rather than as extended data
+	NameProperty        string
+	DescriptionProperty string
+
+	// FolderProperty, when set, is a property holding a path of Folders
+	// separated by slashes, as read by KMLReader. Consecutive features with
+	// the same path are written to the same Folders.
+	FolderProperty string
+
+	// Style, when set, turns the marker-color, marker-size, stroke,
+	// stroke-opacity, stroke-width, fill and fill-opacity properties of
+	// features, as used by GeoJSON viewers, into an inline Style
+	Style bool
+}
+
+func NewKMLWriter(output io.Writer) (*KMLWriter, error) {
+	return &KMLWriter{
+		output:              output,
+		NameProperty:        "name",
+		DescriptionProperty: "description",
+	}, nil
+}
+
+// NewKMZWriter returns a writer of a KMZ archive, whose doc.kml is written
+// like NewKMLWriter's output
+func NewKMZWriter(output io.Writer) (*KMLWriter, error) {
+	writer, err := NewKMLWriter(output)
+	if err != nil {
+		return nil, err
+	}
+	writer.kmz = true
+	return writer, nil
+}
+
+func (k *KMLWriter) Write(in chan *Feature) error {
+	output := k.output
+	var archive *zip.Writer
+	if k.kmz {
+		archive = zip.NewWriter(output)
+		doc, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     "doc.kml",
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		output = doc
+	}
+	w := bufio.NewWriter(output)
+	w.WriteString(kmlHeader)
+	var folders []string
+	for feature := range in {
+		if feature == nil {
+			continue
+		}
+		if k.FolderProperty != "" {
+			folders = k.openFolders(w, folders, feature)
+		}
+		if err := k.writePlacemark(w, feature); err != nil {
+			return err
+		}
+	}
+	for range folders {
+		w.WriteString("</Folder>\n")
+	}
+	w.WriteString("</Document>\n</kml>\n")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if archive != nil {
+		return archive.Close()
+	}
+	return nil
+}
+
+// openFolders closes the open Folders that a feature is not in and opens the
+// ones it is in, returning the new path of open Folders
+func (k *KMLWriter) openFolders(w *bufio.Writer, open []string, feature *Feature) []string {
+	var folders []string
+	if value, ok := feature.Properties.Get(k.FolderProperty); ok && value != nil {
+		for _, name := range strings.Split(stringValue(value), "/") {
+			if name != "" {
+				folders = append(folders, name)
+			}
+		}
+	}
+	common := 0
+	for common < len(open) && common < len(folders) && open[common] == folders[common] {
+		common++
+	}
+	for range open[common:] {
+		w.WriteString("</Folder>\n")
+	}
+	for _, name := range folders[common:] {
+		w.WriteString("<Folder><name>")
+		xml.EscapeText(w, []byte(name))
+		w.WriteString("</name>\n")
+	}
+	return folders
+}
+
+func (k *KMLWriter) writePlacemark(w *bufio.Writer, feature *Feature) error {
+	w.WriteString("<Placemark")
+	if feature.ID != nil {
+		w.WriteString(` id="`)
+		xml.EscapeText(w, []byte(stringValue(feature.ID)))
+		w.WriteString(`"`)
+	}
+	w.WriteString(">\n")
+	for _, element := range []struct{ name, property string }{
+		{"name", k.NameProperty},
+		{"description", k.DescriptionProperty},
+	} {
+		if value, _ := feature.Properties.Get(element.property); element.property != "" && value != nil {
+			fmt.Fprintf(w, "  <%s>", element.name)
+			xml.EscapeText(w, []byte(stringValue(value)))
+			fmt.Fprintf(w, "</%s>\n", element.name)
+		}
+	}
+	if k.Style {
+		writeKMLStyle(w, feature.Properties)
+	}
+	data := false
+	for _, prop := range feature.Properties {
+		if prop.Value == nil || k.isElementProperty(prop.Key) {
+			continue
+		}
+		if !data {
+			w.WriteString("  <ExtendedData>\n")
+			data = true
+		}
+		w.WriteString(`    <Data name="`)
+		xml.EscapeText(w, []byte(prop.Key))
+		w.WriteString(`"><value>`)
+		xml.EscapeText(w, []byte(stringValue(prop.Value)))
+		w.WriteString("</value></Data>\n")
+	}
+	if data {
+		w.WriteString("  </ExtendedData>\n")
+	}
+	if feature.Geometry != nil {
+		g := &kmlGeometryWriter{w: w}
+		if feature.HasZ() {
+			g.z = feature.Z
+		}
+		g.geometry(feature.Geometry)
+	}
+	_, err := w.WriteString("</Placemark>\n")
+	return err
+}
+
+// isElementProperty tells whether a property is written as an element of a
+// Placemark rather than as extended data
+func (k *KMLWriter) isElementProperty(key string) bool {
+	if key == k.NameProperty || key == k.DescriptionProperty || key == k.FolderProperty {
+		return true
+	}
+	if k.Style {
+		for _, name := range kmlStyleProperties {
+			if key == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeKMLStyle writes the Style described by simplestyle-spec properties
+func writeKMLStyle(w *bufio.Writer, properties Properties) {
+	var styles []string
+	if color, ok := kmlColor(properties, "marker-color", "", 1); ok {
+		scale := 1.0
+		if size, _ := properties.Get("marker-size"); size == "small" {
+			scale = 0.8
+		} else if size == "large" {
+			scale = 1.2
+		}
+		styles = append(styles, fmt.Sprintf("<IconStyle><color>%s</color><scale>%s</scale></IconStyle>", color, formatFloat(scale)))
+	}
+	stroke, hasStroke := kmlColor(properties, "stroke", "stroke-opacity", 1)
+	width, hasWidth := properties.Get("stroke-width")
+	if hasStroke || hasWidth {
+		line := "<LineStyle>"
+		if hasStroke {
+			line += "<color>" + stroke + "</color>"
+		}
+		if f, err := strconv.ParseFloat(stringValue(width), 64); hasWidth && err == nil {
+			line += "<width>" + formatFloat(f) + "</width>"
+		}
+		styles = append(styles, line+"</LineStyle>")
+	}
+	if fill, ok := kmlColor(properties, "fill", "fill-opacity", 0.6); ok {
+		styles = append(styles, "<PolyStyle><color>"+fill+"</color></PolyStyle>")
+	}
+	if len(styles) > 0 {
+		w.WriteString("  <Style>" + strings.Join(styles, "") + "</Style>\n")
+	}
+}
+
+// kmlColor converts a CSS hex color property, and an optional opacity
+// property, into a KML color, which is written aabbggrr. Like simplestyle-spec,
+// fills are 60% opaque by default.
+func kmlColor(properties Properties, colorProperty, opacityProperty string, opacity float64) (string, bool) {
+	value, _ := properties.Get(colorProperty)
+	color, ok := value.(string)
+	if !ok {
+		return "", false
+	}
+	color = strings.TrimPrefix(strings.TrimSpace(color), "#")
+	if len(color) == 3 {
+		color = string([]byte{color[0], color[0], color[1], color[1], color[2], color[2]})
+	}
+	if len(color) != 6 {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(color, 16, 32); err != nil {
+		return "", false
+	}
+	if value, ok := properties.Get(opacityProperty); ok && opacityProperty != "" {
+		if f, err := strconv.ParseFloat(stringValue(value), 64); err == nil && f >= 0 && f <= 1 {
+			opacity = f
+		}
+	}
+	alpha := int(opacity*255 + 0.5)
+	return strings.ToLower(fmt.Sprintf("%02x%s%s%s", alpha, color[4:6], color[2:4], color[0:2])), true
+}
+
+// kmlGeometryWriter writes the Z ordinates of the positions of a geometry,
+// in traversal order, as their altitude
+type kmlGeometryWriter struct {
+	w     *bufio.Writer
+	z     []float64
+	index int
+}
+
+func (g *kmlGeometryWriter) geometry(geometry orb.Geometry) {
+	switch geometry := geometry.(type) {
+	case orb.Point:
+		g.w.WriteString("  <Point>")
+		g.coordinates([]orb.Point{geometry})
+		g.w.WriteString("</Point>\n")
+	case orb.LineString:
+		g.w.WriteString("  <LineString>")
+		g.coordinates(geometry)
+		g.w.WriteString("</LineString>\n")
+	case orb.Ring:
+		g.geometry(orb.Polygon{geometry})
+	case orb.Polygon:
+		g.w.WriteString("  <Polygon>")
+		for i, ring := range geometry {
+			boundary := "innerBoundaryIs"
+			if i == 0 {
+				boundary = "outerBoundaryIs"
+			}
+			fmt.Fprintf(g.w, "<%s><LinearRing>", boundary)
+			g.coordinates(ring)
+			fmt.Fprintf(g.w, "</LinearRing></%s>", boundary)
+		}
+		g.w.WriteString("</Polygon>\n")
+	case orb.Bound:
+		g.geometry(geometry.ToPolygon())
+	case orb.MultiPoint:
+		g.w.WriteString("  <MultiGeometry>\n")
+		for _, point := range geometry {
+			g.geometry(point)
+		}
+		g.w.WriteString("  </MultiGeometry>\n")
+	case orb.MultiLineString:
+		g.w.WriteString("  <MultiGeometry>\n")
+		for _, line := range geometry {
+			g.geometry(line)
+		}
+		g.w.WriteString("  </MultiGeometry>\n")
+	case orb.MultiPolygon:
+		g.w.WriteString("  <MultiGeometry>\n")
+		for _, polygon := range geometry {
+			g.geometry(polygon)
+		}
+		g.w.WriteString("  </MultiGeometry>\n")
+	case orb.Collection:
+		g.w.WriteString("  <MultiGeometry>\n")
+		for _, item := range geometry {
+			g.geometry(item)
+		}
+		g.w.WriteString("  </MultiGeometry>\n")
+	}
+}
+
+func (g *kmlGeometryWriter) coordinates(points []orb.Point) {
+	g.w.WriteString("<coordinates>")
+	for i, p := range points {
+		if i > 0 {
+			g.w.WriteByte(' ')
+		}
+		g.w.WriteString(formatFloat(p[0]))
+		g.w.WriteByte(',')
+		g.w.WriteString(formatFloat(p[1]))
+		if g.z != nil {
+			g.w.WriteByte(',')
+			g.w.WriteString(formatFloat(g.z[g.index]))
+		}
+		g.index++
+	}
+	g.w.WriteString("</coordinates>")
+}
@@ -0,0 +1,356 @@
+package io
+
+import (
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/project"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseProjection parses the OGC or ESRI WKT of a coordinate system, as found
+// in .prj files, and returns a projection of its coordinates to WGS84
+// longitude and latitude. The returned projection is nil if the coordinates
+// are already in degrees. Datum shifts are not applied, which is accurate to a
+// few meters for NAD83, ETRS89 and other datums aligned with WGS84.
+func ParseProjection(wkt string) (orb.Projection, error) {
+	projection, _, err := parseProjection(wkt)
+	return projection, err
+}
+
+// parseProjection is ParseProjection, and also returns the name of the datum
+// of the coordinate system, or an empty string if it names none
+func parseProjection(wkt string) (orb.Projection, string, error) {
+	p := &wktParser{input: wkt}
+	root, err := p.parse()
+	if err != nil {
+		return nil, "", err
+	}
+	geogcs := root
+	if root.name == "PROJCS" {
+		geogcs = root.child("GEOGCS")
+	}
+	var datum string
+	if geogcs != nil && geogcs.child("DATUM") != nil {
+		datum = geogcs.child("DATUM").text(0)
+	}
+	var projection orb.Projection
+	switch root.name {
+	case "GEOGCS":
+		projection, err = geographicProjection(root)
+	case "PROJCS":
+		projection, err = projectedProjection(root)
+	default:
+		err = fmt.Errorf("unsupported coordinate system %s", root.name)
+	}
+	return projection, datum, err
+}
+
+// alignedDatum tells whether a datum is within a few meters of WGS84, so that
+// leaving out the datum shift is harmless
+func alignedDatum(name string) bool {
+	key := normalizeParameterName(name)
+	for _, aligned := range []string{"wgs84", "wgs1984", "nad83", "northamerican1983", "etrs89", "etrs1989", "europeanterrestrialreferencesystem1989"} {
+		if strings.Contains(key, aligned) {
+			return true
+		}
+	}
+	return false
+}
+
+// wktNode is a keyword with its bracketed values, which are strings, numbers
+// or other nodes
+type wktNode struct {
+	name   string
+	values []interface{}
+}
+
+func (n *wktNode) child(name string) *wktNode {
+	for _, v := range n.values {
+		if c, ok := v.(*wktNode); ok && c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *wktNode) number(i int) (float64, bool) {
+	if i >= len(n.values) {
+		return 0, false
+	}
+	f, ok := n.values[i].(float64)
+	return f, ok
+}
+
+func (n *wktNode) text(i int) string {
+	if i >= len(n.values) {
+		return ""
+	}
+	s, _ := n.values[i].(string)
+	return s
+}
+
+type wktParser struct {
+	input string
+	pos   int
+}
+
+func (p *wktParser) parse() (*wktNode, error) {
+	node, err := p.parseNode()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+	return node, nil
+}
+
+func (p *wktParser) parseNode() (*wktNode, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && isWKTLetter(p.input[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, p.errorf("keyword expected")
+	}
+	node := &wktNode{name: strings.ToUpper(p.input[start:p.pos])}
+	p.skipSpace()
+	if p.pos >= len(p.input) || p.input[p.pos] != '[' && p.input[p.pos] != '(' {
+		// Bare keywords such as the axis directions NORTH or EAST
+		return node, nil
+	}
+	closing := byte(']')
+	if p.input[p.pos] == '(' {
+		closing = ')'
+	}
+	p.pos++
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return nil, p.errorf("unterminated %s", node.name)
+		}
+		c := p.input[p.pos]
+		switch {
+		case c == '"':
+			end := strings.IndexByte(p.input[p.pos+1:], '"')
+			if end < 0 {
+				return nil, p.errorf("unterminated string")
+			}
+			node.values = append(node.values, p.input[p.pos+1:p.pos+1+end])
+			p.pos += end + 2
+		case c == '-' || c == '+' || c == '.' || c >= '0' && c <= '9':
+			start := p.pos
+			for p.pos < len(p.input) && strings.IndexByte("+-.eE0123456789", p.input[p.pos]) >= 0 {
+				p.pos++
+			}
+			f, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+			if err != nil {
+				return nil, p.errorf("invalid number %q", p.input[start:p.pos])
+			}
+			node.values = append(node.values, f)
+		default:
+			child, err := p.parseNode()
+			if err != nil {
+				return nil, err
+			}
+			node.values = append(node.values, child)
+		}
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return nil, p.errorf("unterminated %s", node.name)
+		}
+		if p.input[p.pos] == closing {
+			p.pos++
+			return node, nil
+		}
+		if p.input[p.pos] != ',' {
+			return nil, p.errorf("unexpected %q in %s", p.input[p.pos], node.name)
+		}
+		p.pos++
+	}
+}
+
+func (p *wktParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *wktParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid WKT at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func isWKTLetter(c byte) bool {
+	return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+// geographicUnits returns the size of the angular unit of a GEOGCS in radians
+// and its prime meridian in that unit
+func geographicUnits(geogcs *wktNode) (float64, float64) {
+	unit := math.Pi / 180
+	if u := geogcs.child("UNIT"); u != nil {
+		if f, ok := u.number(1); ok && f > 0 {
+			unit = f
+		}
+	}
+	var primem float64
+	if pm := geogcs.child("PRIMEM"); pm != nil {
+		primem, _ = pm.number(1)
+	}
+	return unit, primem
+}
+
+func geographicProjection(geogcs *wktNode) (orb.Projection, error) {
+	unit, primem := geographicUnits(geogcs)
+	if math.Abs(unit-math.Pi/180) < 1e-12 && primem == 0 {
+		return nil, nil
+	}
+	return func(p orb.Point) orb.Point {
+		return orb.Point{
+			rad2deg((p[0] + primem) * unit),
+			rad2deg(p[1] * unit),
+		}
+	}, nil
+}
+
+func projectedProjection(projcs *wktNode) (orb.Projection, error) {
+	geogcs := projcs.child("GEOGCS")
+	if geogcs == nil {
+		return nil, fmt.Errorf("PROJCS %q has no GEOGCS", projcs.text(0))
+	}
+	angularUnit, primem := geographicUnits(geogcs)
+	linearUnit := 1.0
+	if u := projcs.child("UNIT"); u != nil {
+		if f, ok := u.number(1); ok && f > 0 {
+			linearUnit = f
+		}
+	}
+
+	e := ellipsoid{a: 6378137, f: 1 / 298.257223563}
+	if datum := geogcs.child("DATUM"); datum != nil {
+		if spheroid := datum.child("SPHEROID"); spheroid != nil {
+			a, _ := spheroid.number(1)
+			invf, _ := spheroid.number(2)
+			if a <= 0 {
+				return nil, fmt.Errorf("invalid SPHEROID %q", spheroid.text(0))
+			}
+			e = ellipsoid{a: a}
+			if invf != 0 {
+				e.f = 1 / invf
+			}
+		}
+	}
+
+	params := projectionParameters{}
+	for _, v := range projcs.values {
+		if n, ok := v.(*wktNode); ok && n.name == "PARAMETER" {
+			value, _ := n.number(1)
+			params[normalizeParameterName(n.text(0))] = value
+		}
+	}
+	angle := func(name string) float64 {
+		return params[name] * angularUnit
+	}
+	lon0 := angle("centralmeridian") + primem*angularUnit
+	fe := params["falseeasting"] * linearUnit
+	fn := params["falsenorthing"] * linearUnit
+	k0, ok := params["scalefactor"]
+	if !ok {
+		k0 = 1
+	}
+
+	var inverse func(x, y float64) (lon, lat float64)
+	method := projcs.child("PROJECTION")
+	if method == nil {
+		return nil, fmt.Errorf("PROJCS %q has no PROJECTION", projcs.text(0))
+	}
+	switch normalizeParameterName(method.text(0)) {
+	case "mercatorauxiliarysphere", "popularvisualisationpseudomercator":
+		if fe == 0 && fn == 0 && lon0 == 0 && e.a == orb.EarthRadius {
+			return scaleProjection(project.Mercator.ToWGS84, linearUnit), nil
+		}
+		sphere := ellipsoid{a: e.a}
+		inverse = sphere.mercator(lon0, 1, fe, fn)
+	case "mercator", "mercator1sp":
+		inverse = e.mercator(lon0, k0, fe, fn)
+	case "mercator2sp":
+		inverse = e.mercator(lon0, e.m(angle("standardparallel1")), fe, fn)
+	case "transversemercator", "gausskruger":
+		inverse = e.transverseMercator(lon0, angle("latitudeoforigin"), k0, fe, fn)
+	case "lambertconformalconic", "lambertconformalconic1sp", "lambertconformalconic2sp":
+		lat0 := angle("latitudeoforigin")
+		lat1, lat2 := lat0, lat0
+		if _, ok := params["standardparallel1"]; ok {
+			lat1 = angle("standardparallel1")
+			lat2 = lat1
+		}
+		if _, ok := params["standardparallel2"]; ok {
+			lat2 = angle("standardparallel2")
+		}
+		inverse = e.lambertConformalConic(lon0, lat0, lat1, lat2, k0, fe, fn)
+	default:
+		return nil, fmt.Errorf("unsupported projection %s", method.text(0))
+	}
+	return func(p orb.Point) orb.Point {
+		lon, lat := inverse(p[0]*linearUnit, p[1]*linearUnit)
+		return orb.Point{rad2deg(normalizeLongitude(lon)), rad2deg(lat)}
+	}, nil
+}
+
+type projectionParameters map[string]float64
+
+// normalizeParameterName maps the OGC, ESRI and EPSG spellings of projection
+// names and parameters to a common lowercase form
+func normalizeParameterName(name string) string {
+	key := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+	switch key {
+	case "latitudeofcenter", "latitudeofnaturalorigin", "latitudeoffalseorigin":
+		return "latitudeoforigin"
+	case "longitudeofcenter", "longitudeofnaturalorigin", "longitudeoffalseorigin", "longitudeoforigin":
+		return "centralmeridian"
+	case "scalefactoratnaturalorigin":
+		return "scalefactor"
+	case "eastingatfalseorigin":
+		return "falseeasting"
+	case "northingatfalseorigin":
+		return "falsenorthing"
+	case "latitudeof1ststandardparallel":
+		return "standardparallel1"
+	case "latitudeof2ndstandardparallel":
+		return "standardparallel2"
+	}
+	return key
+}
+
+func scaleProjection(proj orb.Projection, unit float64) orb.Projection {
+	if unit == 1 {
+		return proj
+	}
+	return func(p orb.Point) orb.Point {
+		return proj(orb.Point{p[0] * unit, p[1] * unit})
+	}
+}
+
+func normalizeLongitude(lon float64) float64 {
+	for lon > math.Pi {
+		lon -= 2 * math.Pi
+	}
+	for lon < -math.Pi {
+		lon += 2 * math.Pi
+	}
+	return lon
+}
+
+func rad2deg(r float64) float64 {
+	return r * 180 / math.Pi
+}
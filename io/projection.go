@@ -0,0 +1,120 @@
+package io
+
+import (
+	"math"
+)
+
+// ellipsoid holds the semi-major axis in meters and the flattening of the
+// earth model used by a projection. The inverse projections below follow
+// Snyder, "Map Projections: A Working Manual" (USGS Professional Paper 1395),
+// and return longitude and latitude in radians.
+type ellipsoid struct {
+	a, f float64
+}
+
+// eccentricity returns the first eccentricity and its square
+func (e ellipsoid) eccentricity() (float64, float64) {
+	e2 := e.f * (2 - e.f)
+	return math.Sqrt(e2), e2
+}
+
+// m computes Snyder's equation 14-15 for a latitude
+func (e ellipsoid) m(lat float64) float64 {
+	_, e2 := e.eccentricity()
+	sin := math.Sin(lat)
+	return math.Cos(lat) / math.Sqrt(1-e2*sin*sin)
+}
+
+// t computes Snyder's equation 15-9 for a latitude
+func (e ellipsoid) t(lat float64) float64 {
+	ecc, _ := e.eccentricity()
+	sin := math.Sin(lat)
+	return math.Tan(math.Pi/4-lat/2) / math.Pow((1-ecc*sin)/(1+ecc*sin), ecc/2)
+}
+
+// latitude inverts t by iterating Snyder's equation 7-9
+func (e ellipsoid) latitude(t float64) float64 {
+	ecc, _ := e.eccentricity()
+	lat := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 15; i++ {
+		sin := math.Sin(lat)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-ecc*sin)/(1+ecc*sin), ecc/2))
+		if math.Abs(next-lat) < 1e-12 {
+			return next
+		}
+		lat = next
+	}
+	return lat
+}
+
+func (e ellipsoid) mercator(lon0, k0, fe, fn float64) func(x, y float64) (float64, float64) {
+	return func(x, y float64) (float64, float64) {
+		lat := e.latitude(math.Exp(-(y - fn) / (e.a * k0)))
+		lon := lon0 + (x-fe)/(e.a*k0)
+		return lon, lat
+	}
+}
+
+// meridianDistance computes Snyder's equation 3-21, the distance along the
+// meridian from the equator to a latitude
+func (e ellipsoid) meridianDistance(lat float64) float64 {
+	_, e2 := e.eccentricity()
+	e4, e6 := e2*e2, e2*e2*e2
+	return e.a * ((1-e2/4-3*e4/64-5*e6/256)*lat -
+		(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*lat) +
+		(15*e4/256+45*e6/1024)*math.Sin(4*lat) -
+		(35*e6/3072)*math.Sin(6*lat))
+}
+
+func (e ellipsoid) transverseMercator(lon0, lat0, k0, fe, fn float64) func(x, y float64) (float64, float64) {
+	_, e2 := e.eccentricity()
+	e4, e6 := e2*e2, e2*e2*e2
+	ep2 := e2 / (1 - e2)
+	m0 := e.meridianDistance(lat0)
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+	return func(x, y float64) (float64, float64) {
+		mu := (m0 + (y-fn)/k0) / (e.a * (1 - e2/4 - 3*e4/64 - 5*e6/256))
+		lat1 := mu +
+			(3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+			(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+			(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+			(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+		sin, cos, tan := math.Sin(lat1), math.Cos(lat1), math.Tan(lat1)
+		c1 := ep2 * cos * cos
+		t1 := tan * tan
+		n1 := e.a / math.Sqrt(1-e2*sin*sin)
+		r1 := e.a * (1 - e2) / math.Pow(1-e2*sin*sin, 1.5)
+		d := (x - fe) / (n1 * k0)
+		lat := lat1 - (n1*tan/r1)*(d*d/2-
+			(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+			(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+		lon := lon0 + (d-
+			(1+2*t1+c1)*math.Pow(d, 3)/6+
+			(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120)/cos
+		return lon, lat
+	}
+}
+
+func (e ellipsoid) lambertConformalConic(lon0, lat0, lat1, lat2, k0, fe, fn float64) func(x, y float64) (float64, float64) {
+	m1, t1 := e.m(lat1), e.t(lat1)
+	n := math.Sin(lat1)
+	if lat1 != lat2 {
+		n = (math.Log(m1) - math.Log(e.m(lat2))) / (math.Log(t1) - math.Log(e.t(lat2)))
+	}
+	f := m1 / (n * math.Pow(t1, n))
+	rho0 := e.a * f * k0 * math.Pow(e.t(lat0), n)
+	sign := 1.0
+	if n < 0 {
+		sign = -1
+	}
+	return func(x, y float64) (float64, float64) {
+		dx, dy := x-fe, rho0-(y-fn)
+		rho := sign * math.Hypot(dx, dy)
+		theta := math.Atan2(sign*dx, sign*dy)
+		if rho == 0 {
+			return lon0, sign * math.Pi / 2
+		}
+		lat := e.latitude(math.Pow(rho/(e.a*f*k0), 1/n))
+		return theta/n + lon0, lat
+	}
+}
@@ -0,0 +1,124 @@
+package io
+
+import (
+	"github.com/paulmach/orb"
+	"math"
+	"testing"
+)
+
+// Projected coordinates of known points. The EPSG examples come from IOGP
+// Guidance Note 7-2, and the others were computed with the Krüger series of
+// the transverse Mercator and the closed form of the Lambert conformal conic.
+var projectionTests = []struct {
+	name     string
+	wkt      string
+	point    orb.Point
+	lon, lat float64
+}{
+	{
+		"web mercator auxiliary sphere",
+		`PROJCS["WGS_1984_Web_Mercator_Auxiliary_Sphere",GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],PROJECTION["Mercator_Auxiliary_Sphere"],PARAMETER["False_Easting",0.0],PARAMETER["False_Northing",0.0],PARAMETER["Central_Meridian",0.0],PARAMETER["Standard_Parallel_1",0.0],PARAMETER["Auxiliary_Sphere_Type",0.0],UNIT["Meter",1.0]]`,
+		orb.Point{-11169055.58, 2800000.00}, -100.333333333, 24.381786944,
+	},
+	{
+		"popular visualisation pseudo mercator",
+		`PROJCS["WGS 84 / Pseudo-Mercator",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],PROJECTION["Popular Visualisation Pseudo Mercator"],PARAMETER["central_meridian",0],PARAMETER["false_easting",0],PARAMETER["false_northing",0],UNIT["metre",1]]`,
+		orb.Point{1113194.9079327357, 1118889.9748579594}, 10, 10,
+	},
+	{
+		"mercator 1SP",
+		`PROJCS["Batavia / NEIEZ",GEOGCS["Batavia",DATUM["Batavia",SPHEROID["Bessel 1841",6377397.155,299.1528128]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],PROJECTION["Mercator_1SP"],PARAMETER["central_meridian",110],PARAMETER["scale_factor",0.997],PARAMETER["false_easting",3900000],PARAMETER["false_northing",900000],UNIT["metre",1]]`,
+		orb.Point{5009726.58, 569150.82}, 120, -3,
+	},
+	{
+		"UTM on the central meridian",
+		`PROJCS["WGS 84 / UTM zone 33N",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],PROJECTION["Transverse_Mercator"],PARAMETER["latitude_of_origin",0],PARAMETER["central_meridian",15],PARAMETER["scale_factor",0.9996],PARAMETER["false_easting",500000],PARAMETER["false_northing",0],UNIT["metre",1]]`,
+		orb.Point{500000, 4982950.400}, 15, 45,
+	},
+	{
+		"UTM",
+		`PROJCS["NAD_1983_UTM_Zone_18N",GEOGCS["GCS_North_American_1983",DATUM["D_North_American_1983",SPHEROID["GRS_1980",6378137.0,298.257222101]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],PROJECTION["Transverse_Mercator"],PARAMETER["False_Easting",500000.0],PARAMETER["False_Northing",0.0],PARAMETER["Central_Meridian",-75.0],PARAMETER["Scale_Factor",0.9996],PARAMETER["Latitude_Of_Origin",0.0],UNIT["Meter",1.0]]`,
+		orb.Point{585628.409, 4511322.447}, -73.9857, 40.7484,
+	},
+	{
+		"transverse mercator",
+		`PROJCS["OSGB 1936 / British National Grid",GEOGCS["OSGB 1936",DATUM["OSGB_1936",SPHEROID["Airy 1830",6377563.396,299.3249646]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],PROJECTION["Transverse_Mercator"],PARAMETER["latitude_of_origin",49],PARAMETER["central_meridian",-2],PARAMETER["scale_factor",0.9996012717],PARAMETER["false_easting",400000],PARAMETER["false_northing",-100000],UNIT["metre",1]]`,
+		orb.Point{577274.99, 69740.50}, 0.5, 50.5,
+	},
+	{
+		"state plane transverse mercator",
+		`PROJCS["NAD_1983_StatePlane_New_Jersey_FIPS_2900_Feet",GEOGCS["GCS_North_American_1983",DATUM["D_North_American_1983",SPHEROID["GRS_1980",6378137.0,298.257222101]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],PROJECTION["Transverse_Mercator"],PARAMETER["False_Easting",492125.0],PARAMETER["False_Northing",0.0],PARAMETER["Central_Meridian",-74.5],PARAMETER["Scale_Factor",0.9999],PARAMETER["Latitude_Of_Origin",38.83333333333334],UNIT["Foot_US",0.3048006096012192]]`,
+		orb.Point{634613.540, 697954.777}, -73.9857, 40.7484,
+	},
+	{
+		"state plane lambert conformal conic",
+		`PROJCS["NAD_1983_StatePlane_Pennsylvania_South_FIPS_3702_Feet",GEOGCS["GCS_North_American_1983",DATUM["D_North_American_1983",SPHEROID["GRS_1980",6378137.0,298.257222101]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],PROJECTION["Lambert_Conformal_Conic"],PARAMETER["False_Easting",1968500.0],PARAMETER["False_Northing",0.0],PARAMETER["Central_Meridian",-77.75],PARAMETER["Standard_Parallel_1",39.93333333333333],PARAMETER["Standard_Parallel_2",40.96666666666667],PARAMETER["Latitude_Of_Origin",39.33333333333334],UNIT["Foot_US",0.3048006096012192]]`,
+		orb.Point{2693060.217, 236194.916}, -75.1652, 39.9526,
+	},
+	{
+		"lambert conformal conic 2SP",
+		`PROJCS["NAD27 / Texas South Central",GEOGCS["NAD27",DATUM["North_American_Datum_1927",SPHEROID["Clarke 1866",6378206.4,294.9786982138982]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],PROJECTION["Lambert_Conformal_Conic_2SP"],PARAMETER["standard_parallel_1",28.38333333333333],PARAMETER["standard_parallel_2",30.28333333333333],PARAMETER["latitude_of_origin",27.83333333333333],PARAMETER["central_meridian",-99],PARAMETER["false_easting",2000000],PARAMETER["false_northing",0],UNIT["US survey foot",0.3048006096012192]]`,
+		orb.Point{2963503.91, 254759.80}, -96, 28.5,
+	},
+	{
+		"geographic in grads",
+		`GEOGCS["NTF (Paris)",DATUM["Nouvelle_Triangulation_Francaise_Paris",SPHEROID["Clarke 1880 (IGN)",6378249.2,293.4660212936269]],PRIMEM["Paris",2.5969213],UNIT["grad",0.01570796326794897]]`,
+		orb.Point{0, 50}, 2.33722917, 45,
+	},
+}
+
+func projectionWKT(name string) string {
+	for _, test := range projectionTests {
+		if test.name == name {
+			return test.wkt
+		}
+	}
+	panic(name)
+}
+
+func TestParseProjection(t *testing.T) {
+	// About a centimeter
+	const tolerance = 1e-7
+	for _, test := range projectionTests {
+		t.Run(test.name, func(t *testing.T) {
+			projection, err := ParseProjection(test.wkt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := projection(test.point)
+			if math.Abs(p[0]-test.lon) > tolerance || math.Abs(p[1]-test.lat) > tolerance {
+				t.Errorf("got %.9f, %.9f, want %.9f, %.9f", p[0], p[1], test.lon, test.lat)
+			}
+		})
+	}
+}
+
+func TestProjectionDatum(t *testing.T) {
+	tests := []struct {
+		wkt     string
+		datum   string
+		aligned bool
+	}{
+		{projectionWKT("UTM"), "D_North_American_1983", true},
+		{projectionWKT("UTM on the central meridian"), "WGS_1984", true},
+		{projectionWKT("lambert conformal conic 2SP"), "North_American_Datum_1927", false},
+		{`GEOGCS["GCS_ETRS_1989",DATUM["D_ETRS_1989",SPHEROID["GRS_1980",6378137.0,298.257222101]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`, "D_ETRS_1989", true},
+		{`GEOGCS["WGS 72",DATUM["WGS_1972",SPHEROID["WGS 72",6378135,298.26]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]]`, "WGS_1972", false},
+	}
+	for _, test := range tests {
+		_, datum, err := parseProjection(test.wkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if datum != test.datum || alignedDatum(datum) != test.aligned {
+			t.Errorf("got datum %s, aligned %v, want %s, %v", datum, alignedDatum(datum), test.datum, test.aligned)
+		}
+	}
+}
+
+func TestUnsupportedProjection(t *testing.T) {
+	albers := `PROJCS["USA_Contiguous_Albers_Equal_Area_Conic",GEOGCS["GCS_North_American_1983",DATUM["D_North_American_1983",SPHEROID["GRS_1980",6378137.0,298.257222101]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],PROJECTION["Albers"],PARAMETER["False_Easting",0.0],PARAMETER["False_Northing",0.0],PARAMETER["Central_Meridian",-96.0],PARAMETER["Standard_Parallel_1",29.5],PARAMETER["Standard_Parallel_2",45.5],PARAMETER["Latitude_Of_Origin",37.5],UNIT["Meter",1.0]]`
+	if _, err := ParseProjection(albers); err == nil {
+		t.Error("parsed an Albers projection")
+	}
+}
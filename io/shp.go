@@ -1,92 +1,403 @@
 package io
 
 import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
 	shp "github.com/jonas-p/go-shp"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/project"
+	"golang.org/x/text/encoding"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 )
 
+// ShapefileReader reads a shapefile, or the shapefiles of a zip archive, which
+// may be nested in subdirectories. Each shapefile of an archive is a layer
+// named after its path in the archive without the extension.
 type ShapefileReader struct {
-	reader shp.SequentialReader
+	archive *zip.ReadCloser
+	layers  []*shapefileSource
+
+	// Encoding is the character encoding of the DBF text fields. If nil, it is
+	// detected for each layer from its .cpg file or the DBF language driver
+	// ID.
+	Encoding encoding.Encoding
+
+	// MeasureProperty, when set, is the name of a property that receives the
+	// M values of each feature's positions, instead of keeping them as the
+	// feature's fourth ordinate
+	MeasureProperty string
+
+	// LayerProperty, when set, is the name of a property that receives the
+	// name of the layer each feature is read from
+	LayerProperty string
+
+	// Reproject converts the coordinates of layers with a .prj file to WGS84,
+	// and is set by default. Layers whose coordinate system is not supported
+	// are read unchanged, and Warnings tells which.
+	Reproject bool
 }
 
-func NewShapefileReader(filename string) (*ShapefileReader, error) {
-	var reader shp.SequentialReader
-	var err error
-	if strings.HasSuffix(filename, ".zip") {
-		reader, err = shp.OpenZip(filename)
+// NewShapefileReader opens a shapefile or a zip archive of shapefiles. If
+// layers are given, only those are read, in that order; otherwise all the
+// layers are. Layers are selected by their full name or by their base name.
+func NewShapefileReader(filename string, layers ...string) (*ShapefileReader, error) {
+	s := &ShapefileReader{Reproject: true}
+	if isZipFile(filename) {
+		archive, err := zip.OpenReader(filename)
+		if err != nil {
+			return nil, err
+		}
+		s.archive = archive
+		s.layers = zipSources(&archive.Reader)
+		if len(s.layers) == 0 {
+			archive.Close()
+			return nil, errors.New("archive does not contain a .shp file")
+		}
 	} else {
-		reader, err = shp.Open(filename)
+		if _, err := os.Stat(filename); err != nil {
+			return nil, err
+		}
+		base := strings.TrimSuffix(filename, path.Ext(filename))
+		s.layers = []*shapefileSource{{
+			name:     filepath.Base(base),
+			base:     base,
+			filename: filename,
+		}}
 	}
-	if err != nil {
-		return nil, err
+	if len(layers) > 0 {
+		selected, err := selectLayers(s.layers, layers)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.layers = selected
 	}
-	return &ShapefileReader{
-		reader,
-	}, nil
+	for _, layer := range s.layers {
+		if err := layer.detect(); err != nil {
+			s.close()
+			if len(s.layers) > 1 {
+				return nil, fmt.Errorf("layer %s: %v", layer.name, err)
+			}
+			return nil, err
+		}
+	}
+	return s, nil
 }
 
-func (s *ShapefileReader) Read(out chan map[string]interface{}) error {
-	defer s.reader.Close()
-	fields := s.reader.Fields()
-	for s.reader.Next() {
-		_, shape := s.reader.Shape()
-		geom := shapeToGeometry(shape)
-		properties := make(map[string]interface{})
-		for i, field := range fields {
-			attr := s.reader.Attribute(i)
-			properties[field.String()] = attr
+// Warnings describes the layers whose coordinates cannot be reprojected, or
+// only with an offset because their datum differs from WGS84
+func (s *ShapefileReader) Warnings() []string {
+	var warnings []string
+	for _, layer := range s.layers {
+		if layer.warning != "" {
+			warnings = append(warnings, fmt.Sprintf("layer %s: %s", layer.name, layer.warning))
 		}
-		out <- map[string]interface{}{
-			"type":       "Feature",
-			"geometry":   geom,
-			"properties": properties,
+	}
+	return warnings
+}
+
+// Layers returns the names of the layers that are read
+func (s *ShapefileReader) Layers() []string {
+	names := make([]string, len(s.layers))
+	for i, layer := range s.layers {
+		names[i] = layer.name
+	}
+	return names
+}
+
+func (s *ShapefileReader) Read(ctx context.Context, out chan *Feature) error {
+	defer s.close()
+	for _, layer := range s.layers {
+		if err := s.readLayer(ctx, layer, out); err != nil {
+			if unsupported, ok := err.(*ErrUnsupportedShape); ok && len(s.layers) > 1 {
+				unsupported.Layer = layer.name
+			}
+			return err
 		}
 	}
 	return nil
 }
 
-func shapeToGeometry(shape shp.Shape) map[string]interface{} {
-	switch s := shape.(type) {
-	case *shp.Polygon:
-		coords := buildCoordinates(s.Parts, s.Points)
-		return map[string]interface{}{
-			"type":        "MultiPolygon",
-			"coordinates": [][][][]float64{coords},
+func (s *ShapefileReader) close() {
+	if s.archive != nil {
+		s.archive.Close()
+		s.archive = nil
+	}
+}
+
+func (s *ShapefileReader) readLayer(ctx context.Context, layer *shapefileSource, out chan *Feature) error {
+	reader, dbf, err := layer.open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	enc := s.Encoding
+	if enc == nil {
+		enc = layer.encoding
+	}
+	fields := reader.Fields()
+	names := make([]string, len(fields))
+	// Fields start after the deletion flag of the record
+	offsets := make([]int, len(fields)+1)
+	offsets[0] = 1
+	for i, field := range fields {
+		names[i] = decodeString(enc, field.String())
+		offsets[i+1] = offsets[i] + int(field.Size)
+	}
+	records := 0
+	for dbf.reset(); reader.Next(); dbf.reset() {
+		records++
+		_, shape := reader.Shape()
+		geometry, z, m := shapeToGeometry(shape)
+		if layer.projection != nil && s.Reproject {
+			geometry = project.Geometry(geometry, layer.projection)
+		}
+		feature := NewFeature(geometry)
+		feature.Z = z
+		feature.Properties = make(Properties, len(fields), len(fields)+2)
+		for i, field := range fields {
+			value := decodeAttribute(field, dbf.field(offsets[i], offsets[i+1]))
+			if str, ok := value.(string); ok {
+				value = decodeString(enc, str)
+			}
+			feature.Properties[i] = Property{names[i], value}
+		}
+		if s.MeasureProperty == "" {
+			feature.M = m
+		} else if m != nil {
+			values := make([]interface{}, len(m))
+			for i, v := range m {
+				values[i] = v
+			}
+			feature.Properties.Set(s.MeasureProperty, values)
+		}
+		if s.LayerProperty != "" {
+			feature.Properties.Set(s.LayerProperty, layer.name)
+		}
+		if err := Emit(ctx, out, feature); err != nil {
+			return err
+		}
+	}
+	err = reader.Err()
+	// go-shp stops at shape types it does not know, with an untyped error
+	if err != nil && strings.HasPrefix(err.Error(), "Error decoding shape type") {
+		return &ErrUnsupportedShape{Record: records + 1, Err: err}
+	}
+	return err
+}
+
+// shapefileSource is a layer of a ShapefileReader: a shapefile on disk, or
+// one in a zip archive
+type shapefileSource struct {
+	name string
+	// base is the path of the shapefile without the extension
+	base string
+	// filename is the path of the .shp file on disk
+	filename string
+	// files are the members of the archive that make up the shapefile, by
+	// lowercase extension
+	files map[string]*zip.File
+
+	encoding   encoding.Encoding
+	projection orb.Projection
+	// warning tells why the coordinates are not reprojected, or only
+	// approximately
+	warning string
+}
+
+// zipSources finds the shapefiles in an archive, skipping the resource forks
+// that macOS adds to archives
+func zipSources(archive *zip.Reader) []*shapefileSource {
+	var sources []*shapefileSource
+	byBase := map[string]*shapefileSource{}
+	for _, f := range archive.File {
+		if isHiddenZipEntry(f.Name) {
+			continue
 		}
-	case *shp.PolyLine:
-		coords := buildCoordinates(s.Parts, s.Points)
-		return map[string]interface{}{
-			"type":        "MultiLineString",
-			"coordinates": [][][][]float64{coords},
+		if strings.EqualFold(path.Ext(f.Name), ".shp") {
+			base := strings.TrimSuffix(f.Name, path.Ext(f.Name))
+			source := &shapefileSource{
+				name:  base,
+				base:  base,
+				files: map[string]*zip.File{},
+			}
+			sources = append(sources, source)
+			byBase[strings.ToLower(base)] = source
 		}
-	case *shp.Point:
-		return map[string]interface{}{
-			"type": "Point",
-			"coordinates": []float64{
-				s.X,
-				s.Y,
-			},
+	}
+	for _, f := range archive.File {
+		ext := path.Ext(f.Name)
+		if source, ok := byBase[strings.ToLower(strings.TrimSuffix(f.Name, ext))]; ok {
+			source.files[strings.ToLower(ext)] = f
 		}
 	}
+	return sources
+}
+
+// isHiddenZipEntry tells whether a zip entry is metadata added by macOS
+func isHiddenZipEntry(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), "._")
+}
+
+func selectLayers(layers []*shapefileSource, names []string) ([]*shapefileSource, error) {
+	var selected []*shapefileSource
+	for _, name := range names {
+		var found *shapefileSource
+		for _, layer := range layers {
+			if layer.name == name || path.Base(layer.name) == name {
+				found = layer
+				break
+			}
+		}
+		if found == nil {
+			available := make([]string, len(layers))
+			for i, layer := range layers {
+				available[i] = layer.name
+			}
+			return nil, fmt.Errorf("no layer %q, available layers are %s", name, strings.Join(available, ", "))
+		}
+		selected = append(selected, found)
+	}
+	return selected, nil
+}
+
+// detect reads the projection and the encoding of a layer from its sidecar
+// files
+func (l *shapefileSource) detect() error {
+	if _, err := l.sidecar(".dbf", 0); err != nil {
+		return err
+	}
+	if prj, err := l.sidecar(".prj", -1); err == nil {
+		projection, datum, err := parseProjection(string(prj))
+		switch {
+		case err != nil:
+			l.warning = fmt.Sprintf("%v, coordinates are read unchanged", err)
+		case datum != "" && !alignedDatum(datum):
+			l.warning = fmt.Sprintf("datum %s is not shifted to WGS84, which may offset positions by up to a few hundred meters", datum)
+		}
+		l.projection = projection
+	}
+	l.encoding = l.detectEncoding()
 	return nil
 }
 
-func buildCoordinates(parts []int32, points []shp.Point) [][][]float64 {
-	numParts := len(parts)
-	var coords [][][]float64
-	for i, start := range parts {
-		var partCoords [][]float64
-		end := len(points)
-		if i < numParts-1 {
-			end = int(parts[i+1])
+func (l *shapefileSource) open() (shp.SequentialReader, *dbfRowReader, error) {
+	var shpFile io.ReadCloser
+	var err error
+	if l.files == nil {
+		shpFile, err = os.Open(l.filename)
+	} else {
+		shpFile, err = l.files[".shp"].Open()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	dbfFile, err := l.openSidecar(".dbf")
+	if err != nil {
+		shpFile.Close()
+		return nil, nil, err
+	}
+	dbf := &dbfRowReader{ReadCloser: dbfFile}
+	return shp.SequentialReaderFromExt(&deferredEOFReader{ReadCloser: shpFile}, dbf), dbf, nil
+}
+
+// dbfRowReader keeps the bytes read from a DBF file since it was last reset,
+// which are the record that go-shp reads for a shape. go-shp trims the spaces
+// around the attributes it returns, which may be bytes of binary values.
+type dbfRowReader struct {
+	io.ReadCloser
+	row []byte
+}
+
+func (r *dbfRowReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.row = append(r.row, p[:n]...)
+	return n, err
+}
+
+func (r *dbfRowReader) reset() {
+	r.row = r.row[:0]
+}
+
+// field returns the raw value of a field of the record from its offsets
+func (r *dbfRowReader) field(start, end int) string {
+	if end > len(r.row) {
+		return ""
+	}
+	return string(r.row[start:end])
+}
+
+// deferredEOFReader returns an error that comes with the last bytes of a
+// file on the next read instead. go-shp stops at such an error without
+// reading those bytes, which drops the last shape of a shapefile if it is a
+// 12-byte null shape.
+type deferredEOFReader struct {
+	io.ReadCloser
+	err error
+}
+
+func (r *deferredEOFReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && err != nil {
+		r.err, err = err, nil
+	}
+	return n, err
+}
+
+// detectEncoding reads the code page of a shapefile from its .cpg file, or
+// falls back to the language driver ID in the DBF header
+func (l *shapefileSource) detectEncoding() encoding.Encoding {
+	cpg, err := l.sidecar(".cpg", -1)
+	if err == nil {
+		if enc, err := LookupEncoding(strings.TrimSpace(string(cpg))); err == nil {
+			return enc
 		}
-		for _, point := range points[start:end] {
-			partCoords = append(partCoords, []float64{
-				point.X,
-				point.Y,
-			})
+	}
+	header, err := l.sidecar(".dbf", 32)
+	if err == nil && len(header) > 29 {
+		return languageDrivers[header[29]]
+	}
+	return nil
+}
+
+// sidecar reads up to limit bytes (or everything, if limit is negative) of the
+// file with the given extension that accompanies a shapefile, either next to
+// it or in the same zip archive
+func (l *shapefileSource) sidecar(ext string, limit int64) ([]byte, error) {
+	r, err := l.openSidecar(ext)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if limit >= 0 {
+		return ioutil.ReadAll(io.LimitReader(r, limit))
+	}
+	return ioutil.ReadAll(r)
+}
+
+// openSidecar opens the file with the given extension that accompanies a
+// shapefile, trying the upper case extension next to it as well
+func (l *shapefileSource) openSidecar(ext string) (io.ReadCloser, error) {
+	if l.files != nil {
+		f, ok := l.files[ext]
+		if !ok {
+			return nil, fmt.Errorf("archive does not contain %s", l.base+ext)
 		}
-		coords = append(coords, partCoords)
+		return f.Open()
+	}
+	r, err := os.Open(l.base + ext)
+	if os.IsNotExist(err) {
+		r, err = os.Open(l.base + strings.ToUpper(ext))
 	}
-	return coords
+	return r, err
 }
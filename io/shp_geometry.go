@@ -0,0 +1,442 @@
+package io
+
+import (
+	shp "github.com/jonas-p/go-shp"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	"math"
+)
+
+// Measures smaller than shpNoData are "no data" according to the shapefile
+// spec, and shpNoDataValue is written for missing measures
+const (
+	shpNoData      = -1e38
+	shpNoDataValue = -1e39
+)
+
+// MultiPatch part types
+const (
+	patchTriangleStrip = 0
+	patchTriangleFan   = 1
+	patchOuterRing     = 2
+	patchInnerRing     = 3
+	patchFirstRing     = 4
+	patchRing          = 5
+)
+
+// shapePart is a single part of a shape along with the optional Z and M
+// values of its points
+type shapePart struct {
+	points orb.LineString
+	z, m   []float64
+}
+
+func (p shapePart) reverse() {
+	p.points.Reverse()
+	reverseFloats(p.z)
+	reverseFloats(p.m)
+}
+
+// shapeToGeometry converts a shape to an orb.Geometry and returns the Z and M
+// values of its positions in traversal order, or nil if the shape type has
+// none. Measures that are all "no data" are treated as absent.
+func shapeToGeometry(shape shp.Shape) (orb.Geometry, []float64, []float64) {
+	var geom orb.Geometry
+	var z, m []float64
+	switch s := shape.(type) {
+	case *shp.Point:
+		geom = orb.Point{s.X, s.Y}
+	case *shp.PointZ:
+		geom, z, m = orb.Point{s.X, s.Y}, []float64{s.Z}, []float64{s.M}
+	case *shp.PointM:
+		geom, m = orb.Point{s.X, s.Y}, []float64{s.M}
+	case *shp.MultiPoint:
+		geom = buildPoints(s.Points)
+	case *shp.MultiPointZ:
+		geom, z, m = buildPoints(s.Points), s.ZArray, s.MArray
+	case *shp.MultiPointM:
+		geom, m = buildPoints(s.Points), s.MArray
+	case *shp.PolyLine:
+		geom, z, m = buildLines(buildParts(s.Parts, s.Points, nil, nil))
+	case *shp.PolyLineZ:
+		geom, z, m = buildLines(buildParts(s.Parts, s.Points, s.ZArray, s.MArray))
+	case *shp.PolyLineM:
+		geom, z, m = buildLines(buildParts(s.Parts, s.Points, nil, s.MArray))
+	case *shp.Polygon:
+		geom, z, m = buildPolygons(buildParts(s.Parts, s.Points, nil, nil))
+	case *shp.PolygonZ:
+		geom, z, m = buildPolygons(buildParts(s.Parts, s.Points, s.ZArray, s.MArray))
+	case *shp.PolygonM:
+		geom, z, m = buildPolygons(buildParts(s.Parts, s.Points, nil, s.MArray))
+	case *shp.MultiPatch:
+		geom, z, m = buildPatches(s)
+	}
+	if geom == nil {
+		return nil, nil, nil
+	}
+	if noData(m) {
+		m = nil
+	}
+	return geom, z, m
+}
+
+func noData(values []float64) bool {
+	for _, v := range values {
+		if v >= shpNoData {
+			return false
+		}
+	}
+	return true
+}
+
+func buildPoints(points []shp.Point) orb.Geometry {
+	if len(points) == 0 {
+		return nil
+	}
+	multiPoint := make(orb.MultiPoint, len(points))
+	for i, p := range points {
+		multiPoint[i] = orb.Point{p.X, p.Y}
+	}
+	return multiPoint
+}
+
+func buildParts(parts []int32, points []shp.Point, z, m []float64) []shapePart {
+	numParts := len(parts)
+	var shapeParts []shapePart
+	for i, start := range parts {
+		end := len(points)
+		if i < numParts-1 {
+			end = int(parts[i+1])
+		}
+		part := shapePart{
+			points: make(orb.LineString, 0, end-int(start)),
+		}
+		for _, point := range points[start:end] {
+			part.points = append(part.points, orb.Point{point.X, point.Y})
+		}
+		if len(z) >= end {
+			part.z = append([]float64(nil), z[start:end]...)
+		}
+		if len(m) >= end {
+			part.m = append([]float64(nil), m[start:end]...)
+		}
+		shapeParts = append(shapeParts, part)
+	}
+	return shapeParts
+}
+
+func buildLines(parts []shapePart) (orb.Geometry, []float64, []float64) {
+	if len(parts) == 0 {
+		return nil, nil, nil
+	}
+	lines := make(orb.MultiLineString, len(parts))
+	for i, part := range parts {
+		lines[i] = part.points
+	}
+	z, m := concatOrdinates(parts)
+	return lines, z, m
+}
+
+// buildPolygons groups shapefile rings into polygons. Shapefiles store outer
+// rings clockwise and holes counter-clockwise, with no explicit grouping, so
+// every hole is assigned to the smallest outer ring that contains it. The
+// rings are reversed to follow the RFC 7946 right-hand rule.
+func buildPolygons(parts []shapePart) (orb.Geometry, []float64, []float64) {
+	var outers, holes []shapePart
+	for _, part := range parts {
+		if len(part.points) < 3 {
+			continue
+		}
+		if orb.Ring(part.points).Orientation() == orb.CCW {
+			holes = append(holes, part)
+		} else {
+			outers = append(outers, part)
+		}
+	}
+	polygons := make([][]shapePart, len(outers))
+	areas := make([]float64, len(outers))
+	for i, outer := range outers {
+		polygons[i] = []shapePart{outer}
+		// The area of a clockwise ring is negative
+		areas[i] = math.Abs(planar.Area(orb.Ring(outer.points)))
+	}
+	for _, hole := range holes {
+		container := -1
+		holeBound := hole.points.Bound()
+		for i, outer := range outers {
+			ring := orb.Ring(outer.points)
+			bound := ring.Bound()
+			if !bound.Contains(holeBound.Min) || !bound.Contains(holeBound.Max) {
+				continue
+			}
+			if !planar.RingContains(ring, hole.points[0]) {
+				continue
+			}
+			if container < 0 || areas[i] < areas[container] {
+				container = i
+			}
+		}
+		if container < 0 {
+			// An orphaned hole is most likely an outer ring with the wrong
+			// winding order, so treat it as a polygon of its own
+			hole.reverse()
+			polygons = append(polygons, []shapePart{hole})
+			continue
+		}
+		polygons[container] = append(polygons[container], hole)
+	}
+	if len(polygons) == 0 {
+		return nil, nil, nil
+	}
+	var ordered []shapePart
+	multiPolygon := make(orb.MultiPolygon, len(polygons))
+	for i, rings := range polygons {
+		for _, ring := range rings {
+			ring.reverse()
+			multiPolygon[i] = append(multiPolygon[i], orb.Ring(ring.points))
+		}
+		ordered = append(ordered, rings...)
+	}
+	z, m := concatOrdinates(ordered)
+	return multiPolygon, z, m
+}
+
+// buildPatches converts the surfaces of a MultiPatch into a MultiPolygon.
+// Triangle strips and fans are split into one polygon per triangle, and rings
+// are grouped the way their part types declare: inner rings and the rings that
+// follow an outer or first ring are its holes, and other rings are polygons.
+func buildPatches(s *shp.MultiPatch) (orb.Geometry, []float64, []float64) {
+	parts := buildParts(s.Parts, s.Points, s.ZArray, s.MArray)
+	var polygons [][]shapePart
+	// Whether the last polygon starts with an outer or first ring
+	outer := false
+	for i, part := range parts {
+		switch s.PartTypes[i] {
+		case patchTriangleStrip:
+			for j := 2; j < len(part.points); j++ {
+				polygons = append(polygons, []shapePart{triangle(part, j-2, j-1, j)})
+			}
+			outer = false
+		case patchTriangleFan:
+			for j := 2; j < len(part.points); j++ {
+				polygons = append(polygons, []shapePart{triangle(part, 0, j-1, j)})
+			}
+			outer = false
+		case patchInnerRing, patchRing:
+			if len(polygons) > 0 && (s.PartTypes[i] == patchInnerRing || outer) {
+				polygons[len(polygons)-1] = append(polygons[len(polygons)-1], part)
+				continue
+			}
+			polygons = append(polygons, []shapePart{part})
+		default:
+			polygons = append(polygons, []shapePart{part})
+			outer = s.PartTypes[i] == patchOuterRing || s.PartTypes[i] == patchFirstRing
+		}
+	}
+	if len(polygons) == 0 {
+		return nil, nil, nil
+	}
+	var ordered []shapePart
+	multiPolygon := make(orb.MultiPolygon, len(polygons))
+	for i, rings := range polygons {
+		for j, ring := range rings {
+			// Exterior rings are counter-clockwise, holes clockwise
+			orientation := orb.Ring(ring.points).Orientation()
+			if j == 0 && orientation == orb.CW || j > 0 && orientation == orb.CCW {
+				ring.reverse()
+			}
+			multiPolygon[i] = append(multiPolygon[i], orb.Ring(ring.points))
+		}
+		ordered = append(ordered, rings...)
+	}
+	z, m := concatOrdinates(ordered)
+	return multiPolygon, z, m
+}
+
+// triangle returns a closed ring from three vertices of a part
+func triangle(part shapePart, a, b, c int) shapePart {
+	indexes := []int{a, b, c, a}
+	t := shapePart{points: make(orb.LineString, len(indexes))}
+	for i, index := range indexes {
+		t.points[i] = part.points[index]
+		if part.z != nil {
+			t.z = append(t.z, part.z[index])
+		}
+		if part.m != nil {
+			t.m = append(t.m, part.m[index])
+		}
+	}
+	return t
+}
+
+func concatOrdinates(parts []shapePart) ([]float64, []float64) {
+	var z, m []float64
+	hasZ, hasM := true, true
+	for _, part := range parts {
+		hasZ = hasZ && part.z != nil
+		hasM = hasM && part.m != nil
+		z = append(z, part.z...)
+		m = append(m, part.m...)
+	}
+	if !hasZ {
+		z = nil
+	}
+	if !hasM {
+		m = nil
+	}
+	return z, m
+}
+
+func reverseFloats(values []float64) {
+	for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+		values[i], values[j] = values[j], values[i]
+	}
+}
+
+// geometryParts splits a geometry into shapefile parts, taking its Z and M
+// values (which may be nil) in traversal order. Polygon rings are reoriented
+// to the shapefile convention of clockwise outer rings and counter-clockwise
+// holes.
+func geometryParts(g orb.Geometry, z, m []float64) []shapePart {
+	b := &partBuilder{z: z, m: m}
+	b.addGeometry(g)
+	return b.parts
+}
+
+type partBuilder struct {
+	z, m  []float64
+	i     int
+	parts []shapePart
+}
+
+func (b *partBuilder) addGeometry(g orb.Geometry) {
+	switch g := g.(type) {
+	case orb.Point:
+		b.add([]orb.Point{g})
+	case orb.MultiPoint:
+		b.add(g)
+	case orb.LineString:
+		b.add(g)
+	case orb.MultiLineString:
+		for _, line := range g {
+			b.add(line)
+		}
+	case orb.Ring:
+		b.addGeometry(orb.Polygon{g})
+	case orb.Bound:
+		b.addGeometry(g.ToPolygon())
+	case orb.Polygon:
+		for i, ring := range g {
+			part := b.add(ring)
+			orientation := orb.Ring(part.points).Orientation()
+			if i == 0 && orientation == orb.CCW || i > 0 && orientation == orb.CW {
+				part.reverse()
+			}
+		}
+	case orb.MultiPolygon:
+		for _, polygon := range g {
+			b.addGeometry(polygon)
+		}
+	case orb.Collection:
+		for _, item := range g {
+			b.addGeometry(item)
+		}
+	}
+}
+
+func (b *partBuilder) add(points []orb.Point) shapePart {
+	n := len(points)
+	part := shapePart{
+		points: append(orb.LineString(nil), points...),
+	}
+	if b.z != nil {
+		part.z = append([]float64(nil), b.z[b.i:b.i+n]...)
+	}
+	if b.m != nil {
+		part.m = append([]float64(nil), b.m[b.i:b.i+n]...)
+	}
+	b.i += n
+	b.parts = append(b.parts, part)
+	return part
+}
+
+// partsToShape builds a shape of the given type from parts. Missing Z values
+// are written as 0 and missing measures as "no data".
+func partsToShape(parts []shapePart, shapeType shp.ShapeType) shp.Shape {
+	var points []shp.Point
+	var z, m []float64
+	indexes := make([]int32, len(parts))
+	for i, part := range parts {
+		indexes[i] = int32(len(points))
+		for j, p := range part.points {
+			points = append(points, shp.Point{X: p[0], Y: p[1]})
+			if part.z != nil {
+				z = append(z, part.z[j])
+			} else {
+				z = append(z, 0)
+			}
+			if part.m != nil {
+				m = append(m, part.m[j])
+			} else {
+				m = append(m, shpNoDataValue)
+			}
+		}
+	}
+	if len(points) == 0 {
+		return &shp.Null{}
+	}
+	box := shp.BBoxFromPoints(points)
+	numParts, numPoints := int32(len(parts)), int32(len(points))
+	switch shapeType {
+	case shp.POINT:
+		return &points[0]
+	case shp.POINTZ:
+		return &shp.PointZ{X: points[0].X, Y: points[0].Y, Z: z[0], M: m[0]}
+	case shp.POINTM:
+		return &shp.PointM{X: points[0].X, Y: points[0].Y, M: m[0]}
+	case shp.MULTIPOINT:
+		return &shp.MultiPoint{Box: box, NumPoints: numPoints, Points: points}
+	case shp.MULTIPOINTZ:
+		return &shp.MultiPointZ{Box: box, NumPoints: numPoints, Points: points,
+			ZRange: floatRange(z), ZArray: z, MRange: floatRange(m), MArray: m}
+	case shp.MULTIPOINTM:
+		return &shp.MultiPointM{Box: box, NumPoints: numPoints, Points: points,
+			MRange: floatRange(m), MArray: m}
+	case shp.POLYLINE:
+		return &shp.PolyLine{Box: box, NumParts: numParts, NumPoints: numPoints, Parts: indexes, Points: points}
+	case shp.POLYLINEZ:
+		return &shp.PolyLineZ{Box: box, NumParts: numParts, NumPoints: numPoints, Parts: indexes, Points: points,
+			ZRange: floatRange(z), ZArray: z, MRange: floatRange(m), MArray: m}
+	case shp.POLYLINEM:
+		return &shp.PolyLineM{Box: box, NumParts: numParts, NumPoints: numPoints, Parts: indexes, Points: points,
+			MRange: floatRange(m), MArray: m}
+	case shp.POLYGON:
+		return &shp.Polygon{Box: box, NumParts: numParts, NumPoints: numPoints, Parts: indexes, Points: points}
+	case shp.POLYGONZ:
+		return &shp.PolygonZ{Box: box, NumParts: numParts, NumPoints: numPoints, Parts: indexes, Points: points,
+			ZRange: floatRange(z), ZArray: z, MRange: floatRange(m), MArray: m}
+	case shp.POLYGONM:
+		return &shp.PolygonM{Box: box, NumParts: numParts, NumPoints: numPoints, Parts: indexes, Points: points,
+			MRange: floatRange(m), MArray: m}
+	}
+	return &shp.Null{}
+}
+
+// floatRange returns the minimum and maximum of values, ignoring "no data"
+func floatRange(values []float64) [2]float64 {
+	var r [2]float64
+	first := true
+	for _, v := range values {
+		if v < shpNoData {
+			continue
+		}
+		if first || v < r[0] {
+			r[0] = v
+		}
+		if first || v > r[1] {
+			r[1] = v
+		}
+		first = false
+	}
+	return r
+}
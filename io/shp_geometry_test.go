@@ -0,0 +1,173 @@
+package io
+
+import (
+	"github.com/jonas-p/go-shp"
+	"github.com/paulmach/orb"
+	"reflect"
+	"testing"
+)
+
+// multiPatch builds a MultiPatch from parts and their types
+func multiPatch(partTypes []int32, parts ...[]shp.Point) *shp.MultiPatch {
+	patch := &shp.MultiPatch{PartTypes: partTypes}
+	for _, part := range parts {
+		patch.Parts = append(patch.Parts, int32(len(patch.Points)))
+		patch.Points = append(patch.Points, part...)
+		for range part {
+			patch.ZArray = append(patch.ZArray, 1)
+		}
+	}
+	patch.NumParts, patch.NumPoints = int32(len(patch.Parts)), int32(len(patch.Points))
+	return patch
+}
+
+// square returns a closed clockwise ring
+func square(x, y, size float64) []shp.Point {
+	return []shp.Point{{X: x, Y: y}, {X: x, Y: y + size}, {X: x + size, Y: y + size}, {X: x + size, Y: y}, {X: x, Y: y}}
+}
+
+func TestBuildPatches(t *testing.T) {
+	strip := []shp.Point{{X: 0, Y: 0}, {X: 0, Y: 1}, {X: 1, Y: 0}, {X: 1, Y: 1}}
+	tests := []struct {
+		name  string
+		patch *shp.MultiPatch
+		// rings is the number of rings of each polygon
+		rings []int
+	}{
+		{"rings", multiPatch([]int32{patchRing, patchRing}, square(0, 0, 1), square(5, 5, 1)), []int{1, 1}},
+		{"first ring", multiPatch([]int32{patchFirstRing, patchRing, patchRing}, square(0, 0, 10), square(1, 1, 1), square(3, 3, 1)), []int{3}},
+		{"outer ring", multiPatch([]int32{patchOuterRing, patchInnerRing, patchRing}, square(0, 0, 10), square(1, 1, 1), square(3, 3, 1)), []int{3}},
+		{"ring after strip", multiPatch([]int32{patchTriangleStrip, patchRing, patchRing}, strip, square(5, 5, 1), square(8, 8, 1)), []int{1, 1, 1, 1}},
+		{"rings after fan", multiPatch([]int32{patchFirstRing, patchRing, patchTriangleFan, patchRing}, square(0, 0, 10), square(1, 1, 1), strip, square(20, 20, 1)), []int{2, 1, 1, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			geometry, z, _ := buildPatches(test.patch)
+			multiPolygon, ok := geometry.(orb.MultiPolygon)
+			if !ok {
+				t.Fatalf("got %T, want a MultiPolygon", geometry)
+			}
+			var rings []int
+			for _, polygon := range multiPolygon {
+				rings = append(rings, len(polygon))
+				for j, ring := range polygon {
+					if orientation := ring.Orientation(); (j == 0) != (orientation == orb.CCW) {
+						t.Errorf("ring %d has orientation %v", j, orientation)
+					}
+				}
+			}
+			if !reflect.DeepEqual(rings, test.rings) {
+				t.Errorf("got rings %v, want %v", rings, test.rings)
+			}
+			if len(z) != PositionCount(geometry) {
+				t.Errorf("got %d Z values for %d positions", len(z), PositionCount(geometry))
+			}
+		})
+	}
+}
+
+// hole returns a closed counter-clockwise ring
+func hole(x, y, size float64) []shp.Point {
+	ring := square(x, y, size)
+	for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+		ring[i], ring[j] = ring[j], ring[i]
+	}
+	return ring
+}
+
+// ordinate is the Z value of a test point, and its negation the M value, so
+// that they show whether they follow their points
+func ordinate(p orb.Point) float64 {
+	return p[0]*1000 + p[1]
+}
+
+func TestBuildPolygons(t *testing.T) {
+	tests := []struct {
+		name  string
+		rings [][]shp.Point
+		// polygons lists the indexes of the rings of each polygon
+		polygons [][]int
+	}{
+		{"one ring", [][]shp.Point{square(0, 0, 1)}, [][]int{{0}}},
+		{"two islands", [][]shp.Point{square(0, 0, 1), square(5, 5, 1)}, [][]int{{0}, {1}}},
+		{"island with a hole", [][]shp.Point{square(0, 0, 10), hole(2, 2, 2)}, [][]int{{0, 1}}},
+		{"hole before its ring", [][]shp.Point{hole(2, 2, 2), square(0, 0, 10)}, [][]int{{1, 0}}},
+		{"islands with holes", [][]shp.Point{square(0, 0, 10), square(20, 0, 10), hole(22, 2, 2), hole(2, 2, 2)}, [][]int{{0, 3}, {1, 2}}},
+		{
+			"nested rings",
+			[][]shp.Point{square(0, 0, 10), hole(2, 2, 1), square(1, 1, 6), hole(8, 8, 1)},
+			[][]int{{0, 3}, {2, 1}},
+		},
+		{"orphaned hole", [][]shp.Point{square(0, 0, 1), hole(5, 5, 1)}, [][]int{{0}, {1}}},
+		{"hole outside the bound", [][]shp.Point{square(0, 0, 4), hole(3, 3, 2)}, [][]int{{0}, {1}}},
+		{"degenerate ring", [][]shp.Point{square(0, 0, 1), {{X: 5, Y: 5}, {X: 6, Y: 6}}}, [][]int{{0}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var parts []int32
+			var points []shp.Point
+			var z, m []float64
+			for _, ring := range test.rings {
+				parts = append(parts, int32(len(points)))
+				points = append(points, ring...)
+				for _, p := range ring {
+					z = append(z, ordinate(orb.Point{p.X, p.Y}))
+					m = append(m, -ordinate(orb.Point{p.X, p.Y}))
+				}
+			}
+			geometry, gotZ, gotM := buildPolygons(buildParts(parts, points, z, m))
+			multiPolygon, ok := geometry.(orb.MultiPolygon)
+			if !ok {
+				t.Fatalf("got %T, want a MultiPolygon", geometry)
+			}
+			var polygons [][]int
+			var wantZ, wantM []float64
+			for _, polygon := range multiPolygon {
+				var rings []int
+				for j, ring := range polygon {
+					// RFC 7946: exterior rings are counter-clockwise, holes
+					// clockwise
+					if orientation := ring.Orientation(); (j == 0) != (orientation == orb.CCW) {
+						t.Errorf("ring %d has orientation %v", j, orientation)
+					}
+					rings = append(rings, ringIndex(test.rings, ring))
+					for _, p := range ring {
+						wantZ = append(wantZ, ordinate(p))
+						wantM = append(wantM, -ordinate(p))
+					}
+				}
+				polygons = append(polygons, rings)
+			}
+			if !reflect.DeepEqual(polygons, test.polygons) {
+				t.Errorf("got rings %v, want %v", polygons, test.polygons)
+			}
+			if !reflect.DeepEqual(gotZ, wantZ) || !reflect.DeepEqual(gotM, wantM) {
+				t.Errorf("got Z %v and M %v, want %v and %v", gotZ, gotM, wantZ, wantM)
+			}
+		})
+	}
+
+	if geometry, z, m := buildPolygons(nil); geometry != nil || z != nil || m != nil {
+		t.Errorf("no parts: got %v, %v, %v", geometry, z, m)
+	}
+}
+
+// ringIndex returns the index of the input ring with the same points as a
+// ring in either order, or -1
+func ringIndex(rings [][]shp.Point, ring orb.Ring) int {
+	for i, input := range rings {
+		if len(input) != len(ring) {
+			continue
+		}
+		forward, backward := true, true
+		for j, p := range input {
+			point := orb.Point{p.X, p.Y}
+			forward = forward && point == ring[j]
+			backward = backward && point == ring[len(ring)-1-j]
+		}
+		if forward || backward {
+			return i
+		}
+	}
+	return -1
+}
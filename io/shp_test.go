@@ -0,0 +1,156 @@
+package io
+
+import (
+	"archive/zip"
+	"github.com/paulmach/orb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// zipShapefiles writes an archive of two shapefiles, one of them in a
+// directory and with upper case extensions, along with the metadata that
+// macOS adds
+func zipShapefiles(t *testing.T, dir string) string {
+	roads, _ := writeShapefile(t, "roads.shp", []*Feature{
+		{Geometry: orb.Point{1, 2}, Properties: Properties{{"name", "road"}}},
+	})
+	defer os.RemoveAll(filepath.Dir(roads))
+	parcels, _ := writeShapefile(t, "parcels.shp", []*Feature{
+		{Geometry: orb.Point{3, 4}, Properties: Properties{{"name", "parcel 1"}}},
+		{Geometry: orb.Point{5, 6}, Properties: Properties{{"name", "parcel 2"}}},
+	})
+	defer os.RemoveAll(filepath.Dir(parcels))
+
+	filename := filepath.Join(dir, "layers.zip")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w := zip.NewWriter(file)
+	add := func(name string, data []byte) {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+	}
+	for _, ext := range []string{".shp", ".shx", ".dbf", ".prj"} {
+		data, _ := ioutil.ReadFile(strings.TrimSuffix(roads, ".shp") + ext)
+		add("roads"+ext, data)
+		data, _ = ioutil.ReadFile(strings.TrimSuffix(parcels, ".shp") + ext)
+		add("data/parcels"+strings.ToUpper(ext), data)
+	}
+	add("__MACOSX/data/._parcels.SHP", []byte("fork"))
+	add("__MACOSX/._roads.shp", []byte("fork"))
+	add("data/._hidden.shp", []byte("fork"))
+	add("data/readme.txt", []byte("text"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestZipSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xgeo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive, err := zip.OpenReader(zipShapefiles(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	sources := zipSources(&archive.Reader)
+	want := map[string][]string{
+		"roads":        {".dbf", ".prj", ".shp", ".shx"},
+		"data/parcels": {".dbf", ".prj", ".shp", ".shx"},
+	}
+	if len(sources) != len(want) {
+		t.Fatalf("found %d layers, want %d", len(sources), len(want))
+	}
+	for _, source := range sources {
+		var exts []string
+		for ext := range source.files {
+			exts = append(exts, ext)
+		}
+		sort.Strings(exts)
+		if !reflect.DeepEqual(exts, want[source.name]) {
+			t.Errorf("%s: got files %v, want %v", source.name, exts, want[source.name])
+		}
+	}
+
+	tests := []struct {
+		names []string
+		want  []string
+		err   string
+	}{
+		{[]string{"roads"}, []string{"roads"}, ""},
+		{[]string{"data/parcels"}, []string{"data/parcels"}, ""},
+		{[]string{"parcels", "roads"}, []string{"data/parcels", "roads"}, ""},
+		{[]string{"data"}, nil, `no layer "data", available layers are roads, data/parcels`},
+		{[]string{"roads", "hidden"}, nil, `no layer "hidden"`},
+	}
+	for _, test := range tests {
+		selected, err := selectLayers(sources, test.names)
+		var names []string
+		for _, layer := range selected {
+			names = append(names, layer.name)
+		}
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("%v: got %v, want %v", test.names, names, test.want)
+		}
+		if (err == nil) != (test.err == "") || err != nil && !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%v: got error %v, want %q", test.names, err, test.err)
+		}
+	}
+}
+
+func TestShapefileReaderZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xgeo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := zipShapefiles(t, dir)
+
+	tests := []struct {
+		layers []string
+		want   []string
+	}{
+		{nil, []string{"roads: road", "data/parcels: parcel 1", "data/parcels: parcel 2"}},
+		{[]string{"parcels"}, []string{"data/parcels: parcel 1", "data/parcels: parcel 2"}},
+		{[]string{"data/parcels", "roads"}, []string{"data/parcels: parcel 1", "data/parcels: parcel 2", "roads: road"}},
+	}
+	for _, test := range tests {
+		reader, err := NewShapefileReader(filename, test.layers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader.LayerProperty = "layer"
+		read, err := readAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range read {
+			layer, _ := f.Properties.Get("layer")
+			name, _ := f.Properties.Get("name")
+			got = append(got, layer.(string)+": "+name.(string))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.layers, got, test.want)
+		}
+	}
+
+	if _, err := NewShapefileReader(filename, "missing"); err == nil {
+		t.Error("selected a missing layer")
+	}
+}
@@ -0,0 +1,316 @@
+package io
+
+import (
+	"archive/zip"
+	"fmt"
+	shp "github.com/jonas-p/go-shp"
+	"github.com/paulmach/orb"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WGS84WKT is the ESRI WKT of the WGS84 coordinate system that GeoJSON
+// coordinates are in
+const WGS84WKT = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+// Shapefiles hold a single type of shape, so features are written to one
+// layer per kind of geometry, in this order
+var shapefileLayerKinds = []string{"point", "multipoint", "line", "polygon", "null"}
+
+var shapefileLayerTypes = map[string][3]shp.ShapeType{
+	"point":      {shp.POINT, shp.POINTZ, shp.POINTM},
+	"multipoint": {shp.MULTIPOINT, shp.MULTIPOINTZ, shp.MULTIPOINTM},
+	"line":       {shp.POLYLINE, shp.POLYLINEZ, shp.POLYLINEM},
+	"polygon":    {shp.POLYGON, shp.POLYGONZ, shp.POLYGONM},
+	"null":       {shp.NULL, shp.NULL, shp.NULL},
+}
+
+// ShapefileWriter writes features to a shapefile, or to a zip archive of
+// shapefiles if the filename ends with .zip. Features with different kinds of
+// geometries are split into layers named after the kind, e.g. out_point.shp
+// and out_polygon.shp, and geometry collections are split into their members.
+// Each layer gets a .prj file and a .cpg file declaring UTF-8 attributes.
+//
+// Only output with a single kind of geometry is written to the given name,
+// e.g. out.shp: mixed output has no out.shp, and features without geometry
+// go to their own out_null.shp layer. A layer has Z or M ordinates if any of
+// its features has them, so features are kept in memory until the input
+// ends.
+type ShapefileWriter struct {
+	filename string
+
+	// Fields is the DBF schema of every layer. Properties are written to the
+	// field with the same name, or with the same name truncated to 10
+	// characters. If Fields is nil, the schema is inferred from the properties
+	// of all features.
+	Fields []shp.Field
+
+	// PRJ is the coordinate system written to the .prj files
+	PRJ string
+}
+
+func NewShapefileWriter(filename string) (*ShapefileWriter, error) {
+	return &ShapefileWriter{
+		filename: filename,
+		PRJ:      WGS84WKT,
+	}, nil
+}
+
+func (s *ShapefileWriter) Write(in chan *Feature) error {
+	dir, err := ioutil.TempDir(filepath.Dir(s.filename), ".xgeo")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	layers := map[string]*shapefileLayer{}
+	for feature := range in {
+		if feature == nil {
+			continue
+		}
+		for _, f := range splitFeature(feature) {
+			kind := layerKind(f.Geometry)
+			layer, ok := layers[kind]
+			if !ok {
+				layer = &shapefileLayer{
+					filename: filepath.Join(dir, kind),
+					kind:     kind,
+					fields:   s.Fields,
+				}
+				layers[kind] = layer
+			}
+			layer.add(f)
+		}
+	}
+	if len(layers) == 0 {
+		layers["null"] = &shapefileLayer{
+			filename: filepath.Join(dir, "null"),
+			kind:     "null",
+			fields:   s.Fields,
+		}
+	}
+
+	base := strings.TrimSuffix(filepath.Base(s.filename), filepath.Ext(s.filename))
+	var files []string
+	names := map[string]string{}
+	for _, kind := range shapefileLayerKinds {
+		layer, ok := layers[kind]
+		if !ok {
+			continue
+		}
+		if err := layer.write(); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(layer.filename+".prj", []byte(s.PRJ), 0644); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(layer.filename+".cpg", []byte("UTF-8"), 0644); err != nil {
+			return err
+		}
+		name := base
+		if len(layers) > 1 {
+			name = base + "_" + kind
+		}
+		for _, ext := range []string{".shp", ".shx", ".dbf", ".prj", ".cpg"} {
+			files = append(files, layer.filename+ext)
+			names[layer.filename+ext] = name + ext
+		}
+	}
+
+	if strings.EqualFold(filepath.Ext(s.filename), ".zip") {
+		return writeZip(s.filename, files, names)
+	}
+	for _, file := range files {
+		if err := os.Rename(file, filepath.Join(filepath.Dir(s.filename), names[file])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shapefileLayer collects the features of one kind of geometry, since the
+// shape type and, unless Fields is set, the schema depend on all of them
+type shapefileLayer struct {
+	filename   string
+	kind       string
+	fields     []shp.Field
+	features   []*Feature
+	hasZ, hasM bool
+}
+
+func (l *shapefileLayer) add(f *Feature) {
+	l.features = append(l.features, f)
+	l.hasZ = l.hasZ || f.HasZ()
+	l.hasM = l.hasM || f.HasM()
+}
+
+// write creates the layer's files. The shape type has Z if any feature has Z,
+// and otherwise M if any feature has M. Features without them are written
+// with a Z of 0 and M values of "no data".
+func (l *shapefileLayer) write() error {
+	types := shapefileLayerTypes[l.kind]
+	shapeType := types[0]
+	if l.hasZ {
+		shapeType = types[1]
+	} else if l.hasM {
+		shapeType = types[2]
+	}
+
+	var keys []string
+	fields := l.fields
+	records := make([]Properties, len(l.features))
+	for row, f := range l.features {
+		records[row] = f.Properties
+	}
+	if fields == nil {
+		keys, fields = inferFields(records)
+		if len(fields) == 0 {
+			// A DBF file needs at least one field
+			fields = []shp.Field{shp.NumberField("FID", 10)}
+			for row := range records {
+				records[row] = Properties{{Key: "FID", Value: row}}
+			}
+			keys = []string{"FID"}
+		}
+	}
+
+	writer, err := shp.Create(l.filename+".shp", shapeType)
+	if err != nil {
+		return err
+	}
+	if err := writer.SetFields(fields); err != nil {
+		writer.Close()
+		return err
+	}
+	for row, f := range l.features {
+		var z, m []float64
+		if f.HasZ() {
+			z = f.Z
+		}
+		if f.HasM() {
+			m = f.M
+		}
+		writer.Write(partsToShape(geometryParts(f.Geometry, z, m), shapeType))
+		for i, field := range fields {
+			var value interface{}
+			if l.fields == nil {
+				value, _ = records[row].Get(keys[i])
+			} else {
+				value = fieldValue(records[row], field)
+			}
+			if value = encodeAttribute(field, value); value == nil {
+				continue
+			}
+			if err := writer.WriteAttribute(row, i, value); err != nil {
+				writer.Close()
+				return fmt.Errorf("feature %d: %v", row, err)
+			}
+		}
+	}
+	writer.Close()
+	// go-shp names the DBF file without the dot before the extension
+	return os.Rename(l.filename+"dbf", l.filename+".dbf")
+}
+
+// fieldValue returns the property written to a field of a given schema
+func fieldValue(properties Properties, field shp.Field) interface{} {
+	name := field.String()
+	if value, ok := properties.Get(name); ok {
+		return value
+	}
+	for _, prop := range properties {
+		if strings.EqualFold(truncateString(prop.Key, dbfNameLength), name) {
+			return prop.Value
+		}
+	}
+	return nil
+}
+
+func layerKind(g orb.Geometry) string {
+	if PositionCount(g) == 0 {
+		return "null"
+	}
+	switch g.(type) {
+	case orb.Point:
+		return "point"
+	case orb.MultiPoint:
+		return "multipoint"
+	case orb.LineString, orb.MultiLineString:
+		return "line"
+	}
+	return "polygon"
+}
+
+// splitFeature returns a feature for every member of a geometry collection,
+// each with the properties of the original
+func splitFeature(f *Feature) []*Feature {
+	switch g := f.Geometry.(type) {
+	case orb.Bound:
+		polygon := *f
+		polygon.Geometry = g.ToPolygon()
+		return []*Feature{&polygon}
+	case orb.Collection:
+		hasZ, hasM := f.HasZ(), f.HasM()
+		var features []*Feature
+		offset := 0
+		for _, item := range g {
+			n := PositionCount(item)
+			member := &Feature{
+				ID:         f.ID,
+				Geometry:   item,
+				Properties: f.Properties,
+			}
+			if hasZ {
+				member.Z = f.Z[offset : offset+n]
+			}
+			if hasM {
+				member.M = f.M[offset : offset+n]
+			}
+			offset += n
+			features = append(features, splitFeature(member)...)
+		}
+		if len(features) == 0 {
+			empty := *f
+			empty.Geometry = nil
+			return []*Feature{&empty}
+		}
+		return features
+	}
+	return []*Feature{f}
+}
+
+func writeZip(filename string, files []string, names map[string]string) error {
+	out, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	z := zip.NewWriter(out)
+	for _, file := range files {
+		w, err := z.CreateHeader(&zip.FileHeader{
+			Name:     names[file],
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,125 @@
+package io
+
+import (
+	"github.com/paulmach/orb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeAll writes features with a writer
+func writeAll(writer FeatureWriter, features []*Feature) error {
+	in := make(chan *Feature)
+	go func() {
+		defer close(in)
+		for _, feature := range features {
+			in <- feature
+		}
+	}()
+	err := writer.Write(in)
+	for range in {
+	}
+	return err
+}
+
+// writeShapefile writes features to a file in a new directory and returns
+// the names of the files written
+func writeShapefile(t *testing.T, filename string, features []*Feature) (string, []string) {
+	dir, err := ioutil.TempDir("", "xgeo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename = filepath.Join(dir, filename)
+	writer, _ := NewShapefileWriter(filename)
+	if err := writeAll(writer, features); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	sort.Strings(names)
+	return filename, names
+}
+
+func TestShapefileWriterZ(t *testing.T) {
+	line := orb.LineString{{0, 0}, {1, 1}}
+	features := []*Feature{
+		{Geometry: line},
+		{Geometry: line, Z: []float64{5, 6}},
+	}
+	filename, _ := writeShapefile(t, "out.shp", features)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	reader, err := NewShapefileReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := readAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2 {
+		t.Fatalf("read %d features, want 2", len(read))
+	}
+	for i, want := range [][]float64{{0, 0}, {5, 6}} {
+		if !reflect.DeepEqual(read[i].Z, want) {
+			t.Errorf("feature %d: got Z %v, want %v", i, read[i].Z, want)
+		}
+	}
+}
+
+func TestShapefileWriterLayers(t *testing.T) {
+	point := &Feature{Geometry: orb.Point{1, 2}}
+	null := &Feature{Properties: Properties{{Key: "name", Value: "x"}}}
+	tests := []struct {
+		name     string
+		filename string
+		features []*Feature
+		files    []string
+	}{
+		{"single kind", "out.shp", []*Feature{point, point}, []string{"out.cpg", "out.dbf", "out.prj", "out.shp", "out.shx"}},
+		{"null", "out.shp", []*Feature{null, null, null}, []string{"out.cpg", "out.dbf", "out.prj", "out.shp", "out.shx"}},
+		{"mixed", "out.shp", []*Feature{point, null, null}, []string{
+			"out_null.cpg", "out_null.dbf", "out_null.prj", "out_null.shp", "out_null.shx",
+			"out_point.cpg", "out_point.dbf", "out_point.prj", "out_point.shp", "out_point.shx",
+		}},
+		{"zipped null", "out.zip", []*Feature{null, null, null}, []string{"out.zip"}},
+		{"zipped mixed", "out.zip", []*Feature{null, point, null}, []string{"out.zip"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename, files := writeShapefile(t, test.filename, test.features)
+			dir := filepath.Dir(filename)
+			defer os.RemoveAll(dir)
+			if !reflect.DeepEqual(files, test.files) {
+				t.Fatalf("wrote %v, want %v", files, test.files)
+			}
+
+			// Read every layer back
+			var read []*Feature
+			for _, file := range files {
+				if ext := filepath.Ext(file); ext != ".shp" && ext != ".zip" {
+					continue
+				}
+				reader, err := NewShapefileReader(filepath.Join(dir, file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				features, err := readAll(reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				read = append(read, features...)
+			}
+			if len(read) != len(test.features) {
+				t.Errorf("read %d features, want %d", len(read), len(test.features))
+			}
+		})
+	}
+}
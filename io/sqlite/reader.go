@@ -0,0 +1,397 @@
+package sqlite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// maxDepth bounds the depth of the b-trees that are read, so that a corrupt
+// database with a cycle of pages does not recurse forever
+const maxDepth = 64
+
+// DB is a SQLite database file open for reading
+type DB struct {
+	file     io.ReaderAt
+	pageSize int
+	usable   int
+	objects  []Object
+}
+
+// Open reads the header and the schema of a database
+func Open(file io.ReaderAt) (*DB, error) {
+	header := make([]byte, headerLength)
+	if _, err := file.ReadAt(header, 0); err != nil || string(header[:len(Magic)]) != Magic {
+		return nil, fmt.Errorf("not a SQLite database")
+	}
+	db := &DB{file: file, pageSize: int(binary.BigEndian.Uint16(header[16:]))}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return nil, ErrCorrupt
+	}
+	db.usable = db.pageSize - int(header[20])
+	if db.usable < 480 {
+		return nil, ErrCorrupt
+	}
+	if encoding := binary.BigEndian.Uint32(header[56:]); encoding > 1 {
+		return nil, fmt.Errorf("UTF-16 databases are not supported")
+	}
+	err := db.scan(1, func(rowid int64, values []interface{}) error {
+		values = append(values, nil, nil, nil, nil, nil)
+		object := Object{}
+		object.Type, _ = values[0].(string)
+		object.Name, _ = values[1].(string)
+		object.TableName, _ = values[2].(string)
+		rootPage, _ := values[3].(int64)
+		object.RootPage = int(rootPage)
+		object.SQL, _ = values[4].(string)
+		db.objects = append(db.objects, object)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("schema: %v", err)
+	}
+	return db, nil
+}
+
+// Objects returns the entries of the schema, in the order they were created
+func (db *DB) Objects() []Object {
+	return db.objects
+}
+
+// Object returns the schema entry of a table, index, view or trigger, whose
+// name is matched regardless of case as in SQL
+func (db *DB) Object(name string) (Object, bool) {
+	for _, object := range db.objects {
+		if strings.EqualFold(object.Name, name) {
+			return object, true
+		}
+	}
+	return Object{}, false
+}
+
+// table returns the schema entry and the declaration of a table
+func (db *DB) table(name string) (Object, *tableSchema, error) {
+	object, ok := db.Object(name)
+	if !ok || object.Type != "table" {
+		return object, nil, fmt.Errorf("no table %s", name)
+	}
+	if object.RootPage == 0 {
+		return object, nil, fmt.Errorf("table %s is virtual", name)
+	}
+	schema, err := parseTable(object.SQL)
+	if err != nil {
+		return object, nil, err
+	}
+	if schema.withoutRowID {
+		return object, nil, fmt.Errorf("table %s is a WITHOUT ROWID table, which is not supported", name)
+	}
+	return object, schema, nil
+}
+
+// Columns returns the columns of a table
+func (db *DB) Columns(table string) ([]Column, error) {
+	_, schema, err := db.table(table)
+	if err != nil {
+		return nil, err
+	}
+	return schema.columns, nil
+}
+
+// Scan calls fn with the rowid and the values of each row of a table, in
+// rowid order, until fn returns an error. There is a value for each column,
+// which is nil, int64, float64, string or []byte, and the INTEGER PRIMARY KEY
+// column, if any, holds the rowid. Columns added to the table after a row was
+// written are nil for that row, whatever their default value.
+func (db *DB) Scan(table string, fn func(rowid int64, values []interface{}) error) error {
+	object, schema, err := db.table(table)
+	if err != nil {
+		return err
+	}
+	return db.scan(object.RootPage, func(rowid int64, values []interface{}) error {
+		return fn(rowid, schema.row(rowid, values))
+	})
+}
+
+// Row returns the values of the row of a table with a given rowid, like Scan,
+// or nil if there is no such row
+func (db *DB) Row(table string, rowid int64) ([]interface{}, error) {
+	object, schema, err := db.table(table)
+	if err != nil {
+		return nil, err
+	}
+	values, err := db.find(object.RootPage, rowid)
+	if values == nil || err != nil {
+		return nil, err
+	}
+	return schema.row(rowid, values), nil
+}
+
+// row completes the values of a record to those of every column
+func (t *tableSchema) row(rowid int64, values []interface{}) []interface{} {
+	for len(values) < len(t.columns) {
+		values = append(values, nil)
+	}
+	if i := t.rowIDColumn(); i >= 0 {
+		values[i] = rowid
+	}
+	return values[:len(t.columns)]
+}
+
+// recoverCorrupt sets *err to ErrCorrupt if the function that defers it
+// panics on an index out of range, which a malformed page causes
+func recoverCorrupt(err *error) {
+	if r := recover(); r != nil {
+		if _, ok := r.(runtime.Error); !ok {
+			panic(r)
+		}
+		*err = ErrCorrupt
+	}
+}
+
+func (db *DB) readPage(number uint32) ([]byte, error) {
+	if number == 0 {
+		return nil, ErrCorrupt
+	}
+	page := make([]byte, db.pageSize)
+	if _, err := db.file.ReadAt(page, int64(number-1)*int64(db.pageSize)); err != nil {
+		if err == io.EOF {
+			err = ErrCorrupt
+		}
+		return nil, err
+	}
+	return page, nil
+}
+
+// btreePage is a page of a b-tree, whose header follows the database header
+// on page 1
+type btreePage struct {
+	data   []byte
+	header int
+}
+
+func (db *DB) readBtreePage(number uint32) (btreePage, error) {
+	data, err := db.readPage(number)
+	if err != nil {
+		return btreePage{}, err
+	}
+	p := btreePage{data: data}
+	if number == 1 {
+		p.header = headerLength
+	}
+	return p, nil
+}
+
+func (p btreePage) pageType() byte {
+	return p.data[p.header]
+}
+
+func (p btreePage) cellCount() int {
+	return int(binary.BigEndian.Uint16(p.data[p.header+3:]))
+}
+
+// cell returns the offset of the ith cell
+func (p btreePage) cell(i int) int {
+	start := p.header + 8
+	if p.pageType() == interiorTablePage || p.pageType() == interiorIndexPage {
+		start += 4
+	}
+	return int(binary.BigEndian.Uint16(p.data[start+2*i:]))
+}
+
+func (p btreePage) rightChild() uint32 {
+	return binary.BigEndian.Uint32(p.data[p.header+8:])
+}
+
+// leafCell returns the rowid and the record of a cell of a table leaf page
+func (db *DB) leafCell(p btreePage, i int) (int64, []byte, error) {
+	pos := p.cell(i)
+	length, n := getVarint(p.data[pos:])
+	if n == 0 {
+		return 0, nil, ErrCorrupt
+	}
+	rowid, m := getVarint(p.data[pos+n:])
+	if m == 0 {
+		return 0, nil, ErrCorrupt
+	}
+	payload, err := db.payload(p.data, pos+n+m, length, db.usable-35)
+	return int64(rowid), payload, err
+}
+
+// interiorCell returns the left child and the key of a cell of an interior
+// table page
+func (p btreePage) interiorCell(i int) (uint32, int64, error) {
+	pos := p.cell(i)
+	key, n := getVarint(p.data[pos+4:])
+	if n == 0 {
+		return 0, 0, ErrCorrupt
+	}
+	return binary.BigEndian.Uint32(p.data[pos:]), int64(key), nil
+}
+
+// payload reads the payload of a cell, which continues on a chain of
+// overflow pages if it is longer than maxLocal
+func (db *DB) payload(page []byte, pos int, length uint64, maxLocal int) ([]byte, error) {
+	if length <= uint64(maxLocal) {
+		return page[pos : pos+int(length)], nil
+	}
+	if length > 1<<30 {
+		return nil, ErrCorrupt
+	}
+	local := localPayload(db.usable, int(length), maxLocal)
+	data := make([]byte, 0, length)
+	data = append(data, page[pos:pos+local]...)
+	next := binary.BigEndian.Uint32(page[pos+local:])
+	for len(data) < int(length) {
+		overflow, err := db.readPage(next)
+		if err != nil {
+			return nil, err
+		}
+		next = binary.BigEndian.Uint32(overflow)
+		n := int(length) - len(data)
+		if n > db.usable-4 {
+			n = db.usable - 4
+		}
+		data = append(data, overflow[4:4+n]...)
+	}
+	return data, nil
+}
+
+// localPayload returns how many bytes of a payload longer than maxLocal are
+// stored in its cell rather than on overflow pages
+func localPayload(usable, length, maxLocal int) int {
+	minLocal := (usable-12)*32/255 - 23
+	local := minLocal + (length-minLocal)%(usable-4)
+	if local > maxLocal {
+		return minLocal
+	}
+	return local
+}
+
+// scan reads the rows of the table b-tree rooted at a page
+func (db *DB) scan(root int, fn func(rowid int64, values []interface{}) error) error {
+	if root <= 0 {
+		return ErrCorrupt
+	}
+	return db.scanPage(uint32(root), 0, fn)
+}
+
+func (db *DB) scanPage(number uint32, depth int, fn func(rowid int64, values []interface{}) error) (err error) {
+	if depth > maxDepth {
+		return ErrCorrupt
+	}
+	p, err := db.readBtreePage(number)
+	if err != nil {
+		return err
+	}
+	var children []uint32
+	err = func() (err error) {
+		defer recoverCorrupt(&err)
+		switch p.pageType() {
+		case leafTablePage:
+			for i := 0; i < p.cellCount(); i++ {
+				rowid, payload, err := db.leafCell(p, i)
+				if err != nil {
+					return err
+				}
+				values, err := decodeRecord(payload)
+				if err != nil {
+					return err
+				}
+				if err := fn(rowid, values); err != nil {
+					return err
+				}
+			}
+		case interiorTablePage:
+			for i := 0; i < p.cellCount(); i++ {
+				child, _, err := p.interiorCell(i)
+				if err != nil {
+					return err
+				}
+				children = append(children, child)
+			}
+			children = append(children, p.rightChild())
+		default:
+			return ErrCorrupt
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := db.scanPage(child, depth+1, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find returns the record of a rowid in the table b-tree rooted at a page,
+// or nil if there is none
+func (db *DB) find(root int, rowid int64) (values []interface{}, err error) {
+	defer recoverCorrupt(&err)
+	if root <= 0 {
+		return nil, ErrCorrupt
+	}
+	number := uint32(root)
+	for depth := 0; depth <= maxDepth; depth++ {
+		p, err := db.readBtreePage(number)
+		if err != nil {
+			return nil, err
+		}
+		switch p.pageType() {
+		case leafTablePage:
+			// Cells are sorted by rowid
+			low, high := 0, p.cellCount()
+			for low < high {
+				mid := (low + high) / 2
+				_, n := getVarint(p.data[p.cell(mid):])
+				key, m := getVarint(p.data[p.cell(mid)+n:])
+				if n == 0 || m == 0 {
+					return nil, ErrCorrupt
+				}
+				switch {
+				case int64(key) == rowid:
+					_, payload, err := db.leafCell(p, mid)
+					if err != nil {
+						return nil, err
+					}
+					return decodeRecord(payload)
+				case int64(key) < rowid:
+					low = mid + 1
+				default:
+					high = mid
+				}
+			}
+			return nil, nil
+		case interiorTablePage:
+			// The key of a cell is the largest rowid of its left child
+			low, high := 0, p.cellCount()
+			for low < high {
+				mid := (low + high) / 2
+				_, key, err := p.interiorCell(mid)
+				if err != nil {
+					return nil, err
+				}
+				if key < rowid {
+					low = mid + 1
+				} else {
+					high = mid
+				}
+			}
+			if low == p.cellCount() {
+				number = p.rightChild()
+			} else {
+				number, _, _ = p.interiorCell(low)
+			}
+		default:
+			return nil, ErrCorrupt
+		}
+	}
+	return nil, ErrCorrupt
+}
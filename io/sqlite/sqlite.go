@@ -0,0 +1,570 @@
+// Package sqlite reads and writes the tables of SQLite 3 database files
+// directly in the file format, without a SQL engine or cgo. It reads rowid
+// tables, and writes new databases of rowid tables along with the indexes
+// that their PRIMARY KEY and UNIQUE constraints require.
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Magic starts every SQLite 3 database file
+const Magic = "SQLite format 3\x00"
+
+// headerLength is the length of the database header at the start of page 1
+const headerLength = 100
+
+// B-tree page types
+const (
+	interiorIndexPage = 2
+	interiorTablePage = 5
+	leafIndexPage     = 10
+	leafTablePage     = 13
+)
+
+// ErrCorrupt is returned for a database whose pages or records are malformed
+var ErrCorrupt = errors.New("malformed SQLite database")
+
+// Object is an entry of the schema table
+type Object struct {
+	// Type is "table", "index", "view" or "trigger"
+	Type      string
+	Name      string
+	TableName string
+	// RootPage is the first page of the b-tree of a table or index, or 0 for
+	// views, triggers and virtual tables
+	RootPage int
+	SQL      string
+}
+
+// Column is a column of a table, as declared in its CREATE TABLE statement
+type Column struct {
+	Name string
+	// Type is the declared type, e.g. "INTEGER" or "VARCHAR(20)", or empty
+	Type string
+	// RowID tells whether the column is an INTEGER PRIMARY KEY, which is
+	// stored as the rowid of each row
+	RowID bool
+}
+
+// tableSchema is what the statement creating a table declares
+type tableSchema struct {
+	columns []Column
+	// unique lists the columns of each PRIMARY KEY or UNIQUE constraint that
+	// SQLite implements with an automatic index, in declaration order
+	unique       [][]int
+	withoutRowID bool
+}
+
+// parseTable parses a CREATE TABLE statement. Only the names, types and key
+// constraints of columns are read.
+func parseTable(sql string) (*tableSchema, error) {
+	tokens := tokenize(sql)
+	start := 0
+	for start < len(tokens) && tokens[start] != "(" {
+		start++
+	}
+	if start == len(tokens) || !strings.EqualFold(tokens[0], "CREATE") {
+		return nil, fmt.Errorf("cannot parse %q", sql)
+	}
+	t := &tableSchema{}
+	depth, end := 0, start
+	var definitions [][]string
+	var definition []string
+	for end = start + 1; end < len(tokens); end++ {
+		token := tokens[end]
+		if token == "(" {
+			depth++
+		} else if token == ")" {
+			if depth == 0 {
+				break
+			}
+			depth--
+		} else if token == "," && depth == 0 {
+			definitions = append(definitions, definition)
+			definition = nil
+			continue
+		}
+		definition = append(definition, token)
+	}
+	definitions = append(definitions, definition)
+	for _, token := range tokens[end:] {
+		if strings.EqualFold(token, "ROWID") {
+			t.withoutRowID = true
+		}
+	}
+
+	var primaryKey []int
+	for _, def := range definitions {
+		if len(def) == 0 {
+			return nil, fmt.Errorf("cannot parse %q", sql)
+		}
+		keyword := strings.ToUpper(def[0])
+		if keyword == "CONSTRAINT" && len(def) > 2 {
+			def = def[2:]
+			keyword = strings.ToUpper(def[0])
+		}
+		switch keyword {
+		case "PRIMARY", "UNIQUE":
+			var key []int
+			for _, name := range parenthesized(def) {
+				i := t.column(name)
+				if i < 0 {
+					return nil, fmt.Errorf("no column %s for constraint in %q", name, sql)
+				}
+				key = append(key, i)
+			}
+			if keyword == "PRIMARY" {
+				primaryKey = key
+			}
+			t.unique = append(t.unique, key)
+			continue
+		case "CHECK", "FOREIGN":
+			continue
+		}
+
+		column := Column{Name: unquote(def[0])}
+		i := 1
+		for ; i < len(def) && !isConstraint(def[i]); i++ {
+			if column.Type != "" && def[i] != "(" && def[i] != ")" && def[i] != "," && !strings.HasSuffix(column.Type, "(") {
+				column.Type += " "
+			}
+			column.Type += def[i]
+		}
+		for ; i < len(def); i++ {
+			switch strings.ToUpper(def[i]) {
+			case "PRIMARY":
+				primaryKey = []int{len(t.columns)}
+				t.unique = append(t.unique, primaryKey)
+			case "UNIQUE":
+				t.unique = append(t.unique, []int{len(t.columns)})
+			case "DEFAULT", "CHECK", "COLLATE", "REFERENCES", "AS":
+				// Skip the expression or name that follows
+				i = skipOperand(def, i+1)
+			}
+		}
+		t.columns = append(t.columns, column)
+	}
+
+	// An INTEGER PRIMARY KEY is an alias for the rowid, rather than an index
+	if len(primaryKey) == 1 && !t.withoutRowID && strings.EqualFold(t.columns[primaryKey[0]].Type, "INTEGER") {
+		t.columns[primaryKey[0]].RowID = true
+		for i, key := range t.unique {
+			if len(key) == 1 && key[0] == primaryKey[0] {
+				t.unique = append(t.unique[:i], t.unique[i+1:]...)
+				break
+			}
+		}
+	}
+	// Constraints on the same columns share an index
+	var unique [][]int
+	for _, key := range t.unique {
+		duplicate := false
+		for _, other := range unique {
+			duplicate = duplicate || sameKey(key, other)
+		}
+		if !duplicate {
+			unique = append(unique, key)
+		}
+	}
+	t.unique = unique
+	return t, nil
+}
+
+func (t *tableSchema) column(name string) int {
+	for i, column := range t.columns {
+		if strings.EqualFold(column.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// rowIDColumn returns the index of the INTEGER PRIMARY KEY column, or -1
+func (t *tableSchema) rowIDColumn() int {
+	for i, column := range t.columns {
+		if column.RowID {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameKey(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isConstraint tells whether a token starts a column constraint, which ends
+// the type of the column
+func isConstraint(token string) bool {
+	switch strings.ToUpper(token) {
+	case "CONSTRAINT", "PRIMARY", "NOT", "NULL", "UNIQUE", "CHECK", "DEFAULT", "COLLATE", "REFERENCES", "GENERATED", "AS":
+		return true
+	}
+	return false
+}
+
+// skipOperand returns the index of the last token of the operand starting at
+// i, which is a single token or a parenthesized expression
+func skipOperand(tokens []string, i int) int {
+	if i >= len(tokens) {
+		return i
+	}
+	if tokens[i] == "-" || tokens[i] == "+" {
+		i++
+	}
+	if i >= len(tokens) || tokens[i] != "(" {
+		return i
+	}
+	for depth := 0; i < len(tokens); i++ {
+		if tokens[i] == "(" {
+			depth++
+		} else if tokens[i] == ")" {
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return i
+}
+
+// parenthesized returns the column names of the first parenthesized list of
+// a table constraint, without their COLLATE and ASC or DESC clauses
+func parenthesized(tokens []string) []string {
+	var names []string
+	i := 0
+	for i < len(tokens) && tokens[i] != "(" {
+		i++
+	}
+	expectName := true
+	for i++; i < len(tokens) && tokens[i] != ")"; i++ {
+		if tokens[i] == "," {
+			expectName = true
+		} else if expectName {
+			names = append(names, unquote(tokens[i]))
+			expectName = false
+		}
+	}
+	return names
+}
+
+// tokenize splits SQL into identifiers, keywords, literals and punctuation,
+// dropping comments
+func tokenize(sql string) []string {
+	var tokens []string
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case c == '"' || c == '\'' || c == '`' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			j := i + 1
+			for j < len(sql) {
+				if sql[j] == closing {
+					// A doubled quote stands for itself
+					if closing != ']' && j+1 < len(sql) && sql[j+1] == closing {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			if j < len(sql) {
+				j++
+			}
+			tokens = append(tokens, sql[i:j])
+			i = j
+		case isIdentifierByte(c):
+			j := i
+			for j < len(sql) && isIdentifierByte(sql[j]) {
+				j++
+			}
+			tokens = append(tokens, sql[i:j])
+			i = j
+		default:
+			tokens = append(tokens, sql[i:i+1])
+			i++
+		}
+	}
+	return tokens
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || c == '.' || c >= 0x80 ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// unquote returns the name of a quoted identifier
+func unquote(token string) string {
+	if len(token) < 2 {
+		return token
+	}
+	switch token[0] {
+	case '"', '`', '\'':
+		q := token[:1]
+		return strings.Replace(token[1:len(token)-1], q+q, q, -1)
+	case '[':
+		return token[1 : len(token)-1]
+	}
+	return token
+}
+
+// QuoteIdentifier quotes a name for use in SQL
+func QuoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// getVarint decodes a variable-length integer, returning the number of bytes
+// it takes, or 0 if data ends first
+func getVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(data) && i < 9; i++ {
+		if i == 8 {
+			return v<<8 | uint64(data[i]), 9
+		}
+		v = v<<7 | uint64(data[i]&0x7f)
+		if data[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// appendVarint encodes a variable-length integer: seven bits per byte, most
+// significant first, and eight bits in the ninth byte
+func appendVarint(data []byte, v uint64) []byte {
+	if v >= 1<<56 {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(data, buf[:]...)
+	}
+	var buf [8]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		buf[i] = byte(v&0x7f) | 0x80
+	}
+	return append(data, buf[i:]...)
+}
+
+// decodeRecord decodes the values of a record: nil, int64, float64, string
+// or []byte
+func decodeRecord(data []byte) ([]interface{}, error) {
+	headerLength, n := getVarint(data)
+	if n == 0 || headerLength > uint64(len(data)) {
+		return nil, ErrCorrupt
+	}
+	var values []interface{}
+	body := data[headerLength:]
+	for pos := n; pos < int(headerLength); {
+		serialType, n := getVarint(data[pos:headerLength])
+		if n == 0 {
+			return nil, ErrCorrupt
+		}
+		pos += n
+		length := serialLength(serialType)
+		if length < 0 || length > len(body) {
+			return nil, ErrCorrupt
+		}
+		field := body[:length]
+		body = body[length:]
+		switch {
+		case serialType == 0:
+			values = append(values, nil)
+		case serialType <= 6:
+			// Big-endian two's complement integers of 1, 2, 3, 4, 6 or 8 bytes
+			v := int64(int8(field[0]))
+			for _, b := range field[1:] {
+				v = v<<8 | int64(b)
+			}
+			values = append(values, v)
+		case serialType == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(field)))
+		case serialType == 8 || serialType == 9:
+			values = append(values, int64(serialType-8))
+		case serialType%2 == 0:
+			values = append(values, append([]byte{}, field...))
+		default:
+			values = append(values, string(field))
+		}
+	}
+	return values, nil
+}
+
+// serialLength returns the length of the value of a serial type, or -1 for
+// the reserved types
+func serialLength(serialType uint64) int {
+	switch {
+	case serialType <= 4:
+		return int(serialType)
+	case serialType == 5:
+		return 6
+	case serialType == 6 || serialType == 7:
+		return 8
+	case serialType == 8 || serialType == 9:
+		return 0
+	case serialType >= 12 && serialType < 1<<32:
+		return int((serialType - 12) / 2)
+	}
+	return -1
+}
+
+// encodeRecord encodes values, which are nil, integers, bools, float64,
+// strings or []byte
+func encodeRecord(values []interface{}) []byte {
+	var header, body []byte
+	for _, value := range values {
+		switch v := value.(type) {
+		case nil:
+			header = append(header, 0)
+		case int:
+			header, body = appendInteger(header, body, int64(v))
+		case int64:
+			header, body = appendInteger(header, body, v)
+		case bool:
+			if v {
+				header = append(header, 9)
+			} else {
+				header = append(header, 8)
+			}
+		case float64:
+			header = append(header, 7)
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+			body = append(body, buf[:]...)
+		case string:
+			header = appendVarint(header, uint64(13+2*len(v)))
+			body = append(body, v...)
+		case []byte:
+			header = appendVarint(header, uint64(12+2*len(v)))
+			body = append(body, v...)
+		default:
+			panic(fmt.Sprintf("sqlite: cannot encode %T", value))
+		}
+	}
+	// The length of the header includes its own varint
+	length := len(header) + 1
+	if length > 127 {
+		length++
+		for len(appendVarint(nil, uint64(length))) != length-len(header) {
+			length++
+		}
+	}
+	record := appendVarint(make([]byte, 0, length+len(body)), uint64(length))
+	return append(append(record, header...), body...)
+}
+
+// appendInteger appends an integer with the shortest serial type that holds
+// it
+func appendInteger(header, body []byte, v int64) ([]byte, []byte) {
+	if v == 0 || v == 1 {
+		return append(header, byte(8+v)), body
+	}
+	serialType, length := byte(6), 8
+	for _, size := range []struct {
+		serialType byte
+		length     int
+	}{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}} {
+		limit := int64(1) << uint(8*size.length-1)
+		if -limit <= v && v < limit {
+			serialType, length = size.serialType, size.length
+			break
+		}
+	}
+	header = append(header, serialType)
+	for i := length - 1; i >= 0; i-- {
+		body = append(body, byte(v>>uint(8*i)))
+	}
+	return header, body
+}
+
+// compareValues orders values as SQLite indexes do with the BINARY
+// collation: NULL first, then numbers, text and blobs. Integers are int64.
+func compareValues(a, b interface{}) int {
+	if ra, rb := valueRank(a), valueRank(b); ra != rb {
+		return ra - rb
+	}
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case int64:
+		if b, ok := b.(int64); ok {
+			return compareIntegers(a, b)
+		}
+		return compareFloats(float64(a), b.(float64))
+	case float64:
+		if b, ok := b.(int64); ok {
+			return compareFloats(a, float64(b))
+		}
+		return compareFloats(a, b.(float64))
+	}
+	return 0
+}
+
+func valueRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	}
+	return 3
+}
+
+func compareIntegers(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
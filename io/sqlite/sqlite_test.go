@@ -0,0 +1,266 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVarint(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want string
+	}{
+		{0, "00"},
+		{1, "01"},
+		{127, "7f"},
+		{128, "8100"},
+		{240, "8170"},
+		{1<<14 - 1, "ff7f"},
+		{1 << 14, "818000"},
+		{1<<21 - 1, "ffff7f"},
+		{1 << 21, "81808000"},
+		{1<<28 - 1, "ffffff7f"},
+		{1 << 28, "8180808000"},
+		{1 << 35, "818080808000"},
+		{1 << 42, "81808080808000"},
+		{1 << 49, "8180808080808000"},
+		{1<<56 - 1, "ffffffffffffff7f"},
+		{1 << 56, "80c080808080808000"},
+		{1<<63 + 1, "c08080808080808001"},
+		{math.MaxUint64, "ffffffffffffffffff"},
+	}
+	for _, test := range tests {
+		data := appendVarint([]byte{0xaa}, test.v)[1:]
+		if got := hex.EncodeToString(data); got != test.want {
+			t.Errorf("%d: got %s, want %s", test.v, got, test.want)
+		}
+		// Bytes after the varint are not read, even with the high bit set
+		v, n := getVarint(append(data, 0xff, 0xff))
+		if v != test.v || n != len(data) {
+			t.Errorf("%s: got %d of %d bytes, want %d", test.want, v, n, test.v)
+		}
+		for i := 0; i < len(data); i++ {
+			if _, n := getVarint(data[:i]); n != 0 {
+				t.Errorf("%s: decoded %d of %d bytes", test.want, i, len(data))
+			}
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		value      interface{}
+		serialType string
+		want       interface{}
+	}{
+		{nil, "00", nil},
+		{0, "08", int64(0)},
+		{int64(1), "09", int64(1)},
+		{false, "08", int64(0)},
+		{true, "09", int64(1)},
+		{2, "01", int64(2)},
+		{127, "01", int64(127)},
+		{-128, "01", int64(-128)},
+		{128, "02", int64(128)},
+		{-129, "02", int64(-129)},
+		{32767, "02", int64(32767)},
+		{32768, "03", int64(32768)},
+		{-1 << 23, "03", int64(-1 << 23)},
+		{1 << 23, "04", int64(1 << 23)},
+		{int64(math.MaxInt32), "04", int64(math.MaxInt32)},
+		{int64(math.MaxInt32) + 1, "05", int64(math.MaxInt32) + 1},
+		{int64(-1 << 47), "05", int64(-1 << 47)},
+		{int64(1 << 47), "06", int64(1 << 47)},
+		{int64(math.MaxInt64), "06", int64(math.MaxInt64)},
+		{int64(math.MinInt64), "06", int64(math.MinInt64)},
+		{1.5, "07", 1.5},
+		{math.Inf(-1), "07", math.Inf(-1)},
+		{"", "0d", ""},
+		{"abc", "13", "abc"},
+		{strings.Repeat("x", 57), "7f", strings.Repeat("x", 57)},
+		{strings.Repeat("x", 58), "8101", strings.Repeat("x", 58)},
+		{[]byte{}, "0c", []byte{}},
+		{[]byte{0, 1}, "10", []byte{0, 1}},
+		{make([]byte, 58), "8100", make([]byte, 58)},
+	}
+	for _, test := range tests {
+		record := encodeRecord([]interface{}{test.value})
+		header := hex.EncodeToString(record[1:record[0]])
+		if header != test.serialType {
+			t.Errorf("%#v: got serial type %s, want %s", test.value, header, test.serialType)
+		}
+		values, err := decodeRecord(record)
+		if err != nil {
+			t.Errorf("%#v: %v", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(values, []interface{}{test.want}) {
+			t.Errorf("%#v: got %#v", test.value, values)
+		}
+	}
+}
+
+func TestRecordHeaderLength(t *testing.T) {
+	// The length of the header counts its own varint, which takes a second
+	// byte from 127 columns on
+	for n := 120; n < 140; n++ {
+		values := make([]interface{}, n)
+		values[n-1] = "last"
+		record := encodeRecord(values)
+		length, size := getVarint(record)
+		if want := 1 + n; n >= 127 {
+			if size != 2 || int(length) != want+1 {
+				t.Errorf("%d values: header of %d bytes with a %d-byte length", n, length, size)
+			}
+		} else if size != 1 || int(length) != want {
+			t.Errorf("%d values: header of %d bytes with a %d-byte length", n, length, size)
+		}
+		got, err := decodeRecord(record)
+		if err != nil || !reflect.DeepEqual(got, values) {
+			t.Errorf("%d values: got %d values, %v", n, len(got), err)
+		}
+	}
+}
+
+func TestRecordMalformed(t *testing.T) {
+	tests := []string{
+		// Reserved serial types
+		"020a",
+		"020b",
+		// A header longer than the record
+		"05",
+		// A serial type cut short by the end of the header
+		"0281",
+		// Values longer than the body
+		"0206",
+		"021101",
+	}
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test)
+		if _, err := decodeRecord(data); err != ErrCorrupt {
+			t.Errorf("%s: got %v, want %v", test, err, ErrCorrupt)
+		}
+	}
+	record := encodeRecord([]interface{}{int64(1 << 40), "text", []byte{1, 2, 3}, 2.5})
+	for n := 0; n < len(record); n++ {
+		if _, err := decodeRecord(record[:n]); err == nil {
+			t.Errorf("decoded %d of %d bytes", n, len(record))
+		}
+	}
+}
+
+func TestSerialLength(t *testing.T) {
+	tests := []struct {
+		serialType uint64
+		want       int
+	}{
+		{0, 0}, {1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}, {6, 8}, {7, 8}, {8, 0}, {9, 0},
+		{10, -1}, {11, -1}, {12, 0}, {13, 0}, {14, 1}, {15, 1}, {1<<32 - 1, 1<<31 - 7},
+		{1 << 32, -1}, {math.MaxUint64, -1},
+	}
+	for _, test := range tests {
+		if got := serialLength(test.serialType); got != test.want {
+			t.Errorf("%d: got %d, want %d", test.serialType, got, test.want)
+		}
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	// In the order of an index
+	values := []interface{}{nil, math.Inf(-1), int64(math.MinInt64), int64(-1), -0.5, int64(0), 0.5, int64(1), 1.5, int64(math.MaxInt64), "", "a", "b", []byte{}, []byte{0}}
+	for i, a := range values {
+		for j, b := range values {
+			got := compareValues(a, b)
+			if (i < j && got >= 0) || (i == j && got != 0) || (i > j && got <= 0) {
+				t.Errorf("%#v and %#v: got %d", a, b, got)
+			}
+		}
+	}
+	if compareValues(int64(2), 2.0) != 0 {
+		t.Error("2 and 2.0 differ")
+	}
+}
+
+func TestParseTable(t *testing.T) {
+	tests := []struct {
+		sql     string
+		columns []Column
+		unique  [][]int
+		noRowID bool
+	}{
+		{
+			sql:     "CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)",
+			columns: []Column{{"id", "INTEGER", true}, {"name", "TEXT", false}},
+		},
+		{
+			sql:     `CREATE TABLE "a b" ("x ""y""" VARCHAR(20) NOT NULL DEFAULT 'a,b', [z] DOUBLE PRECISION UNIQUE, w)`,
+			columns: []Column{{`x "y"`, "VARCHAR(20)", false}, {"z", "DOUBLE PRECISION", false}, {"w", "", false}},
+			unique:  [][]int{{1}},
+		},
+		{
+			sql:     "CREATE TABLE t (id INT PRIMARY KEY, code TEXT, CONSTRAINT u UNIQUE (id), UNIQUE (code, id))",
+			columns: []Column{{"id", "INT", false}, {"code", "TEXT", false}},
+			unique:  [][]int{{0}, {1, 0}},
+		},
+		{
+			sql:     "CREATE TABLE t (a INTEGER, b INTEGER, PRIMARY KEY (a))",
+			columns: []Column{{"a", "INTEGER", true}, {"b", "INTEGER", false}},
+		},
+		{
+			sql:     "CREATE TABLE t (key TEXT PRIMARY KEY, value) WITHOUT ROWID",
+			columns: []Column{{"key", "TEXT", false}, {"value", "", false}},
+			unique:  [][]int{{0}},
+			noRowID: true,
+		},
+		{
+			sql:     "CREATE TABLE t (id INTEGER PRIMARY KEY, v INTEGER) WITHOUT ROWID",
+			columns: []Column{{"id", "INTEGER", false}, {"v", "INTEGER", false}},
+			unique:  [][]int{{0}},
+			noRowID: true,
+		},
+	}
+	for _, test := range tests {
+		schema, err := parseTable(test.sql)
+		if err != nil {
+			t.Errorf("%s: %v", test.sql, err)
+			continue
+		}
+		if !reflect.DeepEqual(schema.columns, test.columns) || !reflect.DeepEqual(schema.unique, test.unique) || schema.withoutRowID != test.noRowID {
+			t.Errorf("%s: got %+v", test.sql, *schema)
+		}
+	}
+}
+
+func TestOpenMalformed(t *testing.T) {
+	file := &memFile{}
+	w, _ := NewWriter(file)
+	table, _ := w.CreateTable("t", "CREATE TABLE t (v)")
+	for i := int64(1); i <= 500; i++ {
+		table.Insert(i, strings.Repeat("x", int(i)))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := file.data
+	if _, err := Open(bytes.NewReader(data[:50])); err == nil {
+		t.Error("opened a truncated header")
+	}
+	// Whatever the bytes of the pages after the first, reading fails with an
+	// error rather than a panic
+	for _, fill := range []byte{0, 0xff, 0x0d, 0x05} {
+		corrupt := append([]byte(nil), data...)
+		for i := pageSize; i < len(corrupt); i += 97 {
+			corrupt[i] = fill
+		}
+		db, err := Open(bytes.NewReader(corrupt))
+		if err != nil {
+			continue
+		}
+		db.Scan("t", func(rowid int64, values []interface{}) error { return nil })
+		db.Row("t", 250)
+	}
+}
@@ -0,0 +1,499 @@
+package sqlite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// pageSize is the size of the pages of the databases that are written
+const pageSize = 4096
+
+// lockBytePage is the page holding the bytes that SQLite locks at 1 GiB into
+// the file, which must not be used
+const lockBytePage = 1<<30/pageSize + 1
+
+// sqliteVersion is written in the header as the version of SQLite that last
+// wrote the file
+const sqliteVersion = 3008000
+
+// schemaSQL declares the schema table, which is stored on page 1
+const schemaSQL = "CREATE TABLE sqlite_schema(type text,name text,tbl_name text,rootpage int,sql text)"
+
+// Writer writes a new database. The rows of each table are written as they
+// are inserted, while the keys of the indexes that table constraints require
+// are kept in memory until Close writes them along with the schema.
+type Writer struct {
+	file    io.WriterAt
+	pages   uint32
+	objects []Object
+	tables  []*TableWriter
+
+	// ApplicationID identifies the format of the database, in the header
+	ApplicationID uint32
+
+	// UserVersion is the version of that format, in the header
+	UserVersion uint32
+}
+
+// NewWriter starts a database. file should be empty, since the pages written
+// do not cover any other content.
+func NewWriter(file io.WriterAt) (*Writer, error) {
+	// Page 1 is written last, with the schema
+	return &Writer{file: file, pages: 1}, nil
+}
+
+// TableWriter appends rows to a table
+type TableWriter struct {
+	w       *Writer
+	name    string
+	schema  *tableSchema
+	object  int
+	indexes []*autoIndex
+
+	// reserve is the room kept free on each leaf, so that the first one
+	// can be written to page 1 after the database header
+	reserve int
+
+	// cells are those of the leaf being filled
+	cells [][]byte
+	size  int
+	// children are the leaves written so far with their last rowid
+	children []pageKey
+	rows     int
+	last     int64
+}
+
+// pageKey is a child page of an interior table page, with the largest rowid
+// it holds
+type pageKey struct {
+	page uint32
+	key  int64
+}
+
+// autoIndex is an index that a PRIMARY KEY or UNIQUE constraint requires
+type autoIndex struct {
+	object  int
+	columns []int
+	entries [][]interface{}
+}
+
+// CreateTable adds a table to the schema and returns a writer of its rows.
+// sql is the CREATE TABLE statement, which is stored as is.
+func (w *Writer) CreateTable(name, sql string) (*TableWriter, error) {
+	schema, err := parseTable(sql)
+	if err != nil {
+		return nil, err
+	}
+	if schema.withoutRowID {
+		return nil, fmt.Errorf("cannot write WITHOUT ROWID table %s", name)
+	}
+	t := &TableWriter{w: w, name: name, schema: schema, object: len(w.objects)}
+	w.objects = append(w.objects, Object{Type: "table", Name: name, TableName: name, SQL: sql})
+	for i, columns := range schema.unique {
+		t.indexes = append(t.indexes, &autoIndex{object: len(w.objects), columns: columns})
+		w.objects = append(w.objects, Object{Type: "index", Name: fmt.Sprintf("sqlite_autoindex_%s_%d", name, i+1), TableName: name})
+	}
+	w.tables = append(w.tables, t)
+	return t, nil
+}
+
+// Redefine replaces the CREATE TABLE statement of a table as ALTER TABLE ADD
+// COLUMN does: the rows already written are NULL in the columns that the new
+// statement adds after theirs. The INTEGER PRIMARY KEY and the constraints
+// that require indexes cannot change.
+func (t *TableWriter) Redefine(sql string) error {
+	schema, err := parseTable(sql)
+	if err != nil {
+		return err
+	}
+	if schema.withoutRowID || schema.rowIDColumn() != t.schema.rowIDColumn() || len(schema.unique) != len(t.schema.unique) {
+		return fmt.Errorf("cannot change the keys of table %s", t.name)
+	}
+	if len(schema.columns) < len(t.schema.columns) {
+		return fmt.Errorf("cannot remove columns of table %s", t.name)
+	}
+	t.schema = schema
+	t.w.objects[t.object].SQL = sql
+	return nil
+}
+
+// AddObject adds an entry without a b-tree to the schema, such as a trigger
+// or a virtual table, whose shadow tables are created separately
+func (w *Writer) AddObject(objectType, name, tableName, sql string) {
+	w.objects = append(w.objects, Object{Type: objectType, Name: name, TableName: tableName, SQL: sql})
+}
+
+// Insert appends a row, whose rowid must be greater than that of the previous
+// row. Values are nil, integers, bools, float64, strings or []byte, and the
+// value of the INTEGER PRIMARY KEY column, if any, is replaced by the rowid.
+// Trailing columns may be left out, and are then NULL.
+func (t *TableWriter) Insert(rowid int64, values ...interface{}) error {
+	if t.rows > 0 && rowid <= t.last {
+		return fmt.Errorf("rowid %d of table %s is not greater than the previous one", rowid, t.name)
+	}
+	if len(values) > len(t.schema.columns) {
+		return fmt.Errorf("%d values for the %d columns of table %s", len(values), len(t.schema.columns), t.name)
+	}
+	// SQLite reports records without values as corrupt
+	if len(values) == 0 {
+		values = []interface{}{nil}
+	}
+	if i := t.schema.rowIDColumn(); i >= 0 && i < len(values) {
+		values = append([]interface{}(nil), values...)
+		values[i] = nil
+	}
+	for _, index := range t.indexes {
+		key := make([]interface{}, len(index.columns)+1)
+		for i, column := range index.columns {
+			if column < len(values) {
+				key[i] = indexValue(values[column])
+			}
+		}
+		key[len(index.columns)] = rowid
+		index.entries = append(index.entries, key)
+	}
+
+	record := encodeRecord(values)
+	cell := appendVarint(appendVarint(nil, uint64(len(record))), uint64(rowid))
+	cell, err := t.w.payloadCell(cell, record, pageSize-35)
+	if err != nil {
+		return err
+	}
+	if !fits(len(t.cells), t.size, len(cell), t.reserve+8) {
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+	t.cells = append(t.cells, cell)
+	t.size += len(cell)
+	t.rows++
+	t.last = rowid
+	return nil
+}
+
+// indexValue converts a value to the type it is read back as
+func indexValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	}
+	return value
+}
+
+// fits tells whether a page whose header ends at offset, holding n cells of
+// size bytes, has room for another cell
+func fits(n, size, cell, offset int) bool {
+	return offset+2*(n+1)+size+cell <= pageSize
+}
+
+// flush writes the leaf being filled
+func (t *TableWriter) flush() error {
+	number := t.w.allocate()
+	if err := t.w.writePage(number, buildPage(0, leafTablePage, t.cells, 0)); err != nil {
+		return err
+	}
+	t.children = append(t.children, pageKey{number, t.last})
+	t.cells, t.size = nil, 0
+	return nil
+}
+
+// finish writes the rest of the table and returns its root page. If root is
+// not 0, the root is written to that page, which is page 1.
+func (t *TableWriter) finish(root uint32) (uint32, error) {
+	if root != 0 && len(t.children) == 0 {
+		return root, t.w.writePage(root, buildPage(headerLength, leafTablePage, t.cells, 0))
+	}
+	if len(t.cells) > 0 || len(t.children) == 0 {
+		if err := t.flush(); err != nil {
+			return 0, err
+		}
+	}
+	children := t.children
+	for {
+		offset := 0
+		if root != 0 {
+			offset = headerLength
+		}
+		// An interior cell is a 4-byte page number and a varint of at most 9
+		// bytes, with its 2-byte pointer
+		capacity := (pageSize-offset-12)/15 + 1
+		if root == 0 && len(children) == 1 {
+			return children[0].page, nil
+		}
+		if root != 0 && len(children) <= capacity {
+			return root, t.w.writePage(root, interiorTablePageOf(offset, children))
+		}
+		// Children are spread evenly, so that every page has at least one cell
+		capacity = (pageSize-12)/15 + 1
+		pages := (len(children) + capacity - 1) / capacity
+		var parents []pageKey
+		for i := 0; i < pages; i++ {
+			group := children[i*len(children)/pages : (i+1)*len(children)/pages]
+			number := t.w.allocate()
+			if err := t.w.writePage(number, interiorTablePageOf(0, group)); err != nil {
+				return 0, err
+			}
+			parents = append(parents, pageKey{number, group[len(group)-1].key})
+		}
+		children = parents
+	}
+}
+
+func interiorTablePageOf(offset int, children []pageKey) []byte {
+	cells := make([][]byte, len(children)-1)
+	for i, child := range children[:len(children)-1] {
+		cells[i] = appendVarint(appendUint32(nil, child.page), uint64(child.key))
+	}
+	return buildPage(offset, interiorTablePage, cells, children[len(children)-1].page)
+}
+
+// buildPage lays out a b-tree page whose header starts at offset, with its
+// cells at the end of the page
+func buildPage(offset int, pageType byte, cells [][]byte, rightChild uint32) []byte {
+	page := make([]byte, pageSize)
+	page[offset] = pageType
+	binary.BigEndian.PutUint16(page[offset+3:], uint16(len(cells)))
+	pointers := offset + 8
+	if pageType == interiorTablePage || pageType == interiorIndexPage {
+		binary.BigEndian.PutUint32(page[offset+8:], rightChild)
+		pointers += 4
+	}
+	content := pageSize
+	for i, cell := range cells {
+		content -= len(cell)
+		copy(page[content:], cell)
+		binary.BigEndian.PutUint16(page[pointers+2*i:], uint16(content))
+	}
+	binary.BigEndian.PutUint16(page[offset+5:], uint16(content))
+	return page
+}
+
+// payloadCell appends a payload to the start of a cell, and writes the part
+// of it that does not fit in the cell to overflow pages
+func (w *Writer) payloadCell(cell []byte, payload []byte, maxLocal int) ([]byte, error) {
+	if len(payload) <= maxLocal {
+		return append(cell, payload...), nil
+	}
+	local := localPayload(pageSize, len(payload), maxLocal)
+	cell = append(cell, payload[:local]...)
+	rest := payload[local:]
+	number := w.allocate()
+	cell = appendUint32(cell, number)
+	for len(rest) > 0 {
+		page := make([]byte, pageSize)
+		n := copy(page[4:], rest)
+		rest = rest[n:]
+		var next uint32
+		if len(rest) > 0 {
+			next = w.allocate()
+		}
+		binary.BigEndian.PutUint32(page, next)
+		if err := w.writePage(number, page); err != nil {
+			return nil, err
+		}
+		number = next
+	}
+	return cell, nil
+}
+
+func appendUint32(data []byte, v uint32) []byte {
+	return append(data, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *Writer) allocate() uint32 {
+	w.pages++
+	if w.pages == lockBytePage {
+		w.pages++
+	}
+	return w.pages
+}
+
+func (w *Writer) writePage(number uint32, page []byte) error {
+	_, err := w.file.WriteAt(page, int64(number-1)*pageSize)
+	return err
+}
+
+// writeIndex writes the b-tree of an index and returns its root page. Unlike
+// those of tables, the interior pages of index b-trees hold entries: the one
+// between two pages moves up to their parent.
+func (w *Writer) writeIndex(t *TableWriter, index *autoIndex) (uint32, error) {
+	entries := index.entries
+	sort.Slice(entries, func(i, j int) bool { return compareKeys(entries[i], entries[j]) < 0 })
+	n := len(index.columns)
+	for i := 1; i < len(entries); i++ {
+		if compareKeys(entries[i-1][:n], entries[i][:n]) == 0 && !hasNull(entries[i][:n]) {
+			names := make([]string, n)
+			for j, column := range index.columns {
+				names[j] = t.name + "." + t.schema.columns[column].Name
+			}
+			return 0, fmt.Errorf("UNIQUE constraint failed: %s", strings.Join(names, ", "))
+		}
+	}
+
+	maxLocal := (pageSize-12)*64/255 - 23
+	var children []uint32
+	var separators [][]byte
+	var cells [][]byte
+	size := 0
+	writeLeaf := func() error {
+		number := w.allocate()
+		children = append(children, number)
+		return w.writePage(number, buildPage(0, leafIndexPage, cells, 0))
+	}
+	for i, entry := range entries {
+		record := encodeRecord(entry)
+		cell, err := w.payloadCell(appendVarint(nil, uint64(len(record))), record, maxLocal)
+		if err != nil {
+			return 0, err
+		}
+		if fits(len(cells), size, len(cell), 8) {
+			cells = append(cells, cell)
+			size += len(cell)
+			continue
+		}
+		// The last leaf must not be empty, so the entry that separates it
+		// from the previous one is taken from that one
+		separator := cell
+		if i == len(entries)-1 {
+			separator = cells[len(cells)-1]
+			cells = cells[:len(cells)-1]
+		}
+		if err := writeLeaf(); err != nil {
+			return 0, err
+		}
+		separators = append(separators, separator)
+		cells, size = nil, 0
+		if i == len(entries)-1 {
+			cells, size = [][]byte{cell}, len(cell)
+		}
+	}
+	if err := writeLeaf(); err != nil {
+		return 0, err
+	}
+
+	for len(children) > 1 {
+		var parents []uint32
+		var up [][]byte
+		cells, size = nil, 0
+		right := children[0]
+		writeInterior := func() error {
+			number := w.allocate()
+			parents = append(parents, number)
+			return w.writePage(number, buildPage(0, interiorIndexPage, cells, right))
+		}
+		for i, separator := range separators {
+			cell := append(appendUint32(nil, right), separator...)
+			if fits(len(cells), size, len(cell), 12) {
+				cells = append(cells, cell)
+				size += len(cell)
+				right = children[i+1]
+				continue
+			}
+			if i == len(separators)-1 {
+				last := cells[len(cells)-1]
+				cells = cells[:len(cells)-1]
+				right = binary.BigEndian.Uint32(last)
+				if err := writeInterior(); err != nil {
+					return 0, err
+				}
+				up = append(up, last[4:])
+				cell = append(appendUint32(nil, children[i]), separator...)
+				cells, size = [][]byte{cell}, len(cell)
+			} else {
+				if err := writeInterior(); err != nil {
+					return 0, err
+				}
+				up = append(up, separator)
+				cells, size = nil, 0
+			}
+			right = children[i+1]
+		}
+		if err := writeInterior(); err != nil {
+			return 0, err
+		}
+		children, separators = parents, up
+	}
+	return children[0], nil
+}
+
+func compareKeys(a, b []interface{}) int {
+	for i := range a {
+		if c := compareValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func hasNull(values []interface{}) bool {
+	for _, v := range values {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Close writes the indexes, the schema and the header. It does not close the
+// file.
+func (w *Writer) Close() error {
+	for _, t := range w.tables {
+		root, err := t.finish(0)
+		if err != nil {
+			return err
+		}
+		w.objects[t.object].RootPage = int(root)
+		for _, index := range t.indexes {
+			root, err := w.writeIndex(t, index)
+			if err != nil {
+				return err
+			}
+			w.objects[index.object].RootPage = int(root)
+			index.entries = nil
+		}
+	}
+
+	parsed, _ := parseTable(schemaSQL)
+	schema := &TableWriter{w: w, name: "sqlite_schema", schema: parsed, reserve: headerLength}
+	for i, object := range w.objects {
+		var sql interface{}
+		if object.SQL != "" {
+			sql = object.SQL
+		}
+		if err := schema.Insert(int64(i+1), object.Type, object.Name, object.TableName, int64(object.RootPage), sql); err != nil {
+			return err
+		}
+	}
+	if _, err := schema.finish(1); err != nil {
+		return err
+	}
+
+	header := make([]byte, headerLength)
+	copy(header, Magic)
+	binary.BigEndian.PutUint16(header[16:], pageSize)
+	// File format versions, reserved bytes and payload fractions
+	copy(header[18:], []byte{1, 1, 0, 64, 32, 32})
+	// The change counter, equal to the version-valid-for number below, makes
+	// the page count valid
+	binary.BigEndian.PutUint32(header[24:], 1)
+	binary.BigEndian.PutUint32(header[28:], w.pages)
+	// Schema cookie and schema format
+	binary.BigEndian.PutUint32(header[40:], 1)
+	binary.BigEndian.PutUint32(header[44:], 4)
+	// UTF-8 text
+	binary.BigEndian.PutUint32(header[56:], 1)
+	binary.BigEndian.PutUint32(header[60:], w.UserVersion)
+	binary.BigEndian.PutUint32(header[68:], w.ApplicationID)
+	binary.BigEndian.PutUint32(header[92:], 1)
+	binary.BigEndian.PutUint32(header[96:], sqliteVersion)
+	_, err := w.file.WriteAt(header, 0)
+	return err
+}
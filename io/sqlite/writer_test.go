@@ -0,0 +1,290 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// memFile is a file in memory
+type memFile struct {
+	data []byte
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	return copy(f.data[off:], p), nil
+}
+
+// testRow is a row of a test table with its rowid
+type testRow struct {
+	rowid  int64
+	values []interface{}
+}
+
+// testTable is a table to write and read back
+type testTable struct {
+	name, sql string
+	rows      []testRow
+}
+
+// testTables returns tables of several levels of pages, both for the table
+// and for its unique indexes, and rows whose payloads are around the lengths
+// at which they overflow, for both table and index cells
+func testTables() []testTable {
+	many := testTable{
+		name: "many",
+		sql:  "CREATE TABLE many (id INTEGER PRIMARY KEY, name TEXT UNIQUE, value REAL, flag BOOLEAN)",
+	}
+	// About 140 rows fit on a leaf and 450 leaves under an interior page, so
+	// the table has three levels
+	for i := 0; i < 70000; i++ {
+		rowid := int64(3*i - 100)
+		if i == 69999 {
+			rowid = 1 << 40
+		}
+		many.rows = append(many.rows, testRow{rowid, []interface{}{nil, fmt.Sprintf("name %d", i*7919%70000), float64(i) / 4, i%2 == 0}})
+	}
+
+	blobs := testTable{
+		name: "blobs",
+		sql:  "CREATE TABLE blobs (id INTEGER PRIMARY KEY, data BLOB, key TEXT, UNIQUE (key))",
+	}
+	// The payloads of table cells overflow from 4062 bytes, and those of
+	// index cells from 1003
+	var lengths []int
+	for _, around := range []int{0, 1003, 4062, 4062 + 4092, 4062 + 3*4092, 489 + 4092, 489 + 2*4092} {
+		for n := around - 8; n <= around+8; n++ {
+			if n >= 0 {
+				lengths = append(lengths, n)
+			}
+		}
+	}
+	lengths = append(lengths, 100000, 1<<20)
+	for i, n := range lengths {
+		data := make([]byte, n)
+		for j := range data {
+			data[j] = byte(i + j)
+		}
+		key := fmt.Sprintf("%05d", i) + strings.Repeat("k", n%5000)
+		blobs.rows = append(blobs.rows, testRow{int64(i + 1), []interface{}{nil, data, key}})
+	}
+
+	// Trailing columns left out are NULL
+	short := testTable{
+		name: "short",
+		sql:  "CREATE TABLE short (a, b, c)",
+		rows: []testRow{{1, []interface{}{int64(1)}}, {2, nil}, {3, []interface{}{nil, "b", 2.5}}},
+	}
+	return []testTable{many, blobs, short}
+}
+
+// writeTestDatabase writes tables to a database in memory
+func writeTestDatabase(t *testing.T, tables []testTable) []byte {
+	file := &memFile{}
+	w, err := NewWriter(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		tw, err := w.CreateTable(table.name, table.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range table.rows {
+			if err := tw.Insert(row.rowid, row.values...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file.data
+}
+
+func TestWriter(t *testing.T) {
+	tables := testTables()
+	data := writeTestDatabase(t, tables)
+	db, err := Open(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, object := range db.Objects() {
+		names = append(names, object.Type+" "+object.Name)
+	}
+	want := []string{"table many", "index sqlite_autoindex_many_1", "table blobs", "index sqlite_autoindex_blobs_1", "table short"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got objects %v, want %v", names, want)
+	}
+
+	for _, table := range tables {
+		columns, _ := db.Columns(table.name)
+		var rows []testRow
+		err := db.Scan(table.name, func(rowid int64, values []interface{}) error {
+			rows = append(rows, testRow{rowid, values})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", table.name, err)
+		}
+		if len(rows) != len(table.rows) {
+			t.Fatalf("%s: read %d rows, want %d", table.name, len(rows), len(table.rows))
+		}
+		for i, row := range table.rows {
+			want := make([]interface{}, len(columns))
+			for j, v := range row.values {
+				want[j] = indexValue(v)
+			}
+			if columns[0].RowID {
+				want[0] = row.rowid
+			}
+			if rows[i].rowid != row.rowid || !reflect.DeepEqual(rows[i].values, want) {
+				t.Errorf("%s: row %d: got rowid %d with %.60v, want %d with %.60v", table.name, i, rows[i].rowid, rows[i].values, row.rowid, want)
+			}
+			if i%97 != 0 && i != len(table.rows)-1 {
+				continue
+			}
+			values, err := db.Row(table.name, row.rowid)
+			if err != nil || !reflect.DeepEqual(values, want) {
+				t.Errorf("%s: rowid %d: got %.60v, %v", table.name, row.rowid, values, err)
+			}
+		}
+	}
+	for _, rowid := range []int64{-101, -99, 0, 1, 209898, 1<<40 - 1, 1<<40 + 1} {
+		if values, err := db.Row("many", rowid); values != nil || err != nil {
+			t.Errorf("rowid %d: got %v, %v", rowid, values, err)
+		}
+	}
+}
+
+// treeDepth returns the number of levels of the b-tree rooted at a page,
+// following the leftmost children
+func treeDepth(t *testing.T, db *DB, root int) int {
+	number := uint32(root)
+	for depth := 1; ; depth++ {
+		p, err := db.readBtreePage(number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch p.pageType() {
+		case leafTablePage, leafIndexPage:
+			return depth
+		}
+		number = p.rightChild()
+		if p.cellCount() > 0 {
+			number = binary.BigEndian.Uint32(p.data[p.cell(0):])
+		}
+	}
+}
+
+func TestWriterDepth(t *testing.T) {
+	tables := testTables()
+	db, err := Open(bytes.NewReader(writeTestDatabase(t, tables[:1])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name  string
+		depth int
+	}{{"many", 3}, {"sqlite_autoindex_many_1", 3}} {
+		object, _ := db.Object(test.name)
+		if depth := treeDepth(t, db, object.RootPage); depth != test.depth {
+			t.Errorf("%s: %d levels, want %d", test.name, depth, test.depth)
+		}
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	w, _ := NewWriter(&memFile{})
+	if _, err := w.CreateTable("t", "CREATE TABLE t (k PRIMARY KEY) WITHOUT ROWID"); err == nil {
+		t.Error("created a WITHOUT ROWID table")
+	}
+	table, _ := w.CreateTable("t", "CREATE TABLE t (a UNIQUE, b)")
+	if err := table.Insert(2, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert(2, "y"); err == nil {
+		t.Error("inserted a rowid twice")
+	}
+	if err := table.Insert(3, "z", 1, 2); err == nil {
+		t.Error("inserted more values than columns")
+	}
+	// NULLs are distinct in UNIQUE indexes
+	for rowid := int64(3); rowid < 6; rowid++ {
+		table.Insert(rowid, nil, rowid)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, _ = NewWriter(&memFile{})
+	table, _ = w.CreateTable("t", "CREATE TABLE t (a UNIQUE, b)")
+	table.Insert(1, "x")
+	table.Insert(2, int64(5))
+	table.Insert(3, 5.0)
+	if err := w.Close(); err == nil || err.Error() != "UNIQUE constraint failed: t.a" {
+		t.Errorf("got %v, want a UNIQUE constraint error", err)
+	}
+}
+
+// TestWriterSQLite checks the databases that are written with the sqlite3
+// shell, if it is installed
+func TestWriterSQLite(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("no sqlite3 shell")
+	}
+	tables := testTables()
+	file, err := ioutil.TempFile("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := file.Write(writeTestDatabase(t, tables)); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []struct {
+		sql, want string
+	}{
+		{"PRAGMA integrity_check", "ok"},
+		{"SELECT count(*), min(id), max(id), sum(flag), count(DISTINCT name) FROM many", "70000|-100|1099511627776|35000|70000"},
+		{"SELECT id, value FROM many WHERE name = 'name 7919'", "-97|0.25"},
+		{"SELECT count(*), sum(length(data)), sum(length(key)) FROM blobs", fmt.Sprintf("%d|%d|%d", len(tables[1].rows), blobLength(tables[1]), keyLength(tables[1]))},
+		{"SELECT count(*) FROM blobs b WHERE (SELECT id FROM blobs WHERE key = b.key) = b.id", fmt.Sprint(len(tables[1].rows))},
+		{"SELECT a, b, c FROM short", "1||\n||\n|b|2.5"},
+	}
+	for _, query := range queries {
+		out, err := exec.Command("sqlite3", file.Name(), query.sql).CombinedOutput()
+		if got := strings.TrimSpace(string(out)); err != nil || got != query.want {
+			t.Errorf("%s: got %q, %v, want %q", query.sql, got, err, query.want)
+		}
+	}
+}
+
+func blobLength(table testTable) int {
+	n := 0
+	for _, row := range table.rows {
+		n += len(row.values[1].([]byte))
+	}
+	return n
+}
+
+func keyLength(table testTable) int {
+	n := 0
+	for _, row := range table.rows {
+		n += len(row.values[2].(string))
+	}
+	return n
+}
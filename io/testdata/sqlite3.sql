@@ -0,0 +1,124 @@
+-- Builds sqlite3.gpkg with the sqlite3 shell, for the tests of the GeoPackage
+-- reader against a database written by SQLite itself:
+--
+--   rm -f sqlite3.gpkg && sqlite3 sqlite3.gpkg < sqlite3.sql
+--
+-- Small pages make b-trees of several levels and long values overflow. The
+-- tables dropped along the way leave pages on the freelist, some of which the
+-- points table then reuses, and deleted rows leave free blocks in its pages.
+
+PRAGMA page_size = 512;
+PRAGMA application_id = 1196444487;
+PRAGMA user_version = 10200;
+
+CREATE TABLE gpkg_spatial_ref_sys (
+  srs_name TEXT NOT NULL,
+  srs_id INTEGER NOT NULL PRIMARY KEY,
+  organization TEXT NOT NULL,
+  organization_coordsys_id INTEGER NOT NULL,
+  definition  TEXT NOT NULL,
+  description TEXT
+);
+INSERT INTO gpkg_spatial_ref_sys VALUES
+  ('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', NULL),
+  ('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', NULL),
+  ('WGS 84 geodetic', 4326, 'EPSG', 4326, 'GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]]', NULL);
+
+CREATE TABLE gpkg_contents (
+  table_name TEXT NOT NULL PRIMARY KEY,
+  data_type TEXT NOT NULL,
+  identifier TEXT UNIQUE,
+  description TEXT DEFAULT '',
+  last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+  min_x DOUBLE,
+  min_y DOUBLE,
+  max_x DOUBLE,
+  max_y DOUBLE,
+  srs_id INTEGER
+);
+CREATE TABLE gpkg_geometry_columns (
+  table_name TEXT NOT NULL,
+  column_name TEXT NOT NULL,
+  geometry_type_name TEXT NOT NULL,
+  srs_id INTEGER NOT NULL,
+  z TINYINT NOT NULL,
+  m TINYINT NOT NULL,
+  CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name),
+  CONSTRAINT uk_gc_table_name UNIQUE (table_name)
+);
+CREATE TABLE gpkg_extensions (
+  table_name TEXT,
+  column_name TEXT,
+  extension_name TEXT NOT NULL,
+  definition TEXT NOT NULL,
+  scope TEXT NOT NULL,
+  CONSTRAINT ge_tce UNIQUE (table_name, column_name, extension_name)
+);
+
+CREATE TABLE scratch (id INTEGER PRIMARY KEY, data BLOB);
+WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 100)
+INSERT INTO scratch SELECT i, zeroblob(100) FROM n;
+DROP TABLE scratch;
+
+-- The IEEE 754 bits of the quarter degrees from -180 to 180, in hexadecimal,
+-- for the big-endian WKB of the points
+CREATE TEMP TABLE doubles (v REAL PRIMARY KEY, hex TEXT);
+WITH RECURSIVE
+  n(q) AS (SELECT 1 UNION ALL SELECT q + 1 FROM n WHERE q < 720),
+  e(q, e) AS (SELECT q, (q >= 2) + (q >= 4) + (q >= 8) + (q >= 16) + (q >= 32) + (q >= 64) + (q >= 128) + (q >= 256) + (q >= 512) FROM n),
+  bits(q, b) AS (SELECT q, ((1021 + e) << 52) + ((q - (1 << e)) << (52 - e)) FROM e)
+INSERT INTO doubles
+  SELECT q / 4.0, printf('%016X', b) FROM bits
+  UNION ALL SELECT -q / 4.0, printf('%016X', b - 9223372036854775807 - 1) FROM bits
+  UNION ALL SELECT 0.0, '0000000000000000';
+
+CREATE TABLE points (
+  fid INTEGER PRIMARY KEY AUTOINCREMENT,
+  geom POINT,
+  name TEXT NOT NULL,
+  code TEXT UNIQUE,
+  value REAL,
+  note TEXT
+);
+CREATE INDEX points_name ON points (name);
+WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 600)
+INSERT INTO points (fid, geom, name, code, value, note)
+  SELECT i,
+    unhex('475000000000' || '10E6' || '0000000001' || x.hex || y.hex),
+    'point ' || (i % 37),
+    CASE WHEN i % 3 <> 0 THEN 'P' || i END,
+    CASE WHEN i % 5 <> 0 THEN i / 2.0 END,
+    CASE WHEN i % 100 = 1 THEN i || ':' || hex(zeroblob(300 * (i / 100 + 1))) ELSE 'short ' || i END
+  FROM n
+  JOIN doubles x ON x.v = ((i * 37) % 1440) / 4.0 - 180
+  JOIN doubles y ON y.v = ((i * 53) % 720) / 4.0 - 90;
+DELETE FROM points WHERE fid % 7 = 0;
+
+CREATE VIRTUAL TABLE rtree_points_geom USING rtree (id, minx, maxx, miny, maxy);
+INSERT INTO rtree_points_geom
+  SELECT fid, ((fid * 37) % 1440) / 4.0 - 180, ((fid * 37) % 1440) / 4.0 - 180,
+    ((fid * 53) % 720) / 4.0 - 90, ((fid * 53) % 720) / 4.0 - 90
+  FROM points;
+
+INSERT INTO gpkg_contents (table_name, data_type, identifier, srs_id) VALUES ('points', 'features', 'points', 4326);
+INSERT INTO gpkg_geometry_columns VALUES ('points', 'geom', 'POINT', 4326, 0, 0);
+INSERT INTO gpkg_extensions VALUES ('points', 'geom', 'gpkg_rtree_index', 'http://www.geopackage.org/spec120/#extension_rtree', 'write-only');
+
+CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT, day DATE, flag BOOLEAN);
+INSERT INTO notes VALUES
+  (1, 'first', '2020-01-02', 1),
+  (5, NULL, NULL, 0),
+  (9, hex(zeroblob(2000)), '2021-12-31', NULL);
+INSERT INTO gpkg_contents (table_name, data_type, identifier) VALUES ('notes', 'attributes', 'notes');
+
+CREATE TABLE tags (key TEXT PRIMARY KEY, value TEXT) WITHOUT ROWID;
+WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 200)
+INSERT INTO tags SELECT 'tag ' || i, 'value ' || i FROM n;
+
+CREATE VIEW named AS SELECT fid, name FROM points;
+CREATE TRIGGER points_touch AFTER UPDATE ON points BEGIN SELECT 1; END;
+
+CREATE TABLE dropped (id INTEGER PRIMARY KEY, data BLOB);
+WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 20)
+INSERT INTO dropped SELECT i, zeroblob(300) FROM n;
+DROP TABLE dropped;
@@ -0,0 +1,401 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/json-iterator/go"
+	"github.com/paulmach/orb"
+	"io"
+	"strings"
+)
+
+// ErrNotTopology is returned when a TopoJSON input is not a Topology object
+var ErrNotTopology = errors.New("not a TopoJSON Topology")
+
+// TopoJSONReader reads the objects of a TopoJSON topology. Each object is a
+// layer: the members of a GeometryCollection object are read as features,
+// and any other object as a single feature.
+//
+// Since geometries refer to arcs that may only come at the end of the input,
+// the whole topology is parsed and kept in memory when the reader is created.
+type TopoJSONReader struct {
+	objects []*topoObject
+	arcs    [][]orb.Point
+
+	// LayerProperty, when set, is the name of a property that receives the
+	// name of the object each feature is read from
+	LayerProperty string
+}
+
+type topoObject struct {
+	name     string
+	geometry *topoGeometry
+}
+
+// topoGeometry is a TopoJSON geometry object, whose arcs are arc indexes,
+// nested like GeoJSON coordinates
+type topoGeometry struct {
+	Type        string
+	ID          interface{}
+	Properties  Properties
+	BBox        []float64
+	Arcs        interface{}
+	Coordinates interface{}
+	Geometries  []*topoGeometry
+}
+
+type topoTransform struct {
+	Scale     [2]float64 `json:"scale"`
+	Translate [2]float64 `json:"translate"`
+}
+
+// apply converts a quantized position to coordinates
+func (t *topoTransform) apply(x, y float64) orb.Point {
+	if t == nil {
+		return orb.Point{x, y}
+	}
+	return orb.Point{x*t.Scale[0] + t.Translate[0], y*t.Scale[1] + t.Translate[1]}
+}
+
+// NewTopoJSONReader parses a topology. If layers are given, only the objects
+// with those names are read, in that order; otherwise all of them are.
+func NewTopoJSONReader(input io.Reader, layers ...string) (*TopoJSONReader, error) {
+	dec := jsoniter.Parse(jsonConfig, input, ParseBufferSize)
+	if dec.WhatIsNext() != jsoniter.ObjectValue {
+		if dec.Error != nil && dec.Error != io.EOF {
+			return nil, dec.Error
+		}
+		return nil, ErrNotTopology
+	}
+	t := &TopoJSONReader{}
+	var topologyType string
+	var transform *topoTransform
+	var arcs [][][]float64
+	for field := dec.ReadObject(); field != ""; field = dec.ReadObject() {
+		switch field {
+		case "type":
+			topologyType = dec.ReadString()
+		case "transform":
+			dec.ReadVal(&transform)
+		case "arcs":
+			dec.ReadVal(&arcs)
+		case "objects":
+			for name := dec.ReadObject(); name != ""; name = dec.ReadObject() {
+				geometry, err := readTopoGeometry(dec)
+				if err != nil {
+					return nil, fmt.Errorf("object %s: %v", name, err)
+				}
+				t.objects = append(t.objects, &topoObject{name, geometry})
+			}
+		default:
+			dec.Skip()
+		}
+		if dec.Error != nil {
+			break
+		}
+	}
+	if dec.Error != nil && dec.Error != io.EOF {
+		return nil, dec.Error
+	}
+	if topologyType != "Topology" {
+		return nil, ErrNotTopology
+	}
+	t.arcs = decodeArcs(arcs, transform)
+	if len(layers) > 0 {
+		selected := make([]*topoObject, 0, len(layers))
+		for _, name := range layers {
+			var found *topoObject
+			for _, object := range t.objects {
+				if object.name == name {
+					found = object
+					break
+				}
+			}
+			if found == nil {
+				return nil, fmt.Errorf("no layer %q, available layers are %s", name, strings.Join(t.Layers(), ", "))
+			}
+			selected = append(selected, found)
+		}
+		t.objects = selected
+	}
+	// Points are quantized but not delta-encoded
+	for _, object := range t.objects {
+		object.geometry.transform(transform)
+	}
+	return t, nil
+}
+
+// Layers returns the names of the objects that are read
+func (t *TopoJSONReader) Layers() []string {
+	names := make([]string, len(t.objects))
+	for i, object := range t.objects {
+		names[i] = object.name
+	}
+	return names
+}
+
+func (t *TopoJSONReader) Read(ctx context.Context, out chan *Feature) error {
+	index := 0
+	for _, object := range t.objects {
+		members := []*topoGeometry{object.geometry}
+		if object.geometry.Type == "GeometryCollection" {
+			members = object.geometry.Geometries
+		}
+		for _, member := range members {
+			g, err := t.geometry(member)
+			if err != nil {
+				return &ErrMalformedFeature{Index: index, Err: fmt.Errorf("object %s: %v", object.name, err)}
+			}
+			feature := NewFeature(g)
+			feature.ID, feature.Properties, feature.BBox = member.ID, member.Properties, member.BBox
+			if t.LayerProperty != "" {
+				feature.Properties.Set(t.LayerProperty, object.name)
+			}
+			if err := Emit(ctx, out, feature); err != nil {
+				return err
+			}
+			index++
+		}
+	}
+	return nil
+}
+
+func readTopoGeometry(dec *jsoniter.Iterator) (*topoGeometry, error) {
+	if dec.WhatIsNext() != jsoniter.ObjectValue {
+		dec.Skip()
+		return nil, errors.New("TopoJSON geometry must be an object")
+	}
+	g := &topoGeometry{}
+	for field := dec.ReadObject(); field != ""; field = dec.ReadObject() {
+		switch field {
+		case "type":
+			if !dec.ReadNil() {
+				g.Type = dec.ReadString()
+			}
+		case "id":
+			g.ID = dec.Read()
+		case "properties":
+			if dec.ReadNil() {
+				break
+			}
+			for key := dec.ReadObject(); key != ""; key = dec.ReadObject() {
+				g.Properties = append(g.Properties, Property{key, dec.Read()})
+			}
+		case "bbox":
+			for dec.ReadArray() {
+				g.BBox = append(g.BBox, dec.ReadFloat64())
+			}
+		case "arcs":
+			g.Arcs = dec.Read()
+		case "coordinates":
+			g.Coordinates = dec.Read()
+		case "geometries":
+			for dec.ReadArray() {
+				member, err := readTopoGeometry(dec)
+				if err != nil {
+					return nil, err
+				}
+				g.Geometries = append(g.Geometries, member)
+			}
+		default:
+			dec.Skip()
+		}
+		if dec.Error != nil {
+			break
+		}
+	}
+	if dec.Error != nil && dec.Error != io.EOF {
+		return nil, dec.Error
+	}
+	return g, nil
+}
+
+// transform converts the quantized coordinates of points to coordinates
+func (g *topoGeometry) transform(t *topoTransform) {
+	switch g.Type {
+	case "Point":
+		if p, err := topoPosition(g.Coordinates, t); err == nil {
+			g.Coordinates = p
+		}
+	case "MultiPoint":
+		positions, ok := g.Coordinates.([]interface{})
+		if !ok {
+			return
+		}
+		points := make(orb.MultiPoint, 0, len(positions))
+		for _, position := range positions {
+			p, err := topoPosition(position, t)
+			if err != nil {
+				return
+			}
+			points = append(points, p)
+		}
+		g.Coordinates = points
+	case "GeometryCollection":
+		for _, member := range g.Geometries {
+			member.transform(t)
+		}
+	}
+}
+
+func topoPosition(v interface{}, t *topoTransform) (orb.Point, error) {
+	position, ok := v.([]interface{})
+	if !ok || len(position) < 2 {
+		return orb.Point{}, errors.New("a position must have at least two elements")
+	}
+	x, xOK := position[0].(float64)
+	y, yOK := position[1].(float64)
+	if !xOK || !yOK {
+		return orb.Point{}, errors.New("a position must contain numbers")
+	}
+	return t.apply(x, y), nil
+}
+
+// decodeArcs converts the positions of arcs to coordinates. Quantized arcs
+// are delta-encoded: each position is relative to the previous one.
+func decodeArcs(arcs [][][]float64, t *topoTransform) [][]orb.Point {
+	decoded := make([][]orb.Point, len(arcs))
+	for i, arc := range arcs {
+		points := make([]orb.Point, 0, len(arc))
+		var x, y float64
+		for _, position := range arc {
+			if len(position) < 2 {
+				continue
+			}
+			if t == nil {
+				points = append(points, orb.Point{position[0], position[1]})
+				continue
+			}
+			x, y = x+position[0], y+position[1]
+			points = append(points, t.apply(x, y))
+		}
+		decoded[i] = points
+	}
+	return decoded
+}
+
+func (t *TopoJSONReader) geometry(g *topoGeometry) (orb.Geometry, error) {
+	switch g.Type {
+	case "":
+		return nil, nil
+	case "Point":
+		p, ok := g.Coordinates.(orb.Point)
+		if !ok {
+			return nil, errors.New("invalid Point coordinates")
+		}
+		return p, nil
+	case "MultiPoint":
+		points, ok := g.Coordinates.(orb.MultiPoint)
+		if !ok {
+			return nil, errors.New("invalid MultiPoint coordinates")
+		}
+		return points, nil
+	case "LineString":
+		line, err := t.line(g.Arcs)
+		return orb.LineString(line), err
+	case "MultiLineString":
+		lines, err := t.lines(g.Arcs)
+		if err != nil {
+			return nil, err
+		}
+		multi := make(orb.MultiLineString, len(lines))
+		for i, line := range lines {
+			multi[i] = orb.LineString(line)
+		}
+		return multi, nil
+	case "Polygon":
+		return t.polygon(g.Arcs)
+	case "MultiPolygon":
+		polygons, ok := g.Arcs.([]interface{})
+		if !ok {
+			return nil, errors.New("invalid MultiPolygon arcs")
+		}
+		multi := make(orb.MultiPolygon, 0, len(polygons))
+		for _, arcs := range polygons {
+			polygon, err := t.polygon(arcs)
+			if err != nil {
+				return nil, err
+			}
+			multi = append(multi, polygon)
+		}
+		return multi, nil
+	case "GeometryCollection":
+		collection := make(orb.Collection, 0, len(g.Geometries))
+		for _, member := range g.Geometries {
+			item, err := t.geometry(member)
+			if err != nil {
+				return nil, err
+			}
+			if item != nil {
+				collection = append(collection, item)
+			}
+		}
+		return collection, nil
+	}
+	return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+}
+
+func (t *TopoJSONReader) polygon(v interface{}) (orb.Polygon, error) {
+	rings, err := t.lines(v)
+	if err != nil {
+		return nil, err
+	}
+	polygon := make(orb.Polygon, len(rings))
+	for i, ring := range rings {
+		polygon[i] = orb.Ring(ring)
+	}
+	return polygon, nil
+}
+
+func (t *TopoJSONReader) lines(v interface{}) ([][]orb.Point, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("invalid arcs")
+	}
+	lines := make([][]orb.Point, 0, len(items))
+	for _, item := range items {
+		line, err := t.line(item)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// line joins a list of arcs, the last position of each being the first of
+// the next. A negative index ~i stands for arc i reversed.
+func (t *TopoJSONReader) line(v interface{}) ([]orb.Point, error) {
+	indexes, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("invalid arcs")
+	}
+	var points []orb.Point
+	for _, value := range indexes {
+		f, ok := value.(float64)
+		if !ok {
+			return nil, errors.New("arc indexes must be numbers")
+		}
+		i := int(f)
+		reversed := i < 0
+		if reversed {
+			i = ^i
+		}
+		if i >= len(t.arcs) {
+			return nil, fmt.Errorf("arc %d does not exist", i)
+		}
+		arc := t.arcs[i]
+		if reversed {
+			arc = make([]orb.Point, len(t.arcs[i]))
+			for j, p := range t.arcs[i] {
+				arc[len(arc)-1-j] = p
+			}
+		}
+		if len(points) > 0 && len(arc) > 0 {
+			arc = arc[1:]
+		}
+		points = append(points, arc...)
+	}
+	return points, nil
+}
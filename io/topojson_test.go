@@ -0,0 +1,326 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/paulmach/orb"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The example topology of the TopoJSON specification
+const topoExample = `{
+  "type": "Topology",
+  "objects": {
+    "example": {
+      "type": "GeometryCollection",
+      "geometries": [
+        {"type": "Point", "properties": {"prop0": "value0"}, "coordinates": [102, 0.5]},
+        {"type": "LineString", "properties": {"prop0": "value0", "prop1": 0}, "arcs": [0]},
+        {"type": "Polygon", "properties": {"prop0": "value0", "prop1": {"this": "that"}}, "arcs": [[-2]]}
+      ]
+    }
+  },
+  "arcs": [
+    [[102, 0], [103, 1], [104, 0], [105, 1]],
+    [[100, 0], [101, 0], [101, 1], [100, 1], [100, 0]]
+  ]
+}`
+
+// The same topology, quantized and delta-encoded. Unlike the example, arc 1
+// goes counterclockwise.
+const topoQuantizedExample = `{
+  "type": "Topology",
+  "transform": {
+    "scale": [0.0005000500050005, 0.00010001000100010001],
+    "translate": [100, 0]
+  },
+  "objects": {
+    "example": {
+      "type": "GeometryCollection",
+      "geometries": [
+        {"type": "Point", "properties": {"prop0": "value0"}, "coordinates": [4000, 5000]},
+        {"type": "LineString", "properties": {"prop0": "value0", "prop1": 0}, "arcs": [0]},
+        {"type": "Polygon", "properties": {"prop0": "value0", "prop1": {"this": "that"}}, "arcs": [[-2]]}
+      ]
+    }
+  },
+  "arcs": [
+    [[4000, 0], [1999, 9999], [2000, -9999], [2000, 9999]],
+    [[0, 0], [0, 9999], [2000, 0], [0, -9999], [-2000, 0]]
+  ]
+}`
+
+var topoExampleFeatures = []*Feature{
+	{
+		Geometry:   orb.Point{102, 0.5},
+		Properties: Properties{{"prop0", "value0"}},
+	},
+	{
+		Geometry:   orb.LineString{{102, 0}, {103, 1}, {104, 0}, {105, 1}},
+		Properties: Properties{{"prop0", "value0"}, {"prop1", 0.0}},
+	},
+	{
+		// Arc 1 reversed
+		Geometry:   orb.Polygon{{{100, 0}, {100, 1}, {101, 1}, {101, 0}, {100, 0}}},
+		Properties: Properties{{"prop0", "value0"}, {"prop1", map[string]interface{}{"this": "that"}}},
+	},
+}
+
+// roundFeatures rounds the coordinates of features to a thousandth
+func roundFeatures(features []*Feature) []*Feature {
+	for _, feature := range features {
+		if feature.Geometry != nil {
+			feature.Geometry = orb.Round(feature.Geometry, 1000)
+		}
+	}
+	return features
+}
+
+func TestTopoJSONReaderExample(t *testing.T) {
+	quantized := append([]*Feature(nil), topoExampleFeatures...)
+	quantized[2] = &Feature{
+		Geometry:   orb.Polygon{{{100, 0}, {101, 0}, {101, 1}, {100, 1}, {100, 0}}},
+		Properties: topoExampleFeatures[2].Properties,
+	}
+	tests := []struct {
+		name     string
+		topology string
+		want     []*Feature
+	}{
+		{"example", topoExample, topoExampleFeatures},
+		{"quantized", topoQuantizedExample, quantized},
+	}
+	for _, test := range tests {
+		reader, err := NewTopoJSONReader(strings.NewReader(test.topology))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		read, err := readAll(reader)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		compareFeatures(t, test.name, roundFeatures(read), test.want)
+	}
+}
+
+// Two squares sharing an edge, arc 0, which the second one follows backwards
+const topoAdjacent = `{
+  "type": "Topology",
+  "objects": {
+    "squares": {
+      "type": "GeometryCollection",
+      "geometries": [
+        {"type": "Polygon", "id": "left", "arcs": [[0, 1]]},
+        {"type": "Polygon", "id": 2, "arcs": [[2, -1]]},
+        {"type": "MultiPolygon", "arcs": [[[0, 1]], [[2, -1]]]}
+      ]
+    },
+    "edges": {"type": "MultiLineString", "bbox": [0, 0, 1, 1], "arcs": [[0], [-2, -1]]},
+    "nothing": {"type": null, "properties": {"empty": true}},
+    "points": {
+      "type": "GeometryCollection",
+      "geometries": [
+        {"type": "MultiPoint", "coordinates": [[0, 0], [2, 1]]},
+        {"type": "GeometryCollection", "geometries": [{"type": "Point", "coordinates": [1, 1]}, {"type": null}]}
+      ]
+    }
+  },
+  "arcs": [
+    [[1, 0], [1, 1]],
+    [[1, 1], [0, 1], [0, 0], [1, 0]],
+    [[1, 0], [2, 0], [2, 1], [1, 1]]
+  ]
+}`
+
+func TestTopoJSONReaderArcs(t *testing.T) {
+	left := orb.Polygon{{{1, 0}, {1, 1}, {0, 1}, {0, 0}, {1, 0}}}
+	right := orb.Polygon{{{1, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 0}}}
+	want := []*Feature{
+		{ID: "left", Geometry: left, Properties: Properties{{"layer", "squares"}}},
+		{ID: 2.0, Geometry: right, Properties: Properties{{"layer", "squares"}}},
+		{Geometry: orb.MultiPolygon{left, right}, Properties: Properties{{"layer", "squares"}}},
+		{
+			Geometry:   orb.MultiLineString{{{1, 0}, {1, 1}}, {{1, 0}, {0, 0}, {0, 1}, {1, 1}, {1, 0}}},
+			Properties: Properties{{"layer", "edges"}},
+			BBox:       []float64{0, 0, 1, 1},
+		},
+		{Properties: Properties{{"empty", true}, {"layer", "nothing"}}},
+		{Geometry: orb.MultiPoint{{0, 0}, {2, 1}}, Properties: Properties{{"layer", "points"}}},
+		{Geometry: orb.Collection{orb.Point{1, 1}}, Properties: Properties{{"layer", "points"}}},
+	}
+	reader, err := NewTopoJSONReader(strings.NewReader(topoAdjacent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reader.Layers(); !reflect.DeepEqual(got, []string{"squares", "edges", "nothing", "points"}) {
+		t.Errorf("got layers %v", got)
+	}
+	reader.LayerProperty = "layer"
+	read, err := readAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFeatures(t, "all layers", read, want)
+
+	reader, err = NewTopoJSONReader(strings.NewReader(topoAdjacent), "points", "edges")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.LayerProperty = "layer"
+	read, err = readAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFeatures(t, "selected layers", read, append(want[5:7:7], want[3]))
+
+	_, err = NewTopoJSONReader(strings.NewReader(topoAdjacent), "squares", "circles")
+	if err == nil || err.Error() != `no layer "circles", available layers are squares, edges, nothing, points` {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestTopoJSONReaderErrors(t *testing.T) {
+	tests := []struct {
+		topology string
+		err      string
+	}{
+		{`{"type": "FeatureCollection", "features": []}`, "not a TopoJSON Topology"},
+		{`[]`, "not a TopoJSON Topology"},
+		{`{"type": "Topology", "objects": {"a": {"type": "LineString", "arcs": [3]}}, "arcs": [[[0, 0], [1, 1]]]}`, "arc 3 does not exist"},
+		{`{"type": "Topology", "objects": {"a": {"type": "Polygon", "arcs": [0]}}, "arcs": [[[0, 0], [1, 1]]]}`, "invalid arcs"},
+		{`{"type": "Topology", "objects": {"a": {"type": "Point", "coordinates": [0]}}, "arcs": []}`, "invalid Point coordinates"},
+		{`{"type": "Topology", "objects": {"a": {"type": "Circle"}}, "arcs": []}`, `unsupported geometry type "Circle"`},
+	}
+	for _, test := range tests {
+		reader, err := NewTopoJSONReader(strings.NewReader(test.topology))
+		if err == nil {
+			_, err = readAll(reader)
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got %v, want %q", test.topology, err, test.err)
+		}
+	}
+}
+
+// writeTopology writes features as TopoJSON, returning the output and its
+// arcs
+func writeTopology(t *testing.T, writer *TopoJSONWriter, features []*Feature) ([]byte, [][][]float64) {
+	var buf bytes.Buffer
+	writer.output = &buf
+	if err := writeAll(writer, features); err != nil {
+		t.Fatal(err)
+	}
+	var topology struct {
+		Arcs [][][]float64
+	}
+	if err := json.Unmarshal(buf.Bytes(), &topology); err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+	return buf.Bytes(), topology.Arcs
+}
+
+func TestTopoJSONWriterSharedArcs(t *testing.T) {
+	features := []*Feature{
+		NewFeature(orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}),
+		NewFeature(orb.Polygon{{{1, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 0}}}),
+		// The same ring as the first polygon, backwards and from elsewhere
+		NewFeature(orb.Polygon{{{1, 1}, {1, 0}, {0, 0}, {0, 1}, {1, 1}}}),
+	}
+	writer, _ := NewTopoJSONWriter(nil)
+	output, arcs := writeTopology(t, writer, features)
+	var topology struct {
+		Objects map[string]struct {
+			Geometries []struct{ Arcs [][]int }
+		}
+	}
+	if err := json.Unmarshal(output, &topology); err != nil {
+		t.Fatal(err)
+	}
+	geometries := topology.Objects["features"].Geometries
+	if len(geometries) != 3 {
+		t.Fatalf("got %d geometries: %s", len(geometries), output)
+	}
+	// The shared edge, the rest of the first square and the rest of the second
+	if len(arcs) != 3 {
+		t.Errorf("got %d arcs, want 3: %s", len(arcs), output)
+	}
+	uses := map[int][]int{}
+	for i, g := range geometries {
+		for _, index := range g.Arcs[0] {
+			if index < 0 {
+				index = ^index
+			}
+			uses[index] = append(uses[index], i)
+		}
+	}
+	if !reflect.DeepEqual(uses[0], []int{0, 1, 2}) {
+		t.Errorf("got arc uses %v: %s", uses, output)
+	}
+}
+
+// canonicalFeatures rotates the rings of polygons to start at their smallest
+// position, since TopoJSONWriter may start them at a junction
+func canonicalFeatures(features []*Feature) []*Feature {
+	polygon := func(p orb.Polygon) orb.Polygon {
+		rings := make(orb.Polygon, len(p))
+		for i, ring := range p {
+			rings[i] = orb.Ring(canonicalRing(ring))
+		}
+		return rings
+	}
+	for _, feature := range features {
+		switch g := feature.Geometry.(type) {
+		case orb.Polygon:
+			feature.Geometry = polygon(g)
+		case orb.MultiPolygon:
+			multi := make(orb.MultiPolygon, len(g))
+			for i, p := range g {
+				multi[i] = polygon(p)
+			}
+			feature.Geometry = multi
+		}
+	}
+	return features
+}
+
+func TestTopoJSONWriterRoundTrip(t *testing.T) {
+	square := orb.Polygon{
+		{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}},
+		{{2, 2}, {2, 4}, {4, 4}, {4, 2}, {2, 2}},
+	}
+	neighbor := orb.Polygon{{{10, 0}, {20, 0}, {20, 10}, {10, 10}, {10, 0}}}
+	features := []*Feature{
+		{ID: "square", Geometry: square, Properties: Properties{{"name", "square"}, {"layer", "land"}}},
+		{ID: 2.0, Geometry: neighbor, Properties: Properties{{"layer", "land"}}},
+		{Geometry: orb.MultiPolygon{neighbor, {{{30, 0}, {31, 0}, {31, 1}, {30, 0}}}}, Properties: Properties{{"layer", "land"}}},
+		{Geometry: orb.LineString{{0, 0}, {10, 0}, {10, 10}, {15, 15}}, Properties: Properties{{"layer", "roads"}}},
+		{Geometry: orb.MultiLineString{{{10, 10}, {10, 0}}, {{0, 10}, {5, 15}}}, Properties: Properties{{"layer", "roads"}}},
+		{Geometry: orb.Point{5, 5}, Properties: Properties{{"count", 3.0}, {"layer", "places"}}},
+		{Geometry: orb.MultiPoint{{1, 1}, {2, 2}}, Properties: Properties{{"layer", "places"}}},
+		{Geometry: orb.Collection{orb.Point{3, 3}, orb.LineString{{0, 0}, {10, 0}}}, Properties: Properties{{"layer", "places"}}},
+	}
+	// The layers come in the same order as the features
+	want := canonicalFeatures(withoutProperty(features, ""))
+	for _, quantization := range []int{0, 1e5} {
+		writer, _ := NewTopoJSONWriter(nil)
+		writer.ObjectProperty = "layer"
+		writer.Quantization = quantization
+		output, _ := writeTopology(t, writer, features)
+		reader, err := NewTopoJSONReader(bytes.NewReader(output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := reader.Layers(); !reflect.DeepEqual(got, []string{"land", "roads", "places"}) {
+			t.Errorf("quantization %d: got layers %v", quantization, got)
+		}
+		reader.LayerProperty = "layer"
+		read, err := readAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareFeatures(t, "quantization "+strconv.Itoa(quantization), canonicalFeatures(roundFeatures(read)), want)
+	}
+}
@@ -0,0 +1,502 @@
+package io
+
+import (
+	"encoding/binary"
+	"github.com/json-iterator/go"
+	"github.com/paulmach/orb"
+	"io"
+	"math"
+)
+
+// TopoJSONWriter writes features as a TopoJSON topology, in which lines and
+// polygon rings that share boundaries refer to the same arcs, so that shared
+// boundaries are only written once.
+//
+// Arcs can only be built once every feature is known, so all the features are
+// kept in memory until the input ends, and the topology takes a few times
+// their size again while it is built. Z and M ordinates are not written.
+type TopoJSONWriter struct {
+	output io.Writer
+
+	// ObjectName is the name of the GeometryCollection object that features
+	// are written to
+	ObjectName string
+
+	// ObjectProperty, when set, is a property naming the object that each
+	// feature is written to instead, as read by TopoJSONReader.LayerProperty.
+	// The property itself is not written.
+	ObjectProperty string
+
+	// Quantization, when above 1, is the number of distinct values that
+	// coordinates are rounded to on each axis, e.g. 1e5. Arcs are then
+	// delta-encoded, which makes the output much smaller.
+	Quantization int
+}
+
+func NewTopoJSONWriter(output io.Writer) (*TopoJSONWriter, error) {
+	return &TopoJSONWriter{
+		output:     output,
+		ObjectName: "features",
+	}, nil
+}
+
+func (t *TopoJSONWriter) Write(in chan *Feature) error {
+	var features []*Feature
+	for feature := range in {
+		if feature != nil {
+			features = append(features, feature)
+		}
+	}
+
+	b := &topologyBuilder{}
+	for _, feature := range features {
+		b.extend(feature.Geometry)
+	}
+	if t.Quantization > 1 {
+		b.quantize(t.Quantization)
+	}
+	for _, feature := range features {
+		b.addPaths(feature.Geometry)
+	}
+	b.buildArcs()
+
+	// Objects are written in order of first appearance
+	var names []string
+	objects := map[string][]*topoGeometry{}
+	for _, feature := range features {
+		name := t.ObjectName
+		properties := feature.Properties
+		if t.ObjectProperty != "" {
+			if value, ok := feature.Properties.Get(t.ObjectProperty); ok && value != nil {
+				name = stringValue(value)
+			}
+			properties = make(Properties, 0, len(feature.Properties))
+			for _, prop := range feature.Properties {
+				if prop.Key != t.ObjectProperty {
+					properties = append(properties, prop)
+				}
+			}
+		}
+		g := b.geometry(feature.Geometry)
+		g.ID, g.Properties, g.BBox = feature.ID, properties, feature.BBox
+		if _, ok := objects[name]; !ok {
+			names = append(names, name)
+		}
+		objects[name] = append(objects[name], g)
+	}
+
+	stream := jsoniter.NewStream(jsonConfig, t.output, ParseBufferSize)
+	stream.WriteObjectStart()
+	stream.WriteObjectField("type")
+	stream.WriteString("Topology")
+	if b.transform != nil {
+		stream.WriteMore()
+		stream.WriteObjectField("transform")
+		stream.WriteVal(b.transform)
+	}
+	if b.bounded {
+		stream.WriteMore()
+		stream.WriteObjectField("bbox")
+		writeFloats(stream, []float64{b.bound.Min[0], b.bound.Min[1], b.bound.Max[0], b.bound.Max[1]})
+	}
+	stream.WriteMore()
+	stream.WriteObjectField("objects")
+	stream.WriteObjectStart()
+	for i, name := range names {
+		if i > 0 {
+			stream.WriteMore()
+		}
+		stream.WriteObjectField(name)
+		writeTopoGeometry(stream, &topoGeometry{Type: "GeometryCollection", Geometries: objects[name]})
+	}
+	stream.WriteObjectEnd()
+	stream.WriteMore()
+	stream.WriteObjectField("arcs")
+	stream.WriteArrayStart()
+	for i, arc := range b.arcs {
+		if i > 0 {
+			stream.WriteMore()
+		}
+		b.writeArc(stream, arc)
+		if stream.Buffered() > ParseBufferSize {
+			if err := stream.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	stream.WriteArrayEnd()
+	stream.WriteObjectEnd()
+	stream.WriteRaw("\n")
+	return stream.Flush()
+}
+
+func writeTopoGeometry(stream *jsoniter.Stream, g *topoGeometry) {
+	stream.WriteObjectStart()
+	stream.WriteObjectField("type")
+	if g.Type == "" {
+		stream.WriteNil()
+	} else {
+		stream.WriteString(g.Type)
+	}
+	if g.ID != nil {
+		stream.WriteMore()
+		stream.WriteObjectField("id")
+		writeValue(stream, g.ID)
+	}
+	if len(g.BBox) > 0 {
+		stream.WriteMore()
+		stream.WriteObjectField("bbox")
+		writeFloats(stream, g.BBox)
+	}
+	if g.Arcs != nil {
+		stream.WriteMore()
+		stream.WriteObjectField("arcs")
+		stream.WriteVal(g.Arcs)
+	}
+	if g.Coordinates != nil {
+		stream.WriteMore()
+		stream.WriteObjectField("coordinates")
+		stream.WriteVal(g.Coordinates)
+	}
+	if g.Geometries != nil {
+		stream.WriteMore()
+		stream.WriteObjectField("geometries")
+		stream.WriteArrayStart()
+		for i, member := range g.Geometries {
+			if i > 0 {
+				stream.WriteMore()
+			}
+			writeTopoGeometry(stream, member)
+		}
+		stream.WriteArrayEnd()
+	}
+	if g.Properties != nil {
+		stream.WriteMore()
+		stream.WriteObjectField("properties")
+		writeProperties(stream, g.Properties)
+	}
+	stream.WriteObjectEnd()
+}
+
+// topologyBuilder turns the lines and rings of geometries into shared arcs.
+// Paths are cut into arcs at junctions, the positions where paths that share
+// a position stop following the same neighbors, and identical arcs, in either
+// direction, are only kept once.
+type topologyBuilder struct {
+	bound     orb.Bound
+	bounded   bool
+	transform *topoTransform
+
+	paths []*topoPath
+	arcs  [][]orb.Point
+	// index finds arcs by their positions, in either direction
+	index map[string]int
+	// next is the path that geometry consumes next
+	next int
+}
+
+type topoPath struct {
+	points []orb.Point
+	ring   bool
+	arcs   []int
+}
+
+// extend grows the bound of all positions
+func (b *topologyBuilder) extend(g orb.Geometry) {
+	if g == nil {
+		return
+	}
+	if !b.bounded {
+		b.bound, b.bounded = g.Bound(), true
+		return
+	}
+	b.bound = b.bound.Union(g.Bound())
+}
+
+// quantize sets the transform that maps the bound of all positions onto a
+// grid of n by n values
+func (b *topologyBuilder) quantize(n int) {
+	if !b.bounded {
+		return
+	}
+	scale := [2]float64{1, 1}
+	for axis := 0; axis < 2; axis++ {
+		if extent := b.bound.Max[axis] - b.bound.Min[axis]; extent > 0 {
+			scale[axis] = extent / float64(n-1)
+		}
+	}
+	b.transform = &topoTransform{Scale: scale, Translate: [2]float64{b.bound.Min[0], b.bound.Min[1]}}
+}
+
+// point returns the position written for a point, quantized if there is a
+// transform
+func (b *topologyBuilder) point(p orb.Point) orb.Point {
+	if b.transform == nil {
+		return p
+	}
+	return orb.Point{
+		math.Round((p[0] - b.transform.Translate[0]) / b.transform.Scale[0]),
+		math.Round((p[1] - b.transform.Translate[1]) / b.transform.Scale[1]),
+	}
+}
+
+// addPaths collects the lines and rings of a geometry, in the order that
+// geometry consumes them
+func (b *topologyBuilder) addPaths(g orb.Geometry) {
+	switch g := g.(type) {
+	case orb.LineString:
+		b.addPath(g, false)
+	case orb.MultiLineString:
+		for _, line := range g {
+			b.addPath(line, false)
+		}
+	case orb.Ring:
+		b.addPath(g, true)
+	case orb.Polygon:
+		for _, ring := range g {
+			b.addPath(ring, true)
+		}
+	case orb.MultiPolygon:
+		for _, polygon := range g {
+			b.addPaths(polygon)
+		}
+	case orb.Bound:
+		b.addPaths(g.ToPolygon())
+	case orb.Collection:
+		for _, item := range g {
+			b.addPaths(item)
+		}
+	}
+}
+
+func (b *topologyBuilder) addPath(points []orb.Point, ring bool) {
+	path := &topoPath{ring: ring}
+	for _, p := range points {
+		p = b.point(p)
+		// Quantization can make consecutive positions identical
+		if n := len(path.points); n == 0 || path.points[n-1] != p {
+			path.points = append(path.points, p)
+		}
+	}
+	if ring && len(path.points) > 0 && path.points[0] != path.points[len(path.points)-1] {
+		path.points = append(path.points, path.points[0])
+	}
+	b.paths = append(b.paths, path)
+}
+
+func (b *topologyBuilder) buildArcs() {
+	junctions := b.junctions()
+	b.index = map[string]int{}
+	for _, path := range b.paths {
+		points := path.points
+		if len(points) < 2 {
+			// A degenerate path is written as an arc of its own
+			path.arcs = []int{b.addArc(points)}
+			continue
+		}
+		if path.ring {
+			start := -1
+			for i, p := range points[:len(points)-1] {
+				if junctions[p] {
+					start = i
+					break
+				}
+			}
+			if start < 0 {
+				path.arcs = []int{b.addRing(points)}
+				continue
+			}
+			// Rotate the ring to start at a junction
+			rotated := make([]orb.Point, 0, len(points))
+			rotated = append(rotated, points[start:len(points)-1]...)
+			rotated = append(rotated, points[:start+1]...)
+			points = rotated
+		}
+		start := 0
+		for i := 1; i < len(points); i++ {
+			if i == len(points)-1 || junctions[points[i]] {
+				path.arcs = append(path.arcs, b.addArc(points[start:i+1]))
+				start = i
+			}
+		}
+	}
+}
+
+// junctions finds the positions where paths join or split. A position is a
+// junction if it ends a line, or if it is visited again with different
+// neighbors than the first time.
+func (b *topologyBuilder) junctions() map[orb.Point]bool {
+	junctions := map[orb.Point]bool{}
+	neighbors := map[orb.Point][2]orb.Point{}
+	visit := func(p, previous, next orb.Point) {
+		if junctions[p] {
+			return
+		}
+		seen, ok := neighbors[p]
+		if !ok {
+			neighbors[p] = [2]orb.Point{previous, next}
+		} else if seen != [2]orb.Point{previous, next} && seen != [2]orb.Point{next, previous} {
+			junctions[p] = true
+		}
+	}
+	for _, path := range b.paths {
+		points := path.points
+		n := len(points)
+		if n < 2 {
+			continue
+		}
+		if path.ring {
+			for i := 0; i < n-1; i++ {
+				previous := points[n-2]
+				if i > 0 {
+					previous = points[i-1]
+				}
+				visit(points[i], previous, points[i+1])
+			}
+			continue
+		}
+		junctions[points[0]] = true
+		junctions[points[n-1]] = true
+		for i := 1; i < n-1; i++ {
+			visit(points[i], points[i-1], points[i+1])
+		}
+	}
+	return junctions
+}
+
+// addArc returns the index of an arc with the given positions, or ~index if
+// the arc is found in the other direction, adding it if it is new
+func (b *topologyBuilder) addArc(points []orb.Point) int {
+	if i, ok := b.index[arcKey(points, false)]; ok {
+		return i
+	}
+	if i, ok := b.index[arcKey(points, true)]; ok {
+		return ^i
+	}
+	arc := append([]orb.Point(nil), points...)
+	b.arcs = append(b.arcs, arc)
+	b.index[arcKey(arc, false)] = len(b.arcs) - 1
+	return len(b.arcs) - 1
+}
+
+// addRing adds a ring without junctions as a single arc. Rings are compared
+// from their smallest position, so that a ring is found whatever position it
+// starts from.
+func (b *topologyBuilder) addRing(points []orb.Point) int {
+	forward := canonicalRing(points)
+	reversed := make([]orb.Point, len(points))
+	for i, p := range points {
+		reversed[len(points)-1-i] = p
+	}
+	if i, ok := b.index[arcKey(canonicalRing(reversed), false)]; ok {
+		return ^i
+	}
+	return b.addArc(forward)
+}
+
+// canonicalRing rotates a closed ring to start at its smallest position
+func canonicalRing(points []orb.Point) []orb.Point {
+	n := len(points) - 1
+	start := 0
+	for i := 1; i < n; i++ {
+		p, min := points[i], points[start]
+		if p[0] < min[0] || p[0] == min[0] && p[1] < min[1] {
+			start = i
+		}
+	}
+	rotated := make([]orb.Point, 0, len(points))
+	rotated = append(rotated, points[start:n]...)
+	rotated = append(rotated, points[:start+1]...)
+	return rotated
+}
+
+func arcKey(points []orb.Point, reversed bool) string {
+	key := make([]byte, 0, len(points)*16)
+	var b [8]byte
+	for i := range points {
+		p := points[i]
+		if reversed {
+			p = points[len(points)-1-i]
+		}
+		for _, v := range p {
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+			key = append(key, b[:]...)
+		}
+	}
+	return string(key)
+}
+
+// geometry converts a geometry to a TopoJSON geometry, consuming the arcs of
+// its paths in the order that addPaths added them
+func (b *topologyBuilder) geometry(g orb.Geometry) *topoGeometry {
+	switch g := g.(type) {
+	case orb.Point:
+		p := b.point(g)
+		return &topoGeometry{Type: "Point", Coordinates: []float64{p[0], p[1]}}
+	case orb.MultiPoint:
+		coordinates := make([][]float64, len(g))
+		for i, p := range g {
+			p = b.point(p)
+			coordinates[i] = []float64{p[0], p[1]}
+		}
+		return &topoGeometry{Type: "MultiPoint", Coordinates: coordinates}
+	case orb.LineString:
+		return &topoGeometry{Type: "LineString", Arcs: b.nextArcs()}
+	case orb.MultiLineString:
+		arcs := make([][]int, len(g))
+		for i := range g {
+			arcs[i] = b.nextArcs()
+		}
+		return &topoGeometry{Type: "MultiLineString", Arcs: arcs}
+	case orb.Ring:
+		return &topoGeometry{Type: "Polygon", Arcs: [][]int{b.nextArcs()}}
+	case orb.Polygon:
+		return &topoGeometry{Type: "Polygon", Arcs: b.polygonArcs(g)}
+	case orb.MultiPolygon:
+		arcs := make([][][]int, len(g))
+		for i, polygon := range g {
+			arcs[i] = b.polygonArcs(polygon)
+		}
+		return &topoGeometry{Type: "MultiPolygon", Arcs: arcs}
+	case orb.Bound:
+		return b.geometry(g.ToPolygon())
+	case orb.Collection:
+		members := make([]*topoGeometry, len(g))
+		for i, item := range g {
+			members[i] = b.geometry(item)
+		}
+		return &topoGeometry{Type: "GeometryCollection", Geometries: members}
+	}
+	return &topoGeometry{}
+}
+
+func (b *topologyBuilder) polygonArcs(polygon orb.Polygon) [][]int {
+	arcs := make([][]int, len(polygon))
+	for i := range polygon {
+		arcs[i] = b.nextArcs()
+	}
+	return arcs
+}
+
+func (b *topologyBuilder) nextArcs() []int {
+	path := b.paths[b.next]
+	b.next++
+	return path.arcs
+}
+
+// writeArc writes the positions of an arc, delta-encoded if they are
+// quantized
+func (b *topologyBuilder) writeArc(stream *jsoniter.Stream, arc []orb.Point) {
+	stream.WriteArrayStart()
+	var previous orb.Point
+	for i, p := range arc {
+		if i > 0 {
+			stream.WriteMore()
+		}
+		if b.transform != nil {
+			p, previous = orb.Point{p[0] - previous[0], p[1] - previous[1]}, p
+		}
+		writeFloats(stream, p[:])
+	}
+	stream.WriteArrayEnd()
+}
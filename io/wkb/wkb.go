@@ -0,0 +1,459 @@
+// Package wkb converts between orb geometries and Well-Known Binary
+package wkb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/stationa/xgeo/io/internal/geom"
+	"math"
+)
+
+const (
+	wkbPoint              = 1
+	wkbLineString         = 2
+	wkbPolygon            = 3
+	wkbMultiPoint         = 4
+	wkbMultiLineString    = 5
+	wkbMultiPolygon       = 6
+	wkbGeometryCollection = 7
+
+	// Flags of the PostGIS extended WKB type
+	ewkbZ    = 0x80000000
+	ewkbM    = 0x40000000
+	ewkbSRID = 0x20000000
+)
+
+var errTruncated = errors.New("invalid WKB: unexpected end of data")
+
+// emptyOrdinate is the quiet NaN that PostGIS and GEOS write for the
+// coordinates of an empty point, whose bits differ from those of math.NaN
+var emptyOrdinate = math.Float64frombits(0x7ff8000000000000)
+
+// Unmarshal decodes the WKB of a geometry, in the ISO or the PostGIS extended
+// flavour. Like io.DecodeGeometryZM, it also returns the Z and M ordinates of
+// every position, in traversal order, and either slice is nil unless all
+// positions have that ordinate. An empty point, with NaN coordinates, is
+// returned as a nil geometry.
+func Unmarshal(data []byte) (orb.Geometry, []float64, []float64, error) {
+	g, z, m, _, err := UnmarshalEWKB(data)
+	return g, z, m, err
+}
+
+// UnmarshalEWKB decodes the WKB of a geometry like Unmarshal, and also returns
+// the SRID of the PostGIS extended WKB, or 0 when it does not have one.
+func UnmarshalEWKB(data []byte) (orb.Geometry, []float64, []float64, int, error) {
+	d := &decoder{data: data}
+	g, err := d.geometry()
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	z, m := d.z, d.m
+	if len(z) != d.count {
+		z = nil
+	}
+	if len(m) != d.count {
+		m = nil
+	}
+	return g, z, m, d.srid, nil
+}
+
+type decoder struct {
+	data  []byte
+	order binary.ByteOrder
+	z, m  []float64
+	count int
+	srid  int
+}
+
+func (d *decoder) read(n int) ([]byte, error) {
+	if len(d.data) < n {
+		return nil, errTruncated
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	b, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+func (d *decoder) float64() (float64, error) {
+	b, err := d.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(d.order.Uint64(b)), nil
+}
+
+func (d *decoder) geometry() (orb.Geometry, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	switch b[0] {
+	case 0:
+		d.order = binary.BigEndian
+	case 1:
+		d.order = binary.LittleEndian
+	default:
+		return nil, fmt.Errorf("invalid WKB: unknown byte order %d", b[0])
+	}
+	t, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	hasZ := t&ewkbZ != 0
+	hasM := t&ewkbM != 0
+	if t&ewkbSRID != 0 {
+		srid, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		// Only the outermost geometry is expected to have an SRID
+		if d.srid == 0 {
+			d.srid = int(srid)
+		}
+	}
+	t &^= ewkbZ | ewkbM | ewkbSRID
+	// ISO WKB adds 1000 for Z, 2000 for M and 3000 for ZM
+	switch t / 1000 {
+	case 1:
+		hasZ = true
+	case 2:
+		hasM = true
+	case 3:
+		hasZ, hasM = true, true
+	}
+	switch t % 1000 {
+	case wkbPoint:
+		p, err := d.position(hasZ, hasM)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(p[0]) && math.IsNaN(p[1]) {
+			// An empty point was counted as a position
+			d.count--
+			d.z, d.m = trim(d.z, d.count), trim(d.m, d.count)
+			return nil, nil
+		}
+		return p, nil
+	case wkbLineString:
+		points, err := d.positions(hasZ, hasM)
+		return orb.LineString(points), err
+	case wkbPolygon:
+		return d.polygon(hasZ, hasM)
+	case wkbMultiPoint, wkbMultiLineString, wkbMultiPolygon, wkbGeometryCollection:
+		// The smallest member is an empty line string or polygon
+		n, err := d.length(9)
+		if err != nil {
+			return nil, err
+		}
+		var members []orb.Geometry
+		for i := 0; i < n; i++ {
+			member, err := d.geometry()
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, member)
+		}
+		return collect(t%1000, members)
+	}
+	return nil, fmt.Errorf("invalid WKB: unsupported geometry type %d", t)
+}
+
+// collect builds a multi-geometry from its decoded members
+func collect(t uint32, members []orb.Geometry) (orb.Geometry, error) {
+	switch t {
+	case wkbMultiPoint:
+		points := make(orb.MultiPoint, 0, len(members))
+		for _, member := range members {
+			if p, ok := member.(orb.Point); ok {
+				points = append(points, p)
+			} else if member != nil {
+				return nil, errors.New("invalid WKB: MultiPoint member is not a point")
+			}
+		}
+		return points, nil
+	case wkbMultiLineString:
+		lines := make(orb.MultiLineString, 0, len(members))
+		for _, member := range members {
+			line, ok := member.(orb.LineString)
+			if !ok {
+				return nil, errors.New("invalid WKB: MultiLineString member is not a line string")
+			}
+			lines = append(lines, line)
+		}
+		return lines, nil
+	case wkbMultiPolygon:
+		polygons := make(orb.MultiPolygon, 0, len(members))
+		for _, member := range members {
+			polygon, ok := member.(orb.Polygon)
+			if !ok {
+				return nil, errors.New("invalid WKB: MultiPolygon member is not a polygon")
+			}
+			polygons = append(polygons, polygon)
+		}
+		return polygons, nil
+	}
+	collection := make(orb.Collection, 0, len(members))
+	for _, member := range members {
+		if member != nil {
+			collection = append(collection, member)
+		}
+	}
+	return collection, nil
+}
+
+// length reads the number of elements that follow, checking it against the
+// minimum size of each element so that corrupt data fails early
+func (d *decoder) length(minSize int) (int, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return 0, err
+	}
+	if int64(n)*int64(minSize) > int64(len(d.data)) {
+		return 0, errTruncated
+	}
+	return int(n), nil
+}
+
+func (d *decoder) position(hasZ, hasM bool) (orb.Point, error) {
+	x, err := d.float64()
+	if err != nil {
+		return orb.Point{}, err
+	}
+	y, err := d.float64()
+	if err != nil {
+		return orb.Point{}, err
+	}
+	d.count++
+	if hasZ {
+		z, err := d.float64()
+		if err != nil {
+			return orb.Point{}, err
+		}
+		if len(d.z) == d.count-1 {
+			d.z = append(d.z, z)
+		}
+	}
+	if hasM {
+		m, err := d.float64()
+		if err != nil {
+			return orb.Point{}, err
+		}
+		if len(d.m) == d.count-1 {
+			d.m = append(d.m, m)
+		}
+	}
+	return orb.Point{x, y}, nil
+}
+
+func (d *decoder) positions(hasZ, hasM bool) ([]orb.Point, error) {
+	dim := 2
+	if hasZ {
+		dim++
+	}
+	if hasM {
+		dim++
+	}
+	n, err := d.length(dim * 8)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]orb.Point, n)
+	for i := range points {
+		if points[i], err = d.position(hasZ, hasM); err != nil {
+			return nil, err
+		}
+	}
+	return points, nil
+}
+
+func (d *decoder) polygon(hasZ, hasM bool) (orb.Polygon, error) {
+	n, err := d.length(4)
+	if err != nil {
+		return nil, err
+	}
+	polygon := make(orb.Polygon, n)
+	for i := range polygon {
+		points, err := d.positions(hasZ, hasM)
+		if err != nil {
+			return nil, err
+		}
+		polygon[i] = orb.Ring(points)
+	}
+	return polygon, nil
+}
+
+func trim(values []float64, n int) []float64 {
+	if len(values) > n {
+		return values[:n]
+	}
+	return values
+}
+
+// Marshal returns the little-endian ISO WKB of a geometry. If z or m hold an
+// ordinate for every position of the geometry, in traversal order, they are
+// written as well. A nil geometry is written as an empty point.
+func Marshal(g orb.Geometry, z, m []float64) []byte {
+	e := newEncoder(g, z, m)
+	e.geometry(g)
+	return e.Bytes()
+}
+
+// MarshalEWKB returns the little-endian PostGIS extended WKB of a geometry.
+// Like Marshal, it writes the z and m ordinates when they hold one for every
+// position, and it writes the SRID of the outermost geometry unless srid is 0.
+func MarshalEWKB(g orb.Geometry, z, m []float64, srid int) []byte {
+	e := newEncoder(g, z, m)
+	e.extended, e.srid = true, uint32(srid)
+	e.geometry(g)
+	return e.Bytes()
+}
+
+type encoder struct {
+	bytes.Buffer
+	z, m     []float64
+	index    int
+	extended bool
+	srid     uint32
+}
+
+// newEncoder drops the z and m ordinates unless there is one per position
+func newEncoder(g orb.Geometry, z, m []float64) *encoder {
+	n := geom.PositionCount(g)
+	if len(z) != n || n == 0 {
+		z = nil
+	}
+	if len(m) != n || n == 0 {
+		m = nil
+	}
+	return &encoder{z: z, m: m}
+}
+
+func (e *encoder) header(t uint32) {
+	if e.extended {
+		e.extendedHeader(t)
+		return
+	}
+	if e.z != nil {
+		t += 1000
+	}
+	if e.m != nil {
+		t += 2000
+	}
+	e.WriteByte(1)
+	e.uint32(t)
+}
+
+func (e *encoder) extendedHeader(t uint32) {
+	if e.z != nil {
+		t |= ewkbZ
+	}
+	if e.m != nil {
+		t |= ewkbM
+	}
+	outermost := e.Len() == 0
+	if outermost && e.srid != 0 {
+		t |= ewkbSRID
+	}
+	e.WriteByte(1)
+	e.uint32(t)
+	if outermost && e.srid != 0 {
+		e.uint32(e.srid)
+	}
+}
+
+func (e *encoder) uint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	e.Write(b[:])
+}
+
+func (e *encoder) float64(f float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	e.Write(b[:])
+}
+
+func (e *encoder) geometry(g orb.Geometry) {
+	switch g := g.(type) {
+	case orb.Point:
+		e.header(wkbPoint)
+		e.position(g)
+	case orb.MultiPoint:
+		e.header(wkbMultiPoint)
+		e.uint32(uint32(len(g)))
+		for _, p := range g {
+			e.geometry(p)
+		}
+	case orb.LineString:
+		e.header(wkbLineString)
+		e.positions(g)
+	case orb.MultiLineString:
+		e.header(wkbMultiLineString)
+		e.uint32(uint32(len(g)))
+		for _, line := range g {
+			e.geometry(line)
+		}
+	case orb.Ring:
+		e.geometry(orb.Polygon{g})
+	case orb.Polygon:
+		e.header(wkbPolygon)
+		e.uint32(uint32(len(g)))
+		for _, ring := range g {
+			e.positions(ring)
+		}
+	case orb.MultiPolygon:
+		e.header(wkbMultiPolygon)
+		e.uint32(uint32(len(g)))
+		for _, polygon := range g {
+			e.geometry(polygon)
+		}
+	case orb.Bound:
+		e.geometry(g.ToPolygon())
+	case orb.Collection:
+		e.header(wkbGeometryCollection)
+		e.uint32(uint32(len(g)))
+		for _, item := range g {
+			e.geometry(item)
+		}
+	default:
+		e.header(wkbPoint)
+		e.float64(emptyOrdinate)
+		e.float64(emptyOrdinate)
+		if e.z != nil {
+			e.float64(emptyOrdinate)
+		}
+		if e.m != nil {
+			e.float64(emptyOrdinate)
+		}
+	}
+}
+
+func (e *encoder) position(p orb.Point) {
+	e.float64(p[0])
+	e.float64(p[1])
+	if e.z != nil {
+		e.float64(e.z[e.index])
+	}
+	if e.m != nil {
+		e.float64(e.m[e.index])
+	}
+	e.index++
+}
+
+func (e *encoder) positions(points []orb.Point) {
+	e.uint32(uint32(len(points)))
+	for _, p := range points {
+		e.position(p)
+	}
+}
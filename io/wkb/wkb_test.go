@@ -0,0 +1,103 @@
+package wkb_test
+
+import (
+	"encoding/hex"
+	"github.com/paulmach/orb"
+	"github.com/stationa/xgeo/io/internal/geomtest"
+	"github.com/stationa/xgeo/io/wkb"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func testCases(t *testing.T) []geomtest.Case {
+	dir, err := ioutil.TempDir("", "wkb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cases, err := geomtest.All(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cases
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, test := range testCases(t) {
+		encodings := []struct {
+			name string
+			srid int
+			data []byte
+		}{
+			{"WKB", 0, wkb.Marshal(test.Geometry, test.Z, test.M)},
+			{"EWKB", 0, wkb.MarshalEWKB(test.Geometry, test.Z, test.M, 0)},
+			{"EWKB with SRID", 4326, wkb.MarshalEWKB(test.Geometry, test.Z, test.M, 4326)},
+		}
+		for _, encoding := range encodings {
+			g, z, m, srid, err := wkb.UnmarshalEWKB(encoding.data)
+			if err != nil {
+				t.Errorf("%s %s: %v", test.Name, encoding.name, err)
+				continue
+			}
+			if !orb.Equal(g, test.Geometry) && !(g == nil && test.Geometry == nil) {
+				t.Errorf("%s %s: got %#v, want %#v", test.Name, encoding.name, g, test.Geometry)
+			}
+			if !reflect.DeepEqual(z, test.Z) || !reflect.DeepEqual(m, test.M) {
+				t.Errorf("%s %s: got Z %v and M %v, want %v and %v", test.Name, encoding.name, z, m, test.Z, test.M)
+			}
+			if srid != encoding.srid {
+				t.Errorf("%s %s: got SRID %d, want %d", test.Name, encoding.name, srid, encoding.srid)
+			}
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		geometry orb.Geometry
+		z, m     []float64
+		srid     int
+		want     string
+	}{
+		{orb.Point{1, 2}, nil, nil, 0, "0101000000000000000000f03f0000000000000040"},
+		{orb.Point{1, 2}, []float64{3}, nil, 0, "01e9030000000000000000f03f00000000000000400000000000000840"},
+		{orb.Point{1, 2}, nil, []float64{4}, 0, "01d1070000000000000000f03f00000000000000400000000000001040"},
+		{orb.Point{1, 2}, []float64{3}, []float64{4}, 0, "01b90b0000000000000000f03f000000000000004000000000000008400000000000001040"},
+		{nil, nil, nil, 0, "0101000000000000000000f87f000000000000f87f"},
+		{orb.LineString{}, nil, nil, 0, "010200000000000000"},
+	}
+	for _, test := range tests {
+		if got := hex.EncodeToString(wkb.Marshal(test.geometry, test.z, test.m)); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
+
+func TestMarshalEWKB(t *testing.T) {
+	got := hex.EncodeToString(wkb.MarshalEWKB(orb.Point{1, 2}, []float64{3}, nil, 4326))
+	if want := "01010000a0e6100000000000000000f03f00000000000000400000000000000840"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestUnmarshalBigEndian(t *testing.T) {
+	data, _ := hex.DecodeString("0000000002000000023ff0000000000000400000000000000040080000000000004010000000000000")
+	g, _, _, err := wkb.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (orb.LineString{{1, 2}, {3, 4}}); !orb.Equal(g, want) {
+		t.Errorf("got %v, want %v", g, want)
+	}
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	data := wkb.Marshal(orb.LineString{{1, 2}, {3, 4}}, nil, nil)
+	for n := 0; n < len(data); n++ {
+		if _, _, _, err := wkb.Unmarshal(data[:n]); err == nil {
+			t.Errorf("decoded %d of %d bytes", n, len(data))
+		}
+	}
+}
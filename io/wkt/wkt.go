@@ -0,0 +1,528 @@
+// Package wkt converts between orb geometries and Well-Known Text
+package wkt
+
+import (
+	"fmt"
+	"github.com/paulmach/orb"
+	"github.com/stationa/xgeo/io/internal/geom"
+	"strconv"
+	"strings"
+)
+
+// Unmarshal parses the WKT of a geometry. Like io.DecodeGeometryZM, it also
+// returns the Z and M ordinates of every position, in traversal order, and
+// either slice is nil unless all positions have that ordinate. POINT EMPTY is
+// returned as a nil geometry. The SRID of extended WKT is accepted and
+// ignored.
+func Unmarshal(text string) (orb.Geometry, []float64, []float64, error) {
+	g, z, m, _, err := UnmarshalEWKT(text)
+	return g, z, m, err
+}
+
+// UnmarshalEWKT parses the WKT of a geometry like Unmarshal, and also returns
+// the SRID of the PostGIS extended WKT, as in SRID=4326;POINT(1 2). The SRID
+// is 0 when the text does not have one.
+func UnmarshalEWKT(text string) (orb.Geometry, []float64, []float64, int, error) {
+	p := &parser{lexer: lexer{input: text}}
+	srid, err := p.srid()
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	g, err := p.geometry()
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	if tok := p.next(); tok != "" {
+		return nil, nil, nil, 0, p.errorf("unexpected %q after geometry", tok)
+	}
+	z, m := p.z, p.m
+	if len(z) != p.count {
+		z = nil
+	}
+	if len(m) != p.count {
+		m = nil
+	}
+	return g, z, m, srid, nil
+}
+
+// lexer splits WKT into words, numbers and punctuation
+type lexer struct {
+	input  string
+	pos    int
+	peeked string
+}
+
+func (l *lexer) next() string {
+	if l.peeked != "" {
+		tok := l.peeked
+		l.peeked = ""
+		return tok
+	}
+	for l.pos < len(l.input) && isSpace(l.input[l.pos]) {
+		l.pos++
+	}
+	if l.pos >= len(l.input) {
+		return ""
+	}
+	start := l.pos
+	if c := l.input[l.pos]; c == '(' || c == ')' || c == ',' {
+		l.pos++
+		return l.input[start:l.pos]
+	}
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		if isSpace(c) || c == '(' || c == ')' || c == ',' {
+			break
+		}
+		l.pos++
+	}
+	return l.input[start:l.pos]
+}
+
+func (l *lexer) peek() string {
+	if l.peeked == "" {
+		l.peeked = l.next()
+	}
+	return l.peeked
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+type parser struct {
+	lexer
+	z, m  []float64
+	count int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid WKT at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		if got == "" {
+			return p.errorf("expected %q, found end of input", tok)
+		}
+		return p.errorf("expected %q, found %q", tok, got)
+	}
+	return nil
+}
+
+// srid reads the SRID=n; prefix of extended WKT, if there is one
+func (p *parser) srid() (int, error) {
+	for p.pos < len(p.input) && isSpace(p.input[p.pos]) {
+		p.pos++
+	}
+	if !strings.HasPrefix(strings.ToUpper(p.input[p.pos:]), "SRID=") {
+		return 0, nil
+	}
+	p.pos += len("SRID=")
+	end := strings.IndexByte(p.input[p.pos:], ';')
+	if end < 0 {
+		return 0, p.errorf("expected \";\" after SRID")
+	}
+	srid, err := strconv.Atoi(strings.TrimSpace(p.input[p.pos : p.pos+end]))
+	if err != nil {
+		return 0, p.errorf("invalid SRID %q", p.input[p.pos:p.pos+end])
+	}
+	p.pos += end + 1
+	return srid, nil
+}
+
+// dimension is the layout of the ordinates of a position: "" if it is implied
+// by the number of ordinates, or "Z", "M" or "ZM"
+type dimension string
+
+func (p *parser) geometry() (orb.Geometry, error) {
+	geomType := strings.ToUpper(p.next())
+	if geomType == "" {
+		return nil, p.errorf("geometry expected")
+	}
+	var dim dimension
+	// The dimension may also be attached to the type, as in POINTZ
+	for _, suffix := range []string{"ZM", "Z", "M"} {
+		trimmed := strings.TrimSuffix(geomType, suffix)
+		if trimmed != geomType && isGeometryType(trimmed) {
+			geomType, dim = trimmed, dimension(suffix)
+			break
+		}
+	}
+	if !isGeometryType(geomType) {
+		return nil, p.errorf("unsupported geometry type %s", geomType)
+	}
+	switch d := strings.ToUpper(p.peek()); d {
+	case "Z", "M", "ZM":
+		p.next()
+		dim = dimension(d)
+	}
+	if p.empty() {
+		return emptyGeometry(geomType), nil
+	}
+	switch geomType {
+	case "POINT":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		point, err := p.position(dim)
+		if err != nil {
+			return nil, err
+		}
+		return point, p.expect(")")
+	case "MULTIPOINT":
+		return p.multiPoint(dim)
+	case "LINESTRING":
+		points, err := p.positions(dim)
+		return orb.LineString(points), err
+	case "MULTILINESTRING":
+		var lines orb.MultiLineString
+		err := p.list(func() error {
+			if p.empty() {
+				lines = append(lines, orb.LineString{})
+				return nil
+			}
+			points, err := p.positions(dim)
+			lines = append(lines, orb.LineString(points))
+			return err
+		})
+		return lines, err
+	case "POLYGON":
+		return p.polygon(dim)
+	case "MULTIPOLYGON":
+		var polygons orb.MultiPolygon
+		err := p.list(func() error {
+			if p.empty() {
+				polygons = append(polygons, orb.Polygon{})
+				return nil
+			}
+			polygon, err := p.polygon(dim)
+			polygons = append(polygons, polygon)
+			return err
+		})
+		return polygons, err
+	}
+	// Empty points are left out, as they are of a MultiPoint
+	collection := orb.Collection{}
+	err := p.list(func() error {
+		g, err := p.geometry()
+		if g != nil {
+			collection = append(collection, g)
+		}
+		return err
+	})
+	return collection, err
+}
+
+// empty reads the EMPTY keyword, if it comes next
+func (p *parser) empty() bool {
+	if strings.ToUpper(p.peek()) != "EMPTY" {
+		return false
+	}
+	p.next()
+	return true
+}
+
+func isGeometryType(name string) bool {
+	switch name {
+	case "POINT", "MULTIPOINT", "LINESTRING", "MULTILINESTRING", "POLYGON", "MULTIPOLYGON", "GEOMETRYCOLLECTION":
+		return true
+	}
+	return false
+}
+
+func emptyGeometry(geomType string) orb.Geometry {
+	switch geomType {
+	case "MULTIPOINT":
+		return orb.MultiPoint{}
+	case "LINESTRING":
+		return orb.LineString{}
+	case "MULTILINESTRING":
+		return orb.MultiLineString{}
+	case "POLYGON":
+		return orb.Polygon{}
+	case "MULTIPOLYGON":
+		return orb.MultiPolygon{}
+	case "GEOMETRYCOLLECTION":
+		return orb.Collection{}
+	}
+	return nil
+}
+
+// list parses a parenthesized, comma separated list, calling item for each
+// element
+func (p *parser) list(item func() error) error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+		switch tok := p.next(); tok {
+		case ")":
+			return nil
+		case ",":
+		default:
+			return p.errorf("expected \",\" or \")\", found %q", tok)
+		}
+	}
+}
+
+func (p *parser) position(dim dimension) (orb.Point, error) {
+	var ordinates []float64
+	for {
+		tok := p.peek()
+		if tok == "" || tok == "," || tok == ")" || tok == "(" {
+			break
+		}
+		p.next()
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return orb.Point{}, p.errorf("invalid number %q", tok)
+		}
+		ordinates = append(ordinates, f)
+	}
+	if len(ordinates) < 2 || len(ordinates) > 4 {
+		return orb.Point{}, p.errorf("a position must have 2 to 4 ordinates")
+	}
+	var z, m []float64
+	switch {
+	case dim == "M" && len(ordinates) == 3:
+		m = ordinates[2:3]
+	case len(ordinates) == 3:
+		z = ordinates[2:3]
+	case len(ordinates) == 4:
+		z, m = ordinates[2:3], ordinates[3:4]
+	}
+	p.count++
+	if z != nil && len(p.z) == p.count-1 {
+		p.z = append(p.z, z[0])
+	}
+	if m != nil && len(p.m) == p.count-1 {
+		p.m = append(p.m, m[0])
+	}
+	return orb.Point{ordinates[0], ordinates[1]}, nil
+}
+
+func (p *parser) positions(dim dimension) ([]orb.Point, error) {
+	var points []orb.Point
+	err := p.list(func() error {
+		point, err := p.position(dim)
+		points = append(points, point)
+		return err
+	})
+	return points, err
+}
+
+// multiPoint accepts points with or without parentheses around each, as in
+// MULTIPOINT ((1 2), (3 4)) and MULTIPOINT (1 2, 3 4). Empty points, which
+// orb cannot hold, are left out.
+func (p *parser) multiPoint(dim dimension) (orb.MultiPoint, error) {
+	points := orb.MultiPoint{}
+	err := p.list(func() error {
+		if p.empty() {
+			return nil
+		}
+		if p.peek() != "(" {
+			point, err := p.position(dim)
+			points = append(points, point)
+			return err
+		}
+		p.next()
+		point, err := p.position(dim)
+		if err != nil {
+			return err
+		}
+		points = append(points, point)
+		return p.expect(")")
+	})
+	return points, err
+}
+
+func (p *parser) polygon(dim dimension) (orb.Polygon, error) {
+	var polygon orb.Polygon
+	err := p.list(func() error {
+		if p.empty() {
+			polygon = append(polygon, orb.Ring{})
+			return nil
+		}
+		points, err := p.positions(dim)
+		polygon = append(polygon, orb.Ring(points))
+		return err
+	})
+	return polygon, err
+}
+
+// Marshal returns the WKT of a geometry. If z or m hold an ordinate for every
+// position of the geometry, in traversal order, they are written as well, and
+// the geometry is tagged Z, M or ZM. A nil geometry is written as POINT EMPTY.
+func Marshal(g orb.Geometry, z, m []float64) string {
+	n := geom.PositionCount(g)
+	if len(z) != n || n == 0 {
+		z = nil
+	}
+	if len(m) != n || n == 0 {
+		m = nil
+	}
+	w := &writer{z: z, m: m}
+	w.geometry(g)
+	return w.String()
+}
+
+// MarshalEWKT returns the PostGIS extended WKT of a geometry, which is its WKT
+// prefixed with SRID=srid; unless srid is 0.
+func MarshalEWKT(g orb.Geometry, z, m []float64, srid int) string {
+	if srid == 0 {
+		return Marshal(g, z, m)
+	}
+	return "SRID=" + strconv.Itoa(srid) + ";" + Marshal(g, z, m)
+}
+
+type writer struct {
+	strings.Builder
+	z, m  []float64
+	index int
+}
+
+func (w *writer) geometry(g orb.Geometry) {
+	switch g := g.(type) {
+	case orb.Point:
+		w.tag("POINT")
+		w.WriteByte('(')
+		w.position(g)
+		w.WriteByte(')')
+	case orb.MultiPoint:
+		w.tag("MULTIPOINT")
+		if len(g) == 0 {
+			w.WriteString("EMPTY")
+			return
+		}
+		w.WriteByte('(')
+		for i, p := range g {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteByte('(')
+			w.position(p)
+			w.WriteByte(')')
+		}
+		w.WriteByte(')')
+	case orb.LineString:
+		w.tag("LINESTRING")
+		w.positions(g)
+	case orb.MultiLineString:
+		w.tag("MULTILINESTRING")
+		if len(g) == 0 {
+			w.WriteString("EMPTY")
+			return
+		}
+		w.WriteByte('(')
+		for i, line := range g {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.positions(line)
+		}
+		w.WriteByte(')')
+	case orb.Ring:
+		w.geometry(orb.Polygon{g})
+	case orb.Polygon:
+		w.tag("POLYGON")
+		w.polygon(g)
+	case orb.MultiPolygon:
+		w.tag("MULTIPOLYGON")
+		if len(g) == 0 {
+			w.WriteString("EMPTY")
+			return
+		}
+		w.WriteByte('(')
+		for i, polygon := range g {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.polygon(polygon)
+		}
+		w.WriteByte(')')
+	case orb.Bound:
+		w.geometry(g.ToPolygon())
+	case orb.Collection:
+		w.tag("GEOMETRYCOLLECTION")
+		if len(g) == 0 {
+			w.WriteString("EMPTY")
+			return
+		}
+		w.WriteByte('(')
+		for i, item := range g {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.geometry(item)
+		}
+		w.WriteByte(')')
+	default:
+		w.WriteString("POINT EMPTY")
+	}
+}
+
+func (w *writer) tag(geomType string) {
+	w.WriteString(geomType)
+	switch {
+	case w.z != nil && w.m != nil:
+		w.WriteString(" ZM")
+	case w.z != nil:
+		w.WriteString(" Z")
+	case w.m != nil:
+		w.WriteString(" M")
+	}
+	w.WriteByte(' ')
+}
+
+func (w *writer) position(p orb.Point) {
+	w.number(p[0])
+	w.WriteByte(' ')
+	w.number(p[1])
+	if w.z != nil {
+		w.WriteByte(' ')
+		w.number(w.z[w.index])
+	}
+	if w.m != nil {
+		w.WriteByte(' ')
+		w.number(w.m[w.index])
+	}
+	w.index++
+}
+
+func (w *writer) positions(points []orb.Point) {
+	if len(points) == 0 {
+		w.WriteString("EMPTY")
+		return
+	}
+	w.WriteByte('(')
+	for i, p := range points {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.position(p)
+	}
+	w.WriteByte(')')
+}
+
+func (w *writer) polygon(polygon orb.Polygon) {
+	if len(polygon) == 0 {
+		w.WriteString("EMPTY")
+		return
+	}
+	w.WriteByte('(')
+	for i, ring := range polygon {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.positions(ring)
+	}
+	w.WriteByte(')')
+}
+
+func (w *writer) number(f float64) {
+	w.WriteString(strconv.FormatFloat(f, 'f', -1, 64))
+}
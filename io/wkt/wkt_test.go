@@ -0,0 +1,114 @@
+package wkt_test
+
+import (
+	"github.com/paulmach/orb"
+	"github.com/stationa/xgeo/io/internal/geomtest"
+	"github.com/stationa/xgeo/io/wkt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func testCases(t *testing.T) []geomtest.Case {
+	dir, err := ioutil.TempDir("", "wkt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cases, err := geomtest.All(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cases
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, test := range testCases(t) {
+		for _, srid := range []int{0, 4326} {
+			text := wkt.MarshalEWKT(test.Geometry, test.Z, test.M, srid)
+			g, z, m, gotSRID, err := wkt.UnmarshalEWKT(text)
+			if err != nil {
+				t.Errorf("%s: %s: %v", test.Name, text, err)
+				continue
+			}
+			if !orb.Equal(g, test.Geometry) && !(g == nil && test.Geometry == nil) {
+				t.Errorf("%s: got %#v, want %#v", text, g, test.Geometry)
+			}
+			if !reflect.DeepEqual(z, test.Z) || !reflect.DeepEqual(m, test.M) {
+				t.Errorf("%s: got Z %v and M %v, want %v and %v", text, z, m, test.Z, test.M)
+			}
+			if gotSRID != srid {
+				t.Errorf("%s: got SRID %d, want %d", text, gotSRID, srid)
+			}
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		geometry orb.Geometry
+		z, m     []float64
+		srid     int
+		want     string
+	}{
+		{orb.Point{1, 2}, nil, nil, 0, "POINT (1 2)"},
+		{orb.Point{1, 2}, []float64{3}, nil, 0, "POINT Z (1 2 3)"},
+		{orb.Point{1, 2}, nil, []float64{4}, 0, "POINT M (1 2 4)"},
+		{orb.Point{1, 2}, []float64{3}, []float64{4}, 4326, "SRID=4326;POINT ZM (1 2 3 4)"},
+		{orb.MultiPoint{{1, 2}, {3, 4}}, []float64{5, 6}, nil, 0, "MULTIPOINT Z ((1 2 5),(3 4 6))"},
+		{orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}, []float64{1, 2}, nil, 0, "POLYGON ((0 0,1 0,1 1,0 0))"},
+		{nil, nil, nil, 0, "POINT EMPTY"},
+		{orb.LineString{}, nil, nil, 3857, "SRID=3857;LINESTRING EMPTY"},
+		{orb.Collection{orb.Point{1, 2}, orb.LineString{{0, 0}, {1, 1}}}, nil, []float64{1, 2, 3}, 0, "GEOMETRYCOLLECTION M (POINT M (1 2 1),LINESTRING M (0 0 2,1 1 3))"},
+	}
+	for _, test := range tests {
+		if got := wkt.MarshalEWKT(test.geometry, test.z, test.m, test.srid); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		text     string
+		geometry orb.Geometry
+		z        []float64
+	}{
+		{"POINT (1 2)", orb.Point{1, 2}, nil},
+		{"MULTIPOINT (1 2, 3 4)", orb.MultiPoint{{1, 2}, {3, 4}}, nil},
+		{"MULTIPOINT EMPTY", orb.MultiPoint{}, nil},
+		// Empty points are left out of multi-geometries
+		{"MULTIPOINT (EMPTY, (1 2))", orb.MultiPoint{{1, 2}}, nil},
+		{"MULTIPOINT Z ((1 2 3), EMPTY, 4 5 6)", orb.MultiPoint{{1, 2}, {4, 5}}, []float64{3, 6}},
+		{"MULTIPOINT (EMPTY)", orb.MultiPoint{}, nil},
+		{"MULTILINESTRING (EMPTY, (1 2, 3 4))", orb.MultiLineString{{}, {{1, 2}, {3, 4}}}, nil},
+		{"MULTIPOLYGON (((0 0, 1 0, 1 1, 0 0)), EMPTY)", orb.MultiPolygon{{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}, {}}, nil},
+		{"MULTIPOLYGON (EMPTY)", orb.MultiPolygon{{}}, nil},
+		{"POLYGON ((0 0, 1 0, 1 1, 0 0), EMPTY)", orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}, {}}, nil},
+		{"GEOMETRYCOLLECTION (POINT EMPTY)", orb.Collection{}, nil},
+		{"GEOMETRYCOLLECTION (POINT EMPTY, LINESTRING EMPTY, POINT Z (1 2 3))", orb.Collection{orb.LineString{}, orb.Point{1, 2}}, []float64{3}},
+		{"GEOMETRYCOLLECTION (MULTIPOINT (EMPTY), GEOMETRYCOLLECTION EMPTY)", orb.Collection{orb.MultiPoint{}, orb.Collection{}}, nil},
+	}
+	for _, test := range tests {
+		g, z, _, err := wkt.Unmarshal(test.text)
+		if err != nil {
+			t.Errorf("%s: %v", test.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(g, test.geometry) || !reflect.DeepEqual(z, test.z) {
+			t.Errorf("%s: got %#v with Z %v, want %#v with %v", test.text, g, z, test.geometry, test.z)
+		}
+		// What is written is read back the same
+		text := wkt.Marshal(g, z, nil)
+		if again, _, _, err := wkt.Unmarshal(text); err != nil || !reflect.DeepEqual(again, g) {
+			t.Errorf("%s: got %#v, %v", text, again, err)
+		}
+	}
+
+	for _, text := range []string{"MULTIPOINT (EMPTY", "MULTIPOINT (EMPTY EMPTY)", "POINT (EMPTY)", "LINESTRING (1 2, EMPTY)", "GEOMETRYCOLLECTION (EMPTY)"} {
+		if g, _, _, err := wkt.Unmarshal(text); err == nil {
+			t.Errorf("%s: got %#v", text, g)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package pluscode annotates features with their Open Location Code, also
+// known as Plus Code, and turns Plus Codes back into geometries
+package pluscode
+
+import (
+	"context"
+	"fmt"
+	olc "github.com/google/open-location-code/go"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	gio "github.com/stationa/xgeo/io"
+	"strings"
+)
+
+// DefaultLength is the length of the Plus Codes of cells of about 14 by 14
+// meters, which is what Google Maps shows
+const DefaultLength = 10
+
+// Encoder stores the Plus Code of the location of each feature in a property.
+// The location of a point is the point itself, that of other geometries is
+// their centroid.
+type Encoder struct {
+	Property string
+	Length   int
+}
+
+func NewEncoder(property string, length int) (*Encoder, error) {
+	if length < 2 || length > 15 || length < DefaultLength && length%2 != 0 {
+		return nil, fmt.Errorf("invalid Plus Code length %d, it must be 2, 4, 6, 8 or 10 to 15", length)
+	}
+	return &Encoder{
+		Property: property,
+		Length:   length,
+	}, nil
+}
+
+// Encode returns the Plus Code of a geometry's location, or an empty string
+// for a nil or empty geometry, which has no location
+func Encode(g orb.Geometry, length int) string {
+	if gio.PositionCount(g) == 0 {
+		return ""
+	}
+	point, ok := g.(orb.Point)
+	if !ok {
+		point, _ = planar.CentroidArea(g)
+	}
+	return olc.Encode(point.Lat(), point.Lon(), length)
+}
+
+// Process sets the property of a feature to its Plus Code, or to null if it
+// has no geometry or an empty one
+func (e *Encoder) Process(feature *gio.Feature) {
+	var code interface{}
+	if c := Encode(feature.Geometry, e.Length); c != "" {
+		code = c
+	}
+	feature.Properties.Set(e.Property, code)
+}
+
+// Run processes the features from in and sends them to out, until in is
+// closed or ctx is done
+func (e *Encoder) Run(ctx context.Context, in chan *gio.Feature, out chan *gio.Feature) error {
+	for feature := range in {
+		if feature == nil {
+			continue
+		}
+		e.Process(feature)
+		if err := gio.Emit(ctx, out, feature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decoder replaces the geometry of each feature with the location of the Plus
+// Code in one of its properties: the center of the code's cell, or the cell
+// itself as a polygon if Cell is set. Features without a code get a null
+// geometry.
+type Decoder struct {
+	Property string
+	Cell     bool
+}
+
+func NewDecoder(property string, cell bool) *Decoder {
+	return &Decoder{
+		Property: property,
+		Cell:     cell,
+	}
+}
+
+// Decode returns the center, or the cell polygon, of a full Plus Code
+func Decode(code string, cell bool) (orb.Geometry, error) {
+	code = strings.TrimSpace(code)
+	if err := olc.CheckShort(code); err == nil {
+		return nil, fmt.Errorf("%q is a short Plus Code, which needs a reference location to be recovered", code)
+	}
+	area, err := olc.Decode(code)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Plus Code %q: %v", code, err)
+	}
+	if cell {
+		return Cell(area), nil
+	}
+	lat, lng := area.Center()
+	return orb.Point{lng, lat}, nil
+}
+
+// Cell returns the polygon of the area of a Plus Code
+func Cell(area olc.CodeArea) orb.Polygon {
+	return orb.Bound{
+		Min: orb.Point{area.LngLo, area.LatLo},
+		Max: orb.Point{area.LngHi, area.LatHi},
+	}.ToPolygon()
+}
+
+// Process replaces the geometry of a feature with the location of its Plus
+// Code. The Z and M ordinates and the bounding box of the former geometry are
+// dropped.
+func (d *Decoder) Process(feature *gio.Feature) error {
+	var g orb.Geometry
+	switch value, _ := feature.Properties.Get(d.Property); v := value.(type) {
+	case nil:
+	case string:
+		if strings.TrimSpace(v) != "" {
+			var err error
+			if g, err = Decode(v, d.Cell); err != nil {
+				return fmt.Errorf("property %s: %v", d.Property, err)
+			}
+		}
+	default:
+		return fmt.Errorf("property %s: Plus Code expected, got %v", d.Property, v)
+	}
+	feature.Geometry, feature.Z, feature.M, feature.BBox = g, nil, nil, nil
+	return nil
+}
+
+// Run processes the features from in and sends them to out, until in is
+// closed, ctx is done or a feature has an invalid Plus Code
+func (d *Decoder) Run(ctx context.Context, in chan *gio.Feature, out chan *gio.Feature) error {
+	for feature := range in {
+		if feature == nil {
+			continue
+		}
+		if err := d.Process(feature); err != nil {
+			return err
+		}
+		if err := gio.Emit(ctx, out, feature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
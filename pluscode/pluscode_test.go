@@ -0,0 +1,144 @@
+package pluscode
+
+import (
+	"context"
+	"github.com/paulmach/orb"
+	gio "github.com/stationa/xgeo/io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewEncoder(t *testing.T) {
+	for length := -1; length <= 17; length++ {
+		_, err := NewEncoder("code", length)
+		valid := length == 2 || length == 4 || length == 6 || length == 8 || length >= 10 && length <= 15
+		if (err == nil) != valid {
+			t.Errorf("length %d: got %v", length, err)
+		}
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	zurich := orb.Point{8.524997, 47.365590}
+	tests := []struct {
+		name     string
+		geometry orb.Geometry
+		length   int
+		want     interface{}
+	}{
+		{"point", zurich, 10, "8FVC9G8F+6X"},
+		{"long code", zurich, 11, "8FVC9G8F+6XQ"},
+		{"short code", zurich, 4, "8FVC0000+"},
+		{"centroid", orb.Polygon{{{8.52, 47.36}, {8.53, 47.36}, {8.53, 47.37}, {8.52, 47.37}, {8.52, 47.36}}}, 8, "8FVC9G8G+"},
+		{"no geometry", nil, 10, nil},
+		{"empty line", orb.LineString{}, 10, nil},
+		{"empty polygon", orb.Polygon{}, 10, nil},
+		{"empty collection", orb.Collection{}, 10, nil},
+	}
+	for _, test := range tests {
+		encoder, err := NewEncoder("code", test.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		feature := gio.NewFeature(test.geometry)
+		feature.Properties = gio.Properties{{Key: "code", Value: "stale"}, {Key: "name", Value: test.name}}
+		encoder.Process(feature)
+		want := gio.Properties{{Key: "code", Value: test.want}, {Key: "name", Value: test.name}}
+		if !reflect.DeepEqual(feature.Properties, want) {
+			t.Errorf("%s: got %v, want %v", test.name, feature.Properties, want)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	center := orb.Point{8.5249375, 47.3655625}
+	tests := []struct {
+		name  string
+		value interface{}
+		cell  bool
+		want  orb.Geometry
+		err   string
+	}{
+		{"center", "8FVC9G8F+6X", false, center, ""},
+		{"spaces", " 8FVC9G8F+6X\n", false, center, ""},
+		{"cell", "8FVC9G8F+6X", true, orb.Bound{Min: orb.Point{8.524875, 47.3655}, Max: orb.Point{8.525, 47.365625}}.ToPolygon(), ""},
+		{"padded", "8FVC0000+", false, orb.Point{8.5, 47.5}, ""},
+		{"null", nil, false, nil, ""},
+		{"blank", "  ", false, nil, ""},
+		{"short code", "9G8F+6X", false, nil, "property code: \"9G8F+6X\" is a short Plus Code"},
+		{"no separator", "8FVC9G8F", false, nil, "property code: invalid Plus Code \"8FVC9G8F\""},
+		{"invalid character", "8FVC9G8F+1", false, nil, "property code: invalid Plus Code"},
+		{"text", "hello", false, nil, "property code: invalid Plus Code"},
+		{"number", 42.0, false, nil, "property code: Plus Code expected, got 42"},
+	}
+	for _, test := range tests {
+		feature := gio.NewFeature(orb.LineString{{0, 0}, {1, 1}})
+		feature.Z = []float64{1, 2}
+		feature.BBox = []float64{0, 0, 1, 1}
+		feature.Properties = gio.Properties{{Key: "code", Value: test.value}}
+		err := NewDecoder("code", test.cell).Process(feature)
+		if test.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !approxEqual(feature.Geometry, test.want) || feature.Z != nil || feature.BBox != nil {
+			t.Errorf("%s: got %v with Z %v and bbox %v, want %v", test.name, feature.Geometry, feature.Z, feature.BBox, test.want)
+		}
+	}
+}
+
+// approxEqual compares geometries up to rounding errors of the cell bounds
+func approxEqual(a, b orb.Geometry) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if a.GeoJSONType() != b.GeoJSONType() {
+		return false
+	}
+	pa, pb := a.Bound(), b.Bound()
+	for _, d := range []float64{pa.Min[0] - pb.Min[0], pa.Min[1] - pb.Min[1], pa.Max[0] - pb.Max[0], pa.Max[1] - pb.Max[1]} {
+		if math.Abs(d) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := make(chan *gio.Feature, 3)
+	points := []orb.Point{{-122.0841, 37.4220}, {151.2153, -33.8568}, {0.0001, 0.0001}}
+	for _, p := range points {
+		in <- gio.NewFeature(p)
+	}
+	close(in)
+	encoder, _ := NewEncoder("code", 11)
+	encoded := make(chan *gio.Feature, 3)
+	if err := encoder.Run(context.Background(), in, encoded); err != nil {
+		t.Fatal(err)
+	}
+	close(encoded)
+	decoded := make(chan *gio.Feature, 3)
+	if err := NewDecoder("code", false).Run(context.Background(), encoded, decoded); err != nil {
+		t.Fatal(err)
+	}
+	close(decoded)
+	i := 0
+	for feature := range decoded {
+		// Cells of 11-digit codes are about 3 by 3 meters
+		if p := feature.Geometry.(orb.Point); math.Abs(p[0]-points[i][0]) > 3e-5 || math.Abs(p[1]-points[i][1]) > 3e-5 {
+			t.Errorf("%v: got %v", points[i], p)
+		}
+		i++
+	}
+	if i != len(points) {
+		t.Errorf("decoded %d features, want %d", i, len(points))
+	}
+}
@@ -0,0 +1,256 @@
+package script
+
+import (
+	"fmt"
+	"github.com/Shopify/go-lua"
+	gio "github.com/stationa/xgeo/io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+func pushFeature(l *lua.State, f *gio.Feature) {
+	l.CreateTable(0, 5)
+	l.PushString("Feature")
+	l.SetField(-2, "type")
+	if f.ID != nil {
+		pushValue(l, f.ID)
+		l.SetField(-2, "id")
+	}
+	if len(f.BBox) > 0 {
+		pushValue(l, f.BBox)
+		l.SetField(-2, "bbox")
+	}
+	if f.Geometry != nil {
+		pushGeometry(l, f.Geometry)
+		l.SetField(-2, "geometry")
+	}
+	if f.HasZ() {
+		pushValue(l, f.Z)
+		l.SetField(-2, "z")
+	}
+	if f.HasM() {
+		pushValue(l, f.M)
+		l.SetField(-2, "m")
+	}
+	l.CreateTable(0, len(f.Properties))
+	for _, prop := range f.Properties {
+		pushValue(l, prop.Value)
+		l.SetField(-2, prop.Key)
+	}
+	l.SetField(-2, "properties")
+}
+
+// toFeature converts the feature table at index back into a Feature.
+// Properties that already existed on the original feature keep their order,
+// new ones are appended in lexical order. Since Lua tables cannot hold nil,
+// the null properties of the original are kept if the feature still has its
+// properties table and the script did not set them.
+func toFeature(l *lua.State, index int, original *gio.Feature, sameProperties bool) (*gio.Feature, error) {
+	table, ok := toValue(l, index).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("feature must be a table with named fields")
+	}
+	feature := &gio.Feature{
+		ID: restoreValue(original.ID, table["id"]),
+	}
+	if geometry, ok := table["geometry"].(map[string]interface{}); ok {
+		g, err := gio.DecodeGeometry(geometry)
+		if err != nil {
+			return nil, err
+		}
+		feature.Geometry = g
+	}
+	feature.BBox = toFloats(table["bbox"])
+	// Ordinates that no longer match the geometry are dropped
+	if feature.Z = toFloats(table["z"]); !feature.HasZ() {
+		feature.Z = nil
+	}
+	if feature.M = toFloats(table["m"]); !feature.HasM() {
+		feature.M = nil
+	}
+	properties, _ := table["properties"].(map[string]interface{})
+	for _, prop := range original.Properties {
+		if value, ok := properties[prop.Key]; ok || (sameProperties && prop.Value == nil) {
+			feature.Properties = append(feature.Properties, gio.Property{Key: prop.Key, Value: restoreValue(prop.Value, value)})
+			delete(properties, prop.Key)
+		}
+	}
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		feature.Properties = append(feature.Properties, gio.Property{Key: key, Value: properties[key]})
+	}
+	return feature, nil
+}
+
+func pushValue(l *lua.State, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		l.PushNil()
+	case bool:
+		l.PushBoolean(v)
+	case string:
+		l.PushString(v)
+	case float64:
+		l.PushNumber(v)
+	case int:
+		pushInteger(l, int64(v))
+	case int64:
+		pushInteger(l, v)
+	case time.Time:
+		l.PushString(gio.FormatTime(v))
+	case map[string]interface{}:
+		l.CreateTable(0, len(v))
+		for key, item := range v {
+			pushValue(l, item)
+			l.SetField(-2, key)
+		}
+	case []interface{}:
+		l.CreateTable(len(v), 0)
+		for i, item := range v {
+			pushValue(l, item)
+			l.RawSetInt(-2, i+1)
+		}
+	default:
+		pushReflectValue(l, reflect.ValueOf(value))
+	}
+}
+
+// pushReflectValue handles the remaining numeric types and typed slices, such
+// as the []float64 of a feature's bbox and Z and M ordinates
+func pushReflectValue(l *lua.State, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		pushInteger(l, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > maxExactInteger {
+			l.PushString(strconv.FormatUint(v.Uint(), 10))
+		} else {
+			l.PushNumber(float64(v.Uint()))
+		}
+	case reflect.Float32, reflect.Float64:
+		l.PushNumber(v.Float())
+	case reflect.Slice, reflect.Array:
+		l.CreateTable(v.Len(), 0)
+		for i := 0; i < v.Len(); i++ {
+			pushValue(l, v.Index(i).Interface())
+			l.RawSetInt(-2, i+1)
+		}
+	case reflect.Map:
+		l.CreateTable(0, v.Len())
+		for _, key := range v.MapKeys() {
+			pushValue(l, v.MapIndex(key).Interface())
+			l.SetField(-2, key.String())
+		}
+	default:
+		l.PushNil()
+	}
+}
+
+// maxExactInteger is the largest integer up to which every integer is a
+// float64, as Lua numbers are
+const maxExactInteger = 1 << 53
+
+// pushInteger pushes an integer as a number if the number holds it exactly,
+// or as its decimal string otherwise, so that large IDs are not corrupted
+func pushInteger(l *lua.State, v int64) {
+	if v > maxExactInteger || v < -maxExactInteger {
+		l.PushString(strconv.FormatInt(v, 10))
+		return
+	}
+	l.PushNumber(float64(v))
+}
+
+// restoreValue converts a value returned by a script back to the integer type
+// of the original value, if it was an integer and the script left it a whole
+// number, or the string that pushInteger made of it
+func restoreValue(original, value interface{}) interface{} {
+	var n int64
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) || v > math.MaxInt64 || v < math.MinInt64 {
+			return value
+		}
+		n = int64(v)
+	case string:
+		// Only integers too large for numbers are pushed as strings
+		var err error
+		if n, err = strconv.ParseInt(v, 10, 64); err != nil || (n <= maxExactInteger && n >= -maxExactInteger) {
+			return value
+		}
+	default:
+		return value
+	}
+	switch original.(type) {
+	case int:
+		return int(n)
+	case int64:
+		return n
+	}
+	return value
+}
+
+func toValue(l *lua.State, index int) interface{} {
+	switch l.TypeOf(index) {
+	case lua.TypeBoolean:
+		return l.ToBoolean(index)
+	case lua.TypeNumber:
+		n, _ := l.ToNumber(index)
+		return n
+	case lua.TypeString:
+		s, _ := l.ToString(index)
+		return s
+	case lua.TypeTable:
+		return toTable(l, index)
+	}
+	return nil
+}
+
+// toTable converts a Lua table to a []interface{} if it is a sequence, or to a
+// map[string]interface{} otherwise. Empty tables are treated as objects.
+func toTable(l *lua.State, index int) interface{} {
+	index = l.AbsIndex(index)
+	length := l.RawLength(index)
+	values := make(map[string]interface{})
+	count := 0
+	l.PushNil()
+	for l.Next(index) {
+		count++
+		// Copy the key so that ToString does not confuse Next
+		l.PushValue(-2)
+		if key, ok := l.ToString(-1); ok {
+			values[key] = toValue(l, -2)
+		}
+		l.Pop(2)
+	}
+	if length == 0 || count != length {
+		return values
+	}
+	array := make([]interface{}, length)
+	for i := 1; i <= length; i++ {
+		l.RawGetInt(index, i)
+		array[i-1] = toValue(l, -1)
+		l.Pop(1)
+	}
+	return array
+}
+
+func toFloats(value interface{}) []float64 {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	floats := make([]float64, 0, len(values))
+	for _, v := range values {
+		if n, ok := v.(float64); ok {
+			floats = append(floats, n)
+		}
+	}
+	return floats
+}
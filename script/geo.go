@@ -0,0 +1,148 @@
+package script
+
+import (
+	"encoding/hex"
+	"github.com/Shopify/go-lua"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/planar"
+	"github.com/paulmach/orb/simplify"
+	gio "github.com/stationa/xgeo/io"
+	"github.com/stationa/xgeo/io/wkb"
+	"github.com/stationa/xgeo/io/wkt"
+)
+
+// geoLibrary is exposed to scripts as the global "geo" table. Functions with
+// a planar_ prefix treat coordinates as 2D euclidean space, the others assume
+// lon/lat coordinates and return meters.
+var geoLibrary = []lua.RegistryFunction{
+	{Name: "area", Function: func(l *lua.State) int {
+		l.PushNumber(geo.Area(checkGeometry(l, 1)))
+		return 1
+	}},
+	{Name: "planar_area", Function: func(l *lua.State) int {
+		l.PushNumber(planar.Area(checkGeometry(l, 1)))
+		return 1
+	}},
+	{Name: "length", Function: func(l *lua.State) int {
+		l.PushNumber(geo.Length(checkGeometry(l, 1)))
+		return 1
+	}},
+	{Name: "planar_length", Function: func(l *lua.State) int {
+		l.PushNumber(planar.Length(checkGeometry(l, 1)))
+		return 1
+	}},
+	{Name: "distance", Function: func(l *lua.State) int {
+		l.PushNumber(geo.Distance(checkPoint(l, 1), checkPoint(l, 2)))
+		return 1
+	}},
+	{Name: "planar_distance", Function: func(l *lua.State) int {
+		l.PushNumber(planar.DistanceFrom(checkGeometry(l, 1), checkPoint(l, 2)))
+		return 1
+	}},
+	{Name: "centroid", Function: func(l *lua.State) int {
+		centroid, _ := planar.CentroidArea(checkGeometry(l, 1))
+		pushGeometry(l, centroid)
+		return 1
+	}},
+	{Name: "bound", Function: func(l *lua.State) int {
+		bound := checkGeometry(l, 1).Bound()
+		pushValue(l, []float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]})
+		return 1
+	}},
+	{Name: "contains", Function: func(l *lua.State) int {
+		point := checkPoint(l, 2)
+		switch g := checkGeometry(l, 1).(type) {
+		case orb.Polygon:
+			l.PushBoolean(planar.PolygonContains(g, point))
+		case orb.MultiPolygon:
+			l.PushBoolean(planar.MultiPolygonContains(g, point))
+		default:
+			lua.ArgumentError(l, 1, "polygon or multipolygon expected")
+		}
+		return 1
+	}},
+	{Name: "simplify", Function: func(l *lua.State) int {
+		g := checkGeometry(l, 1)
+		threshold := lua.CheckNumber(l, 2)
+		pushGeometry(l, simplify.DouglasPeucker(threshold).Simplify(orb.Clone(g)))
+		return 1
+	}},
+	{Name: "wkt", Function: func(l *lua.State) int {
+		g := checkGeometry(l, 1)
+		l.PushString(wkt.MarshalEWKT(g, nil, nil, lua.OptInteger(l, 2, 0)))
+		return 1
+	}},
+	{Name: "from_wkt", Function: func(l *lua.State) int {
+		g, _, _, srid, err := wkt.UnmarshalEWKT(lua.CheckString(l, 1))
+		if err != nil {
+			lua.ArgumentError(l, 1, err.Error())
+		}
+		pushGeometry(l, g)
+		l.PushInteger(srid)
+		return 2
+	}},
+	{Name: "wkb", Function: func(l *lua.State) int {
+		g := checkGeometry(l, 1)
+		data := wkb.Marshal(g, nil, nil)
+		if srid := lua.OptInteger(l, 2, 0); srid != 0 {
+			data = wkb.MarshalEWKB(g, nil, nil, srid)
+		}
+		l.PushString(hex.EncodeToString(data))
+		return 1
+	}},
+	{Name: "from_wkb", Function: func(l *lua.State) int {
+		data, err := hex.DecodeString(lua.CheckString(l, 1))
+		if err != nil {
+			lua.ArgumentError(l, 1, "invalid hex: "+err.Error())
+		}
+		g, _, _, srid, err := wkb.UnmarshalEWKB(data)
+		if err != nil {
+			lua.ArgumentError(l, 1, err.Error())
+		}
+		pushGeometry(l, g)
+		l.PushInteger(srid)
+		return 2
+	}},
+}
+
+func geoOpen(l *lua.State) int {
+	lua.NewLibrary(l, geoLibrary)
+	return 1
+}
+
+func checkGeometry(l *lua.State, index int) orb.Geometry {
+	lua.CheckType(l, index, lua.TypeTable)
+	geometry, ok := toValue(l, index).(map[string]interface{})
+	if !ok {
+		lua.ArgumentError(l, index, "geometry expected")
+	}
+	// Accept whole features as well as bare geometries
+	if geometry["type"] == "Feature" {
+		geometry, _ = geometry["geometry"].(map[string]interface{})
+	}
+	g, err := gio.DecodeGeometry(geometry)
+	if err != nil {
+		lua.ArgumentError(l, index, err.Error())
+	}
+	if g == nil {
+		lua.ArgumentError(l, index, "geometry expected")
+	}
+	return g
+}
+
+func checkPoint(l *lua.State, index int) orb.Point {
+	point, ok := checkGeometry(l, index).(orb.Point)
+	if !ok {
+		lua.ArgumentError(l, index, "point expected")
+	}
+	return point
+}
+
+func pushGeometry(l *lua.State, g orb.Geometry) {
+	if g == nil {
+		l.PushNil()
+		return
+	}
+	pushValue(l, gio.EncodeGeometry(g))
+}
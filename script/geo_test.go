@@ -0,0 +1,105 @@
+package script
+
+import (
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	gio "github.com/stationa/xgeo/io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// geoPrelude defines geometries for the expressions of the geo tests
+const geoPrelude = `
+square = {type = "Polygon", coordinates = {{{0, 0}, {2, 0}, {2, 2}, {0, 2}, {0, 0}}}}
+line = {type = "LineString", coordinates = {{0, 0}, {3, 4}, {3, 5}}}
+origin = {type = "Point", coordinates = {0, 0}}
+east = {type = "Point", coordinates = {1, 0}}
+inside = {type = "Point", coordinates = {1, 1}}
+outside = {type = "Point", coordinates = {3, 1}}
+`
+
+// evaluate returns the value of a Lua expression, as the property of a
+// feature a script sets it to
+func evaluate(t *testing.T, expression string) (interface{}, error) {
+	s := newTestScript(t, geoPrelude+"function process(f) f.properties.result = "+expression+" return f end")
+	features, err := s.Process(gio.NewFeature(nil))
+	if err != nil {
+		return nil, err
+	}
+	value, _ := features[0].Properties.Get("result")
+	return value, nil
+}
+
+func TestGeoLibrary(t *testing.T) {
+	square := orb.Polygon{{{0, 0}, {2, 0}, {2, 2}, {0, 2}, {0, 0}}}
+	line := orb.LineString{{0, 0}, {3, 4}, {3, 5}}
+	tests := []struct {
+		expression string
+		want       interface{}
+	}{
+		{"geo.area(square)", geo.Area(square)},
+		{"geo.area({type = 'Feature', geometry = square, properties = {}})", geo.Area(square)},
+		{"geo.planar_area(square)", 4.0},
+		{"geo.length(line)", geo.Length(line)},
+		{"geo.planar_length(line)", 6.0},
+		{"geo.distance(origin, east)", geo.Distance(orb.Point{0, 0}, orb.Point{1, 0})},
+		{"geo.planar_distance(line, outside)", 1.8},
+		{"geo.planar_distance(origin, east)", 1.0},
+		{"geo.centroid(square).coordinates[1]", 1.0},
+		{"geo.centroid(line).type", "Point"},
+		{"table.concat(geo.bound(line), ',')", "0,0,3,5"},
+		{"geo.contains(square, inside)", true},
+		{"geo.contains(square, outside)", false},
+		{"geo.contains({type = 'MultiPolygon', coordinates = {square.coordinates}}, inside)", true},
+		{"#geo.simplify(line, 2).coordinates", 2.0},
+		{"#line.coordinates", 3.0},
+		{"geo.wkt(line)", "LINESTRING (0 0,3 4,3 5)"},
+		{"geo.wkt(origin, 4326)", "SRID=4326;POINT (0 0)"},
+		{"geo.from_wkt('POINT (3 4)').coordinates[2]", 4.0},
+		{"select(2, geo.from_wkt('SRID=3857;POINT (3 4)'))", 3857.0},
+		{"select(2, geo.from_wkt('POINT (3 4)'))", 0.0},
+		{"geo.wkb(east)", "0101000000000000000000f03f0000000000000000"},
+		{"geo.wkb(east, 4326)", "0101000020e6100000000000000000f03f0000000000000000"},
+		{"geo.from_wkb('0101000000000000000000f03f0000000000000000').coordinates[1]", 1.0},
+		{"select(2, geo.from_wkb('0101000020e6100000000000000000f03f0000000000000000'))", 4326.0},
+		{"geo.wkt(geo.from_wkb(geo.wkb(square)))", "POLYGON ((0 0,2 0,2 2,0 2,0 0))"},
+	}
+	for _, test := range tests {
+		got, err := evaluate(t, test.expression)
+		if err != nil {
+			t.Errorf("%s: %v", test.expression, err)
+			continue
+		}
+		if want, ok := test.want.(float64); ok {
+			if got, ok := got.(float64); ok && math.Abs(got-want) <= 1e-9*math.Max(1, math.Abs(want)) {
+				continue
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.expression, got, test.want)
+		}
+	}
+}
+
+func TestGeoLibraryErrors(t *testing.T) {
+	tests := []struct {
+		expression, want string
+	}{
+		{"geo.area(1)", "table expected"},
+		{"geo.area({})", "coordinates"},
+		{"geo.area({type = 'Nowhere'})", "bad argument #1"},
+		{"geo.distance(square, origin)", "point expected"},
+		{"geo.contains(line, origin)", "polygon or multipolygon expected"},
+		{"geo.simplify(line)", "number expected"},
+		{"geo.from_wkt('POINT (1')", "bad argument #1"},
+		{"geo.from_wkb('zz')", "invalid hex"},
+		{"geo.from_wkb('0101')", "bad argument #1"},
+	}
+	for _, test := range tests {
+		if _, err := evaluate(t, test.expression); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %q", test.expression, err, test.want)
+		}
+	}
+}
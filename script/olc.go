@@ -0,0 +1,63 @@
+package script
+
+import (
+	"github.com/Shopify/go-lua"
+	olc "github.com/google/open-location-code/go"
+	"github.com/stationa/xgeo/pluscode"
+)
+
+// olcLibrary is exposed to scripts as the global "olc" table, to convert
+// between lat/lon coordinates and Open Location Codes (Plus Codes)
+var olcLibrary = []lua.RegistryFunction{
+	{Name: "encode", Function: func(l *lua.State) int {
+		lat, lng := lua.CheckNumber(l, 1), lua.CheckNumber(l, 2)
+		l.PushString(olc.Encode(lat, lng, lua.OptInteger(l, 3, pluscode.DefaultLength)))
+		return 1
+	}},
+	{Name: "decode", Function: func(l *lua.State) int {
+		area, err := olc.Decode(lua.CheckString(l, 1))
+		if err != nil {
+			lua.ArgumentError(l, 1, err.Error())
+		}
+		lat, lng := area.Center()
+		pushValue(l, map[string]interface{}{
+			"lat":    lat,
+			"lng":    lng,
+			"lat_lo": area.LatLo,
+			"lng_lo": area.LngLo,
+			"lat_hi": area.LatHi,
+			"lng_hi": area.LngHi,
+			"length": area.Len,
+		})
+		return 1
+	}},
+	{Name: "cell", Function: func(l *lua.State) int {
+		area, err := olc.Decode(lua.CheckString(l, 1))
+		if err != nil {
+			lua.ArgumentError(l, 1, err.Error())
+		}
+		pushGeometry(l, pluscode.Cell(area))
+		return 1
+	}},
+	{Name: "shorten", Function: func(l *lua.State) int {
+		code, err := olc.Shorten(lua.CheckString(l, 1), lua.CheckNumber(l, 2), lua.CheckNumber(l, 3))
+		if err != nil {
+			lua.ArgumentError(l, 1, err.Error())
+		}
+		l.PushString(code)
+		return 1
+	}},
+	{Name: "recover_nearest", Function: func(l *lua.State) int {
+		code, err := olc.RecoverNearest(lua.CheckString(l, 1), lua.CheckNumber(l, 2), lua.CheckNumber(l, 3))
+		if err != nil {
+			lua.ArgumentError(l, 1, err.Error())
+		}
+		l.PushString(code)
+		return 1
+	}},
+}
+
+func olcOpen(l *lua.State) int {
+	lua.NewLibrary(l, olcLibrary)
+	return 1
+}
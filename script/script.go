@@ -0,0 +1,99 @@
+package script
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shopify/go-lua"
+	gio "github.com/stationa/xgeo/io"
+)
+
+// ProcessFunction is the global Lua function that is called once per feature
+const ProcessFunction = "process"
+
+type Script struct {
+	state *lua.State
+}
+
+func NewScript(filename string) (*Script, error) {
+	l := lua.NewState()
+	lua.OpenLibraries(l)
+	lua.Require(l, "geo", geoOpen, true)
+	lua.Require(l, "olc", olcOpen, true)
+	l.Pop(2)
+	if err := lua.DoFile(l, filename); err != nil {
+		return nil, luaError(l, err)
+	}
+	l.Global(ProcessFunction)
+	isFunction := l.IsFunction(-1)
+	l.Pop(1)
+	if !isFunction {
+		return nil, fmt.Errorf("script %s must define a global %q function", filename, ProcessFunction)
+	}
+	return &Script{
+		l,
+	}, nil
+}
+
+// Process calls the script's process function with the given feature and
+// returns every feature that it emits. A script drops a feature by returning
+// nil, and emits several features by returning several values.
+func (s *Script) Process(feature *gio.Feature) ([]*gio.Feature, error) {
+	l := s.state
+	top := l.Top()
+	defer l.SetTop(top)
+
+	// The feature is kept below the call, to tell the features returned with
+	// its properties table from new ones
+	pushFeature(l, feature)
+	l.Global(ProcessFunction)
+	l.PushValue(-2)
+	if err := l.ProtectedCall(1, lua.MultipleReturns, 0); err != nil {
+		return nil, luaError(l, err)
+	}
+	var features []*gio.Feature
+	for i := top + 2; i <= l.Top(); i++ {
+		if l.IsNil(i) {
+			continue
+		}
+		if !l.IsTable(i) {
+			return nil, fmt.Errorf("%s must return tables or nil, got %s", ProcessFunction, lua.TypeNameOf(l, i))
+		}
+		l.Field(i, "properties")
+		l.Field(top+1, "properties")
+		sameProperties := l.RawEqual(-1, -2)
+		l.Pop(2)
+		f, err := toFeature(l, i, feature, sameProperties)
+		if err != nil {
+			return nil, fmt.Errorf("%s returned an invalid feature: %v", ProcessFunction, err)
+		}
+		features = append(features, f)
+	}
+	return features, nil
+}
+
+// Run processes the features from in and sends the results to out, until in
+// is closed or ctx is done
+func (s *Script) Run(ctx context.Context, in chan *gio.Feature, out chan *gio.Feature) error {
+	for feature := range in {
+		if feature == nil {
+			continue
+		}
+		features, err := s.Process(feature)
+		if err != nil {
+			return err
+		}
+		for _, f := range features {
+			if err := gio.Emit(ctx, out, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func luaError(l *lua.State, err error) error {
+	if msg, ok := l.ToString(-1); ok {
+		return fmt.Errorf("lua: %s", msg)
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package script
+
+import (
+	"context"
+	"github.com/paulmach/orb"
+	gio "github.com/stationa/xgeo/io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestScript loads a script from its source
+func newTestScript(t *testing.T, source string) *Script {
+	file, err := ioutil.TempFile("", "script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(source); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	s, err := NewScript(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// process runs a feature through a script that must emit one feature
+func process(t *testing.T, s *Script, feature *gio.Feature) *gio.Feature {
+	features, err := s.Process(feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 1 {
+		t.Fatalf("got %d features, want 1", len(features))
+	}
+	return features[0]
+}
+
+func TestRoundTrip(t *testing.T) {
+	s := newTestScript(t, "function process(f) return f end")
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		feature *gio.Feature
+		want    *gio.Feature
+	}{
+		{
+			name: "values",
+			feature: &gio.Feature{
+				ID:       int64(7),
+				Geometry: orb.LineString{{1, 2}, {3, 4}},
+				Z:        []float64{5, 6},
+				BBox:     []float64{1, 2, 3, 4},
+				Properties: gio.Properties{
+					{Key: "s", Value: "text"},
+					{Key: "f", Value: 1.5},
+					{Key: "i", Value: int64(-3)},
+					{Key: "n", Value: 3},
+					{Key: "b", Value: true},
+					{Key: "null", Value: nil},
+					{Key: "date", Value: date},
+					{Key: "list", Value: []interface{}{1.0, "a"}},
+					{Key: "object", Value: map[string]interface{}{"k": "v"}},
+				},
+			},
+			want: &gio.Feature{
+				ID:       int64(7),
+				Geometry: orb.LineString{{1, 2}, {3, 4}},
+				Z:        []float64{5, 6},
+				BBox:     []float64{1, 2, 3, 4},
+				Properties: gio.Properties{
+					{Key: "s", Value: "text"},
+					{Key: "f", Value: 1.5},
+					{Key: "i", Value: int64(-3)},
+					{Key: "n", Value: 3},
+					{Key: "b", Value: true},
+					{Key: "null", Value: nil},
+					{Key: "date", Value: "2020-01-02T03:04:05Z"},
+					{Key: "list", Value: []interface{}{1.0, "a"}},
+					{Key: "object", Value: map[string]interface{}{"k": "v"}},
+				},
+			},
+		},
+		{
+			name:    "large IDs",
+			feature: &gio.Feature{ID: int64(1<<62 + 1), Properties: gio.Properties{{Key: "big", Value: int64(-1<<60 - 3)}}},
+			want:    &gio.Feature{ID: int64(1<<62 + 1), Properties: gio.Properties{{Key: "big", Value: int64(-1<<60 - 3)}}},
+		},
+		{
+			name:    "float and string IDs",
+			feature: &gio.Feature{ID: 12.0, Properties: gio.Properties{{Key: "id", Value: "9007199254740993"}}},
+			want:    &gio.Feature{ID: 12.0, Properties: gio.Properties{{Key: "id", Value: "9007199254740993"}}},
+		},
+	}
+	for _, test := range tests {
+		got := process(t, s, test.feature)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	s := newTestScript(t, `
+function process(f)
+  if f.properties.drop then
+    return nil
+  end
+  f.id = f.id + 1
+  f.properties.count = f.properties.count * 2
+  f.properties.gone = nil
+  f.properties.added = "yes"
+  f.properties.another = 1
+  local copy = {type = "Feature", geometry = {type = "Point", coordinates = {1, 2}}, properties = {}}
+  return f, copy
+end
+`)
+	feature := &gio.Feature{
+		ID:         int64(41),
+		Properties: gio.Properties{{Key: "count", Value: int64(3)}, {Key: "gone", Value: "x"}, {Key: "kept", Value: nil}},
+	}
+	features, err := s.Process(feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*gio.Feature{
+		{
+			ID:         int64(42),
+			Properties: gio.Properties{{Key: "count", Value: int64(6)}, {Key: "kept", Value: nil}, {Key: "added", Value: "yes"}, {Key: "another", Value: 1.0}},
+		},
+		{Geometry: orb.Point{1, 2}},
+	}
+	if !reflect.DeepEqual(features, want) {
+		t.Errorf("got %+v and %+v, want %+v and %+v", features[0], features[1], want[0], want[1])
+	}
+
+	features, err = s.Process(&gio.Feature{Properties: gio.Properties{{Key: "drop", Value: true}}})
+	if err != nil || len(features) != 0 {
+		t.Errorf("got %d features, %v", len(features), err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	s := newTestScript(t, "function process(f) f.properties.seen = true return f end")
+	in := make(chan *gio.Feature, 3)
+	in <- &gio.Feature{ID: 1.0}
+	in <- nil
+	in <- &gio.Feature{ID: 2.0}
+	close(in)
+	out := make(chan *gio.Feature, 3)
+	if err := s.Run(context.Background(), in, out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	var ids []interface{}
+	for f := range out {
+		if seen, _ := f.Properties.Get("seen"); seen != true {
+			t.Errorf("feature %v was not processed", f.ID)
+		}
+		ids = append(ids, f.ID)
+	}
+	if !reflect.DeepEqual(ids, []interface{}{1.0, 2.0}) {
+		t.Errorf("got %v", ids)
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	tests := []struct {
+		source, want string
+	}{
+		{"function process(f) return 1 end", "must return tables or nil, got number"},
+		{"function process(f) return {1, 2} end", "invalid feature"},
+		{"function process(f) return {geometry = {type = 'Nowhere'}} end", "invalid feature"},
+		{"function process(f) error('boom') end", "boom"},
+	}
+	for _, test := range tests {
+		s := newTestScript(t, test.source)
+		if _, err := s.Process(&gio.Feature{}); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %q", test.source, err, test.want)
+		}
+	}
+
+	file, _ := ioutil.TempFile("", "script")
+	defer os.Remove(file.Name())
+	file.WriteString("x = 1")
+	file.Close()
+	if _, err := NewScript(file.Name()); err == nil || !strings.Contains(err.Error(), "process") {
+		t.Errorf("script without process: got %v", err)
+	}
+}